@@ -46,9 +46,25 @@ func (m *BearerKeyMW) Handle() gin.HandlerFunc {
 		c.Set("user", "")
 		c.Set("org", "")
 
+		// gemini clients may send the key as the key query parameter, which must not go further, e.g. to the logs
+		query := c.Request.URL.Query()
+		queryKey := query.Get("key")
+		if query.Has("key") {
+			query.Del("key")
+			c.Request.URL.RawQuery = query.Encode()
+		}
+
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			return
+			// gemini clients send the key in x-goog-api-key
+			authHeader = c.GetHeader("x-goog-api-key")
+			if authHeader == "" {
+				authHeader = queryKey
+			}
+			if authHeader == "" {
+				return
+			}
+			authHeader = "Bearer " + authHeader
 		}
 		const bearerPrefix = "Bearer "
 		if len(authHeader) < len(bearerPrefix) || !strings.HasPrefix(strings.ToLower(authHeader), strings.ToLower(bearerPrefix)) {
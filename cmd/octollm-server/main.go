@@ -8,6 +8,7 @@ import (
 	"github.com/gin-contrib/gzip"
 	"github.com/gin-gonic/gin"
 	"github.com/infinigence/octollm/pkg/composer"
+	"github.com/infinigence/octollm/pkg/octollm"
 	"github.com/sirupsen/logrus"
 )
 
@@ -17,6 +18,7 @@ func main() {
 	flag.Parse()
 
 	logrus.SetLevel(logrus.DebugLevel)
+	logrus.AddHook(octollm.TagsLogHook{})
 	r := gin.Default()
 
 	logrus.Infof("Using config file: %s", configFile)
@@ -25,6 +27,11 @@ func main() {
 		logrus.WithError(err).Fatal("failed to read config file")
 	}
 
+	// the client IP comes from X-Forwarded-For only behind the configured proxies, clients could spoof it otherwise
+	if err := r.SetTrustedProxies(conf.TrustedProxies); err != nil {
+		logrus.WithError(err).Fatal("failed to set trusted proxies")
+	}
+
 	s := NewServer(conf)
 
 	auth := &BearerKeyMW{}
@@ -34,10 +41,30 @@ func main() {
 	}
 
 	// Register routes
-	r.Use(gzip.Gzip(gzip.DefaultCompression), auth.Handle())
+	r.Use(gzip.Gzip(gzip.DefaultCompression), clientIP(), auth.Handle())
 	r.POST("/v1/chat/completions", s.ChatCompletionsHandler())
+	r.POST("/v1/completions", s.LegacyCompletionsHandler())
 	r.POST("/v1/messages", s.MessagesHandler())
+	r.POST("/v1/responses", s.ResponsesHandler())
+	r.POST("/v1/embeddings", s.EmbeddingsHandler())
+	r.POST("/v1/rerank", s.RerankHandler())
+	// gemini style and vertex style paths, e.g. /v1beta/models/gemini-2.5-flash:generateContent
+	r.POST("/v1beta/models/:model_method", s.GenerateContentHandler())
+	r.POST("/v1/projects/:project/locations/:location/publishers/:publisher/models/:model_method", s.GenerateContentHandler())
+	r.GET("/metrics", s.MetricsHandler())
+	admin := r.Group("/admin", s.AdminAuth())
+	admin.GET("/experiments", s.ExperimentsHandler())
+	admin.PUT("/experiments/:name", s.RampExperimentHandler())
+	admin.GET("/rules", s.RuleStatsHandler())
+	admin.GET("/backends", s.BackendStatusHandler())
 
 	log.Println("listening :8080")
 	log.Fatal(http.ListenAndServe(":8080", r))
 }
+
+// clientIP passes the client IP resolved by gin, which honors the trusted proxies, on to the engines.
+func clientIP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(octollm.WithClientIP(c.Request.Context(), c.ClientIP()))
+	}
+}
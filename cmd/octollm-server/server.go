@@ -1,10 +1,14 @@
 package main
 
 import (
-	"time"
+	"crypto/subtle"
+	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/infinigence/octollm/pkg/composer"
+	"github.com/infinigence/octollm/pkg/errutils"
+	"github.com/infinigence/octollm/pkg/metrics"
 	"github.com/infinigence/octollm/pkg/octollm"
 	"github.com/sirupsen/logrus"
 )
@@ -21,7 +25,7 @@ func NewServer(conf *composer.ConfigFile) *Server {
 	if err != nil {
 		logrus.WithError(err).Fatal("failed to update model repo from config")
 	}
-	ruleComposer := composer.NewRuleRepoFileBased(modelRepo, 5*time.Second, 10)
+	ruleComposer := composer.NewRuleRepoFileBased(modelRepo)
 	err = ruleComposer.UpdateFromConfig(conf)
 	if err != nil {
 		logrus.WithError(err).Fatal("failed to update rule composer from config")
@@ -44,6 +48,17 @@ func (s *Server) ChatCompletionsHandler() gin.HandlerFunc {
 	}
 }
 
+func (s *Server) LegacyCompletionsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgName := c.GetString("org")
+		userName := c.GetString("user")
+
+		engine := s.ruleComposer.GetEngine(userName, orgName, "")
+		handler := octollm.LegacyCompletionsHandler(engine)
+		handler(c.Writer, c.Request)
+	}
+}
+
 func (s *Server) MessagesHandler() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		orgName := c.GetString("org")
@@ -54,3 +69,117 @@ func (s *Server) MessagesHandler() gin.HandlerFunc {
 		handler(c.Writer, c.Request)
 	}
 }
+
+func (s *Server) ResponsesHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgName := c.GetString("org")
+		userName := c.GetString("user")
+
+		engine := s.ruleComposer.GetEngine(userName, orgName, "")
+		handler := octollm.ResponsesHandler(engine)
+		handler(c.Writer, c.Request)
+	}
+}
+
+func (s *Server) GenerateContentHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgName := c.GetString("org")
+		userName := c.GetString("user")
+
+		engine := s.ruleComposer.GetEngine(userName, orgName, "")
+		handler := octollm.GenerateContentHandler(engine)
+		handler(c.Writer, c.Request)
+	}
+}
+
+func (s *Server) EmbeddingsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgName := c.GetString("org")
+		userName := c.GetString("user")
+
+		engine := s.ruleComposer.GetEngine(userName, orgName, "")
+		handler := octollm.EmbeddingsHandler(engine)
+		handler(c.Writer, c.Request)
+	}
+}
+
+func (s *Server) RerankHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgName := c.GetString("org")
+		userName := c.GetString("user")
+
+		engine := s.ruleComposer.GetEngine(userName, orgName, "")
+		handler := octollm.RerankHandler(engine)
+		handler(c.Writer, c.Request)
+	}
+}
+
+// BackendStatusHandler reports the circuit breaker and health check states of the backends.
+func (s *Server) BackendStatusHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, s.modelRepo.BackendStatuses())
+	}
+}
+
+func (s *Server) MetricsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Content-Type", "text/plain; version=0.0.4")
+		c.Status(http.StatusOK)
+		if err := metrics.Default.WriteText(c.Writer); err != nil {
+			logrus.WithContext(c.Request.Context()).Warnf("write metrics error: %v", err)
+		}
+	}
+}
+
+// AdminAuth lets through the requests bearing the admin_api_key of the config. The admin endpoints are disabled
+// when the config has none.
+func (s *Server) AdminAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := s.conf.AdminAPIKey
+		if key == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin endpoints are disabled"})
+			return
+		}
+		const bearerPrefix = "bearer "
+		auth := c.GetHeader("Authorization")
+		if len(auth) < len(bearerPrefix) || !strings.EqualFold(auth[:len(bearerPrefix)], bearerPrefix) ||
+			subtle.ConstantTimeCompare([]byte(auth[len(bearerPrefix):]), []byte(key)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid admin key"})
+			return
+		}
+	}
+}
+
+// ExperimentsHandler reports the current weights of the arms of the experiments.
+func (s *Server) ExperimentsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, s.ruleComposer.Experiments())
+	}
+}
+
+// RampExperimentHandler changes the weights of arms of an experiment, e.g. {"weights": {"treatment": 20}}.
+func (s *Server) RampExperimentHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Weights map[string]int `json:"weights"`
+		}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		name := c.Param("name")
+		if err := s.ruleComposer.RampExperiment(name, body.Weights); err != nil {
+			handlerErr := errutils.AsHandlerError(err)
+			c.JSON(handlerErr.StatusCode, gin.H{"error": handlerErr.Message})
+			return
+		}
+		c.JSON(http.StatusOK, s.ruleComposer.Experiments()[name])
+	}
+}
+
+// RuleStatsHandler reports the evaluations, matches, executions, errors and evaluation latency of the rules.
+func (s *Server) RuleStatsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, s.ruleComposer.RuleStats())
+	}
+}
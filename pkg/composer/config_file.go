@@ -3,9 +3,15 @@ package composer
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/goccy/go-yaml"
 	"github.com/infinigence/octollm/pkg/engines"
+	"github.com/infinigence/octollm/pkg/engines/limiter"
+	loadbalancer "github.com/infinigence/octollm/pkg/engines/load-balancer"
+	ruleengine "github.com/infinigence/octollm/pkg/engines/rule-engine"
+	"github.com/infinigence/octollm/pkg/octollm"
+	"github.com/redis/go-redis/v9"
 )
 
 const (
@@ -18,6 +24,10 @@ type Model struct {
 	// Name     string              `json:"name" yaml:"name"`
 	Access           string              `json:"access" yaml:"access"` // public(w/o authN), internal(authN required), private(authN+Z)
 	Backends         map[string]*Backend `json:"backends" yaml:"backends"`
+	Strategy         string              `json:"strategy" yaml:"strategy"`                     // load balancing strategy, see LBStrategy*
+	Affinity         *AffinityConfig     `json:"affinity" yaml:"affinity"`                     // only for consistent_hash
+	Fallbacks        []string            `json:"fallbacks" yaml:"fallbacks"`                   // models tried in order when all backends fail
+	Hedging          *HedgingConfig      `json:"hedging" yaml:"hedging"`                       // duplicates requests slow to answer
 	DefaultOrgLimits *LimitsConfig       `json:"default_org_limits" yaml:"default_org_limits"` // only for internal or private
 	DefaultRules     RuleList            `json:"default_rules" yaml:"default_rules"`
 
@@ -27,24 +37,156 @@ type Model struct {
 	StreamChunkRewrites *engines.RewritePolicy `json:"stream_chunk_rewrites" yaml:"stream_chunk_rewrites"`
 }
 
+const (
+	LBStrategyWeightedRoundRobin = "weighted_round_robin" // default
+	LBStrategyLeastInflight      = "least_inflight"
+	LBStrategyPeakEWMA           = "peak_ewma"
+	LBStrategyPowerOfTwo         = "power_of_two"
+	LBStrategyConsistentHash     = "consistent_hash"
+)
+
+const (
+	AffinityKeyPrefix = "prefix" // default
+	AffinityKeyHeader = "header"
+	AffinityKeyUser   = "user"
+)
+
+// AffinityConfig configures the keys of the consistent_hash strategy.
+type AffinityConfig struct {
+	Key          string  `json:"key" yaml:"key"`                     // see AffinityKey*
+	Header       string  `json:"header" yaml:"header"`               // for key "header", defaults to "X-Session-Id"
+	PrefixLength int     `json:"prefix_length" yaml:"prefix_length"` // for key "prefix", bytes of the prompt, defaults to 1024
+	LoadFactor   float64 `json:"load_factor" yaml:"load_factor"`     // defaults to 1.25
+}
+
+func (c *AffinityConfig) KeyFunc() (loadbalancer.AffinityKeyFunc, error) {
+	switch c.Key {
+	case "", AffinityKeyPrefix:
+		length := c.PrefixLength
+		if length <= 0 {
+			length = 1024
+		}
+		return loadbalancer.PromptPrefixAffinityKey(length), nil
+	case AffinityKeyHeader:
+		header := c.Header
+		if header == "" {
+			header = "X-Session-Id"
+		}
+		return loadbalancer.HeaderAffinityKey(header), nil
+	case AffinityKeyUser:
+		return loadbalancer.UserAffinityKey(), nil
+	default:
+		return nil, fmt.Errorf("unsupported affinity key: %s", c.Key)
+	}
+}
+
+// HedgingConfig sends a duplicate of a request to another backend when it has not answered within the delay
+// (the first chunk for streams), the first successful answer is used.
+type HedgingConfig struct {
+	DelayMS       int     `json:"delay_ms" yaml:"delay_ms"`             // defaults to 500
+	MaxHedges     int     `json:"max_hedges" yaml:"max_hedges"`         // extra attempts per request, defaults to 1
+	BudgetPercent float64 `json:"budget_percent" yaml:"budget_percent"` // hedges are at most this percentage of requests, defaults to 10
+}
+
+func (c *HedgingConfig) HedgePolicy() loadbalancer.HedgePolicy {
+	return loadbalancer.HedgePolicy{
+		Delay:         time.Duration(c.DelayMS) * time.Millisecond,
+		MaxHedges:     c.MaxHedges,
+		BudgetPercent: c.BudgetPercent,
+	}
+}
+
 type Backend struct {
-	Use                     string            `json:"use" yaml:"use"` // references a global backend config
-	BaseURL                 string            `json:"base_url" yaml:"base_url"`
-	HTTPProxy               *string           `json:"http_proxy" yaml:"http_proxy"`
-	APIKey                  *string           `json:"api_key" yaml:"api_key"`
-	AnthropicAPIKeyAsBearer *bool             `json:"anthropic_api_key_as_bearer" yaml:"anthropic_api_key_as_bearer"`
-	ExtraHeaders            map[string]string `json:"extra_headers" yaml:"extra_headers"`
-	URLPathChat             *string           `json:"url_path_chat" yaml:"url_path_chat"`
-	URLPathMessages         *string           `json:"url_path_messages" yaml:"url_path_messages"`
-	URLPathVertex           *string           `json:"url_path_vertex" yaml:"url_path_vertex"`
-
-	ConvertToChat     string `json:"convert_to_chat" yaml:"convert_to_chat"`         // "from_messages" or "from_vertex"
-	ConvertToMessages string `json:"convert_to_messages" yaml:"convert_to_messages"` // "from_chat" or "from_vertex"
-	ConvertToVertex   string `json:"convert_to_vertex" yaml:"convert_to_vertex"`     // "from_chat" or "from_messages"
+	Use                     string                `json:"use" yaml:"use"`   // references a global backend config
+	Tier                    int                   `json:"tier" yaml:"tier"` // failover tier, 0 (primary) first
+	BaseURL                 string                `json:"base_url" yaml:"base_url"`
+	HTTPProxy               *string               `json:"http_proxy" yaml:"http_proxy"`
+	APIKey                  *string               `json:"api_key" yaml:"api_key"`
+	AnthropicAPIKeyAsBearer *bool                 `json:"anthropic_api_key_as_bearer" yaml:"anthropic_api_key_as_bearer"`
+	VertexAPIKeyAsBearer    *bool                 `json:"vertex_api_key_as_bearer" yaml:"vertex_api_key_as_bearer"`
+	ExtraHeaders            map[string]string     `json:"extra_headers" yaml:"extra_headers"`
+	Headers                 *engines.HeaderPolicy `json:"headers" yaml:"headers"`
+	URLPathChat             *string               `json:"url_path_chat" yaml:"url_path_chat"`
+	URLPathCompletions      *string               `json:"url_path_completions" yaml:"url_path_completions"`
+	URLPathMessages         *string               `json:"url_path_messages" yaml:"url_path_messages"`
+	URLPathVertex           *string               `json:"url_path_vertex" yaml:"url_path_vertex"`
+	URLPathResponses        *string               `json:"url_path_responses" yaml:"url_path_responses"`
+	URLPathEmbeddings       *string               `json:"url_path_embeddings" yaml:"url_path_embeddings"`
+	URLPathRerank           *string               `json:"url_path_rerank" yaml:"url_path_rerank"`
+
+	ConvertToChat      string `json:"convert_to_chat" yaml:"convert_to_chat"`           // "from_messages" or "from_vertex"
+	ConvertToMessages  string `json:"convert_to_messages" yaml:"convert_to_messages"`   // "from_chat"
+	ConvertToVertex    string `json:"convert_to_vertex" yaml:"convert_to_vertex"`       // "from_chat"
+	ConvertToResponses string `json:"convert_to_responses" yaml:"convert_to_responses"` // "from_chat"
 
 	RequestRewrites     *engines.RewritePolicy `json:"request_rewrites" yaml:"request_rewrites"`
 	ResponseRewrites    *engines.RewritePolicy `json:"response_rewrites" yaml:"response_rewrites"`
 	StreamChunkRewrites *engines.RewritePolicy `json:"stream_chunk_rewrites" yaml:"stream_chunk_rewrites"`
+
+	CircuitBreaker *CircuitBreakerConfig `json:"circuit_breaker" yaml:"circuit_breaker"`
+	HealthCheck    *HealthCheckConfig    `json:"health_check" yaml:"health_check"`
+}
+
+// compileRewrites compiles the expressions of the rewrites.
+func (b *Backend) compileRewrites() error {
+	if err := b.RequestRewrites.Compile(); err != nil {
+		return fmt.Errorf("request_rewrites: %w", err)
+	}
+	if err := b.ResponseRewrites.Compile(); err != nil {
+		return fmt.Errorf("response_rewrites: %w", err)
+	}
+	if err := b.StreamChunkRewrites.Compile(); err != nil {
+		return fmt.Errorf("stream_chunk_rewrites: %w", err)
+	}
+	return nil
+}
+
+// CircuitBreakerConfig takes a backend out of rotation when it keeps failing.
+// Failures are connection errors, 5xx and 429 responses.
+type CircuitBreakerConfig struct {
+	ConsecutiveFailures int     `json:"consecutive_failures" yaml:"consecutive_failures"`
+	ErrorRate           float64 `json:"error_rate" yaml:"error_rate"`                 // e.g. 0.5
+	MinRequests         int     `json:"min_requests" yaml:"min_requests"`             // defaults to 10, only for error_rate
+	WindowSeconds       int     `json:"window_seconds" yaml:"window_seconds"`         // defaults to 60, only for error_rate
+	OpenSeconds         int     `json:"open_seconds" yaml:"open_seconds"`             // defaults to 30
+	HalfOpenRequests    int     `json:"half_open_requests" yaml:"half_open_requests"` // defaults to 1
+}
+
+func (c *CircuitBreakerConfig) BreakerConfig() loadbalancer.BreakerConfig {
+	return loadbalancer.BreakerConfig{
+		ConsecutiveFailures: c.ConsecutiveFailures,
+		ErrorRate:           c.ErrorRate,
+		MinRequests:         c.MinRequests,
+		Window:              time.Duration(c.WindowSeconds) * time.Second,
+		OpenDuration:        time.Duration(c.OpenSeconds) * time.Second,
+		HalfOpenRequests:    c.HalfOpenRequests,
+	}
+}
+
+// HealthCheckConfig probes a backend periodically, either by a GET to URL or by sending Request.
+type HealthCheckConfig struct {
+	URL                string              `json:"url" yaml:"url"` // absolute, or a path relative to base_url
+	Request            *HealthCheckRequest `json:"request" yaml:"request"`
+	IntervalSeconds    int                 `json:"interval_seconds" yaml:"interval_seconds"`       // defaults to 10
+	TimeoutSeconds     int                 `json:"timeout_seconds" yaml:"timeout_seconds"`         // defaults to 5
+	UnhealthyThreshold int                 `json:"unhealthy_threshold" yaml:"unhealthy_threshold"` // defaults to 3
+	HealthyThreshold   int                 `json:"healthy_threshold" yaml:"healthy_threshold"`     // defaults to 2
+}
+
+// HealthCheckRequest is a request sent through the backend as a probe, e.g. a chat completion with max_tokens 1.
+type HealthCheckRequest struct {
+	Format string `json:"format" yaml:"format"` // api format of the body, defaults to "chat/completions"
+	Path   string `json:"path" yaml:"path"`     // request path, only needed by vertex for the model, e.g. "/v1beta/models/gemini-2.5-flash:generateContent"
+	Body   string `json:"body" yaml:"body"`
+}
+
+func (c *HealthCheckConfig) CheckerConfig() loadbalancer.HealthCheckConfig {
+	return loadbalancer.HealthCheckConfig{
+		Interval:           time.Duration(c.IntervalSeconds) * time.Second,
+		Timeout:            time.Duration(c.TimeoutSeconds) * time.Second,
+		UnhealthyThreshold: c.UnhealthyThreshold,
+		HealthyThreshold:   c.HealthyThreshold,
+	}
 }
 
 type RuleList []*RuleConfig
@@ -52,10 +194,172 @@ type RuleList []*RuleConfig
 type RuleConfig struct {
 	Name           string              `json:"name" yaml:"name"`
 	MatchExpr      string              `json:"match" yaml:"match"`
-	AddTags        map[string]string   `json:"add_tags" yaml:"add_tags"`
+	Action         string              `json:"action" yaml:"action"`     // continue, return, jump or call instead of handling the request
+	Chain          string              `json:"chain" yaml:"chain"`       // rule_chains target of jump and call
+	AddTags        map[string]string   `json:"add_tags" yaml:"add_tags"` // tags added to matched requests
 	Deny           *engines.DenyEngine `json:"deny" yaml:"deny"`
 	RuleLimits     *LimitsConfig       `json:"rule_limits" yaml:"rule_limits"`
 	ForwardWeights map[string]int      `json:"forward_weights" yaml:"forward_weights"`
+	Mirror         *MirrorConfig       `json:"mirror" yaml:"mirror"`         // copies a sample of the handled requests to a shadow backend
+	Experiment     string              `json:"experiment" yaml:"experiment"` // experiments arm handling the requests, instead of forward_weights
+	Mode           string              `json:"mode" yaml:"mode"`             // enforce (default) or audit, see RuleMode*
+}
+
+const (
+	RuleModeEnforce = "enforce" // default
+	RuleModeAudit   = "audit"   // the rule is evaluated and its matches logged and counted, but not applied
+)
+
+// isTagOnly tells if the rule only tags the requests it matches, such a rule lets later rules handle them.
+func (c *RuleConfig) isTagOnly() bool {
+	return len(c.AddTags) > 0 && c.Action == "" && c.Deny == nil && c.RuleLimits == nil && len(c.ForwardWeights) == 0 &&
+		c.Mirror == nil && c.Experiment == ""
+}
+
+// validateAction checks the action of a rule, a rule taking an action does not handle requests itself.
+func (c *RuleConfig) validateAction() error {
+	if c.Mode != "" && c.Mode != RuleModeEnforce && c.Mode != RuleModeAudit {
+		return fmt.Errorf("rule %s: unsupported mode %q", c.Name, c.Mode)
+	}
+	switch ruleengine.RuleEngineAction(c.Action) {
+	case "":
+		if c.Experiment != "" && (c.Deny != nil || len(c.ForwardWeights) > 0) {
+			return fmt.Errorf("rule %s: experiment excludes deny and forward_weights", c.Name)
+		}
+		return c.validateMirror()
+	case ruleengine.RuleEngineActionContinue, ruleengine.RuleEngineActionReturn:
+	case ruleengine.RuleEngineActionJump, ruleengine.RuleEngineActionCall:
+		if c.Chain == "" {
+			return fmt.Errorf("rule %s: action %s needs a chain", c.Name, c.Action)
+		}
+	default:
+		return fmt.Errorf("rule %s: unsupported action %q", c.Name, c.Action)
+	}
+	if c.Deny != nil || len(c.ForwardWeights) > 0 || c.Mirror != nil || c.Experiment != "" {
+		return fmt.Errorf("rule %s: action %s excludes deny, forward_weights, mirror and experiment", c.Name, c.Action)
+	}
+	return nil
+}
+
+// effect describes what the rule does for audit logs, "" for rules taking an action.
+func (c *RuleConfig) effect() string {
+	switch {
+	case c.Action != "":
+		return ""
+	case c.Deny != nil:
+		return fmt.Sprintf("deny with %q", c.Deny.ReasonText)
+	case c.isTagOnly():
+		return fmt.Sprintf("add tags %v", c.AddTags)
+	case c.Experiment != "":
+		return "run experiment " + c.Experiment
+	case len(c.ForwardWeights) > 0:
+		return fmt.Sprintf("forward by weights %v", c.ForwardWeights)
+	default:
+		return "forward to the default backends"
+	}
+}
+
+func (c *RuleConfig) validateMirror() error {
+	if c.Mirror == nil {
+		return nil
+	}
+	if c.Deny != nil {
+		return fmt.Errorf("rule %s: mirror excludes deny", c.Name)
+	}
+	if c.Mirror.Backend == "" {
+		return fmt.Errorf("rule %s: mirror needs a backend", c.Name)
+	}
+	if c.Mirror.Percent <= 0 || c.Mirror.Percent > 100 {
+		return fmt.Errorf("rule %s: mirror percent must be in (0, 100], got %v", c.Name, c.Mirror.Percent)
+	}
+	return nil
+}
+
+// MirrorConfig copies a sample of the requests handled by a rule to a shadow backend of the model, e.g. an upstream
+// under evaluation. The client is answered by the rule as usual, the shadow response is only compared with it.
+type MirrorConfig struct {
+	Backend     string  `json:"backend" yaml:"backend"`           // a backend of the model, better not a default one
+	Percent     float64 `json:"percent" yaml:"percent"`           // sampled percentage of the requests
+	TimeoutMS   int     `json:"timeout_ms" yaml:"timeout_ms"`     // timeout of a shadow request, defaults to 60000
+	MaxInflight int     `json:"max_inflight" yaml:"max_inflight"` // shadow requests in flight, requests over it are not mirrored, defaults to 100
+}
+
+func (c *MirrorConfig) MirrorPolicy() engines.MirrorPolicy {
+	return engines.MirrorPolicy{
+		Percent:     c.Percent,
+		Timeout:     time.Duration(c.TimeoutMS) * time.Millisecond,
+		MaxInflight: c.MaxInflight,
+	}
+}
+
+const (
+	ExperimentBucketByUser = "user" // default
+	ExperimentBucketByOrg  = "org"
+)
+
+// ExperimentConfig splits the requests of the rules using it between arms, by a hash of the identity of the caller
+// so that a user (or org) stays on one arm. Anonymous callers are bucketed by client IP.
+type ExperimentConfig struct {
+	BucketBy string                 `json:"bucket_by" yaml:"bucket_by"` // user or org, see ExperimentBucketBy*
+	Arms     []*ExperimentArmConfig `json:"arms" yaml:"arms"`
+}
+
+type ExperimentArmConfig struct {
+	Name           string         `json:"name" yaml:"name"`
+	Weight         int            `json:"weight" yaml:"weight"`
+	ForwardWeights map[string]int `json:"forward_weights" yaml:"forward_weights"` // backends of the arm, the default backends if empty
+
+	// rewrites of the arm, applied before those of the backends
+	RequestRewrites     *engines.RewritePolicy `json:"request_rewrites" yaml:"request_rewrites"`
+	ResponseRewrites    *engines.RewritePolicy `json:"response_rewrites" yaml:"response_rewrites"`
+	StreamChunkRewrites *engines.RewritePolicy `json:"stream_chunk_rewrites" yaml:"stream_chunk_rewrites"`
+}
+
+func (a *ExperimentArmConfig) compileRewrites() error {
+	if err := a.RequestRewrites.Compile(); err != nil {
+		return fmt.Errorf("request_rewrites: %w", err)
+	}
+	if err := a.ResponseRewrites.Compile(); err != nil {
+		return fmt.Errorf("response_rewrites: %w", err)
+	}
+	if err := a.StreamChunkRewrites.Compile(); err != nil {
+		return fmt.Errorf("stream_chunk_rewrites: %w", err)
+	}
+	return nil
+}
+
+// Experiment builds the runtime state of the experiment, whose weights can be ramped.
+func (c *ExperimentConfig) Experiment(name string) (*engines.Experiment, error) {
+	var keyOf func(req *octollm.Request) string
+	switch c.BucketBy {
+	case "", ExperimentBucketByUser:
+		keyOf = func(req *octollm.Request) string {
+			id := octollm.IdentityFrom(req.Context())
+			if id.User == "" && id.Org == "" {
+				return "ip:" + req.ClientIP
+			}
+			return "user:" + id.Org + "/" + id.User
+		}
+	case ExperimentBucketByOrg:
+		keyOf = func(req *octollm.Request) string {
+			id := octollm.IdentityFrom(req.Context())
+			if id.Org == "" {
+				return "ip:" + req.ClientIP
+			}
+			return "org:" + id.Org
+		}
+	default:
+		return nil, fmt.Errorf("experiment %s: unsupported bucket_by %q", name, c.BucketBy)
+	}
+
+	arms := make([]engines.ArmWeight, 0, len(c.Arms))
+	for _, arm := range c.Arms {
+		if err := arm.compileRewrites(); err != nil {
+			return nil, fmt.Errorf("experiment %s arm %s: %w", name, arm.Name, err)
+		}
+		arms = append(arms, engines.ArmWeight{Name: arm.Name, Weight: arm.Weight})
+	}
+	return engines.NewExperiment(name, keyOf, arms)
 }
 
 type LimitsConfig struct {
@@ -67,6 +371,18 @@ type LimitsConfig struct {
 	DenyWhenExceeding bool `json:"deny_when_exceeding" yaml:"deny_when_exceeding"` // only apply to rule_limits
 }
 
+// Limits converts the config to limiter limits, wait makes requests over the limits queue instead of failing with 429.
+func (l *LimitsConfig) Limits(wait bool) limiter.Limits {
+	return limiter.Limits{
+		RPM:         l.RPM,
+		RPD:         l.RPD,
+		TPM:         l.TPM,
+		TPD:         l.TPD,
+		Concurrency: l.Concurrency,
+		Wait:        wait,
+	}
+}
+
 type UserOrg struct {
 	APIKeys map[string]string              `json:"api_keys" yaml:"api_keys"`
 	Models  map[string]*UserOrgModelConfig `json:"models" yaml:"models"`
@@ -77,10 +393,76 @@ type UserOrgModelConfig struct {
 	Rules     RuleList      `json:"rules" yaml:"rules"`
 }
 
+// LimiterConfig configures where the counters of org and rule limits are kept.
+// Without a redis address, limits are kept in memory and enforced per replica.
+type LimiterConfig struct {
+	RedisAddr       string `json:"redis_addr" yaml:"redis_addr"`
+	RedisUsername   string `json:"redis_username" yaml:"redis_username"`
+	RedisPassword   string `json:"redis_password" yaml:"redis_password"`
+	RedisDB         int    `json:"redis_db" yaml:"redis_db"`
+	KeyPrefix       string `json:"key_prefix" yaml:"key_prefix"`               // defaults to "octollm:limits:"
+	LeaseTTLSeconds int    `json:"lease_ttl_seconds" yaml:"lease_ttl_seconds"` // defaults to 600, should exceed the longest stream
+}
+
+func (c *LimiterConfig) Store() limiter.Store {
+	if c.RedisAddr == "" {
+		return limiter.NewMemoryStore()
+	}
+	keyPrefix := c.KeyPrefix
+	if keyPrefix == "" {
+		keyPrefix = "octollm:limits:"
+	}
+	leaseTTL := 600 * time.Second
+	if c.LeaseTTLSeconds > 0 {
+		leaseTTL = time.Duration(c.LeaseTTLSeconds) * time.Second
+	}
+	client := redis.NewClient(&redis.Options{
+		Addr:     c.RedisAddr,
+		Username: c.RedisUsername,
+		Password: c.RedisPassword,
+		DB:       c.RedisDB,
+		// fail fast to the local fallback when redis is down
+		DialTimeout: time.Second,
+	})
+	return limiter.NewRedisStore(client, keyPrefix, leaseTTL)
+}
+
+// RetryConfig configures how the load balancer retries failed requests on other backends.
+// Unset fields keep the defaults of loadbalancer.DefaultRetryPolicy.
+type RetryConfig struct {
+	TimeoutSeconds   *float64 `json:"timeout_seconds" yaml:"timeout_seconds"` // no retry is started after this, defaults to 5
+	MaxAttempts      *int     `json:"max_attempts" yaml:"max_attempts"`       // including the first attempt, defaults to 10, 1 disables retries
+	InitialBackoffMS *int     `json:"initial_backoff_ms" yaml:"initial_backoff_ms"`
+	MaxBackoffMS     *int     `json:"max_backoff_ms" yaml:"max_backoff_ms"`
+}
+
+func (c *RetryConfig) RetryPolicy() loadbalancer.RetryPolicy {
+	p := loadbalancer.DefaultRetryPolicy
+	if c.TimeoutSeconds != nil {
+		p.Timeout = time.Duration(*c.TimeoutSeconds * float64(time.Second))
+	}
+	if c.MaxAttempts != nil {
+		p.MaxAttempts = *c.MaxAttempts
+	}
+	if c.InitialBackoffMS != nil {
+		p.InitialBackoff = time.Duration(*c.InitialBackoffMS) * time.Millisecond
+	}
+	if c.MaxBackoffMS != nil {
+		p.MaxBackoff = time.Duration(*c.MaxBackoffMS) * time.Millisecond
+	}
+	return p
+}
+
 type ConfigFile struct {
-	GlobalBackends map[string]*Backend `json:"backends" yaml:"backends"`
-	Models         map[string]*Model   `json:"models" yaml:"models"`
-	Users          map[string]*UserOrg `json:"users" yaml:"users"`
+	GlobalBackends map[string]*Backend          `json:"backends" yaml:"backends"`
+	Models         map[string]*Model            `json:"models" yaml:"models"`
+	Users          map[string]*UserOrg          `json:"users" yaml:"users"`
+	RuleChains     map[string]RuleList          `json:"rule_chains" yaml:"rule_chains"` // named chains for jump and call
+	Limiter        *LimiterConfig               `json:"limiter" yaml:"limiter"`
+	Retry          *RetryConfig                 `json:"retry" yaml:"retry"`
+	Experiments    map[string]*ExperimentConfig `json:"experiments" yaml:"experiments"`
+	AdminAPIKey    string                       `json:"admin_api_key" yaml:"admin_api_key"`     // bearer key of the /admin endpoints, which are disabled without it
+	TrustedProxies []string                     `json:"trusted_proxies" yaml:"trusted_proxies"` // addresses or CIDRs whose X-Forwarded-For is trusted, none by default
 }
 
 func ReadConfigFile(path string) (*ConfigFile, error) {
@@ -1,17 +1,22 @@
 package composer
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"strings"
 	"sync"
 
 	"github.com/infinigence/octollm/pkg/engines"
 	"github.com/infinigence/octollm/pkg/engines/client"
 	"github.com/infinigence/octollm/pkg/engines/converter"
+	loadbalancer "github.com/infinigence/octollm/pkg/engines/load-balancer"
 	"github.com/infinigence/octollm/pkg/octollm"
 )
 
 type ModelRepo interface {
 	GetBackendNamesByModel(modelName string) []string
+	GetBackendTier(modelName, backendName string) int
 	GetEngine(modelName, backendName string) (octollm.Engine, error)
 }
 
@@ -46,6 +51,9 @@ func (m *ModelRepoFileBased) UpdateFromConfig(conf *ConfigFile) error {
 					finalBackend = *globalBackend
 				}
 			}
+			if backend.Tier != 0 {
+				finalBackend.Tier = backend.Tier
+			}
 			if backend.BaseURL != "" {
 				finalBackend.BaseURL = backend.BaseURL
 			}
@@ -58,6 +66,9 @@ func (m *ModelRepoFileBased) UpdateFromConfig(conf *ConfigFile) error {
 			if backend.AnthropicAPIKeyAsBearer != nil {
 				finalBackend.AnthropicAPIKeyAsBearer = backend.AnthropicAPIKeyAsBearer
 			}
+			if backend.VertexAPIKeyAsBearer != nil {
+				finalBackend.VertexAPIKeyAsBearer = backend.VertexAPIKeyAsBearer
+			}
 			if backend.ExtraHeaders != nil {
 				if finalBackend.ExtraHeaders == nil {
 					finalBackend.ExtraHeaders = make(map[string]string)
@@ -69,12 +80,24 @@ func (m *ModelRepoFileBased) UpdateFromConfig(conf *ConfigFile) error {
 			if backend.URLPathChat != nil {
 				finalBackend.URLPathChat = backend.URLPathChat
 			}
+			if backend.URLPathCompletions != nil {
+				finalBackend.URLPathCompletions = backend.URLPathCompletions
+			}
 			if backend.URLPathMessages != nil {
 				finalBackend.URLPathMessages = backend.URLPathMessages
 			}
 			if backend.URLPathVertex != nil {
 				finalBackend.URLPathVertex = backend.URLPathVertex
 			}
+			if backend.URLPathResponses != nil {
+				finalBackend.URLPathResponses = backend.URLPathResponses
+			}
+			if backend.URLPathEmbeddings != nil {
+				finalBackend.URLPathEmbeddings = backend.URLPathEmbeddings
+			}
+			if backend.URLPathRerank != nil {
+				finalBackend.URLPathRerank = backend.URLPathRerank
+			}
 			if backend.ConvertToChat != "" {
 				finalBackend.ConvertToChat = backend.ConvertToChat
 			}
@@ -84,10 +107,27 @@ func (m *ModelRepoFileBased) UpdateFromConfig(conf *ConfigFile) error {
 			if backend.ConvertToVertex != "" {
 				finalBackend.ConvertToVertex = backend.ConvertToVertex
 			}
+			if backend.ConvertToResponses != "" {
+				finalBackend.ConvertToResponses = backend.ConvertToResponses
+			}
+
+			if backend.CircuitBreaker != nil {
+				finalBackend.CircuitBreaker = backend.CircuitBreaker
+			}
+			if backend.HealthCheck != nil {
+				finalBackend.HealthCheck = backend.HealthCheck
+			}
 
 			finalBackend.RequestRewrites = finalBackend.RequestRewrites.Merge(backend.RequestRewrites)
 			finalBackend.ResponseRewrites = finalBackend.ResponseRewrites.Merge(backend.ResponseRewrites)
 			finalBackend.StreamChunkRewrites = finalBackend.StreamChunkRewrites.Merge(backend.StreamChunkRewrites)
+			if err := finalBackend.compileRewrites(); err != nil {
+				return fmt.Errorf("model %s backend %s: %w", modelName, backendName, err)
+			}
+			finalBackend.Headers = finalBackend.Headers.Merge(backend.Headers)
+			if err := finalBackend.Headers.Compile(); err != nil {
+				return fmt.Errorf("model %s backend %s: headers: %w", modelName, backendName, err)
+			}
 
 			newBackends[modelName][backendName] = &finalBackend
 		}
@@ -96,6 +136,13 @@ func (m *ModelRepoFileBased) UpdateFromConfig(conf *ConfigFile) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.modelBackendConfig = newBackends
+	for _, backendEngines := range m.modelBackendEngine {
+		for _, engine := range backendEngines {
+			if guarded, ok := engine.(*loadbalancer.GuardedBackend); ok {
+				guarded.Stop()
+			}
+		}
+	}
 	m.modelBackendEngine = make(map[string]map[string]octollm.Engine)
 	return nil
 }
@@ -116,6 +163,17 @@ func (m *ModelRepoFileBased) GetBackendNamesByModel(modelName string) []string {
 	return backendNames
 }
 
+// GetBackendTier returns the failover tier of the backend, 0 if not found.
+func (m *ModelRepoFileBased) GetBackendTier(modelName, backendName string) int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if b, ok := m.modelBackendConfig[modelName][backendName]; ok {
+		return b.Tier
+	}
+	return 0
+}
+
 func (m *ModelRepoFileBased) GetEngine(modelName, backendName string) (octollm.Engine, error) {
 	m.mu.RLock()
 	if engine, ok := m.modelBackendEngine[modelName][backendName]; ok {
@@ -140,6 +198,12 @@ func (m *ModelRepoFileBased) GetEngine(modelName, backendName string) (octollm.E
 	if err != nil {
 		return nil, fmt.Errorf("new engine error: %w", err)
 	}
+	if b.CircuitBreaker != nil || b.HealthCheck != nil {
+		engine, err = m.guardEngine(modelName+"/"+backendName, b, engine)
+		if err != nil {
+			return nil, fmt.Errorf("new engine error: %w", err)
+		}
+	}
 	if _, ok := m.modelBackendEngine[modelName]; !ok {
 		m.modelBackendEngine[modelName] = make(map[string]octollm.Engine)
 	}
@@ -147,6 +211,86 @@ func (m *ModelRepoFileBased) GetEngine(modelName, backendName string) (octollm.E
 	return engine, nil
 }
 
+// guardEngine wraps the engine of a backend with its circuit breaker and starts its health checks.
+func (m *ModelRepoFileBased) guardEngine(name string, b *Backend, engine octollm.Engine) (*loadbalancer.GuardedBackend, error) {
+	var breaker *loadbalancer.CircuitBreaker
+	if b.CircuitBreaker != nil {
+		breaker = loadbalancer.NewCircuitBreaker(b.CircuitBreaker.BreakerConfig())
+	}
+	var health *loadbalancer.HealthChecker
+	if b.HealthCheck != nil {
+		probe, err := m.buildProbe(b, engine)
+		if err != nil {
+			return nil, err
+		}
+		health = loadbalancer.NewHealthChecker(name, probe, b.HealthCheck.CheckerConfig())
+	}
+	guarded := loadbalancer.NewGuardedBackend(name, engine, breaker, health)
+	if health != nil {
+		health.Start()
+	}
+	return guarded, nil
+}
+
+func (m *ModelRepoFileBased) buildProbe(b *Backend, engine octollm.Engine) (loadbalancer.Probe, error) {
+	hc := b.HealthCheck
+	if hc.Request != nil {
+		format := octollm.APIFormat(hc.Request.Format)
+		if format == octollm.APIFormatUnknown {
+			format = octollm.APIFormatChatCompletions
+		}
+		parser := octollm.NewRequestParser(format)
+		if parser == nil {
+			return nil, fmt.Errorf("unsupported health_check request format: %s", hc.Request.Format)
+		}
+		body := []byte(hc.Request.Body)
+		newRequest := func(ctx context.Context) (*octollm.Request, error) {
+			httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://octollm-health-check"+hc.Request.Path, nil)
+			if err != nil {
+				return nil, err
+			}
+			req := octollm.NewRequest(httpReq, format)
+			req.Body = octollm.NewBodyFromBytes(body, parser)
+			return req, nil
+		}
+		return loadbalancer.EngineProbe(engine, newRequest), nil
+	}
+	if hc.URL == "" {
+		return nil, fmt.Errorf("health_check must specify url or request")
+	}
+	healthURL := hc.URL
+	if strings.HasPrefix(healthURL, "/") {
+		healthURL = strings.TrimSuffix(b.BaseURL, "/") + healthURL
+	}
+	httpCli := http.DefaultClient
+	if b.HTTPProxy != nil {
+		httpCli = m.cliManager.GetClient(*b.HTTPProxy)
+	}
+	return loadbalancer.HTTPGetProbe(httpCli, healthURL), nil
+}
+
+// BackendStatuses returns the circuit breaker and health check states of the backends built so far,
+// keyed by model name and backend name. Backends without circuit breaker or health check are omitted.
+func (m *ModelRepoFileBased) BackendStatuses() map[string]map[string]loadbalancer.BackendStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	statuses := make(map[string]map[string]loadbalancer.BackendStatus)
+	for modelName, backendEngines := range m.modelBackendEngine {
+		for backendName, engine := range backendEngines {
+			guarded, ok := engine.(*loadbalancer.GuardedBackend)
+			if !ok {
+				continue
+			}
+			if _, ok := statuses[modelName]; !ok {
+				statuses[modelName] = make(map[string]loadbalancer.BackendStatus)
+			}
+			statuses[modelName][backendName] = guarded.Status()
+		}
+	}
+	return statuses
+}
+
 // BuildEngine build an engine for the backend config
 func (m *ModelRepoFileBased) BuildEngineByBackend(b *Backend) (octollm.Engine, error) {
 	var llmEngine octollm.Engine
@@ -161,6 +305,9 @@ func (m *ModelRepoFileBased) BuildEngineByBackend(b *Backend) (octollm.Engine, e
 	if b.AnthropicAPIKeyAsBearer != nil {
 		generalConf.AnthropicAPIKeyAsBearer = *b.AnthropicAPIKeyAsBearer
 	}
+	if b.VertexAPIKeyAsBearer != nil {
+		generalConf.VertexAPIKeyAsBearer = *b.VertexAPIKeyAsBearer
+	}
 	if b.URLPathChat != nil {
 		if *b.URLPathChat != "" {
 			generalConf.Endpoints[octollm.APIFormatChatCompletions] = *b.URLPathChat
@@ -168,6 +315,13 @@ func (m *ModelRepoFileBased) BuildEngineByBackend(b *Backend) (octollm.Engine, e
 	} else {
 		generalConf.Endpoints[octollm.APIFormatChatCompletions] = "" // will use default
 	}
+	if b.URLPathCompletions != nil {
+		if *b.URLPathCompletions != "" {
+			generalConf.Endpoints[octollm.APIFormatLegacyCompletions] = *b.URLPathCompletions
+		}
+	} else {
+		generalConf.Endpoints[octollm.APIFormatLegacyCompletions] = "" // will use default
+	}
 	if b.URLPathMessages != nil {
 		if *b.URLPathMessages != "" {
 			generalConf.Endpoints[octollm.APIFormatClaudeMessages] = *b.URLPathMessages
@@ -175,8 +329,36 @@ func (m *ModelRepoFileBased) BuildEngineByBackend(b *Backend) (octollm.Engine, e
 	} else {
 		generalConf.Endpoints[octollm.APIFormatClaudeMessages] = "" // will use default
 	}
+	if b.URLPathVertex != nil {
+		if *b.URLPathVertex != "" {
+			generalConf.Endpoints[octollm.APIFormatVertexGenerateContent] = *b.URLPathVertex
+		}
+	} else {
+		generalConf.Endpoints[octollm.APIFormatVertexGenerateContent] = "" // will use default
+	}
+	if b.URLPathResponses != nil {
+		if *b.URLPathResponses != "" {
+			generalConf.Endpoints[octollm.APIFormatResponses] = *b.URLPathResponses
+		}
+	} else {
+		generalConf.Endpoints[octollm.APIFormatResponses] = "" // will use default
+	}
+	if b.URLPathEmbeddings != nil {
+		if *b.URLPathEmbeddings != "" {
+			generalConf.Endpoints[octollm.APIFormatEmbeddings] = *b.URLPathEmbeddings
+		}
+	} else {
+		generalConf.Endpoints[octollm.APIFormatEmbeddings] = "" // will use default
+	}
+	if b.URLPathRerank != nil {
+		if *b.URLPathRerank != "" {
+			generalConf.Endpoints[octollm.APIFormatRerank] = *b.URLPathRerank
+		}
+	} else {
+		generalConf.Endpoints[octollm.APIFormatRerank] = "" // will use default
+	}
 	if len(generalConf.Endpoints) == 0 {
-		return nil, fmt.Errorf("backend must specify either URLPathChat, URLPathMessages or URLPathVertex")
+		return nil, fmt.Errorf("backend must specify at least one URL path")
 	}
 
 	llmGE := client.NewGeneralEndpoint(*generalConf)
@@ -186,21 +368,47 @@ func (m *ModelRepoFileBased) BuildEngineByBackend(b *Backend) (octollm.Engine, e
 		llmEngine = llmGE.WithClient(httpCli)
 	}
 
-	if b.ConvertToMessages != "" {
-		oriEngine := llmEngine
-		var convEngine octollm.Engine
-		if b.ConvertToMessages == "from_chat" {
-			convEngine = converter.NewChatCompletionsToClaudeMessages(oriEngine)
-		}
-		if convEngine != nil {
-			conv := func(req *octollm.Request) (*octollm.Response, error) {
-				if req.Format != octollm.APIFormatClaudeMessages {
-					return oriEngine.Process(req)
-				}
+	// converters are keyed by the request format they serve, and all of them use the original engine
+	convEngines := make(map[octollm.APIFormat]octollm.Engine)
+	oriEngine := llmEngine
+	switch b.ConvertToMessages {
+	case "":
+	case "from_chat":
+		convEngines[octollm.APIFormatClaudeMessages] = converter.NewChatCompletionsToClaudeMessages(oriEngine)
+	default:
+		return nil, fmt.Errorf("unsupported convert_to_messages: %s", b.ConvertToMessages)
+	}
+	switch b.ConvertToChat {
+	case "":
+	case "from_messages":
+		convEngines[octollm.APIFormatChatCompletions] = converter.NewMessagesToChatCompletions(oriEngine)
+	case "from_vertex":
+		convEngines[octollm.APIFormatChatCompletions] = converter.NewVertexGenerateContentToChatCompletions(oriEngine)
+	default:
+		return nil, fmt.Errorf("unsupported convert_to_chat: %s", b.ConvertToChat)
+	}
+	switch b.ConvertToVertex {
+	case "":
+	case "from_chat":
+		convEngines[octollm.APIFormatVertexGenerateContent] = converter.NewChatCompletionsToVertexGenerateContent(oriEngine)
+	default:
+		return nil, fmt.Errorf("unsupported convert_to_vertex: %s", b.ConvertToVertex)
+	}
+	switch b.ConvertToResponses {
+	case "":
+	case "from_chat":
+		convEngines[octollm.APIFormatResponses] = converter.NewChatCompletionsToResponses(oriEngine)
+	default:
+		return nil, fmt.Errorf("unsupported convert_to_responses: %s", b.ConvertToResponses)
+	}
+	if len(convEngines) > 0 {
+		conv := func(req *octollm.Request) (*octollm.Response, error) {
+			if convEngine, ok := convEngines[req.Format]; ok {
 				return convEngine.Process(req)
 			}
-			llmEngine = octollm.EngineFunc(conv)
+			return oriEngine.Process(req)
 		}
+		llmEngine = octollm.EngineFunc(conv)
 	}
 
 	if len(b.ExtraHeaders) > 0 {
@@ -209,6 +417,12 @@ func (m *ModelRepoFileBased) BuildEngineByBackend(b *Backend) (octollm.Engine, e
 			Next:   llmEngine,
 		}
 	}
+	// the extra headers come from the config, they are not filtered
+	headerPolicy := engines.DefaultHeaderPolicy().Merge(b.Headers)
+	if err := headerPolicy.Compile(); err != nil {
+		return nil, fmt.Errorf("headers: %w", err)
+	}
+	llmEngine = engines.NewHeaderEngine(llmEngine, headerPolicy)
 
 	if b.RequestRewrites != nil || b.ResponseRewrites != nil || b.StreamChunkRewrites != nil {
 		llmEngine = engines.NewRewriteEngine(
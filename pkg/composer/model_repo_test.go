@@ -0,0 +1,78 @@
+package composer
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/infinigence/octollm/pkg/engines"
+	"github.com/infinigence/octollm/pkg/octollm"
+)
+
+func TestModelRepo_DefaultHeaderPolicy(t *testing.T) {
+	var upstreamHeader http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamHeader = r.Header.Clone()
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "upstream=1")
+		w.Header().Set("X-Ratelimit-Remaining", "10")
+		w.Write([]byte(`{"id":"1","object":"chat.completion","choices":[]}`))
+	}))
+	defer upstream.Close()
+
+	apiKey := "sk-upstream"
+	conf := &ConfigFile{
+		Models: map[string]*Model{
+			"plain": {Backends: map[string]*Backend{"default:1": {BaseURL: upstream.URL, APIKey: &apiKey}}},
+			"configured": {Backends: map[string]*Backend{"default:1": {
+				BaseURL: upstream.URL,
+				APIKey:  &apiKey,
+				Headers: &engines.HeaderPolicy{
+					Set:          map[string]string{"Cookie": "upstream-session"},
+					ResponseDeny: []string{"X-RateLimit-*"},
+				},
+			}}},
+		},
+	}
+	repo := NewModelRepoFileBased()
+	require.NoError(t, repo.UpdateFromConfig(conf))
+
+	process := func(model string) *octollm.Response {
+		httpReq, err := http.NewRequestWithContext(context.Background(), http.MethodPost, "http://localhost/v1/chat/completions",
+			strings.NewReader(`{"model":"`+model+`","messages":[]}`))
+		require.NoError(t, err)
+		httpReq.Header.Set("Authorization", "Bearer gateway-key")
+		httpReq.Header.Set("Cookie", "session=client")
+		httpReq.Header.Set("X-Api-Key", "gateway-key")
+		httpReq.Header.Set("Accept-Encoding", "br")
+		httpReq.Header.Set("X-Request-Id", "r1")
+		engine, err := repo.GetEngine(model, "default:1")
+		require.NoError(t, err)
+		resp, err := engine.Process(octollm.NewRequest(httpReq, octollm.APIFormatChatCompletions))
+		require.NoError(t, err)
+		return resp
+	}
+
+	// a backend without headers gets neither the credentials nor the cookies of the client
+	resp := process("plain")
+	assert.Equal(t, "Bearer sk-upstream", upstreamHeader.Get("Authorization"))
+	assert.Empty(t, upstreamHeader.Get("Cookie"))
+	assert.Empty(t, upstreamHeader.Get("X-Api-Key"))
+	assert.NotEqual(t, "br", upstreamHeader.Get("Accept-Encoding"))
+	assert.Equal(t, "r1", upstreamHeader.Get("X-Request-Id"))
+	assert.Empty(t, resp.Header.Get("Set-Cookie"))
+	assert.Equal(t, "10", resp.Header.Get("X-Ratelimit-Remaining"))
+
+	// the headers of a backend extend the defaults
+	resp = process("configured")
+	assert.Equal(t, "Bearer sk-upstream", upstreamHeader.Get("Authorization"))
+	assert.Equal(t, "upstream-session", upstreamHeader.Get("Cookie"))
+	assert.Empty(t, upstreamHeader.Get("X-Api-Key"))
+	assert.Empty(t, resp.Header.Get("Set-Cookie"))
+	assert.Empty(t, resp.Header.Get("X-Ratelimit-Remaining"))
+}
@@ -1,52 +1,307 @@
 package composer
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
-	"time"
 
 	anthropicSDK "github.com/anthropics/anthropic-sdk-go"
 	openaiSDK "github.com/openai/openai-go/v3"
 	"github.com/sirupsen/logrus"
 
+	"github.com/infinigence/octollm/pkg/engines"
+	"github.com/infinigence/octollm/pkg/engines/limiter"
 	loadbalancer "github.com/infinigence/octollm/pkg/engines/load-balancer"
 	ruleengine "github.com/infinigence/octollm/pkg/engines/rule-engine"
 	"github.com/infinigence/octollm/pkg/errutils"
+	"github.com/infinigence/octollm/pkg/metrics"
 	"github.com/infinigence/octollm/pkg/octollm"
 	"github.com/infinigence/octollm/pkg/types/anthropic"
 	"github.com/infinigence/octollm/pkg/types/openai"
+	"github.com/infinigence/octollm/pkg/types/rerank"
+	"github.com/infinigence/octollm/pkg/types/vertex"
 )
 
 type RuleComposerFileBased struct {
 	mu sync.RWMutex
 
-	modelRepo      ModelRepo
-	conf           *ConfigFile
-	lbRetryTimeout time.Duration
-	lbRetryCount   int
+	modelRepo    ModelRepo
+	conf         *ConfigFile
+	retryPolicy  loadbalancer.RetryPolicy
+	limiterStore limiter.Store
+	matchers     map[*RuleConfig]ruleengine.Matcher // compiled match expressions of the rules of conf
+	experiments  map[string]*engines.Experiment     // experiments of conf, ramped at runtime
+	ruleStats    []*ruleStatsEntry                  // stats of the rules of conf, in the order of the config
 
 	orgModelEngine map[string]map[string]octollm.Engine // orgName -> modelName -> engine
 }
 
-func NewRuleRepoFileBased(modelRepo ModelRepo, lbRetryTimeout time.Duration, lbRetryCount int) *RuleComposerFileBased {
+func NewRuleRepoFileBased(modelRepo ModelRepo) *RuleComposerFileBased {
 	return &RuleComposerFileBased{
 		modelRepo:      modelRepo,
-		lbRetryTimeout: lbRetryTimeout,
-		lbRetryCount:   lbRetryCount,
+		retryPolicy:    loadbalancer.DefaultRetryPolicy,
+		limiterStore:   limiter.NewMemoryStore(),
 		orgModelEngine: make(map[string]map[string]octollm.Engine),
 	}
 }
 
 func (r *RuleComposerFileBased) UpdateFromConfig(conf *ConfigFile) error {
+	if err := validateRuleChains(conf); err != nil {
+		return fmt.Errorf("invalid rules: %w", err)
+	}
+	matchers, err := compileMatchers(conf)
+	if err != nil {
+		return fmt.Errorf("invalid rules: %w", err)
+	}
+	experiments, err := buildExperiments(conf)
+	if err != nil {
+		return fmt.Errorf("invalid experiments: %w", err)
+	}
+	ruleStats := newRuleStats(conf)
+
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	r.conf = conf
+	r.matchers = matchers
+	r.experiments = experiments
+	r.ruleStats = ruleStats
+	if conf.Limiter != nil {
+		r.limiterStore = conf.Limiter.Store()
+	}
+	if conf.Retry != nil {
+		r.retryPolicy = conf.Retry.RetryPolicy()
+	}
+
+	return nil
+}
+
+// validateRuleChains checks the actions of all rules, and that their jumps and calls reach rule_chains without loops.
+func validateRuleChains(conf *ConfigFile) error {
+	// only the actions are checked, the rules handling requests get a placeholder engine
+	placeholder := ruleengine.SideEffect(func(*octollm.Request) {})
+	toChain := func(ruleConfs RuleList) (ruleengine.RuleChain, error) {
+		chain := make(ruleengine.RuleChain, 0, len(ruleConfs))
+		for _, ruleConf := range ruleConfs {
+			if err := ruleConf.validateAction(); err != nil {
+				return nil, err
+			}
+			rule := ruleengine.Rule{Name: ruleConf.Name, Action: ruleengine.RuleEngineAction(ruleConf.Action), Chain: ruleConf.Chain}
+			if rule.Action == "" && ruleConf.isTagOnly() {
+				rule.Action = ruleengine.RuleEngineActionContinue
+			}
+			if rule.Action == "" {
+				rule.Engine = placeholder
+			}
+			chain = append(chain, rule)
+		}
+		return chain, nil
+	}
+
+	chains := make(map[string]ruleengine.RuleChain, len(conf.RuleChains)+1)
+	for name, ruleConfs := range conf.RuleChains {
+		if name == "" || name == "default" {
+			return fmt.Errorf("rule chain name %q is reserved", name)
+		}
+		chain, err := toChain(ruleConfs)
+		if err != nil {
+			return fmt.Errorf("rule chain %s: %w", name, err)
+		}
+		chains[name] = chain
+	}
+
+	validate := func(ruleConfs RuleList) error {
+		chain, err := toChain(ruleConfs)
+		if err != nil {
+			return err
+		}
+		chains["default"] = chain
+		return (&ruleengine.RuleEngine{Chains: chains}).Validate()
+	}
+	for modelName, model := range conf.Models {
+		if err := validate(model.DefaultRules); err != nil {
+			return fmt.Errorf("default rules of model %s: %w", modelName, err)
+		}
+	}
+	for orgName, org := range conf.Users {
+		for modelName, orgModel := range org.Models {
+			if err := validate(orgModel.Rules); err != nil {
+				return fmt.Errorf("rules of org %s model %s: %w", orgName, modelName, err)
+			}
+		}
+	}
+	return nil
+}
+
+// compileMatchers compiles the match expressions of all rules, so that errors show up when the config is loaded.
+func compileMatchers(conf *ConfigFile) (map[*RuleConfig]ruleengine.Matcher, error) {
+	matchers := make(map[*RuleConfig]ruleengine.Matcher)
+	err := forEachRule(conf, func(_ string, ruleConf *RuleConfig) error {
+		matcher, err := newMatcher(ruleConf)
+		if err != nil {
+			return fmt.Errorf("rule %s: %w", ruleConf.Name, err)
+		}
+		matchers[ruleConf] = matcher
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return matchers, nil
+}
+
+// forEachRule calls f with all rules of conf and the rule list they are in, e.g. "default rules of model m".
+// The error tells where the failing rule is.
+func forEachRule(conf *ConfigFile, f func(where string, ruleConf *RuleConfig) error) error {
+	each := func(where string, ruleConfs RuleList) error {
+		for _, ruleConf := range ruleConfs {
+			if err := f(where, ruleConf); err != nil {
+				return fmt.Errorf("%s: %w", where, err)
+			}
+		}
+		return nil
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(conf.RuleChains)) {
+		if err := each(fmt.Sprintf("rule chain %s", name), conf.RuleChains[name]); err != nil {
+			return err
+		}
+	}
+	for _, modelName := range slices.Sorted(maps.Keys(conf.Models)) {
+		if err := each(fmt.Sprintf("default rules of model %s", modelName), conf.Models[modelName].DefaultRules); err != nil {
+			return err
+		}
+	}
+	for _, orgName := range slices.Sorted(maps.Keys(conf.Users)) {
+		org := conf.Users[orgName]
+		for _, modelName := range slices.Sorted(maps.Keys(org.Models)) {
+			if err := each(fmt.Sprintf("rules of org %s model %s", orgName, modelName), org.Models[modelName].Rules); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ruleStatsEntry is the stats of a rule of the config, shared by the rules built from it.
+type ruleStatsEntry struct {
+	where    string
+	ruleConf *RuleConfig
+	stats    *ruleengine.RuleStats
+}
+
+func newRuleStats(conf *ConfigFile) []*ruleStatsEntry {
+	var entries []*ruleStatsEntry
+	_ = forEachRule(conf, func(where string, ruleConf *RuleConfig) error {
+		entries = append(entries, &ruleStatsEntry{where: where, ruleConf: ruleConf, stats: &ruleengine.RuleStats{}})
+		return nil
+	})
+	return entries
+}
+
+// statsOf returns the stats of a rule of the config, nil for rules not in it.
+func (r *RuleComposerFileBased) statsOf(ruleConf *RuleConfig) *ruleengine.RuleStats {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, entry := range r.ruleStats {
+		if entry.ruleConf == ruleConf {
+			return entry.stats
+		}
+	}
+	return nil
+}
+
+// RuleStats is the stats of a rule, as reported by the admin endpoint.
+type RuleStats struct {
+	Where string `json:"where"` // the rule list of the rule, e.g. "default rules of model m"
+	Name  string `json:"name"`
+	Mode  string `json:"mode"`
+	ruleengine.RuleStatsSnapshot
+}
+
+// RuleStats returns the stats of all rules of the config, in order. The rules of a named chain count the requests
+// of all models and orgs reaching them.
+func (r *RuleComposerFileBased) RuleStats() []RuleStats {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	stats := make([]RuleStats, 0, len(r.ruleStats))
+	for _, entry := range r.ruleStats {
+		mode := entry.ruleConf.Mode
+		if mode == "" {
+			mode = RuleModeEnforce
+		}
+		stats = append(stats, RuleStats{
+			Where:             entry.where,
+			Name:              entry.ruleConf.Name,
+			Mode:              mode,
+			RuleStatsSnapshot: entry.stats.Snapshot(),
+		})
+	}
+	return stats
+}
+
+// buildExperiments builds the experiments of conf, and checks that the rules use known ones.
+func buildExperiments(conf *ConfigFile) (map[string]*engines.Experiment, error) {
+	experiments := make(map[string]*engines.Experiment, len(conf.Experiments))
+	for name, experimentConf := range conf.Experiments {
+		x, err := experimentConf.Experiment(name)
+		if err != nil {
+			return nil, err
+		}
+		experiments[name] = x
+	}
+	err := forEachRule(conf, func(_ string, ruleConf *RuleConfig) error {
+		if _, ok := experiments[ruleConf.Experiment]; ruleConf.Experiment != "" && !ok {
+			return fmt.Errorf("rule %s: unknown experiment %q", ruleConf.Name, ruleConf.Experiment)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return experiments, nil
+}
+
+// Experiments returns the current weights of the arms of the experiments, by experiment name.
+func (r *RuleComposerFileBased) Experiments() map[string][]engines.ArmWeight {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	weights := make(map[string][]engines.ArmWeight, len(r.experiments))
+	for name, x := range r.experiments {
+		weights[name] = x.Weights()
+	}
+	return weights
+}
 
+// RampExperiment changes the weights of arms of an experiment, the engines already built included.
+// The weights are those of the config again when the server restarts.
+func (r *RuleComposerFileBased) RampExperiment(name string, weights map[string]int) error {
+	r.mu.RLock()
+	x, ok := r.experiments[name]
+	r.mu.RUnlock()
+	if !ok {
+		return errutils.NewHandlerError(fmt.Errorf("experiment %s not found", name), http.StatusNotFound, "Experiment Not Found")
+	}
+	if err := x.SetWeights(weights); err != nil {
+		return errutils.NewHandlerError(err, http.StatusBadRequest, err.Error())
+	}
+	logrus.Infof("experiment %s ramped to %v", name, x.Weights())
 	return nil
 }
 
+func newMatcher(ruleConf *RuleConfig) (ruleengine.Matcher, error) {
+	if ruleConf.MatchExpr == "" {
+		return ruleengine.AlwaysTrueMatcher, nil
+	}
+	return ruleengine.NewExprMatcher(ruleConf.MatchExpr,
+		&ruleengine.SimpleFeatureExtractor{PrefixHashLen: []int{20}, SuffixHashLen: []int{20}})
+}
+
 func (r *RuleComposerFileBased) getEngine(orgName, modelName string) (octollm.Engine, error) {
 	r.mu.RLock()
 	if engine, ok := r.orgModelEngine[orgName][modelName]; ok {
@@ -100,9 +355,6 @@ func (r *RuleComposerFileBased) getEngine(orgName, modelName string) (octollm.En
 	if orgModelConf != nil {
 		finalOrgLimits = orgModelConf.OrgLimits
 	}
-	// TODO: org limits not implemented
-	_ = finalOrgLimits
-
 	var engine octollm.Engine
 	defaultEngine, err := r.buildDefaultEngine(modelName)
 	if err != nil {
@@ -115,12 +367,18 @@ func (r *RuleComposerFileBased) getEngine(orgName, modelName string) (octollm.En
 		engine = defaultEngine
 	} else {
 		var err error
-		engine, err = r.buildEngineByRuleList(finalRules, modelName, defaultEngine)
+		engine, err = r.buildEngineByRuleList(finalRules, orgName, modelName, defaultEngine)
 		if err != nil {
 			return nil, fmt.Errorf("failed to build engine by rule list: %w", err)
 		}
 	}
 
+	// org limits are shared by all users of the org, requests over them are always denied
+	if finalOrgLimits != nil && orgName != "" {
+		engine = limiter.NewLimiterEngine(engine, r.limiterStore,
+			fmt.Sprintf("org:%s:model:%s", orgName, modelName), finalOrgLimits.Limits(false))
+	}
+
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
@@ -159,49 +417,181 @@ func (r *RuleComposerFileBased) buildDefaultEngine(modelName string) (octollm.En
 	if len(lbItems) == 0 {
 		return nil, fmt.Errorf("no default backend found for model %s", modelName)
 	}
-	lb, err := loadbalancer.NewWeightedRoundRobin(lbItems, r.lbRetryTimeout, r.lbRetryCount)
+	lb, err := r.buildLoadBalancer(modelName, lbItems)
 	if err != nil {
-		return nil, fmt.Errorf("failed to build weighted round robin load balancer: %w", err)
+		return nil, fmt.Errorf("failed to build load balancer: %w", err)
 	}
 
 	return lb, nil
 }
 
-func (r *RuleComposerFileBased) buildEngineByRuleList(ruleConfs RuleList, modelName string, defaultEngine octollm.Engine) (octollm.Engine, error) {
+// buildLoadBalancer builds a load balancer over the backends by the strategy of the model.
+// Backends of each tier are balanced separately, and requests fail over from a tier to the next.
+// Slow requests are hedged if the model has hedging configured.
+func (r *RuleComposerFileBased) buildLoadBalancer(modelName string, lbItems []loadbalancer.BackendItem) (octollm.Engine, error) {
+	r.mu.RLock()
+	strategy := ""
+	affinity := &AffinityConfig{}
+	var hedging *HedgingConfig
+	if model, ok := r.conf.Models[modelName]; ok {
+		strategy = model.Strategy
+		if model.Affinity != nil {
+			affinity = model.Affinity
+		}
+		hedging = model.Hedging
+	}
+	retryPolicy := r.retryPolicy
+	r.mu.RUnlock()
+
+	lb, err := r.buildTiers(modelName, strategy, affinity, lbItems, retryPolicy)
+	if err != nil || hedging == nil {
+		return lb, err
+	}
+	return loadbalancer.NewHedged(lb, hedging.HedgePolicy())
+}
+
+func (r *RuleComposerFileBased) buildTiers(modelName, strategy string, affinity *AffinityConfig, lbItems []loadbalancer.BackendItem, retryPolicy loadbalancer.RetryPolicy) (octollm.Engine, error) {
+	tierItems := make(map[int][]loadbalancer.BackendItem)
+	for _, item := range lbItems {
+		tier := r.modelRepo.GetBackendTier(modelName, item.Name)
+		tierItems[tier] = append(tierItems[tier], item)
+	}
+	if len(tierItems) <= 1 {
+		return newLoadBalancer(strategy, affinity, lbItems, retryPolicy)
+	}
+
+	tiers := slices.Sorted(maps.Keys(tierItems))
+	tierEngines := make([]octollm.Engine, 0, len(tiers))
+	for _, tier := range tiers {
+		lb, err := newLoadBalancer(strategy, affinity, tierItems[tier], retryPolicy)
+		if err != nil {
+			return nil, fmt.Errorf("tier %d: %w", tier, err)
+		}
+		tierEngines = append(tierEngines, lb)
+	}
+	return loadbalancer.NewFailover(tierEngines, retryPolicy)
+}
+
+func newLoadBalancer(strategy string, affinity *AffinityConfig, lbItems []loadbalancer.BackendItem, retryPolicy loadbalancer.RetryPolicy) (octollm.Engine, error) {
+	switch strategy {
+	case "", LBStrategyWeightedRoundRobin:
+		return loadbalancer.NewWeightedRoundRobin(lbItems, retryPolicy)
+	case LBStrategyLeastInflight:
+		return loadbalancer.NewLeastInflight(lbItems, retryPolicy)
+	case LBStrategyPeakEWMA:
+		return loadbalancer.NewPeakEWMA(lbItems, retryPolicy)
+	case LBStrategyPowerOfTwo:
+		return loadbalancer.NewPowerOfTwoChoices(lbItems, retryPolicy)
+	case LBStrategyConsistentHash:
+		keyOf, err := affinity.KeyFunc()
+		if err != nil {
+			return nil, err
+		}
+		return loadbalancer.NewConsistentHash(lbItems, retryPolicy, keyOf, affinity.LoadFactor)
+	default:
+		return nil, fmt.Errorf("unsupported strategy: %s", strategy)
+	}
+}
+
+func (r *RuleComposerFileBased) buildEngineByRuleList(ruleConfs RuleList, orgName, modelName string, defaultEngine octollm.Engine) (octollm.Engine, error) {
+	rules, err := r.buildRuleChain(ruleConfs, orgName, modelName, defaultEngine)
+	if err != nil {
+		return nil, err
+	}
+	chains := map[string]ruleengine.RuleChain{
+		"default": rules,
+	}
+
+	// the named chains reached by jumps and calls are built for the org and model
+	r.mu.RLock()
+	namedChains := r.conf.RuleChains
+	r.mu.RUnlock()
+	pending := chainTargets(ruleConfs)
+	for len(pending) > 0 {
+		name := pending[0]
+		pending = pending[1:]
+		if _, ok := chains[name]; ok {
+			continue
+		}
+		chainConfs, ok := namedChains[name]
+		if !ok {
+			return nil, fmt.Errorf("rule chain %s not found", name)
+		}
+		chain, err := r.buildRuleChain(chainConfs, orgName, modelName, defaultEngine)
+		if err != nil {
+			return nil, fmt.Errorf("rule chain %s: %w", name, err)
+		}
+		chains[name] = chain
+		pending = append(pending, chainTargets(chainConfs)...)
+	}
+
+	// requests not handled by any rule go to the default backends
+	re := &ruleengine.RuleEngine{Chains: chains, Fallback: defaultEngine}
+	if err := re.Validate(); err != nil {
+		return nil, err
+	}
+	return re, nil
+}
+
+func (r *RuleComposerFileBased) buildRuleChain(ruleConfs RuleList, orgName, modelName string, defaultEngine octollm.Engine) (ruleengine.RuleChain, error) {
 	rules := make(ruleengine.RuleChain, 0, len(ruleConfs))
 	for _, ruleConf := range ruleConfs {
-		rule, err := r.buildRuleEngineRuleByConfig(ruleConf, modelName, defaultEngine)
+		rule, err := r.buildRuleEngineRuleByConfig(ruleConf, orgName, modelName, defaultEngine)
 		if err != nil {
 			return nil, fmt.Errorf("failed to build rule engine rule by config: %w", err)
 		}
+		rule.Audit = ruleConf.Mode == RuleModeAudit
+		rule.Effect = ruleConf.effect()
+		rule.Stats = r.statsOf(ruleConf)
 		rules = append(rules, *rule)
 	}
+	return rules, nil
+}
 
-	// add a fallback rule
-	rules = append(rules, ruleengine.Rule{
-		Name:    "fallback",
-		Matcher: ruleengine.AlwaysTrueMatcher,
-		Engine:  defaultEngine,
-	})
-
-	re := &ruleengine.RuleEngine{
-		Chains: map[string]ruleengine.RuleChain{
-			"default": rules,
-		},
+// chainTargets returns the chains the rules jump to or call.
+func chainTargets(ruleConfs RuleList) []string {
+	var targets []string
+	for _, ruleConf := range ruleConfs {
+		switch ruleengine.RuleEngineAction(ruleConf.Action) {
+		case ruleengine.RuleEngineActionJump, ruleengine.RuleEngineActionCall:
+			targets = append(targets, ruleConf.Chain)
+		}
 	}
-
-	return re, nil
+	return targets
 }
 
-func (r *RuleComposerFileBased) buildRuleEngineRuleByConfig(ruleConf *RuleConfig, modelName string, defaultEngine octollm.Engine) (*ruleengine.Rule, error) {
-	var matcher ruleengine.Matcher
-	if ruleConf.MatchExpr == "" {
-		matcher = ruleengine.AlwaysTrueMatcher
-	} else {
-		matcher = &ruleengine.ExprMatcher{
-			Code:             ruleConf.MatchExpr,
-			FeatureExtractor: &ruleengine.SimpleFeatureExtractor{PrefixHashLen: []int{20}, SuffixHashLen: []int{20}},
+func (r *RuleComposerFileBased) buildRuleEngineRuleByConfig(ruleConf *RuleConfig, orgName, modelName string, defaultEngine octollm.Engine) (*ruleengine.Rule, error) {
+	r.mu.RLock()
+	matcher, ok := r.matchers[ruleConf]
+	r.mu.RUnlock()
+	if !ok {
+		var err error
+		if matcher, err = newMatcher(ruleConf); err != nil {
+			return nil, fmt.Errorf("rule %s: %w", ruleConf.Name, err)
+		}
+	}
+
+	if ruleConf.Action != "" {
+		if err := ruleConf.validateAction(); err != nil {
+			return nil, err
 		}
+		return &ruleengine.Rule{
+			Name:    ruleConf.Name,
+			Matcher: matcher,
+			Action:  ruleengine.RuleEngineAction(ruleConf.Action),
+			Chain:   ruleConf.Chain,
+			Tags:    ruleConf.AddTags,
+		}, nil
+	}
+
+	if ruleConf.isTagOnly() {
+		// the rule classifies the traffic, later rules act on it
+		return &ruleengine.Rule{
+			Name:    ruleConf.Name,
+			Matcher: matcher,
+			Action:  ruleengine.RuleEngineActionContinue,
+			Tags:    ruleConf.AddTags,
+		}, nil
 	}
 
 	if ruleConf.Deny != nil {
@@ -209,12 +599,51 @@ func (r *RuleComposerFileBased) buildRuleEngineRuleByConfig(ruleConf *RuleConfig
 			Name:    ruleConf.Name,
 			Matcher: matcher,
 			Engine:  ruleConf.Deny,
+			Tags:    ruleConf.AddTags,
 		}, nil
 	}
 
-	// forward with load balancer
-	lbItems := make([]loadbalancer.BackendItem, 0, len(ruleConf.ForwardWeights))
-	for backendName, weight := range ruleConf.ForwardWeights {
+	var engine octollm.Engine
+	var err error
+	if ruleConf.Experiment != "" {
+		engine, err = r.buildExperimentEngine(ruleConf.Experiment, modelName, defaultEngine)
+	} else {
+		engine, err = r.buildForwardEngine(ruleConf.ForwardWeights, modelName, defaultEngine)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if ruleConf.Mirror != nil {
+		if err := ruleConf.validateMirror(); err != nil {
+			return nil, err
+		}
+		shadow, err := r.modelRepo.GetEngine(modelName, ruleConf.Mirror.Backend)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: mirror backend %s: %w", ruleConf.Name, ruleConf.Mirror.Backend, err)
+		}
+		engine = engines.NewMirrorEngine(engine, shadow, ruleConf.Mirror.Backend, ruleConf.Mirror.MirrorPolicy())
+	}
+
+	if ruleConf.RuleLimits != nil {
+		engine = limiter.NewLimiterEngine(engine, r.limiterStore,
+			fmt.Sprintf("org:%s:model:%s:rule:%s", orgName, modelName, ruleConf.Name),
+			ruleConf.RuleLimits.Limits(!ruleConf.RuleLimits.DenyWhenExceeding))
+	}
+
+	rule := &ruleengine.Rule{
+		Name:    ruleConf.Name,
+		Matcher: matcher,
+		Engine:  engine,
+		Tags:    ruleConf.AddTags,
+	}
+	return rule, nil
+}
+
+// buildForwardEngine builds a load balancer over the backends of forwardWeights, defaultEngine if there are none.
+func (r *RuleComposerFileBased) buildForwardEngine(forwardWeights map[string]int, modelName string, defaultEngine octollm.Engine) (octollm.Engine, error) {
+	lbItems := make([]loadbalancer.BackendItem, 0, len(forwardWeights))
+	for backendName, weight := range forwardWeights {
 		engine, err := r.modelRepo.GetEngine(modelName, backendName)
 		if err != nil {
 			logrus.Warnf("failed to get engine for backend %s: %v", backendName, err)
@@ -228,35 +657,57 @@ func (r *RuleComposerFileBased) buildRuleEngineRuleByConfig(ruleConf *RuleConfig
 		})
 	}
 
-	engine := defaultEngine
-	if len(lbItems) > 0 {
-		lb, err := loadbalancer.NewWeightedRoundRobin(lbItems, r.lbRetryTimeout, r.lbRetryCount)
-		if err != nil {
-			return nil, fmt.Errorf("failed to build weighted round robin load balancer: %w", err)
-		}
-		engine = lb
+	if len(lbItems) == 0 {
+		return defaultEngine, nil
+	}
+	lb, err := r.buildLoadBalancer(modelName, lbItems)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build load balancer: %w", err)
 	}
+	return lb, nil
+}
 
-	rule := &ruleengine.Rule{
-		Name:    ruleConf.Name,
-		Matcher: matcher,
-		Engine:  engine,
+// buildExperimentEngine builds the arms of an experiment for a model.
+func (r *RuleComposerFileBased) buildExperimentEngine(name, modelName string, defaultEngine octollm.Engine) (octollm.Engine, error) {
+	r.mu.RLock()
+	x, ok := r.experiments[name]
+	experimentConf := r.conf.Experiments[name]
+	r.mu.RUnlock()
+	if !ok || experimentConf == nil {
+		return nil, fmt.Errorf("experiment %s not found", name)
 	}
-	return rule, nil
+
+	arms := make(map[string]octollm.Engine, len(experimentConf.Arms))
+	for _, armConf := range experimentConf.Arms {
+		engine, err := r.buildForwardEngine(armConf.ForwardWeights, modelName, defaultEngine)
+		if err != nil {
+			return nil, fmt.Errorf("experiment %s arm %s: %w", name, armConf.Name, err)
+		}
+		if engine == nil {
+			return nil, fmt.Errorf("experiment %s arm %s: no backend for model %s", name, armConf.Name, modelName)
+		}
+		if armConf.RequestRewrites != nil || armConf.ResponseRewrites != nil || armConf.StreamChunkRewrites != nil {
+			engine = engines.NewRewriteEngine(engine, armConf.RequestRewrites, armConf.ResponseRewrites, armConf.StreamChunkRewrites)
+		}
+		arms[armConf.Name] = engine
+	}
+	return &engines.ExperimentEngine{Experiment: x, Arms: arms}, nil
 }
 
 func (r *RuleComposerFileBased) GetEngine(userName, orgName, modelName string) *RuleComposerEngine {
 	return &RuleComposerEngine{
 		RuleComposerFileBased: r,
 		Model:                 modelName,
+		UserName:              userName,
 		OrgName:               orgName,
 	}
 }
 
 type RuleComposerEngine struct {
 	*RuleComposerFileBased
-	Model   string
-	OrgName string
+	Model    string
+	UserName string
+	OrgName  string
 }
 
 var _ octollm.Engine = (*RuleComposerEngine)(nil)
@@ -273,10 +724,28 @@ func (r *RuleComposerEngine) Process(req *octollm.Request) (*octollm.Response, e
 			r.Model = body.Model
 		case *openaiSDK.ChatCompletionNewParams:
 			r.Model = body.Model
+		case *openai.CompletionNewParams:
+			r.Model = string(body.Model)
+		case *openai.EmbeddingNewParams:
+			r.Model = string(body.Model)
+		case *rerank.Request:
+			r.Model = body.Model
 		case *anthropic.MessageNewParams:
 			r.Model = string(body.Model)
 		case *anthropicSDK.MessageNewParams:
 			r.Model = string(body.Model)
+		case *openai.ResponseNewParams:
+			r.Model = body.Model
+		case *vertex.GenerateContentRequest:
+			// vertex requests carry the model in the path
+			if req.URL == nil {
+				return nil, fmt.Errorf("no url in vertex request")
+			}
+			model, _, err := vertex.ParseModelMethod(req.URL.Path)
+			if err != nil {
+				return nil, errutils.NewHandlerError(err, http.StatusNotFound, "Not Found")
+			}
+			r.Model = model
 		default:
 			return nil, fmt.Errorf("unsupported model request type: %T", body)
 		}
@@ -286,5 +755,155 @@ func (r *RuleComposerEngine) Process(req *octollm.Request) (*octollm.Response, e
 	if err != nil {
 		return nil, fmt.Errorf("failed to get engine: %w", err)
 	}
-	return engine.Process(req)
+	req = req.WithContext(octollm.WithIdentity(req.Context(), octollm.Identity{User: r.UserName, Org: r.OrgName}))
+	req.Model = r.Model
+
+	fallbacks := r.fallbacksOf(r.Model)
+	primaryReq := req
+	var snapshot *octollm.RequestSnapshot
+	if len(fallbacks) > 0 {
+		// backends convert and rewrite the request in place, the fallbacks must get it as it came
+		if snapshot, err = req.Snapshot(); err != nil {
+			return nil, err
+		}
+		primaryReq = snapshot.Restore(req)
+	}
+	resp, err := engine.Process(primaryReq)
+	if err == nil {
+		resp = withServedModel(resp, r.Model)
+	} else if snapshot != nil {
+		resp, err = r.fallback(req, snapshot, fallbacks, resp, err)
+	}
+	countRequest(req, r.Model, err)
+	return resp, err
+}
+
+var requestsTotal = metrics.Default.Counter("octollm_requests_total", "Requests by model, status and request tags.")
+
+// countRequest counts a request by its model, status and tags, each tag is a label named tag_<key>.
+func countRequest(req *octollm.Request, model string, err error) {
+	status := http.StatusOK
+	if err != nil {
+		httpErr := &errutils.UpstreamRespError{}
+		if errors.As(err, &httpErr) {
+			status = httpErr.StatusCode
+		} else {
+			status = errutils.AsHandlerError(err).StatusCode
+		}
+	}
+	labels := map[string]string{"model": model, "status": strconv.Itoa(status)}
+	for k, v := range req.Tags().Map() {
+		labels["tag_"+metrics.LabelName(k)] = v
+	}
+	requestsTotal.Inc(labels)
+}
+
+// ServedModelHeader tells the client which model served the request, which differs from the requested one
+// when the request fell back to another model.
+const ServedModelHeader = "X-Octollm-Model"
+
+// fallbacksOf returns the fallback models of a model.
+func (r *RuleComposerEngine) fallbacksOf(modelName string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if model, ok := r.conf.Models[modelName]; ok {
+		return model.Fallbacks
+	}
+	return nil
+}
+
+// fallback sends a request failed on the requested model to the fallbacks of the model in order, as long as the
+// failure is that the model is unavailable upstream, see shouldFallBack. Each fallback gets the request as it was at
+// the snapshot, before any backend changed it. The fallbacks are subject to their own access rules, rules, limits
+// and rewrites; fallbacks of fallbacks are not followed.
+func (r *RuleComposerEngine) fallback(req *octollm.Request, snapshot *octollm.RequestSnapshot, fallbacks []string,
+	resp *octollm.Response, err error) (*octollm.Response, error) {
+	for _, fallbackModel := range fallbacks {
+		if !shouldFallBack(req, err) {
+			break
+		}
+		logger := logrus.WithContext(req.Context())
+		engine, getErr := r.getEngine(r.OrgName, fallbackModel)
+		if getErr != nil {
+			logger.Warnf("[fallback] skip fallback model %s: %v", fallbackModel, getErr)
+			continue
+		}
+		fallbackReq, reqErr := requestForModel(snapshot.Restore(req), fallbackModel)
+		if reqErr != nil {
+			logger.Warnf("[fallback] skip fallback model %s: %v", fallbackModel, reqErr)
+			continue
+		}
+		logger.Warnf("[fallback] model %s failed, fall back to %s: %v", r.Model, fallbackModel, err)
+		resp, err = engine.Process(fallbackReq)
+		if err == nil {
+			return withServedModel(resp, fallbackModel), nil
+		}
+	}
+	return resp, err
+}
+
+// shouldFallBack tells whether a request failed with err because the model is unavailable upstream: its backends
+// could not be reached, answered with 429 or 5xx, or were all taken out of rotation. The errors of the gateway
+// itself, e.g. org limits exceeded or a deny rule, never fall back, another model must not get around them.
+func shouldFallBack(req *octollm.Request, err error) bool {
+	if err == nil || req.Context().Err() != nil {
+		return false
+	}
+	if errors.Is(err, loadbalancer.ErrBackendUnavailable) {
+		return true
+	}
+	respErr := &errutils.UpstreamRespError{}
+	if errors.As(err, &respErr) {
+		return respErr.StatusCode == http.StatusTooManyRequests || respErr.StatusCode >= 500
+	}
+	httpErr := &errutils.UpstreamHTTPError{}
+	return errors.As(err, &httpErr)
+}
+
+// requestForModel returns a copy of req for another model, with the model field of the body (or the model in the
+// path for vertex requests) rewritten.
+func requestForModel(req *octollm.Request, model string) (*octollm.Request, error) {
+	newReq := req.WithContext(req.Context())
+	newReq.Model = model
+	if req.Format == octollm.APIFormatVertexGenerateContent {
+		if req.URL == nil {
+			return nil, fmt.Errorf("no url in vertex request")
+		}
+		_, method, err := vertex.ParseModelMethod(req.URL.Path)
+		if err != nil {
+			return nil, err
+		}
+		u := *req.URL
+		u.Path = u.Path[:strings.LastIndex(u.Path, "/models/")+len("/models/")] + model + ":" + method
+		u.RawPath = ""
+		newReq.URL = &u
+		return newReq, nil
+	}
+
+	b, err := req.Body.Bytes()
+	if err != nil {
+		return nil, fmt.Errorf("read request body failed: %w", err)
+	}
+	body := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(b, &body); err != nil {
+		return nil, fmt.Errorf("unmarshal request body failed: %w", err)
+	}
+	body["model"], err = json.Marshal(model)
+	if err != nil {
+		return nil, err
+	}
+	b, err = json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body failed: %w", err)
+	}
+	newReq.Body = octollm.NewBodyFromBytes(b, octollm.NewRequestParser(req.Format))
+	return newReq, nil
+}
+
+func withServedModel(resp *octollm.Response, model string) *octollm.Response {
+	if resp.Header == nil {
+		resp.Header = make(http.Header)
+	}
+	resp.Header.Set(ServedModelHeader, model)
+	return resp
 }
@@ -0,0 +1,480 @@
+package composer
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	anthropicSDK "github.com/anthropics/anthropic-sdk-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/infinigence/octollm/pkg/engines"
+	"github.com/infinigence/octollm/pkg/engines/converter"
+	"github.com/infinigence/octollm/pkg/errutils"
+	"github.com/infinigence/octollm/pkg/octollm"
+)
+
+// fakeModelRepo serves the backends of the config with the given engines.
+type fakeModelRepo struct {
+	conf    *ConfigFile
+	engines map[string]octollm.Engine // modelName/backendName -> engine
+}
+
+func (f *fakeModelRepo) GetBackendNamesByModel(modelName string) []string {
+	var names []string
+	for name := range f.conf.Models[modelName].Backends {
+		names = append(names, name)
+	}
+	return names
+}
+
+func (f *fakeModelRepo) GetBackendTier(modelName, backendName string) int {
+	return f.conf.Models[modelName].Backends[backendName].Tier
+}
+
+func (f *fakeModelRepo) GetEngine(modelName, backendName string) (octollm.Engine, error) {
+	return f.engines[modelName+"/"+backendName], nil
+}
+
+func TestRuleComposerEngine_TiersAndFallbacks(t *testing.T) {
+	oneAttempt := 1
+	conf := &ConfigFile{
+		Models: map[string]*Model{
+			"primary": {
+				Backends: map[string]*Backend{
+					"default:main":     {},
+					"default:overflow": {Tier: 1},
+				},
+				Fallbacks: []string{"private", "secondary"},
+			},
+			"secondary": {Backends: map[string]*Backend{"default:1": {}}},
+			"private":   {Access: ModelAccessPrivate, Backends: map[string]*Backend{"default:1": {}}},
+		},
+		Retry: &RetryConfig{MaxAttempts: &oneAttempt},
+	}
+
+	statuses := map[string]int{}
+	var servedBodies []string
+	engines := map[string]octollm.Engine{}
+	for _, name := range []string{"primary/default:main", "primary/default:overflow", "secondary/default:1", "private/default:1"} {
+		engines[name] = octollm.EngineFunc(func(req *octollm.Request) (*octollm.Response, error) {
+			if status := statuses[name]; status != 0 {
+				return nil, &errutils.UpstreamRespError{StatusCode: status}
+			}
+			b, err := req.Body.Bytes()
+			require.NoError(t, err)
+			servedBodies = append(servedBodies, name+" "+string(b))
+			return &octollm.Response{StatusCode: http.StatusOK}, nil
+		})
+	}
+
+	rc := NewRuleRepoFileBased(&fakeModelRepo{conf: conf, engines: engines})
+	require.NoError(t, rc.UpdateFromConfig(conf))
+
+	process := func() (*octollm.Response, error) {
+		httpReq, err := http.NewRequestWithContext(context.Background(), http.MethodPost, "http://localhost/v1/chat/completions", nil)
+		require.NoError(t, err)
+		req := octollm.NewRequest(httpReq, octollm.APIFormatChatCompletions)
+		req.Body = octollm.NewBodyFromBytes([]byte(`{"model":"primary","messages":[]}`), octollm.NewRequestParser(octollm.APIFormatChatCompletions))
+		return rc.GetEngine("", "", "").Process(req)
+	}
+
+	resp, err := process()
+	require.NoError(t, err)
+	assert.Equal(t, "primary", resp.Header.Get(ServedModelHeader))
+	assert.Equal(t, []string{`primary/default:main {"model":"primary","messages":[]}`}, servedBodies)
+
+	// the overflow tier serves when the primary tier is down
+	servedBodies = nil
+	statuses["primary/default:main"] = http.StatusServiceUnavailable
+	resp, err = process()
+	require.NoError(t, err)
+	assert.Equal(t, "primary", resp.Header.Get(ServedModelHeader))
+	assert.Equal(t, []string{`primary/default:overflow {"model":"primary","messages":[]}`}, servedBodies)
+
+	// then the fallback model, skipping the one without access
+	servedBodies = nil
+	statuses["primary/default:overflow"] = http.StatusBadGateway
+	resp, err = process()
+	require.NoError(t, err)
+	assert.Equal(t, "secondary", resp.Header.Get(ServedModelHeader))
+	require.Len(t, servedBodies, 1)
+	assert.Contains(t, servedBodies[0], `secondary/default:1 `)
+	assert.Contains(t, servedBodies[0], `"model":"secondary"`)
+
+	// client errors do not fall back
+	servedBodies = nil
+	statuses["primary/default:main"] = http.StatusBadRequest
+	_, err = process()
+	require.Error(t, err)
+	assert.Empty(t, servedBodies)
+}
+
+func TestRuleComposerEngine_FallbackGetsOriginalRequest(t *testing.T) {
+	oneAttempt := 1
+	conf := &ConfigFile{
+		Models: map[string]*Model{
+			"primary": {
+				Backends: map[string]*Backend{
+					"default:main":     {ConvertToChat: "from_messages"},
+					"default:overflow": {ConvertToChat: "from_messages", Tier: 1},
+				},
+				Fallbacks: []string{"secondary"},
+			},
+			"secondary": {Backends: map[string]*Backend{"default:1": {ConvertToChat: "from_messages"}}},
+		},
+		Retry: &RetryConfig{MaxAttempts: &oneAttempt},
+	}
+
+	// the backends serve chat/completions from claude upstreams, which convert the request in place
+	var upstreamReqs []string
+	claudeUpstream := func(name string, fail bool) octollm.Engine {
+		return converter.NewMessagesToChatCompletions(octollm.EngineFunc(func(req *octollm.Request) (*octollm.Response, error) {
+			b, err := req.Body.Bytes()
+			require.NoError(t, err)
+			upstreamReqs = append(upstreamReqs, name+" "+string(req.Format)+" "+string(b))
+			if fail {
+				return nil, &errutils.UpstreamRespError{StatusCode: http.StatusServiceUnavailable}
+			}
+			body := `{"id":"msg_1","type":"message","role":"assistant","model":"claude","content":[{"type":"text","text":"hi"}],"stop_reason":"end_turn","usage":{"input_tokens":1,"output_tokens":1}}`
+			return &octollm.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{},
+				Body:       octollm.NewBodyFromBytes([]byte(body), &octollm.JSONParser[anthropicSDK.Message]{}),
+			}, nil
+		}))
+	}
+	engines := map[string]octollm.Engine{
+		"primary/default:main":     claudeUpstream("main", true),
+		"primary/default:overflow": claudeUpstream("overflow", true),
+		"secondary/default:1":      claudeUpstream("secondary", false),
+	}
+	rc := NewRuleRepoFileBased(&fakeModelRepo{conf: conf, engines: engines})
+	require.NoError(t, rc.UpdateFromConfig(conf))
+
+	httpReq, err := http.NewRequestWithContext(context.Background(), http.MethodPost, "http://localhost/v1/chat/completions", nil)
+	require.NoError(t, err)
+	req := octollm.NewRequest(httpReq, octollm.APIFormatChatCompletions)
+	req.Body = octollm.NewBodyFromBytes([]byte(`{"model":"primary","max_tokens":10,"messages":[{"role":"user","content":"hello"}]}`),
+		octollm.NewRequestParser(octollm.APIFormatChatCompletions))
+	resp, err := rc.GetEngine("", "", "").Process(req)
+	require.NoError(t, err)
+	assert.Equal(t, "secondary", resp.Header.Get(ServedModelHeader))
+
+	// every tier and the fallback converted the request of the client once
+	require.Len(t, upstreamReqs, 3)
+	for i, name := range []string{"main", "overflow", "secondary"} {
+		assert.Contains(t, upstreamReqs[i], name+" messages {")
+		assert.Contains(t, upstreamReqs[i], `"content":[{"text":"hello","type":"text"}]`)
+	}
+	assert.Contains(t, upstreamReqs[2], `"model":"secondary"`)
+
+	// and the client is answered in its own protocol
+	b, err := resp.Body.Bytes()
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `"object":"chat.completion"`)
+	assert.Contains(t, string(b), `"content":"hi"`)
+}
+
+func TestRuleComposerEngine_NoFallbackOverLimits(t *testing.T) {
+	conf := &ConfigFile{
+		Models: map[string]*Model{
+			"primary": {
+				Backends:         map[string]*Backend{"default:1": {}},
+				DefaultOrgLimits: &LimitsConfig{RPM: 1},
+				Fallbacks:        []string{"secondary"},
+			},
+			"secondary": {Backends: map[string]*Backend{"default:1": {}}},
+		},
+	}
+	calls := map[string]int{}
+	upstream := func(name string) octollm.Engine {
+		return octollm.EngineFunc(func(req *octollm.Request) (*octollm.Response, error) {
+			calls[name]++
+			return &octollm.Response{StatusCode: http.StatusOK, Header: http.Header{}}, nil
+		})
+	}
+	engines := map[string]octollm.Engine{
+		"primary/default:1":   upstream("primary"),
+		"secondary/default:1": upstream("secondary"),
+	}
+	rc := NewRuleRepoFileBased(&fakeModelRepo{conf: conf, engines: engines})
+	require.NoError(t, rc.UpdateFromConfig(conf))
+
+	newReq := func() *octollm.Request {
+		httpReq, err := http.NewRequestWithContext(context.Background(), http.MethodPost, "http://localhost/v1/chat/completions", nil)
+		require.NoError(t, err)
+		req := octollm.NewRequest(httpReq, octollm.APIFormatChatCompletions)
+		req.Body = octollm.NewBodyFromBytes([]byte(`{"model":"primary","messages":[{"role":"user","content":"hello"}]}`),
+			octollm.NewRequestParser(octollm.APIFormatChatCompletions))
+		return req
+	}
+	_, err := rc.GetEngine("", "acme", "").Process(newReq())
+	require.NoError(t, err)
+
+	// the org is over its limit of the model, the fallback must not serve it instead
+	_, err = rc.GetEngine("", "acme", "").Process(newReq())
+	require.Error(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, errutils.AsHandlerError(err).StatusCode)
+	assert.Equal(t, map[string]int{"primary": 1}, calls)
+}
+
+func TestRuleComposerEngine_RuleChains(t *testing.T) {
+	conf := &ConfigFile{
+		RuleChains: map[string]RuleList{
+			"guard": {
+				{Name: "trusted", MatchExpr: `RawReq.user == "trusted"`, Action: "return"},
+				{Name: "no-stream", MatchExpr: `RawReq.stream == true`, Deny: &engines.DenyEngine{ReasonText: "no streams"}},
+			},
+		},
+		Models: map[string]*Model{
+			"a": {
+				Backends:     map[string]*Backend{"default:1": {}},
+				DefaultRules: RuleList{{Name: "guard", Action: "call", Chain: "guard"}},
+			},
+			"b": {
+				Backends:     map[string]*Backend{"default:1": {}},
+				DefaultRules: RuleList{{Name: "guard", Action: "jump", Chain: "guard"}},
+			},
+		},
+	}
+	engines := map[string]octollm.Engine{}
+	for _, name := range []string{"a/default:1", "b/default:1"} {
+		engines[name] = octollm.EngineFunc(func(req *octollm.Request) (*octollm.Response, error) {
+			return &octollm.Response{StatusCode: http.StatusOK}, nil
+		})
+	}
+	rc := NewRuleRepoFileBased(&fakeModelRepo{conf: conf, engines: engines})
+	require.NoError(t, rc.UpdateFromConfig(conf))
+
+	process := func(body string) (*octollm.Response, error) {
+		httpReq, err := http.NewRequestWithContext(context.Background(), http.MethodPost, "http://localhost/v1/chat/completions", nil)
+		require.NoError(t, err)
+		req := octollm.NewRequest(httpReq, octollm.APIFormatChatCompletions)
+		req.Body = octollm.NewBodyFromBytes([]byte(body), octollm.NewRequestParser(octollm.APIFormatChatCompletions))
+		return rc.GetEngine("", "", "").Process(req)
+	}
+
+	// both models share the chain
+	for _, model := range []string{"a", "b"} {
+		_, err := process(`{"model":"` + model + `","stream":true,"messages":[]}`)
+		assert.Equal(t, http.StatusForbidden, errutils.AsHandlerError(err).StatusCode, model)
+	}
+	// the chain returns, then the request goes to the default backends
+	_, err := process(`{"model":"a","stream":true,"user":"trusted","messages":[]}`)
+	require.NoError(t, err)
+	_, err = process(`{"model":"b","messages":[]}`)
+	require.NoError(t, err)
+
+	conf.RuleChains["guard"] = append(conf.RuleChains["guard"], &RuleConfig{Name: "loop", Action: "call", Chain: "guard"})
+	assert.ErrorContains(t, NewRuleRepoFileBased(&fakeModelRepo{conf: conf}).UpdateFromConfig(conf), "rule chain loop")
+}
+
+func TestRuleComposerEngine_AddTags(t *testing.T) {
+	conf := &ConfigFile{
+		Models: map[string]*Model{
+			"tagged": {
+				Backends: map[string]*Backend{"default:1": {}, "batch": {}},
+				DefaultRules: RuleList{
+					{Name: "classify", MatchExpr: `RawReq.max_tokens > 1000`, AddTags: map[string]string{"tier": "batch"}},
+					{Name: "batch", MatchExpr: `Tags.tier == "batch"`, ForwardWeights: map[string]int{"batch": 1}},
+				},
+			},
+		},
+	}
+	var served []string
+	engines := map[string]octollm.Engine{}
+	for _, name := range []string{"tagged/default:1", "tagged/batch"} {
+		engines[name] = octollm.EngineFunc(func(req *octollm.Request) (*octollm.Response, error) {
+			served = append(served, name+" "+req.Tags().String())
+			return &octollm.Response{StatusCode: http.StatusOK}, nil
+		})
+	}
+	rc := NewRuleRepoFileBased(&fakeModelRepo{conf: conf, engines: engines})
+	require.NoError(t, rc.UpdateFromConfig(conf))
+
+	for _, body := range []string{
+		`{"model":"tagged","max_tokens":2000,"messages":[]}`,
+		`{"model":"tagged","max_tokens":10,"messages":[]}`,
+	} {
+		httpReq, err := http.NewRequestWithContext(context.Background(), http.MethodPost, "http://localhost/v1/chat/completions", nil)
+		require.NoError(t, err)
+		req := octollm.NewRequest(httpReq, octollm.APIFormatChatCompletions)
+		req.Body = octollm.NewBodyFromBytes([]byte(body), octollm.NewRequestParser(octollm.APIFormatChatCompletions))
+		_, err = rc.GetEngine("", "", "").Process(req)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []string{"tagged/batch tier=batch", "tagged/default:1 "}, served)
+	assert.Equal(t, 1.0, requestsTotal.Value(map[string]string{"model": "tagged", "status": "200", "tag_tier": "batch"}))
+	assert.Equal(t, 1.0, requestsTotal.Value(map[string]string{"model": "tagged", "status": "200"}))
+}
+
+func TestUpdateFromConfig_CompileErrors(t *testing.T) {
+	conf := &ConfigFile{
+		Models: map[string]*Model{
+			"m": {
+				Backends:     map[string]*Backend{"default:1": {}},
+				DefaultRules: RuleList{{Name: "typo", MatchExpr: `RawRequest.stream == true`}},
+			},
+		},
+	}
+	err := NewRuleRepoFileBased(&fakeModelRepo{conf: conf}).UpdateFromConfig(conf)
+	assert.ErrorContains(t, err, "default rules of model m: rule typo")
+	assert.ErrorContains(t, err, "unknown name RawRequest")
+
+	conf.Models["m"].DefaultRules = nil
+	conf.Models["m"].Backends["default:1"].RequestRewrites = &engines.RewritePolicy{
+		SetKeysByExpr: map[string]string{"max_tokens": `RawReq.max_tokens >`},
+	}
+	err = NewModelRepoFileBased().UpdateFromConfig(conf)
+	assert.ErrorContains(t, err, "model m backend default:1: request_rewrites: set_keys_by_expr max_tokens")
+
+	conf.Models["m"].Backends["default:1"].RequestRewrites = nil
+	conf.Models["m"].Backends["default:1"].Headers = &engines.HeaderPolicy{
+		Set: map[string]string{"X-Org": "{{organization}}"},
+	}
+	err = NewModelRepoFileBased().UpdateFromConfig(conf)
+	assert.ErrorContains(t, err, "model m backend default:1: headers: set X-Org: unknown placeholder {{organization}}")
+}
+
+func TestRuleComposerEngine_Mirror(t *testing.T) {
+	conf := &ConfigFile{
+		Models: map[string]*Model{
+			"m": {
+				Backends: map[string]*Backend{"default:1": {}, "canary": {}},
+				DefaultRules: RuleList{
+					{Name: "evaluate-canary", Mirror: &MirrorConfig{Backend: "canary", Percent: 100}},
+				},
+			},
+		},
+	}
+	shadowed := make(chan string, 1)
+	answer := func(req *octollm.Request) (*octollm.Response, error) {
+		return octollm.NewNonStreamResponse(http.StatusOK, nil, octollm.NewBodyFromBytes([]byte(`{}`), nil)), nil
+	}
+	engines := map[string]octollm.Engine{
+		"m/default:1": octollm.EngineFunc(answer),
+		"m/canary": octollm.EngineFunc(func(req *octollm.Request) (*octollm.Response, error) {
+			b, err := req.Body.Bytes()
+			require.NoError(t, err)
+			shadowed <- string(b)
+			return answer(req)
+		}),
+	}
+	rc := NewRuleRepoFileBased(&fakeModelRepo{conf: conf, engines: engines})
+	require.NoError(t, rc.UpdateFromConfig(conf))
+
+	httpReq, err := http.NewRequestWithContext(context.Background(), http.MethodPost, "http://localhost/v1/chat/completions", nil)
+	require.NoError(t, err)
+	req := octollm.NewRequest(httpReq, octollm.APIFormatChatCompletions)
+	body := `{"model":"m","messages":[]}`
+	req.Body = octollm.NewBodyFromBytes([]byte(body), octollm.NewRequestParser(octollm.APIFormatChatCompletions))
+	resp, err := rc.GetEngine("", "", "").Process(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, body, <-shadowed)
+
+	conf.Models["m"].DefaultRules[0].Mirror.Percent = 0
+	assert.ErrorContains(t, NewRuleRepoFileBased(&fakeModelRepo{conf: conf}).UpdateFromConfig(conf), "mirror percent")
+}
+
+func TestRuleComposerEngine_Experiment(t *testing.T) {
+	conf := &ConfigFile{
+		Models: map[string]*Model{
+			"exp": {
+				Backends:     map[string]*Backend{"default:1": {}, "new": {}},
+				DefaultRules: RuleList{{Name: "prompt-test", Experiment: "prompt"}},
+			},
+		},
+		Experiments: map[string]*ExperimentConfig{
+			"prompt": {Arms: []*ExperimentArmConfig{
+				{Name: "control", Weight: 0},
+				{Name: "treatment", Weight: 1, ForwardWeights: map[string]int{"new": 1},
+					RequestRewrites: &engines.RewritePolicy{SetKeys: map[string]any{"temperature": 0.5}}},
+			}},
+		},
+	}
+	var served []string
+	backends := map[string]octollm.Engine{}
+	for _, name := range []string{"exp/default:1", "exp/new"} {
+		backends[name] = octollm.EngineFunc(func(req *octollm.Request) (*octollm.Response, error) {
+			b, err := req.Body.Bytes()
+			require.NoError(t, err)
+			served = append(served, name+" "+string(b))
+			return &octollm.Response{StatusCode: http.StatusOK}, nil
+		})
+	}
+	rc := NewRuleRepoFileBased(&fakeModelRepo{conf: conf, engines: backends})
+	require.NoError(t, rc.UpdateFromConfig(conf))
+
+	process := func() string {
+		httpReq, err := http.NewRequestWithContext(context.Background(), http.MethodPost, "http://localhost/v1/chat/completions", nil)
+		require.NoError(t, err)
+		req := octollm.NewRequest(httpReq, octollm.APIFormatChatCompletions)
+		req.Body = octollm.NewBodyFromBytes([]byte(`{"model":"exp"}`), octollm.NewRequestParser(octollm.APIFormatChatCompletions))
+		resp, err := rc.GetEngine("alice", "acme", "").Process(req)
+		require.NoError(t, err)
+		return resp.Header.Get(engines.ExperimentHeader)
+	}
+
+	assert.Equal(t, "prompt=treatment", process())
+	assert.Equal(t, []string{`exp/new {"model":"exp","temperature":0.5}`}, served)
+	assert.Equal(t, 1.0, requestsTotal.Value(map[string]string{
+		"model": "exp", "status": "200", "tag_experiment": "prompt", "tag_arm": "treatment",
+	}))
+
+	// the engine built is ramped too
+	require.NoError(t, rc.RampExperiment("prompt", map[string]int{"control": 1, "treatment": 0}))
+	assert.Equal(t, "prompt=control", process())
+	assert.Equal(t, `exp/default:1 {"model":"exp"}`, served[1])
+	assert.Equal(t, []engines.ArmWeight{{Name: "control", Weight: 1}, {Name: "treatment", Weight: 0}}, rc.Experiments()["prompt"])
+
+	assert.Equal(t, http.StatusNotFound, errutils.AsHandlerError(rc.RampExperiment("missing", nil)).StatusCode)
+	conf.Models["exp"].DefaultRules[0].Experiment = "missing"
+	assert.ErrorContains(t, NewRuleRepoFileBased(&fakeModelRepo{conf: conf}).UpdateFromConfig(conf), `unknown experiment "missing"`)
+}
+
+func TestRuleComposerEngine_AuditMode(t *testing.T) {
+	conf := &ConfigFile{
+		Models: map[string]*Model{
+			"audited": {
+				Backends: map[string]*Backend{"default:1": {}},
+				DefaultRules: RuleList{
+					{Name: "new-deny", MatchExpr: `RawReq.max_tokens > 1000`, Mode: RuleModeAudit, Deny: &engines.DenyEngine{ReasonText: "too long"}},
+					{Name: "old-deny", MatchExpr: `RawReq.max_tokens > 5000`, Deny: &engines.DenyEngine{ReasonText: "too long"}},
+				},
+			},
+		},
+	}
+	backends := map[string]octollm.Engine{"audited/default:1": octollm.EngineFunc(func(req *octollm.Request) (*octollm.Response, error) {
+		return &octollm.Response{StatusCode: http.StatusOK}, nil
+	})}
+	rc := NewRuleRepoFileBased(&fakeModelRepo{conf: conf, engines: backends})
+	require.NoError(t, rc.UpdateFromConfig(conf))
+
+	for _, maxTokens := range []string{"10", "2000", "8000"} {
+		httpReq, err := http.NewRequestWithContext(context.Background(), http.MethodPost, "http://localhost/v1/chat/completions", nil)
+		require.NoError(t, err)
+		req := octollm.NewRequest(httpReq, octollm.APIFormatChatCompletions)
+		req.Body = octollm.NewBodyFromBytes([]byte(`{"model":"audited","max_tokens":`+maxTokens+`}`), octollm.NewRequestParser(octollm.APIFormatChatCompletions))
+		_, err = rc.GetEngine("", "", "").Process(req)
+		if maxTokens == "8000" {
+			assert.Equal(t, http.StatusForbidden, errutils.AsHandlerError(err).StatusCode)
+		} else {
+			require.NoError(t, err, maxTokens)
+		}
+	}
+
+	stats := rc.RuleStats()
+	require.Len(t, stats, 2)
+	assert.Equal(t, "default rules of model audited", stats[0].Where)
+	assert.Equal(t, "new-deny", stats[0].Name)
+	assert.Equal(t, RuleModeAudit, stats[0].Mode)
+	assert.Equal(t, [4]int64{3, 2, 0, 0}, [4]int64{stats[0].Evaluations, stats[0].Matched, stats[0].Executed, stats[0].Errors})
+	assert.Equal(t, RuleModeEnforce, stats[1].Mode)
+	assert.Equal(t, [4]int64{3, 1, 1, 0}, [4]int64{stats[1].Evaluations, stats[1].Matched, stats[1].Executed, stats[1].Errors})
+
+	conf.Models["audited"].DefaultRules[0].Mode = "dry-run"
+	assert.ErrorContains(t, NewRuleRepoFileBased(&fakeModelRepo{conf: conf}).UpdateFromConfig(conf), `unsupported mode "dry-run"`)
+}
@@ -4,10 +4,14 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
 
 	"github.com/anthropics/anthropic-sdk-go"
 	"github.com/infinigence/octollm/pkg/octollm"
+	"github.com/infinigence/octollm/pkg/types/rerank"
+	"github.com/infinigence/octollm/pkg/types/vertex"
 	"github.com/openai/openai-go/v3"
+	"github.com/openai/openai-go/v3/responses"
 )
 
 type GeneralEndpoint struct {
@@ -23,10 +27,18 @@ type GeneralEndpointConfig struct {
 	APIKey    string
 
 	AnthropicAPIKeyAsBearer bool
+	VertexAPIKeyAsBearer    bool // use "Authorization: Bearer" (Vertex AI) instead of "x-goog-api-key" (Gemini API)
 }
 
 var DefaultURLPathChatCompletions = "/v1/chat/completions"
+var DefaultURLPathLegacyCompletions = "/v1/completions"
 var DefaultURLPathClaudeMessages = "/v1/messages"
+var DefaultURLPathResponses = "/v1/responses"
+var DefaultURLPathEmbeddings = "/v1/embeddings"
+var DefaultURLPathRerank = "/v1/rerank"
+
+// DefaultURLPathVertexGenerateContent is a template, {model} and {method} are replaced by the values in the request path.
+var DefaultURLPathVertexGenerateContent = "/v1beta/models/{model}:{method}"
 
 func NewGeneralEndpoint(conf GeneralEndpointConfig) *GeneralEndpoint {
 	apiKey := conf.APIKey
@@ -47,16 +59,34 @@ func NewGeneralEndpoint(conf GeneralEndpointConfig) *GeneralEndpoint {
 					endpoint = DefaultURLPathClaudeMessages
 				case octollm.APIFormatChatCompletions:
 					endpoint = DefaultURLPathChatCompletions
+				case octollm.APIFormatLegacyCompletions:
+					endpoint = DefaultURLPathLegacyCompletions
+				case octollm.APIFormatResponses:
+					endpoint = DefaultURLPathResponses
+				case octollm.APIFormatVertexGenerateContent:
+					endpoint = DefaultURLPathVertexGenerateContent
+				case octollm.APIFormatEmbeddings:
+					endpoint = DefaultURLPathEmbeddings
+				case octollm.APIFormatRerank:
+					endpoint = DefaultURLPathRerank
 				default:
 					return "", fmt.Errorf("invalid format: %s", req.Format)
 				}
 			}
+			if req.Format == octollm.APIFormatVertexGenerateContent {
+				return vertexURL(conf.BaseURL+endpoint, req)
+			}
 			return conf.BaseURL + endpoint, nil
 		}).
 		WithRequestModifier(func(req *octollm.Request, httpReq *http.Request) *http.Request {
 			if req.Format == octollm.APIFormatClaudeMessages && !conf.AnthropicAPIKeyAsBearer {
 				httpReq.Header.Set("x-api-key", apiKey)
+			} else if req.Format == octollm.APIFormatVertexGenerateContent && !conf.VertexAPIKeyAsBearer {
+				// the client's Authorization header must not reach google along with the api key
+				httpReq.Header.Del("Authorization")
+				httpReq.Header.Set("x-goog-api-key", apiKey)
 			} else {
+				httpReq.Header.Del("x-goog-api-key")
 				httpReq.Header.Set("Authorization", "Bearer "+apiKey)
 			}
 			return httpReq
@@ -66,6 +96,16 @@ func NewGeneralEndpoint(conf GeneralEndpointConfig) *GeneralEndpoint {
 				switch req.Format {
 				case octollm.APIFormatClaudeMessages:
 					return &octollm.JSONParser[anthropic.Message]{}
+				case octollm.APIFormatVertexGenerateContent:
+					return &octollm.JSONParser[vertex.GenerateContentResponse]{}
+				case octollm.APIFormatLegacyCompletions:
+					return &octollm.JSONParser[openai.Completion]{}
+				case octollm.APIFormatResponses:
+					return &octollm.JSONParser[responses.Response]{}
+				case octollm.APIFormatEmbeddings:
+					return &octollm.JSONParser[openai.CreateEmbeddingResponse]{}
+				case octollm.APIFormatRerank:
+					return &octollm.JSONParser[rerank.Response]{}
 				default:
 					return &octollm.JSONParser[openai.ChatCompletion]{}
 				}
@@ -74,6 +114,13 @@ func NewGeneralEndpoint(conf GeneralEndpointConfig) *GeneralEndpoint {
 				switch req.Format {
 				case octollm.APIFormatClaudeMessages:
 					return &octollm.JSONParser[anthropic.BetaRawMessageStreamEventUnion]{}
+				case octollm.APIFormatVertexGenerateContent:
+					return &octollm.JSONParser[vertex.GenerateContentResponse]{}
+				case octollm.APIFormatLegacyCompletions:
+					// legacy completions stream chunks have the same shape as the non-stream response
+					return &octollm.JSONParser[openai.Completion]{}
+				case octollm.APIFormatResponses:
+					return &octollm.JSONParser[responses.ResponseStreamEventUnion]{}
 				default:
 					return &octollm.JSONParser[openai.ChatCompletionChunk]{}
 				}
@@ -83,3 +130,24 @@ func NewGeneralEndpoint(conf GeneralEndpointConfig) *GeneralEndpoint {
 		HTTPEndpoint: httpEndpoint,
 	}
 }
+
+// vertexURL fills the model and the method of the request path into the url template.
+// Streaming is always requested as SSE.
+func vertexURL(tmpl string, req *octollm.Request) (string, error) {
+	if req.URL == nil {
+		return "", fmt.Errorf("no url in vertex request")
+	}
+	model, method, err := vertex.ParseModelMethod(req.URL.Path)
+	if err != nil {
+		return "", err
+	}
+	u := strings.NewReplacer("{model}", model, "{method}", method).Replace(tmpl)
+	if method == vertex.MethodStreamGenerateContent {
+		if strings.Contains(u, "?") {
+			u += "&alt=sse"
+		} else {
+			u += "?alt=sse"
+		}
+	}
+	return u, nil
+}
@@ -0,0 +1,553 @@
+package converter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	anthropicSDK "github.com/anthropics/anthropic-sdk-go"
+	openaiSDK "github.com/openai/openai-go/v3"
+	"github.com/openai/openai-go/v3/packages/param"
+	"github.com/sirupsen/logrus"
+
+	"github.com/infinigence/octollm/pkg/octollm"
+	"github.com/infinigence/octollm/pkg/types/anthropic"
+	"github.com/infinigence/octollm/pkg/types/openai"
+)
+
+// DefaultClaudeMaxTokens is used as max_tokens when the ChatCompletions request does not specify one,
+// since the field is required by the Claude Messages API.
+var DefaultClaudeMaxTokens int64 = 4096
+
+// MessagesToChatCompletions is an engine that handles ChatCompletions requests with an underlying ClaudeMessages engine.
+type MessagesToChatCompletions struct {
+	next octollm.Engine // the engine that can handle ClaudeMessages requests
+}
+
+var _ octollm.Engine = (*MessagesToChatCompletions)(nil)
+
+func NewMessagesToChatCompletions(next octollm.Engine) *MessagesToChatCompletions {
+	return &MessagesToChatCompletions{next: next}
+}
+
+func (e *MessagesToChatCompletions) Process(req *octollm.Request) (*octollm.Response, error) {
+	newBody, includeUsage, err := e.convertRequestBody(req.Context(), req.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert request body: %w", err)
+	}
+	req.Format = octollm.APIFormatClaudeMessages
+	req.Body = newBody
+
+	resp, err := e.next.Process(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Stream != nil {
+		newStream, err := e.convertStreamResponse(req.Context(), resp.Stream, includeUsage)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert stream response body: %w", err)
+		}
+		resp.Stream = newStream
+	} else {
+		nonStreamResp, err := e.convertNonStreamResponseBody(req.Context(), resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert non-stream response body: %w", err)
+		}
+		resp.Body = nonStreamResp
+	}
+
+	return resp, nil
+}
+
+// convertRequestBody converts a ChatCompletions request body into a ClaudeMessages request body.
+// It also reports whether the client asked for a usage chunk at the end of the stream.
+func (e *MessagesToChatCompletions) convertRequestBody(ctx context.Context, srcBody *octollm.UnifiedBody) (*octollm.UnifiedBody, bool, error) {
+	parsed, err := srcBody.Parsed()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to parse request body: %w", err)
+	}
+
+	src, ok := parsed.(*openai.ChatCompletionNewParams)
+	if !ok {
+		return nil, false, fmt.Errorf("parsed body is not *openai.ChatCompletionNewParams, got %T", parsed)
+	}
+
+	dst := &anthropic.MessageNewParams{}
+
+	if src.Stream.Valid() {
+		dst.Stream = anthropicSDK.Bool(src.Stream.Value)
+	}
+	includeUsage := src.StreamOptions.IncludeUsage.Valid() && src.StreamOptions.IncludeUsage.Value
+
+	// Model
+	dst.Model = anthropicSDK.Model(src.Model)
+
+	// MaxTokens, max_completion_tokens takes precedence over the deprecated max_tokens
+	dst.MaxTokens = DefaultClaudeMaxTokens
+	if src.MaxCompletionTokens.Valid() {
+		dst.MaxTokens = src.MaxCompletionTokens.Value
+	} else if src.MaxTokens.Valid() {
+		dst.MaxTokens = src.MaxTokens.Value
+	}
+
+	// Temperature, Claude only accepts [0, 1]
+	if src.Temperature.Valid() {
+		dst.Temperature = anthropicSDK.Float(min(src.Temperature.Value, 1))
+	}
+
+	// TopP
+	if src.TopP.Valid() {
+		dst.TopP = anthropicSDK.Float(src.TopP.Value)
+	}
+
+	// Stop Sequences
+	if src.Stop.OfString.Valid() {
+		dst.StopSequences = []string{src.Stop.OfString.Value}
+	} else if len(src.Stop.OfStringArray) > 0 {
+		dst.StopSequences = src.Stop.OfStringArray
+	}
+
+	// User
+	if src.User.Valid() {
+		dst.Metadata.UserID = anthropicSDK.String(src.User.Value)
+	}
+
+	// Messages
+	var messages []anthropicSDK.MessageParam
+	appendBlocks := func(role anthropicSDK.MessageParamRole, blocks []anthropicSDK.ContentBlockParamUnion) {
+		if len(blocks) == 0 {
+			return
+		}
+		// Claude expects alternating roles, so merge consecutive messages of the same role
+		if len(messages) > 0 && messages[len(messages)-1].Role == role {
+			messages[len(messages)-1].Content = append(messages[len(messages)-1].Content, blocks...)
+			return
+		}
+		messages = append(messages, anthropicSDK.MessageParam{Role: role, Content: blocks})
+	}
+
+	for _, msg := range src.Messages {
+		switch {
+		case msg.OfSystem != nil:
+			// System / Developer Prompt -> System
+			dst.System = append(dst.System, e.textBlocks(msg.OfSystem.Content.OfString, msg.OfSystem.Content.OfArrayOfContentParts)...)
+		case msg.OfDeveloper != nil:
+			dst.System = append(dst.System, e.textBlocks(msg.OfDeveloper.Content.OfString, msg.OfDeveloper.Content.OfArrayOfContentParts)...)
+		case msg.OfUser != nil:
+			var blocks []anthropicSDK.ContentBlockParamUnion
+			if msg.OfUser.Content.OfString.Valid() {
+				blocks = append(blocks, anthropicSDK.NewTextBlock(msg.OfUser.Content.OfString.Value))
+			}
+			for _, part := range msg.OfUser.Content.OfArrayOfContentParts {
+				if part.OfText != nil {
+					// Text
+					blocks = append(blocks, anthropicSDK.NewTextBlock(part.OfText.Text))
+				} else if part.OfImageURL != nil {
+					// Image
+					blocks = append(blocks, e.imageBlock(part.OfImageURL.ImageURL.URL))
+				} else {
+					return nil, false, fmt.Errorf("unsupported user content part")
+				}
+			}
+			appendBlocks(anthropicSDK.MessageParamRoleUser, blocks)
+		case msg.OfAssistant != nil:
+			var blocks []anthropicSDK.ContentBlockParamUnion
+			if msg.OfAssistant.Content.OfString.Valid() && msg.OfAssistant.Content.OfString.Value != "" {
+				blocks = append(blocks, anthropicSDK.NewTextBlock(msg.OfAssistant.Content.OfString.Value))
+			}
+			for _, part := range msg.OfAssistant.Content.OfArrayOfContentParts {
+				if part.OfText != nil {
+					blocks = append(blocks, anthropicSDK.NewTextBlock(part.OfText.Text))
+				} else if part.OfRefusal != nil {
+					blocks = append(blocks, anthropicSDK.NewTextBlock(part.OfRefusal.Refusal))
+				}
+			}
+			for _, toolCall := range msg.OfAssistant.ToolCalls {
+				if toolCall.OfFunction == nil {
+					return nil, false, fmt.Errorf("unsupported assistant tool call type")
+				}
+				// Tool Use, input must be a JSON object
+				args := toolCall.OfFunction.Function.Arguments
+				if strings.TrimSpace(args) == "" || !json.Valid([]byte(args)) {
+					logrus.WithContext(ctx).Warnf("invalid tool call arguments, replaced with {}: %s", args)
+					args = "{}"
+				}
+				blocks = append(blocks, anthropicSDK.NewToolUseBlock(toolCall.OfFunction.ID, json.RawMessage(args), toolCall.OfFunction.Function.Name))
+			}
+			appendBlocks(anthropicSDK.MessageParamRoleAssistant, blocks)
+		case msg.OfTool != nil:
+			// Tool Result -> user message with tool_result block
+			toolResult := &anthropicSDK.ToolResultBlockParam{ToolUseID: msg.OfTool.ToolCallID}
+			for _, text := range e.textBlocks(msg.OfTool.Content.OfString, msg.OfTool.Content.OfArrayOfContentParts) {
+				toolResult.Content = append(toolResult.Content, anthropicSDK.ToolResultBlockParamContentUnion{OfText: &text})
+			}
+			appendBlocks(anthropicSDK.MessageParamRoleUser, []anthropicSDK.ContentBlockParamUnion{{OfToolResult: toolResult}})
+		default:
+			return nil, false, fmt.Errorf("unsupported message type")
+		}
+	}
+	dst.Messages = messages
+
+	// Tools
+	for _, tool := range src.Tools {
+		if tool.OfFunction == nil {
+			continue
+		}
+		fn := tool.OfFunction.Function
+		toolParam := &anthropicSDK.ToolParam{
+			Name:        fn.Name,
+			InputSchema: e.inputSchema(fn.Parameters),
+		}
+		if fn.Description.Valid() {
+			toolParam.Description = anthropicSDK.String(fn.Description.Value)
+		}
+		dst.Tools = append(dst.Tools, anthropicSDK.ToolUnionParam{OfTool: toolParam})
+	}
+
+	// Tool Choice
+	disableParallel := src.ParallelToolCalls.Valid() && !src.ParallelToolCalls.Value
+	if src.ToolChoice.OfAuto.Valid() {
+		switch src.ToolChoice.OfAuto.Value {
+		case string(openaiSDK.ChatCompletionToolChoiceOptionAutoNone):
+			dst.ToolChoice.OfNone = &anthropicSDK.ToolChoiceNoneParam{}
+		case string(openaiSDK.ChatCompletionToolChoiceOptionAutoRequired):
+			dst.ToolChoice.OfAny = &anthropicSDK.ToolChoiceAnyParam{}
+		default:
+			dst.ToolChoice.OfAuto = &anthropicSDK.ToolChoiceAutoParam{}
+		}
+	} else if src.ToolChoice.OfFunctionToolChoice != nil {
+		dst.ToolChoice.OfTool = &anthropicSDK.ToolChoiceToolParam{Name: src.ToolChoice.OfFunctionToolChoice.Function.Name}
+	} else if disableParallel && len(dst.Tools) > 0 {
+		dst.ToolChoice.OfAuto = &anthropicSDK.ToolChoiceAutoParam{}
+	}
+	if disableParallel {
+		switch {
+		case dst.ToolChoice.OfAuto != nil:
+			dst.ToolChoice.OfAuto.DisableParallelToolUse = anthropicSDK.Bool(true)
+		case dst.ToolChoice.OfAny != nil:
+			dst.ToolChoice.OfAny.DisableParallelToolUse = anthropicSDK.Bool(true)
+		case dst.ToolChoice.OfTool != nil:
+			dst.ToolChoice.OfTool.DisableParallelToolUse = anthropicSDK.Bool(true)
+		}
+	}
+
+	newBody := octollm.NewBodyFromBytes([]byte{}, &octollm.JSONParser[anthropic.MessageNewParams]{})
+	newBody.SetParsed(dst)
+
+	return newBody, includeUsage, nil
+}
+
+func (e *MessagesToChatCompletions) textBlocks(str param.Opt[string], parts []openaiSDK.ChatCompletionContentPartTextParam) []anthropicSDK.TextBlockParam {
+	var blocks []anthropicSDK.TextBlockParam
+	if str.Valid() {
+		blocks = append(blocks, anthropicSDK.TextBlockParam{Text: str.Value})
+	}
+	for _, part := range parts {
+		blocks = append(blocks, anthropicSDK.TextBlockParam{Text: part.Text})
+	}
+	return blocks
+}
+
+// imageBlock converts an image url (http(s) or data url) to an image block
+func (e *MessagesToChatCompletions) imageBlock(url string) anthropicSDK.ContentBlockParamUnion {
+	if rest, ok := strings.CutPrefix(url, "data:"); ok {
+		if mediaType, data, ok := strings.Cut(rest, ";base64,"); ok {
+			return anthropicSDK.NewImageBlockBase64(mediaType, data)
+		}
+	}
+	return anthropicSDK.NewImageBlock(anthropicSDK.URLImageSourceParam{URL: url})
+}
+
+func (e *MessagesToChatCompletions) inputSchema(params openaiSDK.FunctionParameters) anthropicSDK.ToolInputSchemaParam {
+	schema := anthropicSDK.ToolInputSchemaParam{}
+	for k, v := range params {
+		switch k {
+		case "type":
+			// always "object"
+		case "properties":
+			schema.Properties = v
+		case "required":
+			switch req := v.(type) {
+			case []string:
+				schema.Required = req
+			case []any:
+				for _, r := range req {
+					if s, ok := r.(string); ok {
+						schema.Required = append(schema.Required, s)
+					}
+				}
+			}
+		default:
+			if schema.ExtraFields == nil {
+				schema.ExtraFields = make(map[string]any)
+			}
+			schema.ExtraFields[k] = v
+		}
+	}
+	return schema
+}
+
+func (e *MessagesToChatCompletions) convertNonStreamResponseBody(ctx context.Context, srcBody *octollm.UnifiedBody) (*octollm.UnifiedBody, error) {
+	parsed, err := srcBody.Parsed()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse response body: %w", err)
+	}
+
+	claudeResp, ok := parsed.(*anthropicSDK.Message)
+	if !ok {
+		return nil, fmt.Errorf("parsed body is not *anthropicSDK.Message, got %T", parsed)
+	}
+
+	finishReason := e.mapStopReason(string(claudeResp.StopReason))
+	msg := openai.ChatCompletionMessageSimple{Role: "assistant"}
+	var text strings.Builder
+	hasText := false
+	for _, block := range claudeResp.Content {
+		switch block.Type {
+		case "text":
+			text.WriteString(block.Text)
+			hasText = true
+		case "thinking":
+			msg.ReasoningContent += block.Thinking
+		case "tool_use":
+			input := string(block.Input)
+			if input == "" {
+				input = "{}"
+			}
+			msg.ToolCalls = append(msg.ToolCalls, openai.ToolCallSimple{
+				ID:   block.ID,
+				Type: "function",
+				Function: openai.ToolCallFunction{
+					Name:      block.Name,
+					Arguments: input,
+				},
+			})
+		default:
+			logrus.WithContext(ctx).Debugf("ignore unsupported content block type: %s", block.Type)
+		}
+	}
+	if hasText {
+		s := text.String()
+		msg.Content = &s
+	}
+
+	openaiResp := &openai.ChatCompletionSimple{
+		ID:      claudeResp.ID,
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   string(claudeResp.Model),
+		Choices: []openai.ChatCompletionChoiceSimple{
+			{
+				Index:        0,
+				Message:      msg,
+				FinishReason: &finishReason,
+			},
+		},
+		Usage: e.mapUsage(claudeResp.Usage.InputTokens, claudeResp.Usage.CacheCreationInputTokens, claudeResp.Usage.CacheReadInputTokens, claudeResp.Usage.OutputTokens),
+	}
+
+	openaiBytes, err := json.Marshal(openaiResp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal openai response: %w", err)
+	}
+
+	return octollm.NewBodyFromBytes(openaiBytes, &octollm.JSONParser[openaiSDK.ChatCompletion]{}), nil
+}
+
+func (e *MessagesToChatCompletions) convertStreamResponse(ctx context.Context, src *octollm.StreamChan, includeUsage bool) (*octollm.StreamChan, error) {
+	inCh := src.Chan()
+	outCh := make(chan *octollm.StreamChunk)
+	ctx, cancel := context.WithCancel(ctx)
+
+	intPtr := func(i int) *int { return &i }
+
+	go func() {
+		defer close(outCh)
+		defer src.Close()
+
+		id := ""
+		model := ""
+		created := time.Now().Unix()
+		var inputTokens, cacheCreationTokens, cacheReadTokens, outputTokens int64
+
+		// maps claude content block index to openai tool call index
+		toolCallIndex := make(map[int64]int)
+		nextToolCallIndex := 0
+
+		newChunk := func() *openai.ChatCompletionChunkSimple {
+			return &openai.ChatCompletionChunkSimple{
+				ID:      id,
+				Object:  "chat.completion.chunk",
+				Created: created,
+				Model:   model,
+			}
+		}
+		sendDelta := func(delta openai.ChatCompletionDeltaSimple, finishReason *string) error {
+			chunk := newChunk()
+			chunk.Choices = []openai.ChatCompletionChunkChoiceSimple{
+				{Index: 0, Delta: delta, FinishReason: finishReason},
+			}
+			return e.sendChunk(ctx, outCh, chunk)
+		}
+
+		for chunk := range inCh {
+			if ctx.Err() != nil {
+				return
+			}
+
+			parsed, err := chunk.Body.Parsed()
+			if err != nil {
+				logrus.WithContext(ctx).Errorf("failed to parse stream chunk: %v", err)
+				continue
+			}
+			event, ok := parsed.(*anthropicSDK.BetaRawMessageStreamEventUnion)
+			if !ok {
+				logrus.WithContext(ctx).Errorf("parsed stream chunk is not *anthropic.BetaRawMessageStreamEventUnion, got %T", parsed)
+				continue
+			}
+
+			switch event.Type {
+			case "message_start":
+				id = event.Message.ID
+				model = string(event.Message.Model)
+				inputTokens = event.Message.Usage.InputTokens
+				cacheCreationTokens = event.Message.Usage.CacheCreationInputTokens
+				cacheReadTokens = event.Message.Usage.CacheReadInputTokens
+				outputTokens = event.Message.Usage.OutputTokens
+				err = sendDelta(openai.ChatCompletionDeltaSimple{Role: "assistant"}, nil)
+			case "content_block_start":
+				switch event.ContentBlock.Type {
+				case "text":
+					if event.ContentBlock.Text != "" {
+						err = sendDelta(openai.ChatCompletionDeltaSimple{Content: event.ContentBlock.Text}, nil)
+					}
+				case "tool_use":
+					idx := nextToolCallIndex
+					nextToolCallIndex++
+					toolCallIndex[event.Index] = idx
+					err = sendDelta(openai.ChatCompletionDeltaSimple{
+						ToolCalls: []openai.ToolCallSimple{{
+							Index:    intPtr(idx),
+							ID:       event.ContentBlock.ID,
+							Type:     "function",
+							Function: openai.ToolCallFunction{Name: event.ContentBlock.Name, Arguments: ""},
+						}},
+					}, nil)
+				}
+			case "content_block_delta":
+				switch event.Delta.Type {
+				case "text_delta":
+					err = sendDelta(openai.ChatCompletionDeltaSimple{Content: event.Delta.Text}, nil)
+				case "thinking_delta":
+					err = sendDelta(openai.ChatCompletionDeltaSimple{ReasoningContent: event.Delta.Thinking}, nil)
+				case "input_json_delta":
+					idx, ok := toolCallIndex[event.Index]
+					if !ok {
+						logrus.WithContext(ctx).Warnf("input_json_delta for unknown content block %d", event.Index)
+						continue
+					}
+					if event.Delta.PartialJSON == "" {
+						continue
+					}
+					err = sendDelta(openai.ChatCompletionDeltaSimple{
+						ToolCalls: []openai.ToolCallSimple{{
+							Index:    intPtr(idx),
+							Function: openai.ToolCallFunction{Arguments: event.Delta.PartialJSON},
+						}},
+					}, nil)
+				}
+			case "message_delta":
+				// some providers only report usage in message_delta
+				if event.Usage.InputTokens > 0 {
+					inputTokens = event.Usage.InputTokens
+				}
+				if event.Usage.CacheCreationInputTokens > 0 {
+					cacheCreationTokens = event.Usage.CacheCreationInputTokens
+				}
+				if event.Usage.CacheReadInputTokens > 0 {
+					cacheReadTokens = event.Usage.CacheReadInputTokens
+				}
+				outputTokens = event.Usage.OutputTokens
+				if event.Delta.StopReason != "" {
+					finishReason := e.mapStopReason(string(event.Delta.StopReason))
+					err = sendDelta(openai.ChatCompletionDeltaSimple{}, &finishReason)
+				}
+			case "message_stop":
+				if includeUsage {
+					usageChunk := newChunk()
+					usageChunk.Choices = []openai.ChatCompletionChunkChoiceSimple{}
+					usageChunk.Usage = e.mapUsage(inputTokens, cacheCreationTokens, cacheReadTokens, outputTokens)
+					if err := e.sendChunk(ctx, outCh, usageChunk); err != nil {
+						logrus.WithContext(ctx).Errorf("failed to send usage chunk: %v", err)
+						return
+					}
+				}
+				done := octollm.NewBodyFromBytes([]byte("[DONE]"), &octollm.JSONParser[openaiSDK.ChatCompletionChunk]{})
+				select {
+				case outCh <- &octollm.StreamChunk{Body: done}:
+				case <-ctx.Done():
+				}
+				return
+			case "error":
+				b, _ := chunk.Body.Bytes()
+				logrus.WithContext(ctx).Errorf("upstream stream error event: %s", string(b))
+			default:
+				// ping, content_block_stop
+			}
+			if err != nil {
+				logrus.WithContext(ctx).Errorf("failed to send %s chunk: %v", event.Type, err)
+				return
+			}
+		}
+	}()
+
+	newStream := octollm.NewStreamChan(outCh, cancel)
+	return newStream, nil
+}
+
+func (e *MessagesToChatCompletions) sendChunk(ctx context.Context, ch chan<- *octollm.StreamChunk, chunk *openai.ChatCompletionChunkSimple) error {
+	bytes, err := json.Marshal(chunk)
+	if err != nil {
+		return fmt.Errorf("failed to marshal openai stream chunk: %w", err)
+	}
+	body := octollm.NewBodyFromBytes(bytes, &octollm.JSONParser[openaiSDK.ChatCompletionChunk]{})
+	select {
+	case ch <- &octollm.StreamChunk{Body: body}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// mapUsage converts claude usage to openai usage. Claude reports cached tokens separately,
+// while openai prompt_tokens includes them.
+func (e *MessagesToChatCompletions) mapUsage(inputTokens, cacheCreationTokens, cacheReadTokens, outputTokens int64) *openai.CompletionUsage {
+	promptTokens := int(inputTokens + cacheCreationTokens + cacheReadTokens)
+	usage := &openai.CompletionUsage{
+		PromptTokens:     promptTokens,
+		CompletionTokens: int(outputTokens),
+		TotalTokens:      promptTokens + int(outputTokens),
+	}
+	if cacheReadTokens > 0 {
+		usage.PromptTokensDetails = &openai.PromptTokensDetails{CachedTokens: int(cacheReadTokens)}
+	}
+	return usage
+}
+
+func (e *MessagesToChatCompletions) mapStopReason(sr string) string {
+	switch sr {
+	case "end_turn", "stop_sequence", "pause_turn":
+		return "stop"
+	case "max_tokens":
+		return "length"
+	case "tool_use":
+		return "tool_calls"
+	case "refusal":
+		return "content_filter"
+	default:
+		return sr // Fallback
+	}
+}
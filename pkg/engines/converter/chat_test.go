@@ -0,0 +1,407 @@
+package converter
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	anthropicSDK "github.com/anthropics/anthropic-sdk-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/sjson"
+
+	"github.com/infinigence/octollm/pkg/octollm"
+	"github.com/infinigence/octollm/pkg/types/openai"
+)
+
+// withoutCreated removes the "created" field, which is generated from the current time
+func withoutCreated(t *testing.T, b []byte) string {
+	b, err := sjson.DeleteBytes(b, "created")
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestMessagesToChatCompletions_NonStream_SimpleText(t *testing.T) {
+	ctx := context.Background()
+
+	openaiReqJSON := `{
+		"model": "claude-sonnet-4-5",
+		"messages": [
+			{"role": "system", "content": "You are a helpful assistant."},
+			{"role": "user", "content": "Hello, how are you?"}
+		]
+	}`
+
+	expectedClaudeReqJSON := `{
+		"model": "claude-sonnet-4-5",
+		"max_tokens": 4096,
+		"system": [{"type": "text", "text": "You are a helpful assistant."}],
+		"messages": [
+			{"role": "user", "content": [{"type": "text", "text": "Hello, how are you?"}]}
+		]
+	}`
+
+	claudeRespJSON := `{
+		"id": "msg_123",
+		"type": "message",
+		"role": "assistant",
+		"model": "claude-sonnet-4-5",
+		"content": [{"type": "text", "text": "I'm doing well, thank you!"}],
+		"stop_reason": "end_turn",
+		"stop_sequence": null,
+		"usage": {"input_tokens": 10, "output_tokens": 8}
+	}`
+
+	expectedOpenaiRespJSON := `{
+		"id": "msg_123",
+		"object": "chat.completion",
+		"model": "claude-sonnet-4-5",
+		"choices": [{
+			"index": 0,
+			"message": {"role": "assistant", "content": "I'm doing well, thank you!"},
+			"finish_reason": "stop"
+		}],
+		"usage": {"prompt_tokens": 10, "completion_tokens": 8, "total_tokens": 18}
+	}`
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", "http://localhost/v1/chat/completions", nil)
+	require.NoError(t, err)
+	req := octollm.NewRequest(httpReq, octollm.APIFormatChatCompletions)
+	req.Body = octollm.NewBodyFromBytes([]byte(openaiReqJSON), &octollm.JSONParser[openai.ChatCompletionNewParams]{})
+
+	mockEng := newMockEngine(t)
+	mockEng.expectedRequestCheck = func(t *testing.T, req *octollm.Request) {
+		assert.Equal(t, octollm.APIFormatClaudeMessages, req.Format)
+		bytes, err := req.Body.Bytes()
+		require.NoError(t, err)
+		assert.JSONEq(t, expectedClaudeReqJSON, string(bytes))
+	}
+	mockEng.responseToReturn = &octollm.Response{
+		StatusCode: 200,
+		Header:     http.Header{},
+		Body:       octollm.NewBodyFromBytes([]byte(claudeRespJSON), &octollm.JSONParser[anthropicSDK.Message]{}),
+	}
+
+	resp, err := NewMessagesToChatCompletions(mockEng).Process(req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+
+	respBytes, err := resp.Body.Bytes()
+	require.NoError(t, err)
+	assert.JSONEq(t, expectedOpenaiRespJSON, withoutCreated(t, respBytes))
+}
+
+func testMessagesToChatCompletions_convertRequestBody(t *testing.T, openaiReqJSON, expectedClaudeReqJSON string) {
+	ctx := context.Background()
+	converter := NewMessagesToChatCompletions(nil)
+
+	srcBody := octollm.NewBodyFromBytes([]byte(openaiReqJSON), &octollm.JSONParser[openai.ChatCompletionNewParams]{})
+
+	dstBody, _, err := converter.convertRequestBody(ctx, srcBody)
+	require.NoError(t, err)
+
+	bytes, err := dstBody.Bytes()
+	require.NoError(t, err)
+
+	if !assert.JSONEq(t, expectedClaudeReqJSON, string(bytes)) {
+		t.Logf("Got: %s", string(bytes))
+	}
+}
+
+func TestMessagesToChatCompletions_convertRequestBody_Meta(t *testing.T) {
+	openaiReqJSON := `{
+		"model": "claude-sonnet-4-5",
+		"max_completion_tokens": 1024,
+		"max_tokens": 2048,
+		"stream": true,
+		"temperature": 1.5,
+		"top_p": 0.9,
+		"stop": "\n\n",
+		"user": "user-1",
+		"messages": [
+			{"role": "developer", "content": [{"type": "text", "text": "Be brief."}]},
+			{"role": "user", "content": "Hello"}
+		]
+	}`
+
+	expectedClaudeReqJSON := `{
+		"model": "claude-sonnet-4-5",
+		"max_tokens": 1024,
+		"stream": true,
+		"temperature": 1,
+		"top_p": 0.9,
+		"stop_sequences": ["\n\n"],
+		"metadata": {"user_id": "user-1"},
+		"system": [{"type": "text", "text": "Be brief."}],
+		"messages": [
+			{"role": "user", "content": [{"type": "text", "text": "Hello"}]}
+		]
+	}`
+
+	testMessagesToChatCompletions_convertRequestBody(t, openaiReqJSON, expectedClaudeReqJSON)
+}
+
+func TestMessagesToChatCompletions_convertRequestBody_Image(t *testing.T) {
+	openaiReqJSON := `{
+		"model": "claude-sonnet-4-5",
+		"messages": [
+			{"role": "user", "content": [
+				{"type": "text", "text": "What's in the images?"},
+				{"type": "image_url", "image_url": {"url": "data:image/png;base64,iVBORw0KGgo="}},
+				{"type": "image_url", "image_url": {"url": "https://example.com/image.png"}}
+			]}
+		]
+	}`
+
+	expectedClaudeReqJSON := `{
+		"model": "claude-sonnet-4-5",
+		"max_tokens": 4096,
+		"messages": [
+			{"role": "user", "content": [
+				{"type": "text", "text": "What's in the images?"},
+				{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "iVBORw0KGgo="}},
+				{"type": "image", "source": {"type": "url", "url": "https://example.com/image.png"}}
+			]}
+		]
+	}`
+
+	testMessagesToChatCompletions_convertRequestBody(t, openaiReqJSON, expectedClaudeReqJSON)
+}
+
+func TestMessagesToChatCompletions_convertRequestBody_ToolCallAndResult(t *testing.T) {
+	openaiReqJSON := `{
+		"model": "claude-sonnet-4-5",
+		"parallel_tool_calls": false,
+		"tool_choice": "required",
+		"tools": [{
+			"type": "function",
+			"function": {
+				"name": "get_weather",
+				"description": "Get the current weather in a given location",
+				"parameters": {
+					"type": "object",
+					"properties": {"location": {"type": "string"}},
+					"required": ["location"],
+					"additionalProperties": false
+				}
+			}
+		}],
+		"messages": [
+			{"role": "user", "content": "Weather in Paris and London?"},
+			{"role": "assistant", "content": "Let me check.", "tool_calls": [
+				{"id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": "{\"location\":\"Paris\"}"}},
+				{"id": "call_2", "type": "function", "function": {"name": "get_weather", "arguments": "{\"location\":\"London\"}"}}
+			]},
+			{"role": "tool", "tool_call_id": "call_1", "content": "15 degrees"},
+			{"role": "tool", "tool_call_id": "call_2", "content": [{"type": "text", "text": "12 degrees"}]},
+			{"role": "user", "content": "Thanks"}
+		]
+	}`
+
+	expectedClaudeReqJSON := `{
+		"model": "claude-sonnet-4-5",
+		"max_tokens": 4096,
+		"tool_choice": {"type": "any", "disable_parallel_tool_use": true},
+		"tools": [{
+			"name": "get_weather",
+			"description": "Get the current weather in a given location",
+			"input_schema": {
+				"type": "object",
+				"properties": {"location": {"type": "string"}},
+				"required": ["location"],
+				"additionalProperties": false
+			}
+		}],
+		"messages": [
+			{"role": "user", "content": [{"type": "text", "text": "Weather in Paris and London?"}]},
+			{"role": "assistant", "content": [
+				{"type": "text", "text": "Let me check."},
+				{"type": "tool_use", "id": "call_1", "name": "get_weather", "input": {"location": "Paris"}},
+				{"type": "tool_use", "id": "call_2", "name": "get_weather", "input": {"location": "London"}}
+			]},
+			{"role": "user", "content": [
+				{"type": "tool_result", "tool_use_id": "call_1", "content": [{"type": "text", "text": "15 degrees"}]},
+				{"type": "tool_result", "tool_use_id": "call_2", "content": [{"type": "text", "text": "12 degrees"}]},
+				{"type": "text", "text": "Thanks"}
+			]}
+		]
+	}`
+
+	testMessagesToChatCompletions_convertRequestBody(t, openaiReqJSON, expectedClaudeReqJSON)
+}
+
+func testMessagesToChatCompletions_convertNonStreamResponseBody(t *testing.T, claudeRespJSON, expectedOpenaiRespJSON string) {
+	ctx := context.Background()
+	converter := NewMessagesToChatCompletions(nil)
+
+	srcBody := octollm.NewBodyFromBytes([]byte(claudeRespJSON), &octollm.JSONParser[anthropicSDK.Message]{})
+
+	dstBody, err := converter.convertNonStreamResponseBody(ctx, srcBody)
+	require.NoError(t, err)
+
+	bytes, err := dstBody.Bytes()
+	require.NoError(t, err)
+
+	if !assert.JSONEq(t, expectedOpenaiRespJSON, withoutCreated(t, bytes)) {
+		t.Logf("Got: %s", string(bytes))
+	}
+}
+
+func TestMessagesToChatCompletions_convertNonStreamResponseBody_ToolUseWithThinking(t *testing.T) {
+	claudeRespJSON := `{
+		"id": "msg_123",
+		"type": "message",
+		"role": "assistant",
+		"model": "claude-sonnet-4-5",
+		"content": [
+			{"type": "thinking", "thinking": "Need the weather tool.", "signature": "sig"},
+			{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"location": "Paris"}}
+		],
+		"stop_reason": "tool_use",
+		"stop_sequence": null,
+		"usage": {"input_tokens": 20, "cache_read_input_tokens": 100, "output_tokens": 30}
+	}`
+
+	expectedOpenaiRespJSON := `{
+		"id": "msg_123",
+		"object": "chat.completion",
+		"model": "claude-sonnet-4-5",
+		"choices": [{
+			"index": 0,
+			"message": {
+				"role": "assistant",
+				"content": null,
+				"reasoning_content": "Need the weather tool.",
+				"tool_calls": [{"id": "toolu_1", "type": "function", "function": {"name": "get_weather", "arguments": "{\"location\": \"Paris\"}"}}]
+			},
+			"finish_reason": "tool_calls"
+		}],
+		"usage": {"prompt_tokens": 120, "completion_tokens": 30, "total_tokens": 150, "prompt_tokens_details": {"cached_tokens": 100}}
+	}`
+
+	testMessagesToChatCompletions_convertNonStreamResponseBody(t, claudeRespJSON, expectedOpenaiRespJSON)
+}
+
+func TestMessagesToChatCompletions_convertNonStreamResponseBody_MaxTokens(t *testing.T) {
+	claudeRespJSON := `{
+		"id": "msg_123",
+		"type": "message",
+		"role": "assistant",
+		"model": "claude-sonnet-4-5",
+		"content": [{"type": "text", "text": "Once upon"}, {"type": "text", "text": " a time"}],
+		"stop_reason": "max_tokens",
+		"stop_sequence": null,
+		"usage": {"input_tokens": 5, "output_tokens": 4}
+	}`
+
+	expectedOpenaiRespJSON := `{
+		"id": "msg_123",
+		"object": "chat.completion",
+		"model": "claude-sonnet-4-5",
+		"choices": [{
+			"index": 0,
+			"message": {"role": "assistant", "content": "Once upon a time"},
+			"finish_reason": "length"
+		}],
+		"usage": {"prompt_tokens": 5, "completion_tokens": 4, "total_tokens": 9}
+	}`
+
+	testMessagesToChatCompletions_convertNonStreamResponseBody(t, claudeRespJSON, expectedOpenaiRespJSON)
+}
+
+func testMessagesToChatCompletions_convertStreamResponse(t *testing.T, claudeEventsJSON, expectedOpenaiChunksJSON []string, includeUsage bool) {
+	ctx := context.Background()
+	converter := NewMessagesToChatCompletions(nil)
+
+	inCh := make(chan *octollm.StreamChunk)
+	closed := false
+	closeFunc := func() { closed = true }
+
+	go func() {
+		defer close(inCh)
+		for _, event := range claudeEventsJSON {
+			body := octollm.NewBodyFromBytes([]byte(event), &octollm.JSONParser[anthropicSDK.BetaRawMessageStreamEventUnion]{})
+			inCh <- &octollm.StreamChunk{Body: body}
+		}
+	}()
+	inStream := octollm.NewStreamChan(inCh, closeFunc)
+
+	dstStream, err := converter.convertStreamResponse(ctx, inStream, includeUsage)
+	require.NoError(t, err)
+
+	i := 0
+	for dstChunk := range dstStream.Chan() {
+		bodyBytes, err := dstChunk.Body.Bytes()
+		require.NoError(t, err)
+		require.Less(t, i, len(expectedOpenaiChunksJSON))
+
+		if expectedOpenaiChunksJSON[i] == "[DONE]" {
+			require.Equal(t, "[DONE]", string(bodyBytes))
+		} else {
+			require.JSONEq(t, expectedOpenaiChunksJSON[i], withoutCreated(t, bodyBytes))
+		}
+		i++
+	}
+	dstStream.Close()
+
+	require.Equal(t, len(expectedOpenaiChunksJSON), i)
+	require.True(t, closed)
+}
+
+func TestMessagesToChatCompletions_convertStreamResponse_SimpleText(t *testing.T) {
+	claudeEventsJSON := []string{
+		`{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","content":[],"model":"claude-sonnet-4-5","stop_reason":null,"stop_sequence":null,"usage":{"input_tokens":25,"output_tokens":1}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		`{"type":"ping"}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello"}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"!"}}`,
+		`{"type":"content_block_stop","index":0}`,
+		`{"type":"message_delta","delta":{"stop_reason":"end_turn","stop_sequence":null},"usage":{"output_tokens":15}}`,
+		`{"type":"message_stop"}`,
+	}
+
+	expectedOpenaiChunksJSON := []string{
+		`{"id":"msg_1","object":"chat.completion.chunk","model":"claude-sonnet-4-5","choices":[{"index":0,"delta":{"role":"assistant"},"finish_reason":null}]}`,
+		`{"id":"msg_1","object":"chat.completion.chunk","model":"claude-sonnet-4-5","choices":[{"index":0,"delta":{"content":"Hello"},"finish_reason":null}]}`,
+		`{"id":"msg_1","object":"chat.completion.chunk","model":"claude-sonnet-4-5","choices":[{"index":0,"delta":{"content":"!"},"finish_reason":null}]}`,
+		`{"id":"msg_1","object":"chat.completion.chunk","model":"claude-sonnet-4-5","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+		`[DONE]`,
+	}
+
+	testMessagesToChatCompletions_convertStreamResponse(t, claudeEventsJSON, expectedOpenaiChunksJSON, false)
+}
+
+func TestMessagesToChatCompletions_convertStreamResponse_ToolUseWithUsage(t *testing.T) {
+	claudeEventsJSON := []string{
+		`{"type":"message_start","message":{"id":"msg_2","type":"message","role":"assistant","content":[],"model":"claude-sonnet-4-5","stop_reason":null,"stop_sequence":null,"usage":{"input_tokens":40,"cache_read_input_tokens":10,"output_tokens":1}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"thinking","thinking":""}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"Use the tool."}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"signature_delta","signature":"sig"}}`,
+		`{"type":"content_block_stop","index":0}`,
+		`{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"get_weather","input":{}}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":""}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"location\":"}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"\"Paris\"}"}}`,
+		`{"type":"content_block_stop","index":1}`,
+		`{"type":"content_block_start","index":2,"content_block":{"type":"tool_use","id":"toolu_2","name":"get_time","input":{}}}`,
+		`{"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":"{}"}}`,
+		`{"type":"content_block_stop","index":2}`,
+		`{"type":"message_delta","delta":{"stop_reason":"tool_use","stop_sequence":null},"usage":{"output_tokens":50}}`,
+		`{"type":"message_stop"}`,
+	}
+
+	expectedOpenaiChunksJSON := []string{
+		`{"id":"msg_2","object":"chat.completion.chunk","model":"claude-sonnet-4-5","choices":[{"index":0,"delta":{"role":"assistant"},"finish_reason":null}]}`,
+		`{"id":"msg_2","object":"chat.completion.chunk","model":"claude-sonnet-4-5","choices":[{"index":0,"delta":{"reasoning_content":"Use the tool."},"finish_reason":null}]}`,
+		`{"id":"msg_2","object":"chat.completion.chunk","model":"claude-sonnet-4-5","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"toolu_1","type":"function","function":{"name":"get_weather","arguments":""}}]},"finish_reason":null}]}`,
+		`{"id":"msg_2","object":"chat.completion.chunk","model":"claude-sonnet-4-5","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"location\":"}}]},"finish_reason":null}]}`,
+		`{"id":"msg_2","object":"chat.completion.chunk","model":"claude-sonnet-4-5","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"Paris\"}"}}]},"finish_reason":null}]}`,
+		`{"id":"msg_2","object":"chat.completion.chunk","model":"claude-sonnet-4-5","choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"id":"toolu_2","type":"function","function":{"name":"get_time","arguments":""}}]},"finish_reason":null}]}`,
+		`{"id":"msg_2","object":"chat.completion.chunk","model":"claude-sonnet-4-5","choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"function":{"arguments":"{}"}}]},"finish_reason":null}]}`,
+		`{"id":"msg_2","object":"chat.completion.chunk","model":"claude-sonnet-4-5","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+		`{"id":"msg_2","object":"chat.completion.chunk","model":"claude-sonnet-4-5","choices":[],"usage":{"prompt_tokens":50,"completion_tokens":50,"total_tokens":100,"prompt_tokens_details":{"cached_tokens":10}}}`,
+		`[DONE]`,
+	}
+
+	testMessagesToChatCompletions_convertStreamResponse(t, claudeEventsJSON, expectedOpenaiChunksJSON, true)
+}
@@ -0,0 +1,554 @@
+package converter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	openaiSDK "github.com/openai/openai-go/v3"
+	"github.com/openai/openai-go/v3/packages/param"
+	"github.com/sirupsen/logrus"
+
+	"github.com/infinigence/octollm/pkg/octollm"
+	"github.com/infinigence/octollm/pkg/types/openai"
+	"github.com/infinigence/octollm/pkg/types/vertex"
+)
+
+// VertexGenerateContentToChatCompletions is an engine that handles ChatCompletions requests with an underlying VertexGenerateContent engine.
+type VertexGenerateContentToChatCompletions struct {
+	next octollm.Engine // the engine that can handle VertexGenerateContent requests
+}
+
+var _ octollm.Engine = (*VertexGenerateContentToChatCompletions)(nil)
+
+func NewVertexGenerateContentToChatCompletions(next octollm.Engine) *VertexGenerateContentToChatCompletions {
+	return &VertexGenerateContentToChatCompletions{next: next}
+}
+
+func (e *VertexGenerateContentToChatCompletions) Process(req *octollm.Request) (*octollm.Response, error) {
+	parsed, err := req.Body.Parsed()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse request body: %w", err)
+	}
+	src, ok := parsed.(*openai.ChatCompletionNewParams)
+	if !ok {
+		return nil, fmt.Errorf("parsed body is not *openai.ChatCompletionNewParams, got %T", parsed)
+	}
+	model := src.Model
+	stream := src.Stream.Valid() && src.Stream.Value
+	includeUsage := src.StreamOptions.IncludeUsage.Valid() && src.StreamOptions.IncludeUsage.Value
+
+	newBody, err := e.convertRequestBody(req.Context(), src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert request body: %w", err)
+	}
+	req.Format = octollm.APIFormatVertexGenerateContent
+	req.Body = newBody
+	// vertex reads the model and the method from the path
+	u := url.URL{}
+	if req.URL != nil {
+		u = *req.URL
+	}
+	u.Path = vertex.ModelMethodPath(model, stream)
+	u.RawPath = ""
+	req.URL = &u
+
+	resp, err := e.next.Process(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Stream != nil {
+		newStream, err := e.convertStreamResponse(req.Context(), resp.Stream, model, includeUsage)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert stream response body: %w", err)
+		}
+		resp.Stream = newStream
+	} else {
+		nonStreamResp, err := e.convertNonStreamResponseBody(req.Context(), resp.Body, model)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert non-stream response body: %w", err)
+		}
+		resp.Body = nonStreamResp
+	}
+
+	return resp, nil
+}
+
+// convertRequestBody converts a ChatCompletions request into a VertexGenerateContent request body.
+func (e *VertexGenerateContentToChatCompletions) convertRequestBody(ctx context.Context, src *openai.ChatCompletionNewParams) (*octollm.UnifiedBody, error) {
+	dst := &vertex.GenerateContentRequest{}
+
+	// Generation Config
+	gc := &vertex.GenerationConfig{}
+	if src.Temperature.Valid() {
+		gc.Temperature = &src.Temperature.Value
+	}
+	if src.TopP.Valid() {
+		gc.TopP = &src.TopP.Value
+	}
+	if src.MaxCompletionTokens.Valid() {
+		gc.MaxOutputTokens = &src.MaxCompletionTokens.Value
+	} else if src.MaxTokens.Valid() {
+		gc.MaxOutputTokens = &src.MaxTokens.Value
+	}
+	if src.N.Valid() {
+		gc.CandidateCount = &src.N.Value
+	}
+	if src.Stop.OfString.Valid() {
+		gc.StopSequences = []string{src.Stop.OfString.Value}
+	} else if len(src.Stop.OfStringArray) > 0 {
+		gc.StopSequences = src.Stop.OfStringArray
+	}
+	if src.PresencePenalty.Valid() {
+		gc.PresencePenalty = &src.PresencePenalty.Value
+	}
+	if src.FrequencyPenalty.Valid() {
+		gc.FrequencyPenalty = &src.FrequencyPenalty.Value
+	}
+	if src.Seed.Valid() {
+		gc.Seed = &src.Seed.Value
+	}
+	if src.ResponseFormat.OfJSONObject != nil {
+		gc.ResponseMimeType = "application/json"
+	} else if src.ResponseFormat.OfJSONSchema != nil {
+		gc.ResponseMimeType = "application/json"
+		schema, err := json.Marshal(jsonSchemaToVertex(src.ResponseFormat.OfJSONSchema.JSONSchema.Schema))
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal response schema: %w", err)
+		}
+		gc.ResponseSchema = schema
+	}
+	dst.GenerationConfig = gc
+
+	// Messages
+	var contents []vertex.Content
+	appendParts := func(role string, parts []vertex.Part) {
+		if len(parts) == 0 {
+			return
+		}
+		// merge consecutive contents of the same role, e.g. the results of parallel tool calls
+		if len(contents) > 0 && contents[len(contents)-1].Role == role {
+			contents[len(contents)-1].Parts = append(contents[len(contents)-1].Parts, parts...)
+			return
+		}
+		contents = append(contents, vertex.Content{Role: role, Parts: parts})
+	}
+	// vertex function responses carry the function name instead of the tool call id only
+	toolCallNames := make(map[string]string)
+
+	for _, msg := range src.Messages {
+		switch {
+		case msg.OfSystem != nil:
+			if dst.SystemInstruction == nil {
+				dst.SystemInstruction = &vertex.Content{}
+			}
+			dst.SystemInstruction.Parts = append(dst.SystemInstruction.Parts, e.textParts(msg.OfSystem.Content.OfString, msg.OfSystem.Content.OfArrayOfContentParts)...)
+		case msg.OfDeveloper != nil:
+			if dst.SystemInstruction == nil {
+				dst.SystemInstruction = &vertex.Content{}
+			}
+			dst.SystemInstruction.Parts = append(dst.SystemInstruction.Parts, e.textParts(msg.OfDeveloper.Content.OfString, msg.OfDeveloper.Content.OfArrayOfContentParts)...)
+		case msg.OfUser != nil:
+			var parts []vertex.Part
+			if msg.OfUser.Content.OfString.Valid() {
+				parts = append(parts, vertex.Part{Text: msg.OfUser.Content.OfString.Value})
+			}
+			for _, part := range msg.OfUser.Content.OfArrayOfContentParts {
+				if part.OfText != nil {
+					parts = append(parts, vertex.Part{Text: part.OfText.Text})
+				} else if part.OfImageURL != nil {
+					parts = append(parts, e.imagePart(part.OfImageURL.ImageURL.URL))
+				} else {
+					return nil, fmt.Errorf("unsupported user content part")
+				}
+			}
+			appendParts("user", parts)
+		case msg.OfAssistant != nil:
+			var parts []vertex.Part
+			if msg.OfAssistant.Content.OfString.Valid() && msg.OfAssistant.Content.OfString.Value != "" {
+				parts = append(parts, vertex.Part{Text: msg.OfAssistant.Content.OfString.Value})
+			}
+			for _, part := range msg.OfAssistant.Content.OfArrayOfContentParts {
+				if part.OfText != nil {
+					parts = append(parts, vertex.Part{Text: part.OfText.Text})
+				} else if part.OfRefusal != nil {
+					parts = append(parts, vertex.Part{Text: part.OfRefusal.Refusal})
+				}
+			}
+			for _, toolCall := range msg.OfAssistant.ToolCalls {
+				if toolCall.OfFunction == nil {
+					return nil, fmt.Errorf("unsupported assistant tool call type")
+				}
+				fn := toolCall.OfFunction
+				args := make(map[string]any)
+				if strings.TrimSpace(fn.Function.Arguments) != "" {
+					if err := json.Unmarshal([]byte(fn.Function.Arguments), &args); err != nil {
+						logrus.WithContext(ctx).Warnf("invalid tool call arguments, replaced with {}: %s", fn.Function.Arguments)
+						args = make(map[string]any)
+					}
+				}
+				toolCallNames[fn.ID] = fn.Function.Name
+				parts = append(parts, vertex.Part{FunctionCall: &vertex.FunctionCall{
+					ID:   fn.ID,
+					Name: fn.Function.Name,
+					Args: args,
+				}})
+			}
+			appendParts("model", parts)
+		case msg.OfTool != nil:
+			// Tool Result -> user content with functionResponse part
+			var text strings.Builder
+			for _, part := range e.textParts(msg.OfTool.Content.OfString, msg.OfTool.Content.OfArrayOfContentParts) {
+				text.WriteString(part.Text)
+			}
+			appendParts("user", []vertex.Part{{FunctionResponse: &vertex.FunctionResponse{
+				ID:       msg.OfTool.ToolCallID,
+				Name:     toolCallNames[msg.OfTool.ToolCallID],
+				Response: map[string]any{"content": text.String()},
+			}}})
+		default:
+			return nil, fmt.Errorf("unsupported message type")
+		}
+	}
+	dst.Contents = contents
+
+	// Tools
+	var declarations []vertex.FunctionDeclaration
+	for _, tool := range src.Tools {
+		if tool.OfFunction == nil {
+			continue
+		}
+		fn := tool.OfFunction.Function
+		fd := vertex.FunctionDeclaration{Name: fn.Name}
+		if fn.Description.Valid() {
+			fd.Description = fn.Description.Value
+		}
+		if len(fn.Parameters) > 0 {
+			fd.Parameters = jsonSchemaToVertex(map[string]any(fn.Parameters))
+		}
+		declarations = append(declarations, fd)
+	}
+	if len(declarations) > 0 {
+		dst.Tools = []vertex.Tool{{FunctionDeclarations: declarations}}
+	}
+
+	// Tool Choice
+	if src.ToolChoice.OfAuto.Valid() {
+		mode := vertex.FunctionCallingModeAuto
+		switch src.ToolChoice.OfAuto.Value {
+		case string(openaiSDK.ChatCompletionToolChoiceOptionAutoNone):
+			mode = vertex.FunctionCallingModeNone
+		case string(openaiSDK.ChatCompletionToolChoiceOptionAutoRequired):
+			mode = vertex.FunctionCallingModeAny
+		}
+		dst.ToolConfig = &vertex.ToolConfig{FunctionCallingConfig: &vertex.FunctionCallingConfig{Mode: mode}}
+	} else if src.ToolChoice.OfFunctionToolChoice != nil {
+		dst.ToolConfig = &vertex.ToolConfig{FunctionCallingConfig: &vertex.FunctionCallingConfig{
+			Mode:                 vertex.FunctionCallingModeAny,
+			AllowedFunctionNames: []string{src.ToolChoice.OfFunctionToolChoice.Function.Name},
+		}}
+	}
+
+	newBody := octollm.NewBodyFromBytes([]byte{}, &octollm.JSONParser[vertex.GenerateContentRequest]{})
+	newBody.SetParsed(dst)
+
+	return newBody, nil
+}
+
+func (e *VertexGenerateContentToChatCompletions) textParts(str param.Opt[string], parts []openaiSDK.ChatCompletionContentPartTextParam) []vertex.Part {
+	var out []vertex.Part
+	if str.Valid() {
+		out = append(out, vertex.Part{Text: str.Value})
+	}
+	for _, part := range parts {
+		out = append(out, vertex.Part{Text: part.Text})
+	}
+	return out
+}
+
+// imagePart converts an image url (http(s) or data url) to an inline data or file data part
+func (e *VertexGenerateContentToChatCompletions) imagePart(imageURL string) vertex.Part {
+	if rest, ok := strings.CutPrefix(imageURL, "data:"); ok {
+		if mediaType, data, ok := strings.Cut(rest, ";base64,"); ok {
+			return vertex.Part{InlineData: &vertex.Blob{MimeType: mediaType, Data: data}}
+		}
+	}
+	// vertex requires the mime type of file data
+	mimeType := "image/jpeg"
+	if u, err := url.Parse(imageURL); err == nil {
+		if t := mime.TypeByExtension(path.Ext(u.Path)); t != "" {
+			mimeType = t
+		}
+	}
+	return vertex.Part{FileData: &vertex.FileData{MimeType: mimeType, FileURI: imageURL}}
+}
+
+func (e *VertexGenerateContentToChatCompletions) convertNonStreamResponseBody(ctx context.Context, srcBody *octollm.UnifiedBody, model string) (*octollm.UnifiedBody, error) {
+	parsed, err := srcBody.Parsed()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse response body: %w", err)
+	}
+
+	vertexResp, ok := parsed.(*vertex.GenerateContentResponse)
+	if !ok {
+		return nil, fmt.Errorf("parsed body is not *vertex.GenerateContentResponse, got %T", parsed)
+	}
+
+	openaiResp := &openai.ChatCompletionSimple{
+		ID:      vertexResp.ResponseID,
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   model,
+		Choices: []openai.ChatCompletionChoiceSimple{},
+		Usage:   e.mapUsage(vertexResp.UsageMetadata),
+	}
+	if vertexResp.ModelVersion != "" {
+		openaiResp.Model = vertexResp.ModelVersion
+	}
+
+	nextCallID := 0
+	for _, candidate := range vertexResp.Candidates {
+		msg := openai.ChatCompletionMessageSimple{Role: "assistant"}
+		var text strings.Builder
+		hasText := false
+		if candidate.Content != nil {
+			for _, part := range candidate.Content.Parts {
+				switch {
+				case part.FunctionCall != nil:
+					msg.ToolCalls = append(msg.ToolCalls, e.toolCall(part.FunctionCall, &nextCallID, nil))
+				case part.Thought:
+					msg.ReasoningContent += part.Text
+				case part.Text != "":
+					text.WriteString(part.Text)
+					hasText = true
+				default:
+					logrus.WithContext(ctx).Debugf("ignore unsupported part")
+				}
+			}
+		}
+		if hasText {
+			s := text.String()
+			msg.Content = &s
+		}
+		finishReason := e.mapFinishReason(candidate.FinishReason, len(msg.ToolCalls) > 0)
+		openaiResp.Choices = append(openaiResp.Choices, openai.ChatCompletionChoiceSimple{
+			Index:        candidate.Index,
+			Message:      msg,
+			FinishReason: &finishReason,
+		})
+	}
+
+	openaiBytes, err := json.Marshal(openaiResp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal openai response: %w", err)
+	}
+
+	return octollm.NewBodyFromBytes(openaiBytes, &octollm.JSONParser[openaiSDK.ChatCompletion]{}), nil
+}
+
+func (e *VertexGenerateContentToChatCompletions) convertStreamResponse(ctx context.Context, src *octollm.StreamChan, model string, includeUsage bool) (*octollm.StreamChan, error) {
+	inCh := src.Chan()
+	outCh := make(chan *octollm.StreamChunk)
+	ctx, cancel := context.WithCancel(ctx)
+
+	go func() {
+		defer close(outCh)
+		defer src.Close()
+
+		id := ""
+		created := time.Now().Unix()
+		started := false
+		finished := false
+		nextToolCallIndex := 0
+		var usage *vertex.UsageMetadata
+
+		newChunk := func() *openai.ChatCompletionChunkSimple {
+			return &openai.ChatCompletionChunkSimple{
+				ID:      id,
+				Object:  "chat.completion.chunk",
+				Created: created,
+				Model:   model,
+			}
+		}
+		sendDelta := func(delta openai.ChatCompletionDeltaSimple, finishReason *string) error {
+			chunk := newChunk()
+			chunk.Choices = []openai.ChatCompletionChunkChoiceSimple{
+				{Index: 0, Delta: delta, FinishReason: finishReason},
+			}
+			return e.sendChunk(ctx, outCh, chunk)
+		}
+
+		for chunk := range inCh {
+			if ctx.Err() != nil {
+				return
+			}
+
+			parsed, err := chunk.Body.Parsed()
+			if err != nil {
+				logrus.WithContext(ctx).Errorf("failed to parse stream chunk: %v", err)
+				continue
+			}
+			vertexResp, ok := parsed.(*vertex.GenerateContentResponse)
+			if !ok {
+				logrus.WithContext(ctx).Errorf("parsed stream chunk is not *vertex.GenerateContentResponse, got %T", parsed)
+				continue
+			}
+
+			if !started {
+				id = vertexResp.ResponseID
+				if vertexResp.ModelVersion != "" {
+					model = vertexResp.ModelVersion
+				}
+				if err := sendDelta(openai.ChatCompletionDeltaSimple{Role: "assistant"}, nil); err != nil {
+					logrus.WithContext(ctx).Errorf("failed to send role chunk: %v", err)
+					return
+				}
+				started = true
+			}
+			if vertexResp.UsageMetadata != nil {
+				usage = vertexResp.UsageMetadata
+			}
+			if len(vertexResp.Candidates) == 0 {
+				continue
+			}
+
+			candidate := vertexResp.Candidates[0]
+			if candidate.Content != nil {
+				for _, part := range candidate.Content.Parts {
+					switch {
+					case part.FunctionCall != nil:
+						toolCall := e.toolCall(part.FunctionCall, &nextToolCallIndex, &nextToolCallIndex)
+						err = sendDelta(openai.ChatCompletionDeltaSimple{ToolCalls: []openai.ToolCallSimple{toolCall}}, nil)
+					case part.Thought:
+						err = sendDelta(openai.ChatCompletionDeltaSimple{ReasoningContent: part.Text}, nil)
+					case part.Text != "":
+						err = sendDelta(openai.ChatCompletionDeltaSimple{Content: part.Text}, nil)
+					}
+					if err != nil {
+						logrus.WithContext(ctx).Errorf("failed to send delta chunk: %v", err)
+						return
+					}
+				}
+			}
+			if candidate.FinishReason != "" && !finished {
+				finishReason := e.mapFinishReason(candidate.FinishReason, nextToolCallIndex > 0)
+				if err := sendDelta(openai.ChatCompletionDeltaSimple{}, &finishReason); err != nil {
+					logrus.WithContext(ctx).Errorf("failed to send finish chunk: %v", err)
+					return
+				}
+				finished = true
+			}
+		}
+
+		// vertex streams end without a terminating event
+		if ctx.Err() != nil || !started {
+			return
+		}
+		if includeUsage && usage != nil {
+			usageChunk := newChunk()
+			usageChunk.Choices = []openai.ChatCompletionChunkChoiceSimple{}
+			usageChunk.Usage = e.mapUsage(usage)
+			if err := e.sendChunk(ctx, outCh, usageChunk); err != nil {
+				logrus.WithContext(ctx).Errorf("failed to send usage chunk: %v", err)
+				return
+			}
+		}
+		done := octollm.NewBodyFromBytes([]byte("[DONE]"), &octollm.JSONParser[openaiSDK.ChatCompletionChunk]{})
+		select {
+		case outCh <- &octollm.StreamChunk{Body: done}:
+		case <-ctx.Done():
+		}
+	}()
+
+	newStream := octollm.NewStreamChan(outCh, cancel)
+	return newStream, nil
+}
+
+// toolCall converts a vertex function call to an openai tool call. Vertex may omit the id,
+// so one is generated from nextID. index is set for stream chunks only.
+func (e *VertexGenerateContentToChatCompletions) toolCall(fc *vertex.FunctionCall, nextID *int, index *int) openai.ToolCallSimple {
+	id := fc.ID
+	if id == "" {
+		id = fmt.Sprintf("call_%d", *nextID)
+	}
+	var idx *int
+	if index != nil {
+		i := *index
+		idx = &i
+	}
+	*nextID++
+
+	args := []byte("{}")
+	if fc.Args != nil {
+		if b, err := json.Marshal(fc.Args); err == nil {
+			args = b
+		}
+	}
+	return openai.ToolCallSimple{
+		Index: idx,
+		ID:    id,
+		Type:  "function",
+		Function: openai.ToolCallFunction{
+			Name:      fc.Name,
+			Arguments: string(args),
+		},
+	}
+}
+
+func (e *VertexGenerateContentToChatCompletions) sendChunk(ctx context.Context, ch chan<- *octollm.StreamChunk, chunk *openai.ChatCompletionChunkSimple) error {
+	bytes, err := json.Marshal(chunk)
+	if err != nil {
+		return fmt.Errorf("failed to marshal openai stream chunk: %w", err)
+	}
+	body := octollm.NewBodyFromBytes(bytes, &octollm.JSONParser[openaiSDK.ChatCompletionChunk]{})
+	select {
+	case ch <- &octollm.StreamChunk{Body: body}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// mapUsage converts vertex usage to openai usage. Vertex reports thoughts separately,
+// while openai completion_tokens includes them.
+func (e *VertexGenerateContentToChatCompletions) mapUsage(usage *vertex.UsageMetadata) *openai.CompletionUsage {
+	if usage == nil {
+		return nil
+	}
+	completionTokens := usage.CandidatesTokenCount + usage.ThoughtsTokenCount
+	totalTokens := usage.TotalTokenCount
+	if totalTokens == 0 {
+		totalTokens = usage.PromptTokenCount + completionTokens
+	}
+	u := &openai.CompletionUsage{
+		PromptTokens:     usage.PromptTokenCount,
+		CompletionTokens: completionTokens,
+		TotalTokens:      totalTokens,
+	}
+	if usage.CachedContentTokenCount > 0 {
+		u.PromptTokensDetails = &openai.PromptTokensDetails{CachedTokens: usage.CachedContentTokenCount}
+	}
+	return u
+}
+
+func (e *VertexGenerateContentToChatCompletions) mapFinishReason(fr string, hasToolCalls bool) string {
+	switch fr {
+	case vertex.FinishReasonStop, "":
+		if hasToolCalls {
+			return "tool_calls"
+		}
+		return "stop"
+	case vertex.FinishReasonMaxTokens:
+		return "length"
+	case vertex.FinishReasonSafety, "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII", "IMAGE_SAFETY":
+		return "content_filter"
+	default:
+		return "stop"
+	}
+}
@@ -0,0 +1,168 @@
+package converter
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/infinigence/octollm/pkg/octollm"
+	"github.com/infinigence/octollm/pkg/types/openai"
+	"github.com/infinigence/octollm/pkg/types/vertex"
+)
+
+func TestVertexGenerateContentToChatCompletions_NonStream_ToolCall(t *testing.T) {
+	ctx := context.Background()
+
+	openaiReqJSON := `{
+		"model": "gemini-2.5-flash",
+		"max_tokens": 256,
+		"messages": [
+			{"role": "system", "content": "You are a helpful assistant."},
+			{"role": "user", "content": [
+				{"type": "text", "text": "What is the weather?"},
+				{"type": "image_url", "image_url": {"url": "https://example.com/city.png"}}
+			]},
+			{"role": "assistant", "content": null, "tool_calls": [
+				{"id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": "{\"location\":\"Paris\"}"}}
+			]},
+			{"role": "tool", "tool_call_id": "call_1", "content": "Sunny"}
+		],
+		"tools": [{"type": "function", "function": {
+			"name": "get_weather",
+			"parameters": {"type": "object", "properties": {"location": {"type": "string"}}, "additionalProperties": false}
+		}}],
+		"tool_choice": "required"
+	}`
+
+	expectedVertexReqJSON := `{
+		"systemInstruction": {"parts": [{"text": "You are a helpful assistant."}]},
+		"contents": [
+			{"role": "user", "parts": [
+				{"text": "What is the weather?"},
+				{"fileData": {"mimeType": "image/png", "fileUri": "https://example.com/city.png"}}
+			]},
+			{"role": "model", "parts": [{"functionCall": {"id": "call_1", "name": "get_weather", "args": {"location": "Paris"}}}]},
+			{"role": "user", "parts": [{"functionResponse": {"id": "call_1", "name": "get_weather", "response": {"content": "Sunny"}}}]}
+		],
+		"tools": [{"functionDeclarations": [{
+			"name": "get_weather",
+			"parameters": {"type": "OBJECT", "properties": {"location": {"type": "STRING"}}}
+		}]}],
+		"toolConfig": {"functionCallingConfig": {"mode": "ANY"}},
+		"generationConfig": {"maxOutputTokens": 256}
+	}`
+
+	vertexRespJSON := `{
+		"candidates": [{
+			"content": {"role": "model", "parts": [
+				{"text": "Checking again.", "thought": true},
+				{"functionCall": {"name": "get_weather", "args": {"location": "London"}}}
+			]},
+			"finishReason": "STOP",
+			"index": 0
+		}],
+		"usageMetadata": {"promptTokenCount": 30, "candidatesTokenCount": 10, "totalTokenCount": 45, "cachedContentTokenCount": 8, "thoughtsTokenCount": 5},
+		"modelVersion": "gemini-2.5-flash-001",
+		"responseId": "resp-1"
+	}`
+
+	expectedOpenaiRespJSON := `{
+		"id": "resp-1",
+		"object": "chat.completion",
+		"model": "gemini-2.5-flash-001",
+		"choices": [{
+			"index": 0,
+			"message": {
+				"role": "assistant",
+				"content": null,
+				"reasoning_content": "Checking again.",
+				"tool_calls": [{"id": "call_0", "type": "function", "function": {"name": "get_weather", "arguments": "{\"location\":\"London\"}"}}]
+			},
+			"finish_reason": "tool_calls"
+		}],
+		"usage": {"prompt_tokens": 30, "completion_tokens": 15, "total_tokens": 45, "prompt_tokens_details": {"cached_tokens": 8}}
+	}`
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", "http://localhost/v1/chat/completions", nil)
+	require.NoError(t, err)
+	req := octollm.NewRequest(httpReq, octollm.APIFormatChatCompletions)
+	req.Body = octollm.NewBodyFromBytes([]byte(openaiReqJSON), &octollm.JSONParser[openai.ChatCompletionNewParams]{})
+
+	mockEng := newMockEngine(t)
+	mockEng.expectedRequestCheck = func(t *testing.T, req *octollm.Request) {
+		assert.Equal(t, octollm.APIFormatVertexGenerateContent, req.Format)
+		assert.Equal(t, "/v1beta/models/gemini-2.5-flash:generateContent", req.URL.Path)
+		bytes, err := req.Body.Bytes()
+		require.NoError(t, err)
+		assert.JSONEq(t, expectedVertexReqJSON, string(bytes))
+	}
+	mockEng.responseToReturn = &octollm.Response{
+		StatusCode: 200,
+		Header:     http.Header{},
+		Body:       octollm.NewBodyFromBytes([]byte(vertexRespJSON), &octollm.JSONParser[vertex.GenerateContentResponse]{}),
+	}
+
+	resp, err := NewVertexGenerateContentToChatCompletions(mockEng).Process(req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+
+	respBytes, err := resp.Body.Bytes()
+	require.NoError(t, err)
+	assert.JSONEq(t, expectedOpenaiRespJSON, withoutCreated(t, respBytes))
+}
+
+func TestVertexGenerateContentToChatCompletions_convertStreamResponse_WithUsage(t *testing.T) {
+	vertexChunksJSON := []string{
+		`{"candidates":[{"content":{"role":"model","parts":[{"text":"Hello"}]},"index":0}],"usageMetadata":{"promptTokenCount":5,"totalTokenCount":5},"modelVersion":"gemini-2.5-flash","responseId":"resp-2"}`,
+		`{"candidates":[{"content":{"role":"model","parts":[{"text":" world"}]},"finishReason":"MAX_TOKENS","index":0}],"usageMetadata":{"promptTokenCount":5,"candidatesTokenCount":2,"totalTokenCount":7},"modelVersion":"gemini-2.5-flash","responseId":"resp-2"}`,
+	}
+
+	expectedOpenaiChunksJSON := []string{
+		`{"id":"resp-2","object":"chat.completion.chunk","model":"gemini-2.5-flash","choices":[{"index":0,"delta":{"role":"assistant"},"finish_reason":null}]}`,
+		`{"id":"resp-2","object":"chat.completion.chunk","model":"gemini-2.5-flash","choices":[{"index":0,"delta":{"content":"Hello"},"finish_reason":null}]}`,
+		`{"id":"resp-2","object":"chat.completion.chunk","model":"gemini-2.5-flash","choices":[{"index":0,"delta":{"content":" world"},"finish_reason":null}]}`,
+		`{"id":"resp-2","object":"chat.completion.chunk","model":"gemini-2.5-flash","choices":[{"index":0,"delta":{},"finish_reason":"length"}]}`,
+		`{"id":"resp-2","object":"chat.completion.chunk","model":"gemini-2.5-flash","choices":[],"usage":{"prompt_tokens":5,"completion_tokens":2,"total_tokens":7}}`,
+		`[DONE]`,
+	}
+
+	ctx := context.Background()
+	converter := NewVertexGenerateContentToChatCompletions(nil)
+
+	inCh := make(chan *octollm.StreamChunk)
+	closed := false
+	closeFunc := func() { closed = true }
+
+	go func() {
+		defer close(inCh)
+		for _, chunk := range vertexChunksJSON {
+			body := octollm.NewBodyFromBytes([]byte(chunk), &octollm.JSONParser[vertex.GenerateContentResponse]{})
+			inCh <- &octollm.StreamChunk{Body: body}
+		}
+	}()
+	inStream := octollm.NewStreamChan(inCh, closeFunc)
+
+	dstStream, err := converter.convertStreamResponse(ctx, inStream, "gemini-2.5-flash", true)
+	require.NoError(t, err)
+
+	i := 0
+	for dstChunk := range dstStream.Chan() {
+		bodyBytes, err := dstChunk.Body.Bytes()
+		require.NoError(t, err)
+		require.Less(t, i, len(expectedOpenaiChunksJSON))
+
+		if expectedOpenaiChunksJSON[i] == "[DONE]" {
+			require.Equal(t, "[DONE]", string(bodyBytes))
+		} else {
+			require.JSONEq(t, expectedOpenaiChunksJSON[i], withoutCreated(t, bodyBytes))
+		}
+		i++
+	}
+	dstStream.Close()
+
+	require.Equal(t, len(expectedOpenaiChunksJSON), i)
+	require.True(t, closed)
+}
@@ -0,0 +1,642 @@
+package converter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	openaiSDK "github.com/openai/openai-go/v3"
+	"github.com/openai/openai-go/v3/responses"
+	"github.com/openai/openai-go/v3/shared"
+	"github.com/sirupsen/logrus"
+
+	"github.com/infinigence/octollm/pkg/errutils"
+	"github.com/infinigence/octollm/pkg/octollm"
+	"github.com/infinigence/octollm/pkg/types/openai"
+)
+
+// ChatCompletionsToResponses is an engine that handles Responses requests with an underlying ChatCompletions engine.
+// It is stateless, so previous_response_id is not supported and the whole conversation must be sent as input.
+type ChatCompletionsToResponses struct {
+	next octollm.Engine // the engine that can handle ChatCompletions requests
+}
+
+var _ octollm.Engine = (*ChatCompletionsToResponses)(nil)
+
+func NewChatCompletionsToResponses(next octollm.Engine) *ChatCompletionsToResponses {
+	return &ChatCompletionsToResponses{next: next}
+}
+
+func (e *ChatCompletionsToResponses) Process(req *octollm.Request) (*octollm.Response, error) {
+	parsed, err := req.Body.Parsed()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse request body: %w", err)
+	}
+	src, ok := parsed.(*openai.ResponseNewParams)
+	if !ok {
+		return nil, fmt.Errorf("parsed body is not *openai.ResponseNewParams, got %T", parsed)
+	}
+	if src.PreviousResponseID != nil && *src.PreviousResponseID != "" {
+		return nil, errutils.NewHandlerError(
+			fmt.Errorf("previous_response_id is not supported when converting to chat/completions"),
+			http.StatusBadRequest, "previous_response_id is not supported")
+	}
+
+	newBody, err := e.convertRequestBody(req.Context(), src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert request body: %w", err)
+	}
+	req.Format = octollm.APIFormatChatCompletions
+	req.Body = newBody
+
+	resp, err := e.next.Process(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Stream != nil {
+		newStream, err := e.convertStreamResponse(req.Context(), resp.Stream, src.Model)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert stream response body: %w", err)
+		}
+		resp.Stream = newStream
+	} else {
+		nonStreamResp, err := e.convertNonStreamResponseBody(req.Context(), resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert non-stream response body: %w", err)
+		}
+		resp.Body = nonStreamResp
+	}
+
+	return resp, nil
+}
+
+// convertRequestBody converts a Responses request into a ChatCompletions request body.
+func (e *ChatCompletionsToResponses) convertRequestBody(ctx context.Context, src *openai.ResponseNewParams) (*octollm.UnifiedBody, error) {
+	dst := &openai.ChatCompletionNewParams{}
+	dst.Model = src.Model
+
+	if src.Stream != nil {
+		dst.Stream = openaiSDK.Bool(*src.Stream)
+		if *src.Stream {
+			// usage is reported in response.completed
+			dst.StreamOptions.IncludeUsage = openaiSDK.Bool(true)
+		}
+	}
+	if src.MaxOutputTokens != nil {
+		dst.MaxTokens = openaiSDK.Int(*src.MaxOutputTokens)
+	}
+	if src.Temperature != nil {
+		dst.Temperature = openaiSDK.Float(*src.Temperature)
+	}
+	if src.TopP != nil {
+		dst.TopP = openaiSDK.Float(*src.TopP)
+	}
+	if src.User != nil {
+		dst.User = openaiSDK.String(*src.User)
+	}
+	if src.ParallelToolCalls != nil {
+		dst.ParallelToolCalls = openaiSDK.Bool(*src.ParallelToolCalls)
+	}
+	if src.Reasoning != nil && src.Reasoning.Effort != "" {
+		dst.ReasoningEffort = shared.ReasoningEffort(src.Reasoning.Effort)
+	}
+	if src.Text != nil && src.Text.Format != nil {
+		switch src.Text.Format.Type {
+		case "json_object":
+			jsonObject := shared.NewResponseFormatJSONObjectParam()
+			dst.ResponseFormat.OfJSONObject = &jsonObject
+		case "json_schema":
+			jsonSchema := shared.ResponseFormatJSONSchemaJSONSchemaParam{
+				Name:   src.Text.Format.Name,
+				Schema: src.Text.Format.Schema,
+			}
+			if src.Text.Format.Description != "" {
+				jsonSchema.Description = openaiSDK.String(src.Text.Format.Description)
+			}
+			if src.Text.Format.Strict != nil {
+				jsonSchema.Strict = openaiSDK.Bool(*src.Text.Format.Strict)
+			}
+			dst.ResponseFormat.OfJSONSchema = &shared.ResponseFormatJSONSchemaParam{JSONSchema: jsonSchema}
+		}
+	}
+
+	// Instructions -> System Message
+	var messages []openaiSDK.ChatCompletionMessageParamUnion
+	if src.Instructions != nil && *src.Instructions != "" {
+		messages = append(messages, openaiSDK.SystemMessage(*src.Instructions))
+	}
+
+	// Input Items
+	if src.Input.OfString != nil {
+		messages = append(messages, openaiSDK.UserMessage(*src.Input.OfString))
+	}
+	// function calls and reasoning are separate items, but belong to the assistant message before them
+	var lastAssistant *openaiSDK.ChatCompletionAssistantMessageParam
+	pendingReasoning := ""
+	assistant := func() *openaiSDK.ChatCompletionAssistantMessageParam {
+		if lastAssistant == nil {
+			msg := openaiSDK.AssistantMessage("")
+			messages = append(messages, msg)
+			lastAssistant = messages[len(messages)-1].OfAssistant
+		}
+		if pendingReasoning != "" {
+			lastAssistant.SetExtraFields(map[string]any{"reasoning_content": pendingReasoning})
+			pendingReasoning = ""
+		}
+		return lastAssistant
+	}
+
+	for _, item := range src.Input.OfItems {
+		switch item.Type {
+		case "message", "":
+			switch item.Role {
+			case "system":
+				messages = append(messages, openaiSDK.SystemMessage(item.Content.Text()))
+				lastAssistant = nil
+			case "developer":
+				messages = append(messages, openaiSDK.DeveloperMessage(item.Content.Text()))
+				lastAssistant = nil
+			case "user":
+				if item.Content != nil && item.Content.OfString != nil {
+					messages = append(messages, openaiSDK.UserMessage(*item.Content.OfString))
+					lastAssistant = nil
+					continue
+				}
+				parts, err := e.userContentParts(item.Content)
+				if err != nil {
+					return nil, err
+				}
+				messages = append(messages, openaiSDK.UserMessage(parts))
+				lastAssistant = nil
+			case "assistant":
+				text := ""
+				if item.Content != nil {
+					if item.Content.OfString != nil {
+						text = *item.Content.OfString
+					}
+					for _, part := range item.Content.OfParts {
+						if part.Type == "refusal" {
+							text += part.Refusal
+						} else {
+							text += part.Text
+						}
+					}
+				}
+				lastAssistant = nil
+				msg := assistant()
+				msg.Content.OfString = openaiSDK.String(text)
+			default:
+				return nil, fmt.Errorf("unsupported message role: %s", item.Role)
+			}
+		case "reasoning":
+			// sent back as reasoning_content of the following assistant message
+			text := item.Content.Text()
+			if text == "" {
+				for _, part := range item.Summary {
+					text += part.Text
+				}
+			}
+			pendingReasoning += text
+			lastAssistant = nil
+		case "function_call":
+			args := "{}"
+			if item.Arguments != nil && *item.Arguments != "" {
+				args = *item.Arguments
+			}
+			msg := assistant()
+			msg.ToolCalls = append(msg.ToolCalls, openaiSDK.ChatCompletionMessageToolCallUnionParam{
+				OfFunction: &openaiSDK.ChatCompletionMessageFunctionToolCallParam{
+					ID: item.CallID,
+					Function: openaiSDK.ChatCompletionMessageFunctionToolCallFunctionParam{
+						Name:      item.Name,
+						Arguments: args,
+					},
+				},
+			})
+		case "function_call_output":
+			messages = append(messages, openaiSDK.ToolMessage(item.Output.Text(), item.CallID))
+			lastAssistant = nil
+		default:
+			return nil, fmt.Errorf("unsupported input item type: %s", item.Type)
+		}
+	}
+	dst.Messages = messages
+
+	// Tools
+	for _, tool := range src.Tools {
+		if tool.Type != "function" {
+			logrus.WithContext(ctx).Warnf("ignore unsupported tool type: %s", tool.Type)
+			continue
+		}
+		fdp := openaiSDK.FunctionDefinitionParam{
+			Name:       tool.Name,
+			Parameters: tool.Parameters,
+		}
+		if tool.Description != "" {
+			fdp.Description = openaiSDK.String(tool.Description)
+		}
+		if tool.Strict != nil {
+			fdp.Strict = openaiSDK.Bool(*tool.Strict)
+		}
+		dst.Tools = append(dst.Tools, openaiSDK.ChatCompletionFunctionTool(fdp))
+	}
+
+	// Tool Choice
+	if tc := src.ToolChoice; tc != nil {
+		if tc.OfMode != "" {
+			dst.ToolChoice.OfAuto = openaiSDK.String(tc.OfMode)
+		} else if tc.Type == "function" {
+			dst.ToolChoice = openaiSDK.ToolChoiceOptionFunctionToolChoice(openaiSDK.ChatCompletionNamedToolChoiceFunctionParam{
+				Name: tc.Name,
+			})
+		}
+	}
+
+	newBody := octollm.NewBodyFromBytes([]byte{}, &octollm.JSONParser[openai.ChatCompletionNewParams]{})
+	newBody.SetParsed(dst)
+
+	return newBody, nil
+}
+
+func (e *ChatCompletionsToResponses) userContentParts(content *openai.ResponseContent) ([]openaiSDK.ChatCompletionContentPartUnionParam, error) {
+	var parts []openaiSDK.ChatCompletionContentPartUnionParam
+	if content == nil {
+		return parts, nil
+	}
+	for _, part := range content.OfParts {
+		switch part.Type {
+		case "input_text":
+			parts = append(parts, openaiSDK.TextContentPart(part.Text))
+		case "input_image":
+			if part.ImageURL == "" {
+				return nil, fmt.Errorf("input_image without image_url is not supported")
+			}
+			image := openaiSDK.ChatCompletionContentPartImageImageURLParam{URL: part.ImageURL}
+			if part.Detail != "" {
+				image.Detail = part.Detail
+			}
+			parts = append(parts, openaiSDK.ImageContentPart(image))
+		case "input_file":
+			if part.FileData == "" {
+				return nil, fmt.Errorf("input_file without file_data is not supported")
+			}
+			file := openaiSDK.ChatCompletionContentPartFileFileParam{FileData: openaiSDK.String(part.FileData)}
+			if part.Filename != "" {
+				file.Filename = openaiSDK.String(part.Filename)
+			}
+			parts = append(parts, openaiSDK.FileContentPart(file))
+		default:
+			return nil, fmt.Errorf("unsupported user content part type: %s", part.Type)
+		}
+	}
+	return parts, nil
+}
+
+func (e *ChatCompletionsToResponses) convertNonStreamResponseBody(ctx context.Context, srcBody *octollm.UnifiedBody) (*octollm.UnifiedBody, error) {
+	parsed, err := srcBody.Parsed()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse response body: %w", err)
+	}
+
+	openaiResp, ok := parsed.(*openaiSDK.ChatCompletion)
+	if !ok {
+		return nil, fmt.Errorf("parsed body is not *openaiSDK.ChatCompletion, got %T", parsed)
+	}
+
+	resp := &openai.ResponseSimple{
+		ID:        e.responseID(openaiResp.ID),
+		Object:    "response",
+		CreatedAt: openaiResp.Created,
+		Status:    "completed",
+		Model:     openaiResp.Model,
+		Output:    []openai.ResponseItem{},
+	}
+	if len(openaiResp.Choices) > 0 {
+		choice := openaiResp.Choices[0]
+		msg := choice.Message
+		if reasoning := reasoningContent(msg.JSON.ExtraFields); reasoning != "" {
+			resp.Output = append(resp.Output, e.reasoningItem(resp.ID, reasoning))
+		}
+		if msg.Content != "" || msg.Refusal != "" || len(msg.ToolCalls) == 0 {
+			part := openai.ResponseContentPart{Type: "output_text", Text: msg.Content, Annotations: []any{}}
+			if msg.Refusal != "" {
+				part = openai.ResponseContentPart{Type: "refusal", Refusal: msg.Refusal}
+			}
+			resp.Output = append(resp.Output, e.messageItem(resp.ID, "completed", []openai.ResponseContentPart{part}))
+		}
+		for _, toolCall := range msg.ToolCalls {
+			resp.Output = append(resp.Output, *e.functionCallItem(toolCall.ID, toolCall.Function.Name, toolCall.Function.Arguments, "completed"))
+		}
+		resp.Status, resp.IncompleteDetails = e.mapFinishReason(choice.FinishReason)
+	}
+	if openaiResp.JSON.Usage.Valid() {
+		resp.Usage = e.mapUsage(&openaiResp.Usage)
+	}
+
+	respBytes, err := json.Marshal(resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal responses response: %w", err)
+	}
+
+	return octollm.NewBodyFromBytes(respBytes, &octollm.JSONParser[responses.Response]{}), nil
+}
+
+// convertStreamResponse converts ChatCompletions chunks into the semantic events of the Responses API.
+// Each output item (reasoning, message, function call) is opened when its first delta arrives,
+// and closed when a delta of another item arrives or the stream ends.
+func (e *ChatCompletionsToResponses) convertStreamResponse(ctx context.Context, src *octollm.StreamChan, model string) (*octollm.StreamChan, error) {
+	inCh := src.Chan()
+	outCh := make(chan *octollm.StreamChunk)
+	ctx, cancel := context.WithCancel(ctx)
+
+	intPtr := func(i int) *int { return &i }
+	strPtr := func(s string) *string { return &s }
+
+	go func() {
+		defer close(outCh)
+		defer src.Close()
+
+		resp := &openai.ResponseSimple{
+			Object:    "response",
+			CreatedAt: time.Now().Unix(),
+			Status:    "in_progress",
+			Model:     model,
+			Output:    []openai.ResponseItem{},
+		}
+		seq := 0
+		send := func(event *openai.ResponseStreamEventSimple) error {
+			event.SequenceNumber = seq
+			seq++
+			return e.sendEvent(ctx, outCh, event)
+		}
+		// snapshot copies the response, since the events are serialized after the response changes
+		snapshot := func() *openai.ResponseSimple {
+			r := *resp
+			r.Output = append([]openai.ResponseItem{}, resp.Output...)
+			return &r
+		}
+
+		// the output item being streamed
+		const (
+			itemNone = iota
+			itemReasoning
+			itemMessage
+			itemFunctionCall
+		)
+		curType := itemNone
+		var curItem *openai.ResponseItem
+		var curText strings.Builder
+		curToolIndex := int64(-1)
+		finishReason := ""
+
+		closeItem := func() error {
+			if curType == itemNone {
+				return nil
+			}
+			outputIndex := len(resp.Output)
+			text := curText.String()
+			var err error
+			switch curType {
+			case itemReasoning:
+				curItem.Content = &openai.ResponseContent{OfParts: []openai.ResponseContentPart{{Type: "reasoning_text", Text: text}}}
+				err = send(&openai.ResponseStreamEventSimple{Type: "response.reasoning_text.done", ItemID: curItem.ID, OutputIndex: intPtr(outputIndex), ContentIndex: intPtr(0), Text: strPtr(text)})
+			case itemMessage:
+				part := openai.ResponseContentPart{Type: "output_text", Text: text, Annotations: []any{}}
+				curItem.Content = &openai.ResponseContent{OfParts: []openai.ResponseContentPart{part}}
+				err = send(&openai.ResponseStreamEventSimple{Type: "response.output_text.done", ItemID: curItem.ID, OutputIndex: intPtr(outputIndex), ContentIndex: intPtr(0), Text: strPtr(text)})
+				if err == nil {
+					err = send(&openai.ResponseStreamEventSimple{Type: "response.content_part.done", ItemID: curItem.ID, OutputIndex: intPtr(outputIndex), ContentIndex: intPtr(0), Part: &part})
+				}
+			case itemFunctionCall:
+				if text == "" {
+					text = "{}"
+				}
+				curItem.Arguments = strPtr(text)
+				err = send(&openai.ResponseStreamEventSimple{Type: "response.function_call_arguments.done", ItemID: curItem.ID, OutputIndex: intPtr(outputIndex), Arguments: strPtr(text)})
+			}
+			if err != nil {
+				return err
+			}
+			curItem.Status = "completed"
+			if curType == itemReasoning {
+				curItem.Status = ""
+			}
+			done := *curItem
+			resp.Output = append(resp.Output, done)
+			curType, curItem = itemNone, nil
+			curText.Reset()
+			return send(&openai.ResponseStreamEventSimple{Type: "response.output_item.done", OutputIndex: intPtr(outputIndex), Item: &done})
+		}
+		openItem := func(typ int, item *openai.ResponseItem) error {
+			if err := closeItem(); err != nil {
+				return err
+			}
+			outputIndex := len(resp.Output)
+			curType, curItem = typ, item
+			added := *item
+			if err := send(&openai.ResponseStreamEventSimple{Type: "response.output_item.added", OutputIndex: intPtr(outputIndex), Item: &added}); err != nil {
+				return err
+			}
+			if typ == itemMessage {
+				return send(&openai.ResponseStreamEventSimple{Type: "response.content_part.added", ItemID: item.ID, OutputIndex: intPtr(outputIndex), ContentIndex: intPtr(0),
+					Part: &openai.ResponseContentPart{Type: "output_text", Text: "", Annotations: []any{}}})
+			}
+			return nil
+		}
+		finish := func() {
+			if err := closeItem(); err != nil {
+				logrus.WithContext(ctx).Errorf("failed to close output item: %v", err)
+				return
+			}
+			resp.Status, resp.IncompleteDetails = e.mapFinishReason(finishReason)
+			eventType := "response.completed"
+			if resp.Status == "incomplete" {
+				eventType = "response.incomplete"
+			}
+			if err := send(&openai.ResponseStreamEventSimple{Type: eventType, Response: snapshot()}); err != nil {
+				logrus.WithContext(ctx).Errorf("failed to send %s event: %v", eventType, err)
+			}
+		}
+
+		started := false
+		for chunk := range inCh {
+			if ctx.Err() != nil {
+				return
+			}
+
+			parsed, err := chunk.Body.Parsed()
+			if err != nil {
+				if errors.Is(err, octollm.ErrStreamDone) {
+					if started {
+						finish()
+					}
+					return
+				}
+				logrus.WithContext(ctx).Errorf("failed to parse stream chunk: %v", err)
+				continue
+			}
+			openaiChunk, ok := parsed.(*openaiSDK.ChatCompletionChunk)
+			if !ok {
+				logrus.WithContext(ctx).Errorf("parsed stream chunk is not *openai.ChatCompletionChunk, got %T", parsed)
+				continue
+			}
+
+			if !started {
+				resp.ID = e.responseID(openaiChunk.ID)
+				if openaiChunk.Model != "" {
+					resp.Model = openaiChunk.Model
+				}
+				if openaiChunk.Created != 0 {
+					resp.CreatedAt = openaiChunk.Created
+				}
+				if err := send(&openai.ResponseStreamEventSimple{Type: "response.created", Response: snapshot()}); err != nil {
+					logrus.WithContext(ctx).Errorf("failed to send response.created event: %v", err)
+					return
+				}
+				if err := send(&openai.ResponseStreamEventSimple{Type: "response.in_progress", Response: snapshot()}); err != nil {
+					logrus.WithContext(ctx).Errorf("failed to send response.in_progress event: %v", err)
+					return
+				}
+				started = true
+			}
+			if openaiChunk.JSON.Usage.Valid() && openaiChunk.Usage.JSON.PromptTokens.Valid() {
+				resp.Usage = e.mapUsage(&openaiChunk.Usage)
+			}
+			if len(openaiChunk.Choices) == 0 {
+				continue
+			}
+
+			choice := openaiChunk.Choices[0]
+			if choice.FinishReason != "" {
+				finishReason = choice.FinishReason
+			}
+
+			if reasoning := reasoningContent(choice.Delta.JSON.ExtraFields); reasoning != "" {
+				if curType != itemReasoning {
+					err = openItem(itemReasoning, &openai.ResponseItem{Type: "reasoning", ID: e.itemID("rs", resp.ID, len(resp.Output)), Summary: []openai.ResponseContentPart{}})
+				}
+				if err == nil {
+					curText.WriteString(reasoning)
+					err = send(&openai.ResponseStreamEventSimple{Type: "response.reasoning_text.delta", ItemID: curItem.ID, OutputIndex: intPtr(len(resp.Output)), ContentIndex: intPtr(0), Delta: reasoning})
+				}
+			}
+			if err == nil && choice.Delta.Content != "" {
+				if curType != itemMessage {
+					err = openItem(itemMessage, &openai.ResponseItem{Type: "message", ID: e.itemID("msg", resp.ID, len(resp.Output)), Status: "in_progress", Role: "assistant", Content: &openai.ResponseContent{}})
+				}
+				if err == nil {
+					curText.WriteString(choice.Delta.Content)
+					err = send(&openai.ResponseStreamEventSimple{Type: "response.output_text.delta", ItemID: curItem.ID, OutputIndex: intPtr(len(resp.Output)), ContentIndex: intPtr(0), Delta: choice.Delta.Content})
+				}
+			}
+			for _, tc := range choice.Delta.ToolCalls {
+				if err != nil {
+					break
+				}
+				if curType != itemFunctionCall || tc.Index != curToolIndex {
+					curToolIndex = tc.Index
+					err = openItem(itemFunctionCall, e.functionCallItem(tc.ID, tc.Function.Name, "", "in_progress"))
+				}
+				if err == nil && tc.Function.Arguments != "" {
+					curText.WriteString(tc.Function.Arguments)
+					err = send(&openai.ResponseStreamEventSimple{Type: "response.function_call_arguments.delta", ItemID: curItem.ID, OutputIndex: intPtr(len(resp.Output)), Delta: tc.Function.Arguments})
+				}
+			}
+			if err != nil {
+				logrus.WithContext(ctx).Errorf("failed to send event: %v", err)
+				return
+			}
+		}
+
+		// upstream closed without [DONE]
+		if ctx.Err() == nil && started {
+			finish()
+		}
+	}()
+
+	newStream := octollm.NewStreamChan(outCh, cancel)
+	return newStream, nil
+}
+
+func (e *ChatCompletionsToResponses) sendEvent(ctx context.Context, ch chan<- *octollm.StreamChunk, event *openai.ResponseStreamEventSimple) error {
+	bytes, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal responses stream event: %w", err)
+	}
+	body := octollm.NewBodyFromBytes(bytes, &octollm.JSONParser[responses.ResponseStreamEventUnion]{})
+	select {
+	case ch <- &octollm.StreamChunk{Body: body, Metadata: map[string]string{"event": event.Type}}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *ChatCompletionsToResponses) responseID(chatID string) string {
+	if strings.HasPrefix(chatID, "resp_") {
+		return chatID
+	}
+	return "resp_" + chatID
+}
+
+// itemID derives the id of the n-th output item from the response id
+func (e *ChatCompletionsToResponses) itemID(prefix, respID string, n int) string {
+	return fmt.Sprintf("%s_%s_%d", prefix, strings.TrimPrefix(respID, "resp_"), n)
+}
+
+func (e *ChatCompletionsToResponses) reasoningItem(respID, text string) openai.ResponseItem {
+	return openai.ResponseItem{
+		Type:    "reasoning",
+		ID:      e.itemID("rs", respID, 0),
+		Summary: []openai.ResponseContentPart{},
+		Content: &openai.ResponseContent{OfParts: []openai.ResponseContentPart{{Type: "reasoning_text", Text: text}}},
+	}
+}
+
+func (e *ChatCompletionsToResponses) messageItem(respID, status string, parts []openai.ResponseContentPart) openai.ResponseItem {
+	return openai.ResponseItem{
+		Type:    "message",
+		ID:      e.itemID("msg", respID, 0),
+		Status:  status,
+		Role:    "assistant",
+		Content: &openai.ResponseContent{OfParts: parts},
+	}
+}
+
+func (e *ChatCompletionsToResponses) functionCallItem(callID, name, arguments, status string) *openai.ResponseItem {
+	return &openai.ResponseItem{
+		Type:      "function_call",
+		ID:        "fc_" + callID,
+		Status:    status,
+		CallID:    callID,
+		Name:      name,
+		Arguments: &arguments,
+	}
+}
+
+func (e *ChatCompletionsToResponses) mapUsage(usage *openaiSDK.CompletionUsage) *openai.ResponseUsage {
+	return &openai.ResponseUsage{
+		InputTokens:         int(usage.PromptTokens),
+		InputTokensDetails:  openai.ResponseInputTokensDetails{CachedTokens: int(usage.PromptTokensDetails.CachedTokens)},
+		OutputTokens:        int(usage.CompletionTokens),
+		OutputTokensDetails: openai.ResponseOutputTokensDetails{ReasoningTokens: int(usage.CompletionTokensDetails.ReasoningTokens)},
+		TotalTokens:         int(usage.TotalTokens),
+	}
+}
+
+// mapFinishReason maps the chat finish reason to the response status and incomplete details
+func (e *ChatCompletionsToResponses) mapFinishReason(fr string) (string, *openai.ResponseIncompleteDetails) {
+	switch fr {
+	case "length":
+		return "incomplete", &openai.ResponseIncompleteDetails{Reason: "max_output_tokens"}
+	case "content_filter":
+		return "incomplete", &openai.ResponseIncompleteDetails{Reason: "content_filter"}
+	default:
+		return "completed", nil
+	}
+}
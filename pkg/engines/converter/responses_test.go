@@ -0,0 +1,203 @@
+package converter
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	openaiSDK "github.com/openai/openai-go/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/infinigence/octollm/pkg/octollm"
+	"github.com/infinigence/octollm/pkg/types/openai"
+)
+
+func TestChatCompletionsToResponses_NonStream_ToolCall(t *testing.T) {
+	ctx := context.Background()
+
+	responsesReqJSON := `{
+		"model": "gpt-4o",
+		"instructions": "You are a helpful assistant.",
+		"max_output_tokens": 256,
+		"input": [
+			{"role": "user", "content": "What is the weather in Paris?"},
+			{"type": "reasoning", "id": "rs_1", "summary": [], "content": [{"type": "reasoning_text", "text": "Need the tool."}]},
+			{"type": "function_call", "id": "fc_call_1", "call_id": "call_1", "name": "get_weather", "arguments": "{\"location\":\"Paris\"}"},
+			{"type": "function_call_output", "call_id": "call_1", "output": "Sunny"},
+			{"type": "message", "role": "assistant", "content": [{"type": "output_text", "text": "It is sunny."}]},
+			{"role": "user", "content": [{"type": "input_text", "text": "And London?"}, {"type": "input_image", "image_url": "https://example.com/london.png"}]}
+		],
+		"tools": [
+			{"type": "function", "name": "get_weather", "parameters": {"type": "object", "properties": {"location": {"type": "string"}}}},
+			{"type": "web_search"}
+		],
+		"tool_choice": "auto",
+		"text": {"format": {"type": "json_object"}}
+	}`
+
+	expectedOpenaiReqJSON := `{
+		"model": "gpt-4o",
+		"max_tokens": 256,
+		"messages": [
+			{"role": "system", "content": "You are a helpful assistant."},
+			{"role": "user", "content": "What is the weather in Paris?"},
+			{"role": "assistant", "content": "", "reasoning_content": "Need the tool.", "tool_calls": [
+				{"id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": "{\"location\":\"Paris\"}"}}
+			]},
+			{"role": "tool", "tool_call_id": "call_1", "content": "Sunny"},
+			{"role": "assistant", "content": "It is sunny."},
+			{"role": "user", "content": [
+				{"type": "text", "text": "And London?"},
+				{"type": "image_url", "image_url": {"url": "https://example.com/london.png"}}
+			]}
+		],
+		"tools": [{"type": "function", "function": {
+			"name": "get_weather",
+			"parameters": {"type": "object", "properties": {"location": {"type": "string"}}}
+		}}],
+		"tool_choice": "auto",
+		"response_format": {"type": "json_object"}
+	}`
+
+	openaiRespJSON := `{
+		"id": "chatcmpl-1",
+		"object": "chat.completion",
+		"created": 1700000000,
+		"model": "gpt-4o",
+		"choices": [{
+			"index": 0,
+			"message": {
+				"role": "assistant",
+				"content": null,
+				"reasoning_content": "Check London.",
+				"tool_calls": [{"id": "call_2", "type": "function", "function": {"name": "get_weather", "arguments": "{\"location\":\"London\"}"}}]
+			},
+			"finish_reason": "tool_calls"
+		}],
+		"usage": {"prompt_tokens": 30, "completion_tokens": 15, "total_tokens": 45, "prompt_tokens_details": {"cached_tokens": 8}}
+	}`
+
+	expectedResponsesRespJSON := `{
+		"id": "resp_chatcmpl-1",
+		"object": "response",
+		"created_at": 1700000000,
+		"status": "completed",
+		"model": "gpt-4o",
+		"output": [
+			{"type": "reasoning", "id": "rs_chatcmpl-1_0", "summary": [], "content": [{"type": "reasoning_text", "text": "Check London."}]},
+			{"type": "function_call", "id": "fc_call_2", "status": "completed", "call_id": "call_2", "name": "get_weather", "arguments": "{\"location\":\"London\"}"}
+		],
+		"incomplete_details": null,
+		"error": null,
+		"usage": {
+			"input_tokens": 30,
+			"input_tokens_details": {"cached_tokens": 8},
+			"output_tokens": 15,
+			"output_tokens_details": {"reasoning_tokens": 0},
+			"total_tokens": 45
+		}
+	}`
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", "http://localhost/v1/responses", nil)
+	require.NoError(t, err)
+	req := octollm.NewRequest(httpReq, octollm.APIFormatResponses)
+	req.Body = octollm.NewBodyFromBytes([]byte(responsesReqJSON), &octollm.JSONParser[openai.ResponseNewParams]{})
+
+	mockEng := newMockEngine(t)
+	mockEng.expectedRequestCheck = func(t *testing.T, req *octollm.Request) {
+		assert.Equal(t, octollm.APIFormatChatCompletions, req.Format)
+		bytes, err := req.Body.Bytes()
+		require.NoError(t, err)
+		assert.JSONEq(t, expectedOpenaiReqJSON, string(bytes))
+	}
+	mockEng.responseToReturn = &octollm.Response{
+		StatusCode: 200,
+		Header:     http.Header{},
+		Body:       octollm.NewBodyFromBytes([]byte(openaiRespJSON), &octollm.JSONParser[openaiSDK.ChatCompletion]{}),
+	}
+
+	resp, err := NewChatCompletionsToResponses(mockEng).Process(req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+
+	respBytes, err := resp.Body.Bytes()
+	require.NoError(t, err)
+	assert.JSONEq(t, expectedResponsesRespJSON, string(respBytes))
+}
+
+func TestChatCompletionsToResponses_PreviousResponseID(t *testing.T) {
+	ctx := context.Background()
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", "http://localhost/v1/responses", nil)
+	require.NoError(t, err)
+	req := octollm.NewRequest(httpReq, octollm.APIFormatResponses)
+	req.Body = octollm.NewBodyFromBytes([]byte(`{"model":"gpt-4o","input":"Hi","previous_response_id":"resp_1"}`), &octollm.JSONParser[openai.ResponseNewParams]{})
+
+	_, err = NewChatCompletionsToResponses(nil).Process(req)
+	assert.Error(t, err)
+}
+
+func TestChatCompletionsToResponses_convertStreamResponse(t *testing.T) {
+	openaiChunksJSON := []string{
+		`{"id":"chatcmpl-2","object":"chat.completion.chunk","created":1700000000,"model":"gpt-4o","choices":[{"index":0,"delta":{"role":"assistant","content":"Hello"},"finish_reason":null}]}`,
+		`{"id":"chatcmpl-2","object":"chat.completion.chunk","created":1700000000,"model":"gpt-4o","choices":[{"index":0,"delta":{"content":" world"},"finish_reason":null}]}`,
+		`{"id":"chatcmpl-2","object":"chat.completion.chunk","created":1700000000,"model":"gpt-4o","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"f","arguments":""}}]},"finish_reason":null}]}`,
+		`{"id":"chatcmpl-2","object":"chat.completion.chunk","created":1700000000,"model":"gpt-4o","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{}"}}]},"finish_reason":"tool_calls"}]}`,
+		`{"id":"chatcmpl-2","object":"chat.completion.chunk","created":1700000000,"model":"gpt-4o","choices":[],"usage":{"prompt_tokens":5,"completion_tokens":3,"total_tokens":8}}`,
+		`[DONE]`,
+	}
+
+	messageDone := `{"type":"message","id":"msg_chatcmpl-2_0","status":"completed","role":"assistant","content":[{"type":"output_text","text":"Hello world","annotations":[]}]}`
+	callDone := `{"type":"function_call","id":"fc_call_1","status":"completed","call_id":"call_1","name":"f","arguments":"{}"}`
+	baseResp := `"id":"resp_chatcmpl-2","object":"response","created_at":1700000000,"model":"gpt-4o","incomplete_details":null,"error":null`
+	expectedEventsJSON := []string{
+		`{"type":"response.created","sequence_number":0,"response":{` + baseResp + `,"status":"in_progress","output":[]}}`,
+		`{"type":"response.in_progress","sequence_number":1,"response":{` + baseResp + `,"status":"in_progress","output":[]}}`,
+		`{"type":"response.output_item.added","sequence_number":2,"output_index":0,"item":{"type":"message","id":"msg_chatcmpl-2_0","status":"in_progress","role":"assistant","content":[]}}`,
+		`{"type":"response.content_part.added","sequence_number":3,"output_index":0,"content_index":0,"item_id":"msg_chatcmpl-2_0","part":{"type":"output_text","annotations":[]}}`,
+		`{"type":"response.output_text.delta","sequence_number":4,"output_index":0,"content_index":0,"item_id":"msg_chatcmpl-2_0","delta":"Hello"}`,
+		`{"type":"response.output_text.delta","sequence_number":5,"output_index":0,"content_index":0,"item_id":"msg_chatcmpl-2_0","delta":" world"}`,
+		`{"type":"response.output_text.done","sequence_number":6,"output_index":0,"content_index":0,"item_id":"msg_chatcmpl-2_0","text":"Hello world"}`,
+		`{"type":"response.content_part.done","sequence_number":7,"output_index":0,"content_index":0,"item_id":"msg_chatcmpl-2_0","part":{"type":"output_text","text":"Hello world","annotations":[]}}`,
+		`{"type":"response.output_item.done","sequence_number":8,"output_index":0,"item":` + messageDone + `}`,
+		`{"type":"response.output_item.added","sequence_number":9,"output_index":1,"item":{"type":"function_call","id":"fc_call_1","status":"in_progress","call_id":"call_1","name":"f","arguments":""}}`,
+		`{"type":"response.function_call_arguments.delta","sequence_number":10,"output_index":1,"item_id":"fc_call_1","delta":"{}"}`,
+		`{"type":"response.function_call_arguments.done","sequence_number":11,"output_index":1,"item_id":"fc_call_1","arguments":"{}"}`,
+		`{"type":"response.output_item.done","sequence_number":12,"output_index":1,"item":` + callDone + `}`,
+		`{"type":"response.completed","sequence_number":13,"response":{` + baseResp + `,"status":"completed","output":[` + messageDone + `,` + callDone + `],` +
+			`"usage":{"input_tokens":5,"input_tokens_details":{"cached_tokens":0},"output_tokens":3,"output_tokens_details":{"reasoning_tokens":0},"total_tokens":8}}}`,
+	}
+
+	ctx := context.Background()
+	converter := NewChatCompletionsToResponses(nil)
+
+	inCh := make(chan *octollm.StreamChunk)
+	closed := false
+	closeFunc := func() { closed = true }
+
+	go func() {
+		defer close(inCh)
+		for _, chunk := range openaiChunksJSON {
+			body := octollm.NewBodyFromBytes([]byte(chunk), &octollm.JSONParser[openaiSDK.ChatCompletionChunk]{})
+			inCh <- &octollm.StreamChunk{Body: body}
+		}
+	}()
+	inStream := octollm.NewStreamChan(inCh, closeFunc)
+
+	dstStream, err := converter.convertStreamResponse(ctx, inStream, "gpt-4o")
+	require.NoError(t, err)
+
+	i := 0
+	for dstChunk := range dstStream.Chan() {
+		bodyBytes, err := dstChunk.Body.Bytes()
+		require.NoError(t, err)
+		require.Less(t, i, len(expectedEventsJSON))
+		require.JSONEq(t, expectedEventsJSON[i], string(bodyBytes))
+		i++
+	}
+	dstStream.Close()
+
+	require.Equal(t, len(expectedEventsJSON), i)
+	require.True(t, closed)
+}
@@ -0,0 +1,574 @@
+package converter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	openaiSDK "github.com/openai/openai-go/v3"
+	"github.com/openai/openai-go/v3/packages/respjson"
+	"github.com/openai/openai-go/v3/shared"
+	"github.com/sirupsen/logrus"
+
+	"github.com/infinigence/octollm/pkg/octollm"
+	"github.com/infinigence/octollm/pkg/types/openai"
+	"github.com/infinigence/octollm/pkg/types/vertex"
+)
+
+// ChatCompletionsToVertexGenerateContent is an engine that handles VertexGenerateContent requests with an underlying ChatCompletions engine.
+type ChatCompletionsToVertexGenerateContent struct {
+	next octollm.Engine // the engine that can handle ChatCompletions requests
+}
+
+var _ octollm.Engine = (*ChatCompletionsToVertexGenerateContent)(nil)
+
+func NewChatCompletionsToVertexGenerateContent(next octollm.Engine) *ChatCompletionsToVertexGenerateContent {
+	return &ChatCompletionsToVertexGenerateContent{next: next}
+}
+
+func (e *ChatCompletionsToVertexGenerateContent) Process(req *octollm.Request) (*octollm.Response, error) {
+	if req.URL == nil {
+		return nil, fmt.Errorf("no url in vertex request")
+	}
+	model, method, err := vertex.ParseModelMethod(req.URL.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse model from path: %w", err)
+	}
+	stream := method == vertex.MethodStreamGenerateContent
+
+	newBody, err := e.convertRequestBody(req.Context(), req.Body, model, stream)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert request body: %w", err)
+	}
+	req.Format = octollm.APIFormatChatCompletions
+	req.Body = newBody
+
+	resp, err := e.next.Process(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Stream != nil {
+		newStream, err := e.convertStreamResponse(req.Context(), resp.Stream)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert stream response body: %w", err)
+		}
+		resp.Stream = newStream
+	} else {
+		nonStreamResp, err := e.convertNonStreamResponseBody(req.Context(), resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert non-stream response body: %w", err)
+		}
+		resp.Body = nonStreamResp
+	}
+
+	return resp, nil
+}
+
+// convertRequestBody converts a VertexGenerateContent request body into a ChatCompletions request body.
+// The model and stream flag come from the request path, since they are not part of the vertex body.
+func (e *ChatCompletionsToVertexGenerateContent) convertRequestBody(ctx context.Context, srcBody *octollm.UnifiedBody, model string, stream bool) (*octollm.UnifiedBody, error) {
+	parsed, err := srcBody.Parsed()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse request body: %w", err)
+	}
+
+	src, ok := parsed.(*vertex.GenerateContentRequest)
+	if !ok {
+		return nil, fmt.Errorf("parsed body is not *vertex.GenerateContentRequest, got %T", parsed)
+	}
+
+	dst := &openai.ChatCompletionNewParams{}
+	dst.Model = model
+	if stream {
+		dst.Stream = openaiSDK.Bool(true)
+		dst.StreamOptions.IncludeUsage = openaiSDK.Bool(true) // usageMetadata is sent in the last chunk
+	}
+
+	// Generation Config
+	if gc := src.GenerationConfig; gc != nil {
+		if gc.Temperature != nil {
+			dst.Temperature = openaiSDK.Float(*gc.Temperature)
+		}
+		if gc.TopP != nil {
+			dst.TopP = openaiSDK.Float(*gc.TopP)
+		}
+		if gc.MaxOutputTokens != nil {
+			dst.MaxTokens = openaiSDK.Int(*gc.MaxOutputTokens)
+		}
+		if gc.CandidateCount != nil {
+			dst.N = openaiSDK.Int(*gc.CandidateCount)
+		}
+		if len(gc.StopSequences) > 0 {
+			dst.Stop.OfStringArray = gc.StopSequences
+		}
+		if gc.PresencePenalty != nil {
+			dst.PresencePenalty = openaiSDK.Float(*gc.PresencePenalty)
+		}
+		if gc.FrequencyPenalty != nil {
+			dst.FrequencyPenalty = openaiSDK.Float(*gc.FrequencyPenalty)
+		}
+		if gc.Seed != nil {
+			dst.Seed = openaiSDK.Int(*gc.Seed)
+		}
+		if gc.ResponseMimeType == "application/json" {
+			jsonObject := shared.NewResponseFormatJSONObjectParam()
+			dst.ResponseFormat.OfJSONObject = &jsonObject
+		}
+	}
+
+	// System Instruction -> System Message
+	var messages []openaiSDK.ChatCompletionMessageParamUnion
+	if src.SystemInstruction != nil {
+		var texts []string
+		for _, part := range src.SystemInstruction.Parts {
+			if part.Text != "" {
+				texts = append(texts, part.Text)
+			}
+		}
+		if len(texts) > 0 {
+			messages = append(messages, openaiSDK.SystemMessage(strings.Join(texts, "\n")))
+		}
+	}
+
+	// vertex function responses may not carry ids, so they are paired with the calls by name in order
+	pendingCallIDs := make(map[string][]string)
+	nextCallID := 0
+
+	for _, content := range src.Contents {
+		switch content.Role {
+		case "user", "":
+			var contentParts []openaiSDK.ChatCompletionContentPartUnionParam
+			for _, part := range content.Parts {
+				switch {
+				case part.FunctionResponse != nil:
+					// Function Response -> Tool Message, which must directly follow the assistant message
+					fr := part.FunctionResponse
+					id := fr.ID
+					if ids := pendingCallIDs[fr.Name]; len(ids) > 0 {
+						if id == "" {
+							id = ids[0]
+						}
+						pendingCallIDs[fr.Name] = ids[1:]
+					}
+					if id == "" {
+						id = "call_" + fr.Name
+					}
+					messages = append(messages, openaiSDK.ToolMessage(e.functionResponseText(fr.Response), id))
+				case part.InlineData != nil:
+					url := fmt.Sprintf("data:%s;base64,%s", part.InlineData.MimeType, part.InlineData.Data)
+					contentParts = append(contentParts, openaiSDK.ImageContentPart(openaiSDK.ChatCompletionContentPartImageImageURLParam{URL: url}))
+				case part.FileData != nil:
+					contentParts = append(contentParts, openaiSDK.ImageContentPart(openaiSDK.ChatCompletionContentPartImageImageURLParam{URL: part.FileData.FileURI}))
+				case part.Text != "":
+					contentParts = append(contentParts, openaiSDK.TextContentPart(part.Text))
+				default:
+					logrus.WithContext(ctx).Debugf("ignore unsupported user part")
+				}
+			}
+			if len(contentParts) > 0 {
+				messages = append(messages, openaiSDK.UserMessage(contentParts))
+			}
+		case "model":
+			var text strings.Builder
+			var toolCalls []openaiSDK.ChatCompletionMessageToolCallUnionParam
+			for _, part := range content.Parts {
+				switch {
+				case part.FunctionCall != nil:
+					fc := part.FunctionCall
+					id := fc.ID
+					if id == "" {
+						id = fmt.Sprintf("call_%d", nextCallID)
+						nextCallID++
+					}
+					pendingCallIDs[fc.Name] = append(pendingCallIDs[fc.Name], id)
+					args, err := json.Marshal(fc.Args)
+					if err != nil {
+						return nil, fmt.Errorf("failed to marshal function call args: %w", err)
+					}
+					if fc.Args == nil {
+						args = []byte("{}")
+					}
+					toolCalls = append(toolCalls, openaiSDK.ChatCompletionMessageToolCallUnionParam{
+						OfFunction: &openaiSDK.ChatCompletionMessageFunctionToolCallParam{
+							ID: id,
+							Function: openaiSDK.ChatCompletionMessageFunctionToolCallFunctionParam{
+								Name:      fc.Name,
+								Arguments: string(args),
+							},
+						},
+					})
+				case part.Thought:
+					// thoughts are not sent back to the model
+				default:
+					text.WriteString(part.Text)
+				}
+			}
+			assistantMsg := openaiSDK.AssistantMessage(text.String())
+			if len(toolCalls) > 0 {
+				assistantMsg.OfAssistant.ToolCalls = toolCalls
+			}
+			messages = append(messages, assistantMsg)
+		default:
+			return nil, fmt.Errorf("unsupported content role: %s", content.Role)
+		}
+	}
+	dst.Messages = messages
+
+	// Tools
+	for _, tool := range src.Tools {
+		for _, fd := range tool.FunctionDeclarations {
+			fdp := openaiSDK.FunctionDefinitionParam{Name: fd.Name}
+			if fd.Description != "" {
+				fdp.Description = openaiSDK.String(fd.Description)
+			}
+			schema := fd.ParametersJSONSchema
+			if schema == nil {
+				schema = fd.Parameters
+			}
+			if params, ok := jsonSchemaFromVertex(schema).(map[string]any); ok {
+				fdp.Parameters = params
+			}
+			dst.Tools = append(dst.Tools, openaiSDK.ChatCompletionFunctionTool(fdp))
+		}
+	}
+
+	// Tool Config
+	if src.ToolConfig != nil && src.ToolConfig.FunctionCallingConfig != nil {
+		fcc := src.ToolConfig.FunctionCallingConfig
+		switch fcc.Mode {
+		case vertex.FunctionCallingModeNone:
+			dst.ToolChoice.OfAuto = openaiSDK.String(string(openaiSDK.ChatCompletionToolChoiceOptionAutoNone))
+		case vertex.FunctionCallingModeAny:
+			if len(fcc.AllowedFunctionNames) == 1 {
+				dst.ToolChoice = openaiSDK.ToolChoiceOptionFunctionToolChoice(openaiSDK.ChatCompletionNamedToolChoiceFunctionParam{
+					Name: fcc.AllowedFunctionNames[0],
+				})
+			} else {
+				dst.ToolChoice.OfAuto = openaiSDK.String(string(openaiSDK.ChatCompletionToolChoiceOptionAutoRequired))
+			}
+		case vertex.FunctionCallingModeAuto:
+			dst.ToolChoice.OfAuto = openaiSDK.String(string(openaiSDK.ChatCompletionToolChoiceOptionAutoAuto))
+		}
+	}
+
+	newBody := octollm.NewBodyFromBytes([]byte{}, &octollm.JSONParser[openai.ChatCompletionNewParams]{})
+	newBody.SetParsed(dst)
+
+	return newBody, nil
+}
+
+// functionResponseText unwraps {"content": "..."} responses, and serializes the others as JSON.
+func (e *ChatCompletionsToVertexGenerateContent) functionResponseText(resp map[string]any) string {
+	if len(resp) == 1 {
+		if s, ok := resp["content"].(string); ok {
+			return s
+		}
+	}
+	b, err := json.Marshal(resp)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+func (e *ChatCompletionsToVertexGenerateContent) convertNonStreamResponseBody(ctx context.Context, srcBody *octollm.UnifiedBody) (*octollm.UnifiedBody, error) {
+	parsed, err := srcBody.Parsed()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse response body: %w", err)
+	}
+
+	openaiResp, ok := parsed.(*openaiSDK.ChatCompletion)
+	if !ok {
+		return nil, fmt.Errorf("parsed body is not *openaiSDK.ChatCompletion, got %T", parsed)
+	}
+
+	vertexResp := &vertex.GenerateContentResponse{
+		ModelVersion: openaiResp.Model,
+		ResponseID:   openaiResp.ID,
+	}
+	for _, choice := range openaiResp.Choices {
+		content := &vertex.Content{Role: "model", Parts: []vertex.Part{}}
+		if reasoning := reasoningContent(choice.Message.JSON.ExtraFields); reasoning != "" {
+			content.Parts = append(content.Parts, vertex.Part{Text: reasoning, Thought: true})
+		}
+		if choice.Message.Content != "" {
+			content.Parts = append(content.Parts, vertex.Part{Text: choice.Message.Content})
+		}
+		for _, toolCall := range choice.Message.ToolCalls {
+			content.Parts = append(content.Parts, vertex.Part{FunctionCall: &vertex.FunctionCall{
+				ID:   toolCall.ID,
+				Name: toolCall.Function.Name,
+				Args: e.functionArgs(ctx, toolCall.Function.Arguments),
+			}})
+		}
+		vertexResp.Candidates = append(vertexResp.Candidates, vertex.Candidate{
+			Content:      content,
+			FinishReason: e.mapFinishReason(string(choice.FinishReason)),
+			Index:        int(choice.Index),
+		})
+	}
+	if openaiResp.JSON.Usage.Valid() {
+		vertexResp.UsageMetadata = e.mapUsage(&openaiResp.Usage)
+	}
+
+	vertexBytes, err := json.Marshal(vertexResp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal vertex response: %w", err)
+	}
+
+	return octollm.NewBodyFromBytes(vertexBytes, &octollm.JSONParser[vertex.GenerateContentResponse]{}), nil
+}
+
+// convertStreamResponse converts ChatCompletions chunks into VertexGenerateContent chunks.
+// Vertex sends every function call complete in one part, so tool call deltas are accumulated
+// and sent in the last chunk, together with the finish reason and the usage.
+func (e *ChatCompletionsToVertexGenerateContent) convertStreamResponse(ctx context.Context, src *octollm.StreamChan) (*octollm.StreamChan, error) {
+	inCh := src.Chan()
+	outCh := make(chan *octollm.StreamChunk)
+	ctx, cancel := context.WithCancel(ctx)
+
+	go func() {
+		defer close(outCh)
+		defer src.Close()
+
+		id := ""
+		model := ""
+		finishReason := ""
+		var usage *openaiSDK.CompletionUsage
+
+		type toolCallAcc struct {
+			id   string
+			name string
+			args strings.Builder
+		}
+		var toolCalls []*toolCallAcc
+		toolCallByIndex := make(map[int64]*toolCallAcc)
+
+		newResp := func(parts []vertex.Part) *vertex.GenerateContentResponse {
+			return &vertex.GenerateContentResponse{
+				Candidates: []vertex.Candidate{
+					{Content: &vertex.Content{Role: "model", Parts: parts}, Index: 0},
+				},
+				ModelVersion: model,
+				ResponseID:   id,
+			}
+		}
+
+		flush := func() {
+			parts := []vertex.Part{}
+			for _, tc := range toolCalls {
+				parts = append(parts, vertex.Part{FunctionCall: &vertex.FunctionCall{
+					ID:   tc.id,
+					Name: tc.name,
+					Args: e.functionArgs(ctx, tc.args.String()),
+				}})
+			}
+			last := newResp(parts)
+			last.Candidates[0].FinishReason = e.mapFinishReason(finishReason)
+			if usage != nil {
+				last.UsageMetadata = e.mapUsage(usage)
+			}
+			if err := e.sendChunk(ctx, outCh, last); err != nil {
+				logrus.WithContext(ctx).Errorf("failed to send last chunk: %v", err)
+			}
+		}
+
+		for chunk := range inCh {
+			if ctx.Err() != nil {
+				return
+			}
+
+			parsed, err := chunk.Body.Parsed()
+			if err != nil {
+				if errors.Is(err, octollm.ErrStreamDone) {
+					flush()
+					return
+				}
+				logrus.WithContext(ctx).Errorf("failed to parse stream chunk: %v", err)
+				continue
+			}
+			openaiChunk, ok := parsed.(*openaiSDK.ChatCompletionChunk)
+			if !ok {
+				logrus.WithContext(ctx).Errorf("parsed stream chunk is not *openai.ChatCompletionChunk, got %T", parsed)
+				continue
+			}
+
+			if id == "" {
+				id = openaiChunk.ID
+				model = openaiChunk.Model
+			}
+			if openaiChunk.JSON.Usage.Valid() && openaiChunk.Usage.JSON.PromptTokens.Valid() {
+				usage = &openaiChunk.Usage
+			}
+			if len(openaiChunk.Choices) == 0 {
+				continue
+			}
+
+			choice := openaiChunk.Choices[0]
+			if choice.FinishReason != "" {
+				finishReason = choice.FinishReason
+			}
+			for _, tc := range choice.Delta.ToolCalls {
+				acc, ok := toolCallByIndex[tc.Index]
+				if !ok {
+					acc = &toolCallAcc{}
+					toolCallByIndex[tc.Index] = acc
+					toolCalls = append(toolCalls, acc)
+				}
+				if tc.ID != "" {
+					acc.id = tc.ID
+				}
+				if tc.Function.Name != "" {
+					acc.name = tc.Function.Name
+				}
+				acc.args.WriteString(tc.Function.Arguments)
+			}
+
+			var parts []vertex.Part
+			if reasoning := reasoningContent(choice.Delta.JSON.ExtraFields); reasoning != "" {
+				parts = append(parts, vertex.Part{Text: reasoning, Thought: true})
+			}
+			if choice.Delta.Content != "" {
+				parts = append(parts, vertex.Part{Text: choice.Delta.Content})
+			}
+			if len(parts) == 0 {
+				continue
+			}
+			if err := e.sendChunk(ctx, outCh, newResp(parts)); err != nil {
+				logrus.WithContext(ctx).Errorf("failed to send chunk: %v", err)
+				return
+			}
+		}
+
+		// upstream closed without [DONE]
+		if ctx.Err() == nil {
+			flush()
+		}
+	}()
+
+	newStream := octollm.NewStreamChan(outCh, cancel)
+	return newStream, nil
+}
+
+func (e *ChatCompletionsToVertexGenerateContent) sendChunk(ctx context.Context, ch chan<- *octollm.StreamChunk, chunk *vertex.GenerateContentResponse) error {
+	bytes, err := json.Marshal(chunk)
+	if err != nil {
+		return fmt.Errorf("failed to marshal vertex stream chunk: %w", err)
+	}
+	body := octollm.NewBodyFromBytes(bytes, &octollm.JSONParser[vertex.GenerateContentResponse]{})
+	select {
+	case ch <- &octollm.StreamChunk{Body: body}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reasoningContent reads the non-standard reasoning_content field returned by some providers.
+func reasoningContent(extraFields map[string]respjson.Field) string {
+	field, ok := extraFields["reasoning_content"]
+	if !ok || field.Raw() == "" {
+		return ""
+	}
+	var s string
+	if err := json.Unmarshal([]byte(field.Raw()), &s); err != nil {
+		return ""
+	}
+	return s
+}
+
+// functionArgs parses tool call arguments, vertex requires them to be a JSON object.
+func (e *ChatCompletionsToVertexGenerateContent) functionArgs(ctx context.Context, arguments string) map[string]any {
+	args := make(map[string]any)
+	if strings.TrimSpace(arguments) == "" {
+		return args
+	}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		logrus.WithContext(ctx).Warnf("invalid tool call arguments, replaced with {}: %s", arguments)
+		return make(map[string]any)
+	}
+	return args
+}
+
+// mapUsage converts openai usage to vertex usage. Vertex reports thoughts separately from candidates,
+// while openai completion_tokens includes the reasoning tokens.
+func (e *ChatCompletionsToVertexGenerateContent) mapUsage(usage *openaiSDK.CompletionUsage) *vertex.UsageMetadata {
+	reasoningTokens := int(usage.CompletionTokensDetails.ReasoningTokens)
+	return &vertex.UsageMetadata{
+		PromptTokenCount:        int(usage.PromptTokens),
+		CandidatesTokenCount:    int(usage.CompletionTokens) - reasoningTokens,
+		TotalTokenCount:         int(usage.TotalTokens),
+		CachedContentTokenCount: int(usage.PromptTokensDetails.CachedTokens),
+		ThoughtsTokenCount:      reasoningTokens,
+	}
+}
+
+func (e *ChatCompletionsToVertexGenerateContent) mapFinishReason(fr string) string {
+	switch fr {
+	case "stop", "tool_calls", "function_call", "":
+		return vertex.FinishReasonStop
+	case "length":
+		return vertex.FinishReasonMaxTokens
+	case "content_filter":
+		return vertex.FinishReasonSafety
+	default:
+		return "OTHER"
+	}
+}
+
+// jsonSchemaFromVertex converts a vertex (OpenAPI) schema into a JSON schema, the type names are upper case in vertex.
+func jsonSchemaFromVertex(schema any) any {
+	switch s := schema.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(s))
+		for k, v := range s {
+			if t, ok := v.(string); ok && k == "type" {
+				out[k] = strings.ToLower(t)
+				continue
+			}
+			out[k] = jsonSchemaFromVertex(v)
+		}
+		return out
+	case []any:
+		out := make([]any, len(s))
+		for i, v := range s {
+			out[i] = jsonSchemaFromVertex(v)
+		}
+		return out
+	default:
+		return schema
+	}
+}
+
+// jsonSchemaToVertex converts a JSON schema into a vertex (OpenAPI) schema,
+// dropping the keywords that vertex does not accept.
+func jsonSchemaToVertex(schema any) any {
+	switch s := schema.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(s))
+		for k, v := range s {
+			switch k {
+			case "$schema", "additionalProperties", "strict":
+				continue
+			case "type":
+				if t, ok := v.(string); ok {
+					out[k] = strings.ToUpper(t)
+					continue
+				}
+			}
+			out[k] = jsonSchemaToVertex(v)
+		}
+		return out
+	case []any:
+		out := make([]any, len(s))
+		for i, v := range s {
+			out[i] = jsonSchemaToVertex(v)
+		}
+		return out
+	default:
+		return schema
+	}
+}
@@ -0,0 +1,197 @@
+package converter
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	openaiSDK "github.com/openai/openai-go/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/infinigence/octollm/pkg/octollm"
+	"github.com/infinigence/octollm/pkg/types/vertex"
+)
+
+func TestChatCompletionsToVertexGenerateContent_NonStream_SimpleText(t *testing.T) {
+	ctx := context.Background()
+
+	vertexReqJSON := `{
+		"systemInstruction": {"parts": [{"text": "You are a helpful assistant."}]},
+		"contents": [
+			{"role": "user", "parts": [{"text": "Hello, how are you?"}]}
+		],
+		"generationConfig": {"temperature": 0.5, "maxOutputTokens": 100, "stopSequences": ["END"]}
+	}`
+
+	expectedOpenaiReqJSON := `{
+		"model": "gemini-2.5-flash",
+		"max_tokens": 100,
+		"temperature": 0.5,
+		"stop": ["END"],
+		"messages": [
+			{"role": "system", "content": "You are a helpful assistant."},
+			{"role": "user", "content": [{"type": "text", "text": "Hello, how are you?"}]}
+		]
+	}`
+
+	openaiRespJSON := `{
+		"id": "chatcmpl-1",
+		"object": "chat.completion",
+		"created": 1700000000,
+		"model": "gemini-2.5-flash",
+		"choices": [{
+			"index": 0,
+			"message": {"role": "assistant", "content": "I'm doing well!"},
+			"finish_reason": "stop"
+		}],
+		"usage": {"prompt_tokens": 10, "completion_tokens": 5, "total_tokens": 15}
+	}`
+
+	expectedVertexRespJSON := `{
+		"candidates": [{
+			"content": {"role": "model", "parts": [{"text": "I'm doing well!"}]},
+			"finishReason": "STOP",
+			"index": 0
+		}],
+		"usageMetadata": {"promptTokenCount": 10, "candidatesTokenCount": 5, "totalTokenCount": 15},
+		"modelVersion": "gemini-2.5-flash",
+		"responseId": "chatcmpl-1"
+	}`
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", "http://localhost/v1beta/models/gemini-2.5-flash:generateContent", nil)
+	require.NoError(t, err)
+	req := octollm.NewRequest(httpReq, octollm.APIFormatVertexGenerateContent)
+	req.Body = octollm.NewBodyFromBytes([]byte(vertexReqJSON), &octollm.JSONParser[vertex.GenerateContentRequest]{})
+
+	mockEng := newMockEngine(t)
+	mockEng.expectedRequestCheck = func(t *testing.T, req *octollm.Request) {
+		assert.Equal(t, octollm.APIFormatChatCompletions, req.Format)
+		bytes, err := req.Body.Bytes()
+		require.NoError(t, err)
+		assert.JSONEq(t, expectedOpenaiReqJSON, string(bytes))
+	}
+	mockEng.responseToReturn = &octollm.Response{
+		StatusCode: 200,
+		Header:     http.Header{},
+		Body:       octollm.NewBodyFromBytes([]byte(openaiRespJSON), &octollm.JSONParser[openaiSDK.ChatCompletion]{}),
+	}
+
+	resp, err := NewChatCompletionsToVertexGenerateContent(mockEng).Process(req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+
+	respBytes, err := resp.Body.Bytes()
+	require.NoError(t, err)
+	assert.JSONEq(t, expectedVertexRespJSON, string(respBytes))
+}
+
+func TestChatCompletionsToVertexGenerateContent_convertRequestBody_FunctionCalling(t *testing.T) {
+	vertexReqJSON := `{
+		"contents": [
+			{"role": "user", "parts": [
+				{"text": "What is in the image and what is the weather?"},
+				{"inlineData": {"mimeType": "image/png", "data": "iVBORw0KGgo="}}
+			]},
+			{"role": "model", "parts": [
+				{"text": "thinking...", "thought": true},
+				{"functionCall": {"name": "get_weather", "args": {"location": "Paris"}}}
+			]},
+			{"role": "user", "parts": [
+				{"functionResponse": {"name": "get_weather", "response": {"content": "Sunny"}}}
+			]}
+		],
+		"tools": [{"functionDeclarations": [{
+			"name": "get_weather",
+			"description": "Get the weather",
+			"parameters": {"type": "OBJECT", "properties": {"location": {"type": "STRING"}}, "required": ["location"]}
+		}]}],
+		"toolConfig": {"functionCallingConfig": {"mode": "ANY", "allowedFunctionNames": ["get_weather"]}}
+	}`
+
+	expectedOpenaiReqJSON := `{
+		"model": "gemini-2.5-flash",
+		"stream": true,
+		"stream_options": {"include_usage": true},
+		"messages": [
+			{"role": "user", "content": [
+				{"type": "text", "text": "What is in the image and what is the weather?"},
+				{"type": "image_url", "image_url": {"url": "data:image/png;base64,iVBORw0KGgo="}}
+			]},
+			{"role": "assistant", "content": "", "tool_calls": [
+				{"id": "call_0", "type": "function", "function": {"name": "get_weather", "arguments": "{\"location\":\"Paris\"}"}}
+			]},
+			{"role": "tool", "tool_call_id": "call_0", "content": "Sunny"}
+		],
+		"tools": [{"type": "function", "function": {
+			"name": "get_weather",
+			"description": "Get the weather",
+			"parameters": {"type": "object", "properties": {"location": {"type": "string"}}, "required": ["location"]}
+		}}],
+		"tool_choice": {"type": "function", "function": {"name": "get_weather"}}
+	}`
+
+	converter := NewChatCompletionsToVertexGenerateContent(nil)
+	srcBody := octollm.NewBodyFromBytes([]byte(vertexReqJSON), &octollm.JSONParser[vertex.GenerateContentRequest]{})
+
+	dstBody, err := converter.convertRequestBody(context.Background(), srcBody, "gemini-2.5-flash", true)
+	require.NoError(t, err)
+
+	bytes, err := dstBody.Bytes()
+	require.NoError(t, err)
+	if !assert.JSONEq(t, expectedOpenaiReqJSON, string(bytes)) {
+		t.Logf("Got: %s", string(bytes))
+	}
+}
+
+func TestChatCompletionsToVertexGenerateContent_convertStreamResponse_ToolCallWithUsage(t *testing.T) {
+	openaiChunksJSON := []string{
+		`{"id":"chatcmpl-2","object":"chat.completion.chunk","created":1,"model":"gpt-4o","choices":[{"index":0,"delta":{"role":"assistant","content":""},"finish_reason":null}]}`,
+		`{"id":"chatcmpl-2","object":"chat.completion.chunk","created":1,"model":"gpt-4o","choices":[{"index":0,"delta":{"reasoning_content":"Use the tool."},"finish_reason":null}]}`,
+		`{"id":"chatcmpl-2","object":"chat.completion.chunk","created":1,"model":"gpt-4o","choices":[{"index":0,"delta":{"content":"Let me check."},"finish_reason":null}]}`,
+		`{"id":"chatcmpl-2","object":"chat.completion.chunk","created":1,"model":"gpt-4o","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"get_weather","arguments":""}}]},"finish_reason":null}]}`,
+		`{"id":"chatcmpl-2","object":"chat.completion.chunk","created":1,"model":"gpt-4o","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"location\":"}}]},"finish_reason":null}]}`,
+		`{"id":"chatcmpl-2","object":"chat.completion.chunk","created":1,"model":"gpt-4o","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"Paris\"}"}}]},"finish_reason":null}]}`,
+		`{"id":"chatcmpl-2","object":"chat.completion.chunk","created":1,"model":"gpt-4o","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+		`{"id":"chatcmpl-2","object":"chat.completion.chunk","created":1,"model":"gpt-4o","choices":[],"usage":{"prompt_tokens":20,"completion_tokens":12,"total_tokens":32,"completion_tokens_details":{"reasoning_tokens":4}}}`,
+		`[DONE]`,
+	}
+
+	expectedVertexChunksJSON := []string{
+		`{"candidates":[{"content":{"role":"model","parts":[{"text":"Use the tool.","thought":true}]},"index":0}],"modelVersion":"gpt-4o","responseId":"chatcmpl-2"}`,
+		`{"candidates":[{"content":{"role":"model","parts":[{"text":"Let me check."}]},"index":0}],"modelVersion":"gpt-4o","responseId":"chatcmpl-2"}`,
+		`{"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"id":"call_1","name":"get_weather","args":{"location":"Paris"}}}]},"finishReason":"STOP","index":0}],"usageMetadata":{"promptTokenCount":20,"candidatesTokenCount":8,"totalTokenCount":32,"thoughtsTokenCount":4},"modelVersion":"gpt-4o","responseId":"chatcmpl-2"}`,
+	}
+
+	ctx := context.Background()
+	converter := NewChatCompletionsToVertexGenerateContent(nil)
+
+	inCh := make(chan *octollm.StreamChunk)
+	closed := false
+	closeFunc := func() { closed = true }
+
+	go func() {
+		defer close(inCh)
+		for _, chunk := range openaiChunksJSON {
+			body := octollm.NewBodyFromBytes([]byte(chunk), &octollm.JSONParser[openaiSDK.ChatCompletionChunk]{})
+			inCh <- &octollm.StreamChunk{Body: body}
+		}
+	}()
+	inStream := octollm.NewStreamChan(inCh, closeFunc)
+
+	dstStream, err := converter.convertStreamResponse(ctx, inStream)
+	require.NoError(t, err)
+
+	i := 0
+	for dstChunk := range dstStream.Chan() {
+		bodyBytes, err := dstChunk.Body.Bytes()
+		require.NoError(t, err)
+		require.Less(t, i, len(expectedVertexChunksJSON))
+		require.JSONEq(t, expectedVertexChunksJSON[i], string(bodyBytes))
+		i++
+	}
+	dstStream.Close()
+
+	require.Equal(t, len(expectedVertexChunksJSON), i)
+	require.True(t, closed)
+}
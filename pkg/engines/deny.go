@@ -1,20 +1,29 @@
 package engines
 
 import (
+	"fmt"
+	"net/http"
+
 	"github.com/infinigence/octollm/pkg/errutils"
 	"github.com/infinigence/octollm/pkg/octollm"
 )
 
+// DenyEngine rejects requests. The reason is returned as the error message in the protocol of the request.
 type DenyEngine struct {
 	ReasonText     string `json:"reason_text" yaml:"reason_text"`
-	HTTPStatusCode int    `json:"http_status_code" yaml:"http_status_code"`
+	HTTPStatusCode int    `json:"http_status_code" yaml:"http_status_code"` // defaults to 403
 }
 
 var _ octollm.Engine = (*DenyEngine)(nil)
 
 func (e *DenyEngine) Process(req *octollm.Request) (*octollm.Response, error) {
-	return nil, &errutils.UpstreamRespError{
-		StatusCode: e.HTTPStatusCode,
-		Body:       []byte(e.ReasonText),
+	status := e.HTTPStatusCode
+	if status == 0 {
+		status = http.StatusForbidden
+	}
+	msg := e.ReasonText
+	if msg == "" {
+		msg = http.StatusText(status)
 	}
+	return nil, errutils.NewHandlerError(fmt.Errorf("request denied: %s", msg), status, msg)
 }
@@ -0,0 +1,144 @@
+package engines
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/infinigence/octollm/pkg/octollm"
+)
+
+// ExperimentHeader tells the client the experiment and arm serving the request, e.g. "new-prompt=treatment".
+const ExperimentHeader = "X-Octollm-Experiment"
+
+// experimentBuckets is the resolution of the split of an experiment.
+const experimentBuckets = 10000
+
+// ArmWeight is the share of the traffic of an arm of an Experiment.
+type ArmWeight struct {
+	Name   string `json:"name"`
+	Weight int    `json:"weight"`
+}
+
+// Experiment splits traffic between arms by a hash of a key of the requests, e.g. the user, so that all requests
+// with the same key go to the same arm. The weights can be changed at runtime. The arms take consecutive ranges of
+// buckets in order, so with two arms, growing the share of the second one only moves keys from the first one to it.
+// With more arms, changing a weight also shifts the ranges of the arms after it, moving some keys between them.
+type Experiment struct {
+	Name  string
+	KeyOf func(req *octollm.Request) string
+
+	mu   sync.RWMutex
+	arms []ArmWeight
+}
+
+func NewExperiment(name string, keyOf func(req *octollm.Request) string, arms []ArmWeight) (*Experiment, error) {
+	x := &Experiment{Name: name, KeyOf: keyOf}
+	if err := x.setArms(slices.Clone(arms)); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+func (x *Experiment) setArms(arms []ArmWeight) error {
+	if len(arms) == 0 {
+		return fmt.Errorf("experiment %s has no arms", x.Name)
+	}
+	total := 0
+	seen := make(map[string]bool, len(arms))
+	for _, arm := range arms {
+		if arm.Name == "" {
+			return fmt.Errorf("experiment %s: arm name must not be empty", x.Name)
+		}
+		if seen[arm.Name] {
+			return fmt.Errorf("experiment %s: duplicate arm %s", x.Name, arm.Name)
+		}
+		seen[arm.Name] = true
+		if arm.Weight < 0 {
+			return fmt.Errorf("experiment %s: negative weight of arm %s", x.Name, arm.Name)
+		}
+		total += arm.Weight
+	}
+	if total == 0 {
+		return fmt.Errorf("experiment %s: the weights of the arms sum to 0", x.Name)
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.arms = arms
+	return nil
+}
+
+// Weights returns the current weights of the arms, in order.
+func (x *Experiment) Weights() []ArmWeight {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return slices.Clone(x.arms)
+}
+
+// SetWeights ramps the arms of the experiment, the arms left out of weights keep theirs.
+func (x *Experiment) SetWeights(weights map[string]int) error {
+	arms := x.Weights()
+	for name, weight := range weights {
+		i := slices.IndexFunc(arms, func(arm ArmWeight) bool { return arm.Name == name })
+		if i < 0 {
+			return fmt.Errorf("experiment %s has no arm %s", x.Name, name)
+		}
+		arms[i].Weight = weight
+	}
+	return x.setArms(arms)
+}
+
+// Assign returns the arm of a key.
+func (x *Experiment) Assign(key string) string {
+	hasher := fnv.New64a()
+	hasher.Write([]byte(x.Name))
+	hasher.Write([]byte{0})
+	hasher.Write([]byte(key))
+	bucket := int(hasher.Sum64() % experimentBuckets)
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	total := 0
+	for _, arm := range x.arms {
+		total += arm.Weight
+	}
+	target := bucket * total / experimentBuckets
+	for _, arm := range x.arms {
+		if target < arm.Weight {
+			return arm.Name
+		}
+		target -= arm.Weight
+	}
+	return x.arms[len(x.arms)-1].Name
+}
+
+// ExperimentEngine sends each request to the engine of its arm. The arm is tagged on the request as
+// experiment=<name> and arm=<arm>, and returned in the ExperimentHeader of the response.
+type ExperimentEngine struct {
+	Experiment *Experiment
+	Arms       map[string]octollm.Engine // by arm name
+}
+
+var _ octollm.Engine = (*ExperimentEngine)(nil)
+
+func (e *ExperimentEngine) Process(req *octollm.Request) (*octollm.Response, error) {
+	arm := e.Experiment.Assign(e.Experiment.KeyOf(req))
+	engine, ok := e.Arms[arm]
+	if !ok {
+		return nil, fmt.Errorf("experiment %s: no engine for arm %s", e.Experiment.Name, arm)
+	}
+	req.Tags().Set("experiment", e.Experiment.Name)
+	req.Tags().Set("arm", arm)
+
+	resp, err := engine.Process(req)
+	if resp != nil {
+		if resp.Header == nil {
+			resp.Header = make(http.Header)
+		}
+		resp.Header.Set(ExperimentHeader, e.Experiment.Name+"="+arm)
+	}
+	return resp, err
+}
@@ -0,0 +1,63 @@
+package engines
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/infinigence/octollm/pkg/octollm"
+)
+
+func TestExperiment_Assign(t *testing.T) {
+	x, err := NewExperiment("prompt", nil, []ArmWeight{{Name: "control", Weight: 90}, {Name: "treatment", Weight: 10}})
+	require.NoError(t, err)
+
+	assigned := make(map[string]string)
+	counts := make(map[string]int)
+	for i := range 10000 {
+		key := fmt.Sprintf("user-%d", i)
+		arm := x.Assign(key)
+		assert.Equal(t, arm, x.Assign(key), "sticky")
+		assigned[key] = arm
+		counts[arm]++
+	}
+	assert.InDelta(t, 1000, counts["treatment"], 150)
+
+	// ramping the treatment up only moves keys into it
+	require.NoError(t, x.SetWeights(map[string]int{"treatment": 50}))
+	counts = make(map[string]int)
+	for key, before := range assigned {
+		arm := x.Assign(key)
+		if before == "treatment" {
+			assert.Equal(t, "treatment", arm, key)
+		}
+		counts[arm]++
+	}
+	assert.InDelta(t, 10000*50/140, counts["treatment"], 250)
+	assert.Equal(t, []ArmWeight{{Name: "control", Weight: 90}, {Name: "treatment", Weight: 50}}, x.Weights())
+
+	assert.ErrorContains(t, x.SetWeights(map[string]int{"unknown": 1}), "no arm unknown")
+	assert.ErrorContains(t, x.SetWeights(map[string]int{"control": 0, "treatment": 0}), "sum to 0")
+}
+
+func TestExperimentEngine(t *testing.T) {
+	x, err := NewExperiment("prompt", func(req *octollm.Request) string { return octollm.IdentityFrom(req.Context()).User },
+		[]ArmWeight{{Name: "control", Weight: 0}, {Name: "treatment", Weight: 1}})
+	require.NoError(t, err)
+	e := &ExperimentEngine{Experiment: x, Arms: map[string]octollm.Engine{
+		"control":   chatAnswer("control"),
+		"treatment": chatAnswer("treatment"),
+	}}
+
+	req := newTestRequest(t, context.Background(), `{"model":"m"}`)
+	req = req.WithContext(octollm.WithIdentity(req.Context(), octollm.Identity{User: "alice"}))
+	resp, err := e.Process(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "prompt=treatment", resp.Header.Get(ExperimentHeader))
+	assert.Equal(t, "arm=treatment,experiment=prompt", req.Tags().String())
+}
@@ -0,0 +1,246 @@
+package engines
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/infinigence/octollm/pkg/errutils"
+	"github.com/infinigence/octollm/pkg/octollm"
+)
+
+// HeaderPolicy controls the headers exchanged with an upstream. Names are case-insensitive, and a name ending with
+// "*" matches the headers starting with it, e.g. "X-RateLimit-*".
+//
+// The request headers are filtered by Allow and Deny, then Set and Remove apply. The values of Set may refer to the
+// caller and the request as {{org}}, {{user}}, {{model}}, {{client_ip}} and {{tag.<name>}}, a header whose value
+// comes out empty is removed, so that the client cannot set it itself.
+type HeaderPolicy struct {
+	Allow         []string          `json:"allow" yaml:"allow"`                   // request headers forwarded, all if empty
+	Deny          []string          `json:"deny" yaml:"deny"`                     // request headers never forwarded
+	Set           map[string]string `json:"set" yaml:"set"`                       // request headers set
+	Remove        []string          `json:"remove" yaml:"remove"`                 // request headers removed, after Set
+	ResponseAllow []string          `json:"response_allow" yaml:"response_allow"` // response headers returned, all if empty
+	ResponseDeny  []string          `json:"response_deny" yaml:"response_deny"`   // response headers never returned
+
+	templates map[string]headerTemplate // compiled Set by header, set by Compile
+}
+
+// DefaultHeaderPolicy returns the policy of all upstreams, which the policies of the backends extend: the
+// credentials and cookies of the client, and the headers of its connection, are not forwarded, and the cookies of
+// the upstream are not returned. The credentials of the upstream are set after the policy applies.
+func DefaultHeaderPolicy() *HeaderPolicy {
+	return &HeaderPolicy{
+		Deny:         []string{"Authorization", "Cookie", "X-Api-Key", "X-Goog-Api-Key", "Host", "Content-Length", "Accept-Encoding"},
+		ResponseDeny: []string{"Set-Cookie"},
+	}
+}
+
+// Compile parses the templates of Set, so that errors show up when the config is loaded.
+// It must be called before the policy is used by requests.
+func (p *HeaderPolicy) Compile() error {
+	if p == nil {
+		return nil
+	}
+	templates := make(map[string]headerTemplate, len(p.Set))
+	for _, k := range slices.Sorted(maps.Keys(p.Set)) {
+		t, err := parseHeaderTemplate(p.Set[k])
+		if err != nil {
+			return fmt.Errorf("set %s: %w", k, err)
+		}
+		templates[k] = t
+	}
+	p.templates = templates
+	return nil
+}
+
+// Merge returns p overridden by other: other wins on the headers of Set, the allowlists of other replace the ones of
+// p when not empty, and the denylists and Remove are joined.
+func (p *HeaderPolicy) Merge(other *HeaderPolicy) *HeaderPolicy {
+	if other == nil {
+		return p
+	}
+	if p == nil {
+		return other
+	}
+
+	merged := &HeaderPolicy{
+		Allow:         p.Allow,
+		Deny:          slices.Concat(p.Deny, other.Deny),
+		Set:           make(map[string]string),
+		Remove:        slices.Concat(p.Remove, other.Remove),
+		ResponseAllow: p.ResponseAllow,
+		ResponseDeny:  slices.Concat(p.ResponseDeny, other.ResponseDeny),
+	}
+	if len(other.Allow) > 0 {
+		merged.Allow = other.Allow
+	}
+	if len(other.ResponseAllow) > 0 {
+		merged.ResponseAllow = other.ResponseAllow
+	}
+	maps.Copy(merged.Set, p.Set)
+	maps.Copy(merged.Set, other.Set)
+	return merged
+}
+
+// RequestHeader returns the headers of req to send upstream.
+func (p *HeaderPolicy) RequestHeader(req *octollm.Request) (http.Header, error) {
+	header := filterHeader(req.Header, p.Allow, p.Deny)
+	for _, k := range slices.Sorted(maps.Keys(p.Set)) {
+		t, ok := p.templates[k]
+		if !ok {
+			var err error
+			if t, err = parseHeaderTemplate(p.Set[k]); err != nil {
+				return nil, fmt.Errorf("header %s: %w", k, err)
+			}
+		}
+		if v := t.render(req); v != "" {
+			header.Set(k, v)
+		} else {
+			header.Del(k)
+		}
+	}
+	for k := range header {
+		if matchHeader(p.Remove, k) {
+			header.Del(k)
+		}
+	}
+	return header, nil
+}
+
+// ResponseHeader returns the headers of an upstream response to return to the client.
+func (p *HeaderPolicy) ResponseHeader(header http.Header) http.Header {
+	return filterHeader(header, p.ResponseAllow, p.ResponseDeny)
+}
+
+func filterHeader(header http.Header, allow, deny []string) http.Header {
+	filtered := make(http.Header, len(header))
+	for k, v := range header {
+		if len(allow) > 0 && !matchHeader(allow, k) {
+			continue
+		}
+		if matchHeader(deny, k) {
+			continue
+		}
+		filtered[k] = slices.Clone(v)
+	}
+	return filtered
+}
+
+func matchHeader(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if len(name) >= len(prefix) && strings.EqualFold(name[:len(prefix)], prefix) {
+				return true
+			}
+		} else if strings.EqualFold(name, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// headerTemplate is a parsed header value, the literal text and the placeholders in order.
+type headerTemplate []headerTemplatePart
+
+type headerTemplatePart struct {
+	text string
+	ref  string // placeholder, without the braces
+}
+
+func parseHeaderTemplate(s string) (headerTemplate, error) {
+	var t headerTemplate
+	for {
+		start := strings.Index(s, "{{")
+		if start < 0 {
+			if s != "" {
+				t = append(t, headerTemplatePart{text: s})
+			}
+			return t, nil
+		}
+		end := strings.Index(s[start:], "}}")
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed {{ in %q", s)
+		}
+		ref := strings.TrimSpace(s[start+2 : start+end])
+		switch {
+		case ref == "org", ref == "user", ref == "model", ref == "client_ip":
+		case strings.HasPrefix(ref, "tag.") && len(ref) > len("tag."):
+		default:
+			return nil, fmt.Errorf("unknown placeholder {{%s}}", ref)
+		}
+		if start > 0 {
+			t = append(t, headerTemplatePart{text: s[:start]})
+		}
+		t = append(t, headerTemplatePart{ref: ref})
+		s = s[start+end+2:]
+	}
+}
+
+// render returns the value of the template for req, empty if a placeholder is.
+func (t headerTemplate) render(req *octollm.Request) string {
+	var sb strings.Builder
+	for _, part := range t {
+		if part.ref == "" {
+			sb.WriteString(part.text)
+			continue
+		}
+		var v string
+		switch part.ref {
+		case "org":
+			v = octollm.IdentityFrom(req.Context()).Org
+		case "user":
+			v = octollm.IdentityFrom(req.Context()).User
+		case "model":
+			v = req.Model
+		case "client_ip":
+			v = req.ClientIP
+		default:
+			v = req.Tags().Get(strings.TrimPrefix(part.ref, "tag."))
+		}
+		if v == "" {
+			return ""
+		}
+		sb.WriteString(v)
+	}
+	return sb.String()
+}
+
+// HeaderEngine applies a HeaderPolicy to the requests to Next and to their responses, including the headers of
+// upstream error responses.
+type HeaderEngine struct {
+	Policy *HeaderPolicy
+	Next   octollm.Engine
+}
+
+var _ octollm.Engine = (*HeaderEngine)(nil)
+
+func NewHeaderEngine(next octollm.Engine, policy *HeaderPolicy) *HeaderEngine {
+	return &HeaderEngine{Policy: policy, Next: next}
+}
+
+func (e *HeaderEngine) Process(req *octollm.Request) (*octollm.Response, error) {
+	header, err := e.Policy.RequestHeader(req)
+	if err != nil {
+		return nil, err
+	}
+	// the request may go on to other backends, e.g. on retries, with their own policies
+	upstreamReq := req.WithContext(req.Context())
+	upstreamReq.Header = header
+
+	resp, err := e.Next.Process(upstreamReq)
+	if err != nil {
+		httpErr := &errutils.UpstreamRespError{}
+		if errors.As(err, &httpErr) {
+			httpErr.Header = e.Policy.ResponseHeader(httpErr.Header)
+		}
+		return resp, err
+	}
+	if resp != nil {
+		resp.Header = e.Policy.ResponseHeader(resp.Header)
+	}
+	return resp, nil
+}
@@ -0,0 +1,92 @@
+package engines
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/infinigence/octollm/pkg/errutils"
+	"github.com/infinigence/octollm/pkg/octollm"
+)
+
+func TestHeaderEngine(t *testing.T) {
+	var sent http.Header
+	upstream := octollm.EngineFunc(func(req *octollm.Request) (*octollm.Response, error) {
+		sent = req.Header
+		header := http.Header{
+			"Content-Type":          {"application/json"},
+			"Set-Cookie":            {"session=1"},
+			"X-Ratelimit-Remaining": {"10"},
+		}
+		if req.Header.Get("X-Fail") != "" {
+			return nil, &errutils.UpstreamRespError{StatusCode: http.StatusTooManyRequests, Header: header}
+		}
+		return octollm.NewNonStreamResponse(http.StatusOK, header, octollm.NewBodyFromBytes([]byte(`{}`), nil)), nil
+	})
+	policy := &HeaderPolicy{
+		Deny:         []string{"authorization", "Cookie", "X-Internal-*"},
+		Set:          map[string]string{"X-Org": "{{org}}", "X-Caller": "{{org}}/{{user}}", "X-Arm": "arm-{{tag.arm}}"},
+		Remove:       []string{"X-Debug"},
+		ResponseDeny: []string{"Set-Cookie", "X-RateLimit-*"},
+	}
+	require.NoError(t, policy.Compile())
+	e := NewHeaderEngine(upstream, policy)
+
+	req := newTestRequest(t, context.Background(), `{}`)
+	req = req.WithContext(octollm.WithIdentity(req.Context(), octollm.Identity{Org: "acme"}))
+	req.Header.Set("Authorization", "Bearer client-key")
+	req.Header.Set("Cookie", "a=b")
+	req.Header.Set("X-Internal-Trace", "1")
+	req.Header.Set("X-Debug", "1")
+	req.Header.Set("X-Caller", "spoofed")
+	req.Header.Set("X-Request-Id", "r1")
+	resp, err := e.Process(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.Header{"X-Request-Id": {"r1"}, "X-Org": {"acme"}}, sent)
+	assert.Equal(t, http.Header{"Content-Type": {"application/json"}}, resp.Header)
+	// the client request is left alone for the other backends
+	assert.Equal(t, "Bearer client-key", req.Header.Get("Authorization"))
+
+	req.Tags().Set("arm", "b")
+	req.Header.Set("X-Fail", "1")
+	_, err = e.Process(req)
+	httpErr := &errutils.UpstreamRespError{}
+	require.ErrorAs(t, err, &httpErr)
+	assert.Equal(t, http.Header{"Content-Type": {"application/json"}}, httpErr.Header)
+	assert.Equal(t, "arm-b", sent.Get("X-Arm"))
+}
+
+func TestHeaderPolicy_Allow(t *testing.T) {
+	policy := &HeaderPolicy{Allow: []string{"X-Request-Id", "Accept*"}, ResponseAllow: []string{"Content-Type"}}
+	require.NoError(t, policy.Compile())
+	req := newTestRequest(t, context.Background(), `{}`)
+	req.Header.Set("X-Request-Id", "r1")
+	req.Header.Set("Accept-Language", "en")
+	req.Header.Set("Authorization", "Bearer client-key")
+	header, err := policy.RequestHeader(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.Header{"X-Request-Id": {"r1"}, "Accept-Language": {"en"}}, header)
+	assert.Equal(t, http.Header{"Content-Type": {"text/plain"}},
+		policy.ResponseHeader(http.Header{"Content-Type": {"text/plain"}, "Server": {"upstream"}}))
+}
+
+func TestHeaderPolicy_Merge(t *testing.T) {
+	base := &HeaderPolicy{Allow: []string{"A"}, Deny: []string{"B"}, Set: map[string]string{"X": "1", "Y": "1"}}
+	override := &HeaderPolicy{Deny: []string{"C"}, Set: map[string]string{"Y": "2"}}
+	merged := base.Merge(override)
+	assert.Equal(t, []string{"A"}, merged.Allow)
+	assert.Equal(t, []string{"B", "C"}, merged.Deny)
+	assert.Equal(t, map[string]string{"X": "1", "Y": "2"}, merged.Set)
+
+	assert.Equal(t, []string{"D"}, base.Merge(&HeaderPolicy{Allow: []string{"D"}}).Allow)
+}
+
+func TestHeaderPolicy_CompileErrors(t *testing.T) {
+	for _, v := range []string{"{{org", "{{tag.}}", "{{ env.KEY }}"} {
+		p := &HeaderPolicy{Set: map[string]string{"X": v}}
+		assert.Error(t, p.Compile(), v)
+	}
+}
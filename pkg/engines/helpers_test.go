@@ -0,0 +1,48 @@
+package engines
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/infinigence/octollm/pkg/octollm"
+)
+
+// newTestRequest returns a chat completions request, with its body to be read through its reader.
+func newTestRequest(t *testing.T, ctx context.Context, body string) *octollm.Request {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://localhost/v1/chat/completions", strings.NewReader(body))
+	require.NoError(t, err)
+	req := octollm.NewRequest(httpReq, octollm.APIFormatChatCompletions)
+	req.Body.SetParser(octollm.NewRequestParser(octollm.APIFormatChatCompletions))
+	return req
+}
+
+// chatAnswer answers chat completions requests with content.
+func chatAnswer(content string) octollm.Engine {
+	return octollm.EngineFunc(func(req *octollm.Request) (*octollm.Response, error) {
+		b := []byte(`{"choices":[{"message":{"role":"assistant","content":"` + content + `"}}]}`)
+		return octollm.NewNonStreamResponse(http.StatusOK, nil, octollm.NewBodyFromBytes(b, nil)), nil
+	})
+}
+
+// chatStream answers chat completions requests with a stream of a chunk per content.
+func chatStream(contents ...string) octollm.Engine {
+	return octollm.EngineFunc(func(req *octollm.Request) (*octollm.Response, error) {
+		ch := make(chan *octollm.StreamChunk)
+		go func() {
+			defer close(ch)
+			for _, c := range contents {
+				b := []byte(`{"choices":[{"delta":{"content":"` + c + `"}}]}`)
+				select {
+				case ch <- &octollm.StreamChunk{Body: octollm.NewBodyFromBytes(b, nil)}:
+				case <-req.Context().Done():
+					return
+				}
+			}
+		}()
+		return octollm.NewStreamResponse(http.StatusOK, nil, octollm.NewStreamChan(ch, nil)), nil
+	})
+}
@@ -0,0 +1,226 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+
+	"github.com/infinigence/octollm/pkg/errutils"
+	"github.com/infinigence/octollm/pkg/octollm"
+)
+
+// Limits are the limits enforced by a LimiterEngine. Zero values mean unlimited.
+type Limits struct {
+	RPM         int // requests per minute
+	RPD         int // requests per day
+	TPM         int // tokens per minute
+	TPD         int // tokens per day
+	Concurrency int // requests in flight, streams count until they are closed
+
+	// Wait makes requests over the limits wait for capacity (or the client to go away),
+	// instead of failing with 429.
+	Wait bool
+}
+
+func (l *Limits) limitsTokens() bool {
+	return l.TPM > 0 || l.TPD > 0
+}
+
+// LimiterEngine enforces Limits on the requests passed to next, with the counters kept under key in store.
+// Token limits are checked against a reservation estimated from the request body size,
+// which is reconciled with the usage reported by the upstream once the response is done.
+type LimiterEngine struct {
+	next   octollm.Engine
+	store  Store
+	key    string
+	limits Limits
+
+	mu       sync.Mutex
+	released chan struct{} // closed and replaced each time this engine gives back capacity
+}
+
+var _ octollm.Engine = (*LimiterEngine)(nil)
+
+func NewLimiterEngine(next octollm.Engine, store Store, key string, limits Limits) *LimiterEngine {
+	return &LimiterEngine{
+		next:     next,
+		store:    store,
+		key:      key,
+		limits:   limits,
+		released: make(chan struct{}),
+	}
+}
+
+func (e *LimiterEngine) Process(req *octollm.Request) (*octollm.Response, error) {
+	var reserved int64
+	var hideUsage bool
+	if e.limits.limitsTokens() {
+		reserved = estimateTokens(req)
+		hideUsage = includeUsage(req)
+	}
+	lease, err := e.acquire(req.Context(), reserved)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := e.next.Process(req)
+	if err != nil {
+		// nothing was generated, give back the reservation
+		e.finish(req.Context(), lease, reserved, &tokenUsage{})
+		return nil, err
+	}
+
+	if resp.Stream != nil {
+		resp.Stream = e.wrapStream(req.Context(), resp.Stream, lease, reserved, hideUsage)
+		return resp, nil
+	}
+
+	var usage *tokenUsage
+	if e.limits.limitsTokens() && resp.Body != nil {
+		if b, err := resp.Body.Bytes(); err == nil {
+			usage, _ = usageFromBody(b)
+		}
+	}
+	e.finish(req.Context(), lease, reserved, usage)
+	return resp, nil
+}
+
+// wrapStream gives back the concurrency slot when the stream ends, and reconciles the token reservation
+// with the usage found in the chunks. With hideUsage, the usage chunk asked for by includeUsage is not passed on.
+func (e *LimiterEngine) wrapStream(ctx context.Context, src *octollm.StreamChan, lease string, reserved int64, hideUsage bool) *octollm.StreamChan {
+	inCh := src.Chan()
+	outCh := make(chan *octollm.StreamChunk)
+	ctx, cancel := context.WithCancel(ctx)
+	var closeOnce sync.Once
+	closeSrc := func() { closeOnce.Do(src.Close) }
+
+	go func() {
+		defer close(outCh)
+		defer closeSrc()
+
+		var usage *tokenUsage
+		defer func() { e.finish(ctx, lease, reserved, usage) }()
+
+		for chunk := range inCh {
+			if e.limits.limitsTokens() {
+				if b, err := chunk.Body.Bytes(); err == nil {
+					if u, ok := usageFromBody(b); ok {
+						if usage == nil {
+							usage = &tokenUsage{}
+						}
+						usage.merge(u)
+						if hideUsage && gjson.GetBytes(b, "choices.#").Int() == 0 {
+							continue
+						}
+					}
+				}
+			}
+			select {
+			case outCh <- chunk:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// closing the source ends the loop even while the upstream is idle
+	return octollm.NewStreamChan(outCh, func() {
+		cancel()
+		closeSrc()
+	})
+}
+
+// acquire takes a request slot and reserves tokens, waiting for capacity if configured so.
+func (e *LimiterEngine) acquire(ctx context.Context, reserved int64) (string, error) {
+	for {
+		e.mu.Lock()
+		released := e.released
+		e.mu.Unlock()
+
+		res, err := e.store.Acquire(ctx, e.key, &e.limits, reserved)
+		if err != nil {
+			return "", fmt.Errorf("failed to acquire limits of %s: %w", e.key, err)
+		}
+		if res.OK {
+			return res.Lease, nil
+		}
+
+		if !e.limits.Wait {
+			logrus.WithContext(ctx).Infof("[limiter] %s: limits exceeded, retry after %v", e.key, res.Wait)
+			herr := errutils.NewHandlerError(
+				fmt.Errorf("limits of %s exceeded", e.key),
+				http.StatusTooManyRequests, "Rate Limit Exceeded")
+			herr.Header = http.Header{"Retry-After": []string{strconv.Itoa(max(1, int(math.Ceil(res.Wait.Seconds()))))}}
+			return "", herr
+		}
+
+		logrus.WithContext(ctx).Debugf("[limiter] %s: limits exceeded, waiting up to %v", e.key, res.Wait)
+		timer := time.NewTimer(res.Wait)
+		select {
+		case <-released:
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return "", errutils.NewHandlerError(
+				fmt.Errorf("waiting for limits of %s: %w", e.key, ctx.Err()),
+				http.StatusTooManyRequests, "Rate Limit Exceeded")
+		}
+		timer.Stop()
+	}
+}
+
+// finish releases the concurrency lease, and replaces the token reservation with the actual usage if known.
+func (e *LimiterEngine) finish(ctx context.Context, lease string, reserved int64, usage *tokenUsage) {
+	// release even if the client has gone away
+	ctx = context.WithoutCancel(ctx)
+	var delta int64
+	if usage != nil {
+		delta = usage.tokens() - reserved
+	}
+	if err := e.store.Release(ctx, e.key, lease, delta); err != nil {
+		logrus.WithContext(ctx).Warnf("[limiter] %s: failed to release: %v", e.key, err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	close(e.released)
+	e.released = make(chan struct{})
+}
+
+// includeUsage sets stream_options.include_usage on OpenAI style streams, whose upstreams report no usage
+// without it, and tells if it was not set by the client.
+func includeUsage(req *octollm.Request) bool {
+	if req.Format != octollm.APIFormatChatCompletions && req.Format != octollm.APIFormatLegacyCompletions || req.Body == nil {
+		return false
+	}
+	b, err := req.Body.Bytes()
+	if err != nil || !gjson.GetBytes(b, "stream").Bool() || gjson.GetBytes(b, "stream_options.include_usage").Bool() {
+		return false
+	}
+	b, err = sjson.SetBytes(b, "stream_options.include_usage", true)
+	if err != nil {
+		return false
+	}
+	req.Body.SetBytes(b)
+	return true
+}
+
+// estimateTokens estimates the prompt tokens from the body size, about 4 bytes per token for English text and JSON.
+func estimateTokens(req *octollm.Request) int64 {
+	if req.Body == nil {
+		return 0
+	}
+	b, err := req.Body.Bytes()
+	if err != nil {
+		return 0
+	}
+	return int64(len(b)/4) + 1
+}
@@ -0,0 +1,192 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/infinigence/octollm/pkg/errutils"
+	"github.com/infinigence/octollm/pkg/octollm"
+)
+
+func newTestRequest(t *testing.T, ctx context.Context, body string) *octollm.Request {
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", "http://localhost/v1/chat/completions", nil)
+	require.NoError(t, err)
+	req := octollm.NewRequest(httpReq, octollm.APIFormatChatCompletions)
+	req.Body = octollm.NewBodyFromBytes([]byte(body), nil)
+	return req
+}
+
+func okEngine(respBody string) octollm.Engine {
+	return octollm.EngineFunc(func(req *octollm.Request) (*octollm.Response, error) {
+		return &octollm.Response{
+			StatusCode: 200,
+			Header:     http.Header{},
+			Body:       octollm.NewBodyFromBytes([]byte(respBody), nil),
+		}, nil
+	})
+}
+
+func TestLimiterEngine_RPMDeny(t *testing.T) {
+	e := NewLimiterEngine(okEngine(`{}`), NewMemoryStore(), "test", Limits{RPM: 2})
+	ctx := context.Background()
+
+	for range 2 {
+		_, err := e.Process(newTestRequest(t, ctx, `{}`))
+		require.NoError(t, err)
+	}
+	_, err := e.Process(newTestRequest(t, ctx, `{}`))
+	herr := &errutils.HandlerError{}
+	require.True(t, errors.As(err, &herr))
+	assert.Equal(t, http.StatusTooManyRequests, herr.StatusCode)
+	assert.NotEmpty(t, herr.Header.Get("Retry-After"))
+}
+
+func TestLimiterEngine_TPMReconciledWithUsage(t *testing.T) {
+	// the reservation of the small body is replaced by the reported 100 tokens
+	store := NewMemoryStore()
+	e := NewLimiterEngine(okEngine(`{"usage":{"prompt_tokens":60,"completion_tokens":40,"total_tokens":100}}`), store, "test", Limits{TPM: 150})
+	ctx := context.Background()
+
+	_, err := e.Process(newTestRequest(t, ctx, `{}`))
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), store.states["test"].tokMinute.sum(time.Now()))
+
+	_, err = e.Process(newTestRequest(t, ctx, `{}`))
+	require.NoError(t, err)
+	assert.Equal(t, int64(200), store.states["test"].tokMinute.sum(time.Now()))
+
+	_, err = e.Process(newTestRequest(t, ctx, `{}`))
+	assert.Error(t, err)
+}
+
+func TestLimiterEngine_ConcurrencyWaitsForStream(t *testing.T) {
+	chunks := make(chan *octollm.StreamChunk)
+	streaming := octollm.EngineFunc(func(req *octollm.Request) (*octollm.Response, error) {
+		return &octollm.Response{StatusCode: 200, Header: http.Header{}, Stream: octollm.NewStreamChan(chunks, func() {})}, nil
+	})
+	e := NewLimiterEngine(streaming, NewMemoryStore(), "test", Limits{Concurrency: 1, Wait: true})
+	ctx := context.Background()
+
+	resp, err := e.Process(newTestRequest(t, ctx, `{}`))
+	require.NoError(t, err)
+
+	// the second request waits until the first stream ends
+	done := make(chan error)
+	go func() {
+		_, err := e.Process(newTestRequest(t, ctx, `{}`))
+		done <- err
+	}()
+	select {
+	case <-done:
+		t.Fatal("second request should wait for the concurrency slot")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(chunks)
+	for range resp.Stream.Chan() {
+	}
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("second request should be admitted after the stream ends")
+	}
+}
+
+func TestLimiterEngine_CloseReleasesIdleStream(t *testing.T) {
+	// like the http client, the source ends its channel when closed, but sends nothing before
+	chunks := make(chan *octollm.StreamChunk)
+	var closed sync.Once
+	idle := octollm.EngineFunc(func(req *octollm.Request) (*octollm.Response, error) {
+		stream := octollm.NewStreamChan(chunks, func() { closed.Do(func() { close(chunks) }) })
+		return &octollm.Response{StatusCode: 200, Header: http.Header{}, Stream: stream}, nil
+	})
+	e := NewLimiterEngine(idle, NewMemoryStore(), "test", Limits{Concurrency: 1, Wait: true})
+
+	resp, err := e.Process(newTestRequest(t, context.Background(), `{}`))
+	require.NoError(t, err)
+	// the client goes away
+	resp.Stream.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = e.Process(newTestRequest(t, ctx, `{"stream":false}`))
+	assert.NoError(t, err, "the lease should be released when the stream is closed")
+}
+
+func TestLimiterEngine_StreamIncludesUsage(t *testing.T) {
+	var upstreamBody string
+	chunks := []string{
+		`{"choices":[{"delta":{"content":"hi"}}],"usage":null}`,
+		`{"choices":[],"usage":{"prompt_tokens":60,"completion_tokens":40,"total_tokens":100}}`,
+	}
+	upstream := octollm.EngineFunc(func(req *octollm.Request) (*octollm.Response, error) {
+		b, err := req.Body.Bytes()
+		require.NoError(t, err)
+		upstreamBody = string(b)
+		ch := make(chan *octollm.StreamChunk, len(chunks))
+		for _, c := range chunks {
+			ch <- &octollm.StreamChunk{Body: octollm.NewBodyFromBytes([]byte(c), nil)}
+		}
+		close(ch)
+		return &octollm.Response{StatusCode: 200, Header: http.Header{}, Stream: octollm.NewStreamChan(ch, func() {})}, nil
+	})
+	store := NewMemoryStore()
+	e := NewLimiterEngine(upstream, store, "test", Limits{TPM: 1000})
+
+	resp, err := e.Process(newTestRequest(t, context.Background(), `{"stream":true}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"stream":true,"stream_options":{"include_usage":true}}`, upstreamBody)
+
+	var got []string
+	for chunk := range resp.Stream.Chan() {
+		b, err := chunk.Body.Bytes()
+		require.NoError(t, err)
+		got = append(got, string(b))
+	}
+	assert.Equal(t, chunks[:1], got, "the client did not ask for the usage chunk")
+	assert.Equal(t, int64(100), store.states["test"].tokMinute.sum(time.Now()))
+}
+
+func TestLimiterEngine_WaitCancelled(t *testing.T) {
+	e := NewLimiterEngine(okEngine(`{}`), NewMemoryStore(), "test", Limits{RPM: 1, Wait: true})
+
+	_, err := e.Process(newTestRequest(t, context.Background(), `{}`))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = e.Process(newTestRequest(t, ctx, `{}`))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestUsageFromBody(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want int64
+	}{
+		{name: "chat", body: `{"usage":{"prompt_tokens":5,"completion_tokens":3,"total_tokens":8}}`, want: 8},
+		{name: "claude", body: `{"type":"message","usage":{"input_tokens":5,"output_tokens":3}}`, want: 8},
+		{name: "claude message_start", body: `{"type":"message_start","message":{"usage":{"input_tokens":5,"output_tokens":1}}}`, want: 6},
+		{name: "responses completed", body: `{"type":"response.completed","response":{"usage":{"input_tokens":5,"output_tokens":3,"total_tokens":8}}}`, want: 8},
+		{name: "gemini", body: `{"usageMetadata":{"promptTokenCount":5,"candidatesTokenCount":3,"totalTokenCount":8}}`, want: 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, ok := usageFromBody([]byte(tt.body))
+			require.True(t, ok)
+			assert.Equal(t, tt.want, u.tokens())
+		})
+	}
+
+	_, ok := usageFromBody([]byte(`{"choices":[]}`))
+	assert.False(t, ok)
+}
@@ -0,0 +1,189 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+)
+
+// acquireScript checks and updates all counters of a key atomically.
+// Windows are hashes of bucket index -> amount, with 60 buckets of 1s per minute and 1440 buckets of 1m per day.
+// Concurrency leases are members of a sorted set scored by their expiry time, so leases of crashed replicas expire.
+//
+// KEYS: rpm, rpd, tpm, tpd, leases
+// ARGV: now (ms), tokens, rpm, rpd, tpm, tpd, concurrency, lease, lease ttl (ms)
+// returns {1, 0} when admitted, or {0, wait (ms)}
+var acquireScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local tokens = tonumber(ARGV[2])
+
+local function window(key, bucket, n)
+	local cur = math.floor(now / bucket)
+	local vals = redis.call('HGETALL', key)
+	local sum, oldest = 0, cur
+	for i = 1, #vals, 2 do
+		local b = tonumber(vals[i])
+		local v = tonumber(vals[i + 1])
+		if cur - b >= n then
+			redis.call('HDEL', key, vals[i])
+		else
+			sum = sum + v
+			if v > 0 and b < oldest then
+				oldest = b
+			end
+		end
+	end
+	return sum, (oldest + n) * bucket - now
+end
+
+local exceeded = false
+local wait = 0
+local function check(key, bucket, n, limit, amount)
+	if limit <= 0 then
+		return
+	end
+	local used, w = window(key, bucket, n)
+	if used > 0 and used + amount > limit then
+		exceeded = true
+		if w > wait then
+			wait = w
+		end
+	end
+end
+
+local rpm, rpd, tpm, tpd = tonumber(ARGV[3]), tonumber(ARGV[4]), tonumber(ARGV[5]), tonumber(ARGV[6])
+check(KEYS[1], 1000, 60, rpm, 1)
+check(KEYS[2], 60000, 1440, rpd, 1)
+check(KEYS[3], 1000, 60, tpm, tokens)
+check(KEYS[4], 60000, 1440, tpd, tokens)
+
+local concurrency = tonumber(ARGV[7])
+if concurrency > 0 then
+	redis.call('ZREMRANGEBYSCORE', KEYS[5], '-inf', now)
+	if redis.call('ZCARD', KEYS[5]) >= concurrency then
+		exceeded = true
+		if wait < 200 then
+			wait = 200
+		end
+	end
+end
+
+if exceeded then
+	return {0, math.ceil(wait)}
+end
+
+local function incr(key, bucket, n, limit, amount)
+	if limit <= 0 or amount == 0 then
+		return
+	end
+	redis.call('HINCRBY', key, math.floor(now / bucket), amount)
+	redis.call('PEXPIRE', key, bucket * n)
+end
+incr(KEYS[1], 1000, 60, rpm, 1)
+incr(KEYS[2], 60000, 1440, rpd, 1)
+incr(KEYS[3], 1000, 60, tpm, tokens)
+incr(KEYS[4], 60000, 1440, tpd, tokens)
+if concurrency > 0 then
+	local ttl = tonumber(ARGV[9])
+	redis.call('ZADD', KEYS[5], now + ttl, ARGV[8])
+	redis.call('PEXPIRE', KEYS[5], ttl)
+end
+return {1, 0}
+`)
+
+// releaseScript removes the lease and adds the token delta to the current buckets.
+//
+// KEYS: tpm, tpd, leases
+// ARGV: now (ms), token delta, lease
+var releaseScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local delta = tonumber(ARGV[2])
+redis.call('ZREM', KEYS[3], ARGV[3])
+if delta ~= 0 then
+	if redis.call('EXISTS', KEYS[1]) == 1 then
+		redis.call('HINCRBY', KEYS[1], math.floor(now / 1000), delta)
+	end
+	if redis.call('EXISTS', KEYS[2]) == 1 then
+		redis.call('HINCRBY', KEYS[2], math.floor(now / 60000), delta)
+	end
+end
+return 1
+`)
+
+// localLeasePrefix marks leases taken from the local fallback store.
+const localLeasePrefix = "local:"
+
+// RedisStore keeps the counters in Redis (or any store speaking the Redis protocol with Lua scripting),
+// so limits are shared across replicas. When Redis is unreachable, it falls back to local limits.
+type RedisStore struct {
+	client    redis.Scripter
+	keyPrefix string
+	leaseTTL  time.Duration
+	local     *MemoryStore
+}
+
+var _ Store = (*RedisStore)(nil)
+
+// NewRedisStore creates a RedisStore. Keys are prefixed with keyPrefix, and concurrency leases
+// not released within leaseTTL (e.g. of a crashed replica) expire.
+func NewRedisStore(client redis.Scripter, keyPrefix string, leaseTTL time.Duration) *RedisStore {
+	return &RedisStore{
+		client:    client,
+		keyPrefix: keyPrefix,
+		leaseTTL:  leaseTTL,
+		local:     NewMemoryStore(),
+	}
+}
+
+// keys returns the keys of a limiter, with a hash tag so that they are in the same slot of a Redis Cluster.
+func (s *RedisStore) keys(key string, names ...string) []string {
+	keys := make([]string, len(names))
+	for i, name := range names {
+		keys[i] = fmt.Sprintf("%s{%s}:%s", s.keyPrefix, key, name)
+	}
+	return keys
+}
+
+func (s *RedisStore) Acquire(ctx context.Context, key string, limits *Limits, tokens int64) (*AcquireResult, error) {
+	lease := newLeaseID()
+	res, err := acquireScript.Run(ctx, s.client,
+		s.keys(key, "rpm", "rpd", "tpm", "tpd", "leases"),
+		time.Now().UnixMilli(), tokens,
+		limits.RPM, limits.RPD, limits.TPM, limits.TPD, limits.Concurrency,
+		lease, s.leaseTTL.Milliseconds(),
+	).Int64Slice()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		logrus.WithContext(ctx).Warnf("[limiter] redis acquire failed, falling back to local limits: %v", err)
+		local, err := s.local.Acquire(ctx, key, limits, tokens)
+		if err != nil || !local.OK {
+			return local, err
+		}
+		local.Lease = localLeasePrefix + local.Lease
+		return local, nil
+	}
+	if len(res) != 2 {
+		return nil, fmt.Errorf("unexpected acquire script result: %v", res)
+	}
+	if res[0] == 0 {
+		return &AcquireResult{Wait: time.Duration(res[1]) * time.Millisecond}, nil
+	}
+	return &AcquireResult{OK: true, Lease: lease}, nil
+}
+
+func (s *RedisStore) Release(ctx context.Context, key string, lease string, tokenDelta int64) error {
+	if localLease, ok := strings.CutPrefix(lease, localLeasePrefix); ok {
+		return s.local.Release(ctx, key, localLease, tokenDelta)
+	}
+	// an expired lease or a lost token delta is tolerable, so errors are not retried
+	return releaseScript.Run(ctx, s.client,
+		s.keys(key, "tpm", "tpd", "leases"),
+		time.Now().UnixMilli(), tokenDelta, lease,
+	).Err()
+}
@@ -0,0 +1,101 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRedisStore(t *testing.T) (*RedisStore, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { client.Close() })
+	return NewRedisStore(client, "octollm:limits:", time.Minute), mr
+}
+
+func TestRedisStore_RPMSharedAcrossStores(t *testing.T) {
+	ctx := context.Background()
+	s1, mr := newTestRedisStore(t)
+	// a second replica on the same redis
+	s2 := NewRedisStore(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "octollm:limits:", time.Minute)
+
+	limits := &Limits{RPM: 2}
+	res, err := s1.Acquire(ctx, "org-a", limits, 0)
+	require.NoError(t, err)
+	assert.True(t, res.OK)
+	res, err = s2.Acquire(ctx, "org-a", limits, 0)
+	require.NoError(t, err)
+	assert.True(t, res.OK)
+
+	res, err = s1.Acquire(ctx, "org-a", limits, 0)
+	require.NoError(t, err)
+	assert.False(t, res.OK)
+	assert.Greater(t, res.Wait, time.Duration(0))
+	assert.LessOrEqual(t, res.Wait, time.Minute)
+
+	// other keys are independent
+	res, err = s1.Acquire(ctx, "org-b", limits, 0)
+	require.NoError(t, err)
+	assert.True(t, res.OK)
+}
+
+func TestRedisStore_TokensReconciled(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestRedisStore(t)
+	limits := &Limits{TPM: 100}
+
+	res, err := s.Acquire(ctx, "k", limits, 10)
+	require.NoError(t, err)
+	require.True(t, res.OK)
+	// the actual usage was 95 tokens
+	require.NoError(t, s.Release(ctx, "k", res.Lease, 85))
+
+	res, err = s.Acquire(ctx, "k", limits, 10)
+	require.NoError(t, err)
+	assert.False(t, res.OK)
+}
+
+func TestRedisStore_ConcurrencyLeases(t *testing.T) {
+	ctx := context.Background()
+	s, mr := newTestRedisStore(t)
+	limits := &Limits{Concurrency: 1}
+
+	first, err := s.Acquire(ctx, "k", limits, 0)
+	require.NoError(t, err)
+	require.True(t, first.OK)
+
+	res, err := s.Acquire(ctx, "k", limits, 0)
+	require.NoError(t, err)
+	assert.False(t, res.OK)
+
+	require.NoError(t, s.Release(ctx, "k", first.Lease, 0))
+	res, err = s.Acquire(ctx, "k", limits, 0)
+	require.NoError(t, err)
+	require.True(t, res.OK)
+
+	// the lease of a crashed replica expires, and the key with it
+	mr.FastForward(2 * time.Minute)
+	assert.False(t, mr.Exists("octollm:limits:{k}:leases"))
+}
+
+func TestRedisStore_FallbackToLocal(t *testing.T) {
+	ctx := context.Background()
+	s, mr := newTestRedisStore(t)
+	mr.Close()
+
+	limits := &Limits{RPM: 1}
+	res, err := s.Acquire(ctx, "k", limits, 0)
+	require.NoError(t, err)
+	require.True(t, res.OK)
+	assert.Contains(t, res.Lease, localLeasePrefix)
+	require.NoError(t, s.Release(ctx, "k", res.Lease, 0))
+
+	res, err = s.Acquire(ctx, "k", limits, 0)
+	require.NoError(t, err)
+	assert.False(t, res.OK)
+}
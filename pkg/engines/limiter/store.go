@@ -0,0 +1,125 @@
+package limiter
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Store keeps the counters of limiters, so that limits can be shared across gateway replicas.
+// Implementations must be safe for concurrent use.
+type Store interface {
+	// Acquire admits a request under key if all limits allow it, counting the request,
+	// reserving tokens and taking a concurrency lease.
+	Acquire(ctx context.Context, key string, limits *Limits, tokens int64) (*AcquireResult, error)
+	// Release gives back the concurrency lease, and adds tokenDelta (which can be negative) to the token counters.
+	Release(ctx context.Context, key string, lease string, tokenDelta int64) error
+}
+
+type AcquireResult struct {
+	OK    bool
+	Lease string        // to be released when OK
+	Wait  time.Duration // how long until capacity may free up when not OK
+}
+
+// concurrencyPollInterval is the wait reported when only the concurrency limit is exceeded,
+// leases of other replicas are not notified, so waiters poll.
+const concurrencyPollInterval = 200 * time.Millisecond
+
+func newLeaseID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// MemoryStore keeps the counters in process, limits are per replica.
+type MemoryStore struct {
+	mu     sync.Mutex
+	states map[string]*memoryState
+}
+
+type memoryState struct {
+	reqMinute *slidingWindow
+	reqDay    *slidingWindow
+	tokMinute *slidingWindow
+	tokDay    *slidingWindow
+	leases    map[string]struct{}
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{states: make(map[string]*memoryState)}
+}
+
+func (s *MemoryStore) state(key string) *memoryState {
+	st, ok := s.states[key]
+	if !ok {
+		st = &memoryState{
+			reqMinute: newSlidingWindow(time.Minute, 60),
+			reqDay:    newSlidingWindow(24*time.Hour, 1440),
+			tokMinute: newSlidingWindow(time.Minute, 60),
+			tokDay:    newSlidingWindow(24*time.Hour, 1440),
+			leases:    make(map[string]struct{}),
+		}
+		s.states[key] = st
+	}
+	return st
+}
+
+// Acquire admits the request if all limits allow it, otherwise it returns how long to wait.
+// A token reservation larger than the limit is admitted when the window is empty, so it can't wait forever.
+func (s *MemoryStore) Acquire(ctx context.Context, key string, limits *Limits, tokens int64) (*AcquireResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	st := s.state(key)
+	var wait time.Duration
+	exceeded := false
+	check := func(w *slidingWindow, limit int, amount int64) {
+		if limit <= 0 {
+			return
+		}
+		used := w.sum(now)
+		if used > 0 && used+amount > int64(limit) {
+			exceeded = true
+			wait = max(wait, w.retryAfter(now))
+		}
+	}
+	check(st.reqMinute, limits.RPM, 1)
+	check(st.reqDay, limits.RPD, 1)
+	check(st.tokMinute, limits.TPM, tokens)
+	check(st.tokDay, limits.TPD, tokens)
+	if limits.Concurrency > 0 && len(st.leases) >= limits.Concurrency {
+		exceeded = true
+		wait = max(wait, concurrencyPollInterval)
+	}
+	if exceeded {
+		return &AcquireResult{Wait: wait}, nil
+	}
+
+	st.reqMinute.add(now, 1)
+	st.reqDay.add(now, 1)
+	st.tokMinute.add(now, tokens)
+	st.tokDay.add(now, tokens)
+	lease := newLeaseID()
+	st.leases[lease] = struct{}{}
+	return &AcquireResult{OK: true, Lease: lease}, nil
+}
+
+func (s *MemoryStore) Release(ctx context.Context, key string, lease string, tokenDelta int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	st := s.state(key)
+	delete(st.leases, lease)
+	if tokenDelta != 0 {
+		st.tokMinute.add(now, tokenDelta)
+		st.tokDay.add(now, tokenDelta)
+	}
+	return nil
+}
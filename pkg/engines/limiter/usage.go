@@ -0,0 +1,90 @@
+package limiter
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// tokenUsage is the token usage reported by the upstream, in any of the supported protocols.
+type tokenUsage struct {
+	input  int64
+	output int64
+	total  int64
+}
+
+func (u *tokenUsage) tokens() int64 {
+	if u.total > 0 {
+		return u.total
+	}
+	return u.input + u.output
+}
+
+// merge keeps the latest non-zero values, usage in streams is either reported once at the end,
+// or cumulatively in several chunks (e.g. message_start and message_delta of Claude).
+func (u *tokenUsage) merge(o *tokenUsage) {
+	if o.input > 0 {
+		u.input = o.input
+	}
+	if o.output > 0 {
+		u.output = o.output
+	}
+	if o.total > 0 {
+		u.total = o.total
+	}
+}
+
+type usageFields struct {
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+	InputTokens      int64 `json:"input_tokens"`
+	OutputTokens     int64 `json:"output_tokens"`
+	TotalTokens      int64 `json:"total_tokens"`
+}
+
+func (f *usageFields) toUsage() *tokenUsage {
+	return &tokenUsage{
+		input:  f.PromptTokens + f.InputTokens,
+		output: f.CompletionTokens + f.OutputTokens,
+		total:  f.TotalTokens,
+	}
+}
+
+// usageFromBody extracts the usage of OpenAI, Claude, Responses and Gemini response bodies or stream chunks.
+func usageFromBody(b []byte) (*tokenUsage, bool) {
+	if !bytes.Contains(b, []byte(`"usage`)) {
+		return nil, false
+	}
+	var v struct {
+		Usage   *usageFields `json:"usage"`
+		Message *struct {
+			Usage *usageFields `json:"usage"`
+		} `json:"message"` // claude message_start
+		Response *struct {
+			Usage *usageFields `json:"usage"`
+		} `json:"response"` // responses response.completed
+		UsageMetadata *struct {
+			PromptTokenCount     int64 `json:"promptTokenCount"`
+			CandidatesTokenCount int64 `json:"candidatesTokenCount"`
+			ThoughtsTokenCount   int64 `json:"thoughtsTokenCount"`
+			TotalTokenCount      int64 `json:"totalTokenCount"`
+		} `json:"usageMetadata"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, false
+	}
+	switch {
+	case v.Usage != nil:
+		return v.Usage.toUsage(), true
+	case v.Message != nil && v.Message.Usage != nil:
+		return v.Message.Usage.toUsage(), true
+	case v.Response != nil && v.Response.Usage != nil:
+		return v.Response.Usage.toUsage(), true
+	case v.UsageMetadata != nil:
+		return &tokenUsage{
+			input:  v.UsageMetadata.PromptTokenCount,
+			output: v.UsageMetadata.CandidatesTokenCount + v.UsageMetadata.ThoughtsTokenCount,
+			total:  v.UsageMetadata.TotalTokenCount,
+		}, true
+	}
+	return nil, false
+}
@@ -0,0 +1,63 @@
+package limiter
+
+import "time"
+
+// slidingWindow counts amounts over the last size duration, in buckets of size/len(buckets).
+type slidingWindow struct {
+	bucketDur time.Duration
+	buckets   []int64
+	slots     []int64 // the bucket index (unix time / bucketDur) each slot holds
+}
+
+func newSlidingWindow(size time.Duration, n int) *slidingWindow {
+	return &slidingWindow{
+		bucketDur: size / time.Duration(n),
+		buckets:   make([]int64, n),
+		slots:     make([]int64, n),
+	}
+}
+
+func (w *slidingWindow) index(now time.Time) int64 {
+	return now.UnixNano() / int64(w.bucketDur)
+}
+
+// sum returns the total amount in the window ending at now.
+func (w *slidingWindow) sum(now time.Time) int64 {
+	cur := w.index(now)
+	n := int64(len(w.buckets))
+	var total int64
+	for i, slot := range w.slots {
+		if cur-slot < n {
+			total += w.buckets[i]
+		}
+	}
+	if total < 0 {
+		return 0
+	}
+	return total
+}
+
+// add adds v to the current bucket, v can be negative to give back reserved amounts.
+func (w *slidingWindow) add(now time.Time, v int64) {
+	cur := w.index(now)
+	i := cur % int64(len(w.buckets))
+	if w.slots[i] != cur {
+		w.slots[i] = cur
+		w.buckets[i] = 0
+	}
+	w.buckets[i] += v
+}
+
+// retryAfter returns how long until the oldest non-empty bucket leaves the window.
+func (w *slidingWindow) retryAfter(now time.Time) time.Duration {
+	cur := w.index(now)
+	n := int64(len(w.buckets))
+	oldest := cur
+	for i, slot := range w.slots {
+		if cur-slot < n && w.buckets[i] > 0 && slot < oldest {
+			oldest = slot
+		}
+	}
+	expire := time.Unix(0, (oldest+n)*int64(w.bucketDur))
+	return expire.Sub(now)
+}
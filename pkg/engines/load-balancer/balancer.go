@@ -0,0 +1,327 @@
+package loadbalancer
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/infinigence/octollm/pkg/errutils"
+	"github.com/infinigence/octollm/pkg/octollm"
+)
+
+type BackendItem struct {
+	Name   string // optional
+	Weight int
+	Engine octollm.Engine
+}
+
+// backend is a backend of a balancer, with the state kept for all strategies.
+type backend struct {
+	name   string
+	weight int
+	engine octollm.Engine
+
+	currentWeight int       // smooth weighted round robin
+	notBefore     time.Time // set by Retry-After of the upstream
+	inflight      int       // requests in flight, streams count until they end
+	latency       float64   // peak EWMA of the latency in seconds, time to first chunk for streams
+	latencyAt     time.Time // last update of latency
+}
+
+// balancer sends requests to one of its backends and retries failed requests by its RetryPolicy.
+// The strategy is given by choose, which is called with mu held.
+type balancer struct {
+	name string // for logs
+
+	mu       sync.Mutex
+	backends []*backend
+	retry    RetryPolicy
+
+	choose func(candidates []*backend, now time.Time, key string) *backend
+	// keyOf returns the affinity key of a request for strategies routing by key
+	keyOf func(req *octollm.Request) string
+	// trackLoad keeps inflight and latency of backends up to date, only strategies using them pay for it
+	trackLoad bool
+}
+
+func newBalancer(name string, items []BackendItem, retry RetryPolicy) (*balancer, error) {
+	if len(items) == 0 {
+		return nil, fmt.Errorf("backends must have at least one item")
+	}
+	// if all weights are 0, set all weights to 1
+	allZero := true
+	for _, item := range items {
+		if item.Weight < 0 {
+			return nil, fmt.Errorf("weight must be >= 0")
+		}
+		if item.Weight != 0 {
+			allZero = false
+		}
+	}
+	now := time.Now()
+	backends := make([]*backend, len(items))
+	for i, item := range items {
+		w := item.Weight
+		if allZero {
+			w = 100
+		}
+		backends[i] = &backend{
+			name:      item.Name,
+			weight:    w,
+			engine:    item.Engine,
+			latency:   defaultLatency.Seconds(),
+			latencyAt: now,
+		}
+	}
+	return &balancer{
+		name:     name,
+		backends: backends,
+		retry:    retry,
+	}, nil
+}
+
+func (l *balancer) Process(req *octollm.Request) (*octollm.Response, error) {
+	ctx := req.Context()
+	start := time.Now()
+	attempt := 0
+	var failed *backend
+	var lastResp *octollm.Response
+	var lastErr error
+	key := ""
+	if l.keyOf != nil {
+		key = l.keyOf(req)
+	}
+	group := hedgeGroupFrom(ctx)
+	// backends convert and rewrite the request in place, a retry must not get it as changed by the failed attempt
+	var snapshot *octollm.RequestSnapshot
+	if l.retry.MaxAttempts > 1 {
+		var err error
+		if snapshot, err = req.Snapshot(); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		b, wait := l.next(key, failed, group)
+		if b == nil {
+			if wait == 0 {
+				logrus.WithContext(ctx).Warnf("[%s] no available backend", l.name)
+				if lastErr != nil {
+					return lastResp, lastErr
+				}
+				return nil, errutils.NewHandlerError(ErrBackendUnavailable, http.StatusServiceUnavailable, "No Available Backend")
+			}
+			// every backend asked to retry later
+			if lastErr == nil {
+				lastErr = &errutils.HandlerError{
+					Err:        fmt.Errorf("all backends asked to retry after %v: %w", wait, ErrBackendUnavailable),
+					StatusCode: http.StatusTooManyRequests,
+					Message:    "Too Many Requests",
+					Header:     http.Header{"Retry-After": []string{strconv.Itoa(int(math.Ceil(wait.Seconds())))}},
+				}
+			}
+			if time.Since(start)+wait >= l.retry.Timeout {
+				logrus.WithContext(ctx).Warnf("[%s] backends retry after %v, beyond retry period %v", l.name, wait, l.retry.Timeout)
+				return lastResp, lastErr
+			}
+			logrus.WithContext(ctx).Infof("[%s] backends retry after %v, waiting", l.name, wait)
+			if !sleepContext(ctx, wait) {
+				return lastResp, lastErr
+			}
+			continue
+		}
+
+		attempt++
+		logrus.WithContext(ctx).Infof("[%s] will use engine name: %s", l.name, b.name)
+		attemptReq := req
+		if snapshot != nil {
+			attemptReq = snapshot.Restore(req)
+		}
+		resp, err := l.send(b, attemptReq)
+		if err == nil {
+			return resp, nil
+		}
+		lastResp, lastErr = resp, err
+		if retryAfter := RetryAfter(err); retryAfter > 0 {
+			l.coolDown(b, retryAfter)
+		}
+		if !l.retry.Retryable(req, err) {
+			logrus.WithContext(ctx).Infof("[%s] error not retryable, return last resp and err: %v", l.name, err)
+			return resp, err
+		}
+		if attempt >= l.retry.MaxAttempts {
+			// retry max count reached, return last resp and err
+			logrus.WithContext(ctx).Warnf("[%s] retry max count %d reached, return last resp and err", l.name, l.retry.MaxAttempts)
+			return resp, err
+		}
+		backoff := l.retry.Backoff(attempt)
+		if time.Since(start)+backoff >= l.retry.Timeout {
+			// retry peroid reached, return last resp and err
+			logrus.WithContext(ctx).Warnf("[%s] retry peroid %v reached, return last resp and err", l.name, l.retry.Timeout)
+			return resp, err
+		}
+		logrus.WithContext(ctx).Infof("[%s] will retry after %v, count %d, time %v", l.name, backoff, attempt, time.Since(start))
+		if !sleepContext(ctx, backoff) {
+			return resp, err
+		}
+		failed = b
+	}
+}
+
+// next picks the next backend, avoiding skip and the backends used by the other attempts of a hedged request
+// if any other backend is available. Backends whose engine implements Availability and is not available, and backends asked to retry later by
+// Retry-After, are not picked. When all available backends are cooling down after a Retry-After, it returns
+// nil and the time until the first of them may be retried.
+func (l *balancer) next(key string, skip *backend, group *hedgeGroup) (*backend, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	var wait time.Duration
+	candidates := make([]*backend, 0, len(l.backends))
+	for _, b := range l.backends {
+		if b.weight == 0 {
+			continue
+		}
+		if a, ok := b.engine.(Availability); ok && !a.Available() {
+			continue
+		}
+		if d := b.notBefore.Sub(now); d > 0 {
+			if wait == 0 || d < wait {
+				wait = d
+			}
+			continue
+		}
+		candidates = append(candidates, b)
+	}
+	if len(candidates) == 0 {
+		return nil, wait
+	}
+	if len(candidates) > 1 && skip != nil {
+		for i, b := range candidates {
+			if b == skip {
+				candidates = append(candidates[:i], candidates[i+1:]...)
+				break
+			}
+		}
+	}
+	b := group.pick(candidates, func(candidates []*backend) *backend {
+		return l.choose(candidates, now, key)
+	})
+	if l.trackLoad {
+		b.inflight++
+	}
+	return b, 0
+}
+
+func (l *balancer) coolDown(b *backend, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if notBefore := time.Now().Add(d); notBefore.After(b.notBefore) {
+		b.notBefore = notBefore
+	}
+}
+
+// send processes req on b, keeping track of the load of b if needed.
+func (l *balancer) send(b *backend, req *octollm.Request) (*octollm.Response, error) {
+	if !l.trackLoad {
+		return b.engine.Process(req)
+	}
+	start := time.Now()
+	resp, err := b.engine.Process(req)
+	if err != nil {
+		if isBackendFailure(req, err) {
+			l.done(b, time.Since(start), true)
+		} else {
+			// the latency of a request refused for its input says nothing about the backend
+			l.release(b)
+		}
+		return resp, err
+	}
+	if resp.Stream == nil {
+		l.done(b, time.Since(start), false)
+		return resp, nil
+	}
+	resp.Stream = l.wrapStream(req.Context(), b, start, resp.Stream)
+	return resp, nil
+}
+
+// wrapStream observes the time to the first chunk, and keeps the stream in flight until it ends.
+func (l *balancer) wrapStream(ctx context.Context, b *backend, start time.Time, src *octollm.StreamChan) *octollm.StreamChan {
+	inCh := src.Chan()
+	outCh := make(chan *octollm.StreamChunk)
+	ctx, cancel := context.WithCancel(ctx)
+
+	go func() {
+		defer close(outCh)
+		defer src.Close()
+
+		first := true
+		defer func() {
+			if first {
+				// ended without any chunk
+				l.done(b, time.Since(start), false)
+			} else {
+				l.release(b)
+			}
+		}()
+
+		for chunk := range inCh {
+			if first {
+				first = false
+				l.observe(b, time.Since(start))
+			}
+			select {
+			case outCh <- chunk:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return octollm.NewStreamChan(outCh, cancel)
+}
+
+// done releases b and observes the latency of the request, failures of the backend are observed as at least
+// failureLatency so that it gets avoided.
+func (l *balancer) done(b *backend, latency time.Duration, failed bool) {
+	if failed {
+		latency = max(latency, failureLatency)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b.inflight--
+	b.updateLatency(latency.Seconds(), time.Now())
+}
+
+func (l *balancer) observe(b *backend, latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b.updateLatency(latency.Seconds(), time.Now())
+}
+
+func (l *balancer) release(b *backend) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b.inflight--
+}
+
+// sleepContext sleeps for d, returns false if ctx is done before.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
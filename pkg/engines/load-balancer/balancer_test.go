@@ -0,0 +1,114 @@
+package loadbalancer
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/infinigence/octollm/pkg/octollm"
+)
+
+func TestLeastInflight_Streams(t *testing.T) {
+	streams := map[string]chan *octollm.StreamChunk{}
+	newEngine := func(name string) octollm.Engine {
+		return octollm.EngineFunc(func(req *octollm.Request) (*octollm.Response, error) {
+			ch := make(chan *octollm.StreamChunk)
+			streams[name] = ch
+			return &octollm.Response{StatusCode: http.StatusOK, Stream: octollm.NewStreamChan(ch, nil)}, nil
+		})
+	}
+	lb, err := NewLeastInflight([]BackendItem{
+		{Name: "a", Weight: 1, Engine: newEngine("a")},
+		{Name: "b", Weight: 1, Engine: newEngine("b")},
+	}, DefaultRetryPolicy)
+	require.NoError(t, err)
+
+	httpReq, err := http.NewRequestWithContext(context.Background(), http.MethodPost, "http://localhost/v1/chat/completions", nil)
+	require.NoError(t, err)
+	req := octollm.NewRequest(httpReq, octollm.APIFormatChatCompletions)
+
+	first, err := lb.Process(req)
+	require.NoError(t, err)
+	firstName := "a"
+	if _, ok := streams["b"]; ok {
+		firstName = "b"
+	}
+	otherName := map[string]string{"a": "b", "b": "a"}[firstName]
+
+	// the first stream is still in flight, so the other backend is picked
+	second, err := lb.Process(req)
+	require.NoError(t, err)
+	require.Contains(t, streams, otherName)
+
+	// the first stream ends, its backend is free again
+	close(streams[firstName])
+	for range first.Stream.Chan() {
+	}
+	first.Stream.Close()
+	delete(streams, firstName)
+	_, err = lb.Process(req)
+	require.NoError(t, err)
+	assert.Contains(t, streams, firstName)
+
+	close(streams[otherName])
+	for range second.Stream.Chan() {
+	}
+}
+
+func TestChooseLeastInflight(t *testing.T) {
+	a := &backend{name: "a", weight: 1, inflight: 2}
+	b := &backend{name: "b", weight: 3, inflight: 4}
+	c := &backend{name: "c", weight: 1, inflight: 3}
+	// loads are 3, 5/3 and 4
+	assert.Equal(t, b, chooseLeastInflight([]*backend{a, b, c}, time.Now(), ""))
+}
+
+func TestChoosePeakEWMA(t *testing.T) {
+	now := time.Now()
+	newBackend := func(name string) *backend {
+		return &backend{name: name, weight: 1, latency: defaultLatency.Seconds(), latencyAt: now}
+	}
+	a, b := newBackend("a"), newBackend("b")
+
+	// a slow response is taken at once
+	a.updateLatency(3, now)
+	assert.Equal(t, 3.0, a.latency)
+	assert.Equal(t, b, choosePeakEWMA([]*backend{a, b}, now, ""))
+
+	// more requests in flight on b make it costlier than a
+	b.inflight = 3
+	assert.Equal(t, a, choosePeakEWMA([]*backend{a, b}, now, ""))
+	b.inflight = 0
+
+	// fast responses are averaged in
+	later := now.Add(latencyDecay)
+	a.updateLatency(0.5, later)
+	assert.Less(t, a.latency, 3.0)
+	assert.Greater(t, a.latency, 0.5)
+
+	// the latency of an idle backend decays
+	cost := a.cost(later.Add(time.Minute))
+	assert.Less(t, cost, 0.1)
+}
+
+func TestChoosePowerOfTwo(t *testing.T) {
+	a := &backend{name: "a", weight: 1, inflight: 5}
+	b := &backend{name: "b", weight: 1, inflight: 0}
+	for range 10 {
+		// with two candidates both are always compared
+		assert.Equal(t, b, choosePowerOfTwo([]*backend{a, b}, time.Now(), ""))
+	}
+
+	counts := map[string]int{}
+	c := &backend{name: "c", weight: 1, inflight: 0}
+	for range 1000 {
+		counts[choosePowerOfTwo([]*backend{a, b, c}, time.Now(), "").name]++
+	}
+	assert.Zero(t, counts["a"], "the busiest backend never wins a comparison")
+	assert.Greater(t, counts["b"], 0)
+	assert.Greater(t, counts["c"], 0)
+}
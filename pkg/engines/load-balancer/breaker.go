@@ -0,0 +1,226 @@
+package loadbalancer
+
+import (
+	"sync"
+	"time"
+)
+
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half_open"
+)
+
+// BreakerConfig configures a CircuitBreaker. A zero ConsecutiveFailures or ErrorRate disables that trigger.
+type BreakerConfig struct {
+	ConsecutiveFailures int           // open after this many failures in a row
+	ErrorRate           float64       // open when the failure ratio in Window reaches this, e.g. 0.5
+	MinRequests         int           // requests needed in Window before ErrorRate applies, defaults to 10
+	Window              time.Duration // defaults to 1 minute
+	OpenDuration        time.Duration // how long to stay open before probing, defaults to 30s
+	HalfOpenRequests    int           // trial requests in half-open state, all must succeed to close, defaults to 1
+}
+
+const breakerBuckets = 10
+
+// CircuitBreaker tracks the outcome of requests to a backend.
+// It opens when failures pile up, rejects requests while open, and lets a few trial requests through
+// (half-open) once OpenDuration has passed; the backend is closed again when all of them succeed.
+type CircuitBreaker struct {
+	conf BreakerConfig
+
+	mu          sync.Mutex
+	state       BreakerState
+	consecutive int
+	successes   [breakerBuckets]int
+	failures    [breakerBuckets]int
+	bucketStart [breakerBuckets]time.Time
+	openedAt    time.Time
+	halfOpenIn  int // trial requests admitted in half-open state
+	halfOpenOK  int // trial requests succeeded in half-open state
+	lastChange  time.Time
+
+	now func() time.Time
+}
+
+func NewCircuitBreaker(conf BreakerConfig) *CircuitBreaker {
+	if conf.MinRequests <= 0 {
+		conf.MinRequests = 10
+	}
+	if conf.Window <= 0 {
+		conf.Window = time.Minute
+	}
+	if conf.OpenDuration <= 0 {
+		conf.OpenDuration = 30 * time.Second
+	}
+	if conf.HalfOpenRequests <= 0 {
+		conf.HalfOpenRequests = 1
+	}
+	return &CircuitBreaker{
+		conf:       conf,
+		state:      BreakerClosed,
+		lastChange: time.Now(),
+		now:        time.Now,
+	}
+}
+
+// State returns the current state, an open breaker whose OpenDuration has passed is reported as half-open.
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.maybeHalfOpen()
+	return b.state
+}
+
+// Available reports whether Allow would admit a request now, without admitting it.
+func (b *CircuitBreaker) Available() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.maybeHalfOpen()
+	switch b.state {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		return b.halfOpenIn < b.conf.HalfOpenRequests
+	default:
+		return true
+	}
+}
+
+// Allow admits a request, every admitted request must be followed by a Record call.
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.maybeHalfOpen()
+	switch b.state {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		if b.halfOpenIn >= b.conf.HalfOpenRequests {
+			return false
+		}
+		b.halfOpenIn++
+		return true
+	default:
+		return true
+	}
+}
+
+// Record reports the outcome of a request admitted by Allow.
+func (b *CircuitBreaker) Record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerHalfOpen:
+		if !success {
+			b.setState(BreakerOpen)
+			return
+		}
+		b.halfOpenOK++
+		if b.halfOpenOK >= b.conf.HalfOpenRequests {
+			b.setState(BreakerClosed)
+		}
+	case BreakerClosed:
+		i := b.bucket()
+		if success {
+			b.consecutive = 0
+			b.successes[i]++
+			return
+		}
+		b.consecutive++
+		b.failures[i]++
+		if b.conf.ConsecutiveFailures > 0 && b.consecutive >= b.conf.ConsecutiveFailures {
+			b.setState(BreakerOpen)
+			return
+		}
+		if b.conf.ErrorRate > 0 {
+			total, failed := b.counts()
+			if total >= b.conf.MinRequests && float64(failed)/float64(total) >= b.conf.ErrorRate {
+				b.setState(BreakerOpen)
+			}
+		}
+	}
+	// outcomes of requests admitted before the breaker opened are ignored
+}
+
+// Reset closes the breaker and forgets past outcomes, e.g. when a health check re-admits the backend.
+func (b *CircuitBreaker) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != BreakerClosed {
+		b.setState(BreakerClosed)
+	}
+}
+
+// Snapshot returns the state and the request counts in the current window.
+func (b *CircuitBreaker) Snapshot() BreakerSnapshot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.maybeHalfOpen()
+	total, failed := b.counts()
+	return BreakerSnapshot{
+		State:               b.state,
+		Since:               b.lastChange,
+		ConsecutiveFailures: b.consecutive,
+		Requests:            total,
+		Failures:            failed,
+	}
+}
+
+type BreakerSnapshot struct {
+	State               BreakerState `json:"state"`
+	Since               time.Time    `json:"since"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+	Requests            int          `json:"requests"` // in the error rate window
+	Failures            int          `json:"failures"` // in the error rate window
+}
+
+func (b *CircuitBreaker) maybeHalfOpen() {
+	if b.state == BreakerOpen && b.now().Sub(b.openedAt) >= b.conf.OpenDuration {
+		b.setState(BreakerHalfOpen)
+	}
+}
+
+func (b *CircuitBreaker) setState(state BreakerState) {
+	now := b.now()
+	b.state = state
+	b.lastChange = now
+	b.halfOpenIn = 0
+	b.halfOpenOK = 0
+	switch state {
+	case BreakerOpen:
+		b.openedAt = now
+	case BreakerClosed:
+		b.consecutive = 0
+		b.successes = [breakerBuckets]int{}
+		b.failures = [breakerBuckets]int{}
+	}
+}
+
+// bucket returns the index of the bucket of now, clearing it if it belongs to an earlier window.
+func (b *CircuitBreaker) bucket() int {
+	width := b.conf.Window / breakerBuckets
+	now := b.now()
+	start := now.Truncate(width)
+	i := int(start.UnixNano()/int64(width)) % breakerBuckets
+	if !b.bucketStart[i].Equal(start) {
+		b.bucketStart[i] = start
+		b.successes[i] = 0
+		b.failures[i] = 0
+	}
+	return i
+}
+
+func (b *CircuitBreaker) counts() (total, failed int) {
+	from := b.now().Add(-b.conf.Window)
+	for i := range breakerBuckets {
+		if b.bucketStart[i].After(from) {
+			total += b.successes[i] + b.failures[i]
+			failed += b.failures[i]
+		}
+	}
+	return total, failed
+}
@@ -0,0 +1,185 @@
+package loadbalancer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/infinigence/octollm/pkg/errutils"
+	"github.com/infinigence/octollm/pkg/octollm"
+)
+
+func TestCircuitBreaker_ConsecutiveFailures(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	b := NewCircuitBreaker(BreakerConfig{ConsecutiveFailures: 3, OpenDuration: 10 * time.Second, HalfOpenRequests: 2})
+	b.now = func() time.Time { return now }
+
+	for range 2 {
+		require.True(t, b.Allow())
+		b.Record(false)
+	}
+	require.True(t, b.Allow())
+	b.Record(true) // a success resets the count
+	for range 3 {
+		require.True(t, b.Allow())
+		b.Record(false)
+	}
+	assert.Equal(t, BreakerOpen, b.State())
+	assert.False(t, b.Available())
+	assert.False(t, b.Allow())
+
+	now = now.Add(10 * time.Second)
+	assert.Equal(t, BreakerHalfOpen, b.State())
+	require.True(t, b.Allow())
+	require.True(t, b.Allow())
+	assert.False(t, b.Allow(), "only 2 trial requests in half-open state")
+	b.Record(true)
+	assert.Equal(t, BreakerHalfOpen, b.State())
+	b.Record(true)
+	assert.Equal(t, BreakerClosed, b.State())
+
+	// a failed trial request opens the breaker again
+	for range 3 {
+		require.True(t, b.Allow())
+		b.Record(false)
+	}
+	now = now.Add(10 * time.Second)
+	require.True(t, b.Allow())
+	b.Record(false)
+	assert.Equal(t, BreakerOpen, b.State())
+}
+
+func TestCircuitBreaker_ErrorRate(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	b := NewCircuitBreaker(BreakerConfig{ErrorRate: 0.5, MinRequests: 10, Window: 10 * time.Second})
+	b.now = func() time.Time { return now }
+
+	// 4 of 9 failed, not enough requests yet
+	for i := range 9 {
+		require.True(t, b.Allow())
+		b.Record(i%2 == 0)
+	}
+	assert.Equal(t, BreakerClosed, b.State())
+
+	// the window slides, old outcomes are forgotten
+	now = now.Add(11 * time.Second)
+	require.True(t, b.Allow())
+	b.Record(false)
+	assert.Equal(t, 1, b.Snapshot().Requests)
+	assert.Equal(t, BreakerClosed, b.State())
+
+	// 6 of 10 failed
+	for i := range 9 {
+		require.True(t, b.Allow())
+		b.Record(i%2 == 1)
+	}
+	assert.Equal(t, BreakerOpen, b.State())
+}
+
+func TestGuardedBackend_WeightedRoundRobin(t *testing.T) {
+	failures := map[string]error{
+		"a": &errutils.UpstreamRespError{StatusCode: http.StatusInternalServerError},
+	}
+	calls := map[string]int{}
+	newBackend := func(name string) *GuardedBackend {
+		engine := octollm.EngineFunc(func(req *octollm.Request) (*octollm.Response, error) {
+			calls[name]++
+			if err := failures[name]; err != nil {
+				return nil, err
+			}
+			return &octollm.Response{StatusCode: http.StatusOK}, nil
+		})
+		return NewGuardedBackend(name, engine, NewCircuitBreaker(BreakerConfig{ConsecutiveFailures: 2}), nil)
+	}
+	a, b := newBackend("a"), newBackend("b")
+	lb, err := NewWeightedRoundRobin([]BackendItem{
+		{Name: "a", Weight: 1, Engine: a},
+		{Name: "b", Weight: 1, Engine: b},
+	}, RetryPolicy{Timeout: time.Minute, MaxAttempts: 3})
+	require.NoError(t, err)
+
+	httpReq, err := http.NewRequestWithContext(context.Background(), http.MethodPost, "http://localhost/v1/chat/completions", nil)
+	require.NoError(t, err)
+	req := octollm.NewRequest(httpReq, octollm.APIFormatChatCompletions)
+
+	for range 10 {
+		_, err := lb.Process(req)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 2, calls["a"], "a is skipped once its breaker opens")
+	assert.Equal(t, BreakerOpen, a.Status().Breaker.State)
+	assert.False(t, a.Status().Available)
+
+	// client errors do not count as failures
+	failures["b"] = &errutils.UpstreamRespError{StatusCode: http.StatusBadRequest}
+	for range 3 {
+		_, err := lb.Process(req)
+		require.Error(t, err)
+	}
+	assert.Equal(t, BreakerClosed, b.Status().Breaker.State)
+
+	// nor errors before the upstream, e.g. a converter refusing the body
+	failures["b"] = errors.New("failed to parse request body")
+	for range 3 {
+		_, err := lb.Process(req)
+		require.Error(t, err)
+	}
+	assert.Equal(t, BreakerClosed, b.Status().Breaker.State)
+
+	failures["b"] = &errutils.UpstreamHTTPError{Err: errors.New("connection refused")}
+	_, err = lb.Process(req)
+	require.Error(t, err)
+	_, err = lb.Process(req)
+	handlerErr := errutils.AsHandlerError(err)
+	assert.Equal(t, http.StatusServiceUnavailable, handlerErr.StatusCode)
+	assert.ErrorIs(t, err, ErrBackendUnavailable)
+}
+
+func TestHealthChecker(t *testing.T) {
+	var probeErr error
+	h := NewHealthChecker("test", func(ctx context.Context) error { return probeErr }, HealthCheckConfig{
+		UnhealthyThreshold: 2,
+		HealthyThreshold:   2,
+	})
+	breaker := NewCircuitBreaker(BreakerConfig{ConsecutiveFailures: 1})
+	g := NewGuardedBackend("test", octollm.EngineFunc(func(req *octollm.Request) (*octollm.Response, error) {
+		return nil, errors.New("not called")
+	}), breaker, h)
+
+	probeErr = errors.New("down")
+	h.Check()
+	assert.True(t, g.Available())
+	h.Check()
+	assert.False(t, g.Available())
+	assert.Equal(t, "down", g.Status().Health.LastError)
+
+	require.True(t, breaker.Allow())
+	breaker.Record(false)
+	assert.Equal(t, BreakerOpen, breaker.State())
+
+	probeErr = nil
+	h.Check()
+	assert.False(t, g.Available())
+	h.Check()
+	assert.True(t, g.Available(), "re-admitted backends get a closed breaker")
+	assert.Equal(t, BreakerClosed, breaker.State())
+}
+
+func TestHTTPGetProbe(t *testing.T) {
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/health", r.URL.Path)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+	probe := HTTPGetProbe(nil, srv.URL+"/health")
+	assert.NoError(t, probe(context.Background()))
+	status = http.StatusServiceUnavailable
+	assert.Error(t, probe(context.Background()))
+}
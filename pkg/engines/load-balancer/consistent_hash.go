@@ -0,0 +1,204 @@
+package loadbalancer
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/infinigence/octollm/pkg/octollm"
+)
+
+// ringPointsPerBackend is the number of points of the backend with the largest weight on the hash ring
+const ringPointsPerBackend = 160
+
+// ConsistentHash routes requests with the same affinity key to the same backend, e.g. requests sharing a prompt
+// prefix to the replica which has it in its prefix cache. Backends are placed on a hash ring in proportion to
+// their weights. The load is bounded: a backend which already has loadFactor times its fair share of the requests
+// in flight is passed over for the next one on the ring, so a hot key spills over instead of overloading a
+// backend. Requests without key go to the least loaded backend.
+type ConsistentHash struct {
+	*balancer
+	ring       []ringPoint
+	loadFactor float64
+}
+
+type ringPoint struct {
+	hash    uint64
+	backend *backend
+}
+
+var _ octollm.Engine = (*ConsistentHash)(nil)
+
+// NewConsistentHash builds a consistent hash balancer keyed by keyOf, loadFactor defaults to 1.25.
+func NewConsistentHash(backends []BackendItem, retry RetryPolicy, keyOf AffinityKeyFunc, loadFactor float64) (*ConsistentHash, error) {
+	l, err := newBalancer("consistent hash load balancer", backends, retry)
+	if err != nil {
+		return nil, err
+	}
+	if loadFactor <= 1 {
+		loadFactor = 1.25
+	}
+	maxWeight := 0
+	for _, b := range l.backends {
+		maxWeight = max(maxWeight, b.weight)
+	}
+	h := &ConsistentHash{balancer: l, loadFactor: loadFactor}
+	for i, b := range l.backends {
+		if b.weight == 0 {
+			continue
+		}
+		name := b.name
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		points := max(1, ringPointsPerBackend*b.weight/maxWeight)
+		for j := range points {
+			h.ring = append(h.ring, ringPoint{hash: hash64(name + "#" + strconv.Itoa(j)), backend: b})
+		}
+	}
+	sort.Slice(h.ring, func(i, j int) bool { return h.ring[i].hash < h.ring[j].hash })
+
+	l.choose = h.choose
+	l.keyOf = keyOf
+	l.trackLoad = true
+	return h, nil
+}
+
+func (h *ConsistentHash) choose(candidates []*backend, now time.Time, key string) *backend {
+	if key == "" || len(candidates) == 1 {
+		return chooseLeastInflight(candidates, now, key)
+	}
+
+	isCandidate := make(map[*backend]bool, len(candidates))
+	totalInflight, totalWeight := 0, 0
+	for _, b := range candidates {
+		isCandidate[b] = true
+		totalInflight += b.inflight
+		totalWeight += b.weight
+	}
+
+	keyHash := hash64(key)
+	start := sort.Search(len(h.ring), func(i int) bool { return h.ring[i].hash >= keyHash })
+	for i := range h.ring {
+		b := h.ring[(start+i)%len(h.ring)].backend
+		if !isCandidate[b] {
+			continue
+		}
+		// the fair share counts the new request too
+		capacity := math.Ceil(h.loadFactor * float64(totalInflight+1) * float64(b.weight) / float64(totalWeight))
+		if float64(b.inflight) < capacity {
+			return b
+		}
+		delete(isCandidate, b)
+		if len(isCandidate) == 0 {
+			break
+		}
+	}
+	// not reachable as the capacities sum up to more than the requests in flight
+	return chooseLeastInflight(candidates, now, key)
+}
+
+// AffinityKeyFunc returns the affinity key of a request, "" if the request has none.
+type AffinityKeyFunc func(req *octollm.Request) string
+
+// HeaderAffinityKey keys requests by a request header, e.g. X-Session-Id.
+func HeaderAffinityKey(header string) AffinityKeyFunc {
+	return func(req *octollm.Request) string {
+		return req.Header.Get(header)
+	}
+}
+
+// UserAffinityKey keys requests by the authenticated user, or by the user field of the request body
+// (user in OpenAI requests, metadata.user_id in Claude requests) for anonymous requests.
+func UserAffinityKey() AffinityKeyFunc {
+	return func(req *octollm.Request) string {
+		if id := octollm.IdentityFrom(req.Context()); id.User != "" {
+			return id.Org + "/" + id.User
+		}
+		body := struct {
+			User     string `json:"user"`
+			Metadata struct {
+				UserID string `json:"user_id"`
+			} `json:"metadata"`
+		}{}
+		b, err := req.Body.Bytes()
+		if err != nil || json.Unmarshal(b, &body) != nil {
+			return ""
+		}
+		if body.User != "" {
+			return body.User
+		}
+		return body.Metadata.UserID
+	}
+}
+
+// PromptPrefixAffinityKey keys requests by the first length bytes of their prompt, so that requests sharing a
+// system prompt or a conversation prefix share a key. The prompt is the text of the system prompt, the messages
+// and the input of any supported format, in order.
+func PromptPrefixAffinityKey(length int) AffinityKeyFunc {
+	return func(req *octollm.Request) string {
+		b, err := req.Body.Bytes()
+		if err != nil {
+			return ""
+		}
+		body := make(map[string]any)
+		if err := json.Unmarshal(b, &body); err != nil {
+			return ""
+		}
+		var sb strings.Builder
+		for _, key := range []string{"system", "instructions", "systemInstruction", "messages", "contents", "input", "prompt"} {
+			if v, ok := body[key]; ok {
+				writePromptText(&sb, v, length)
+			}
+		}
+		if sb.Len() == 0 {
+			return ""
+		}
+		prefix := sb.String()
+		if len(prefix) > length {
+			prefix = prefix[:length]
+		}
+		model, _ := body["model"].(string)
+		return model + "\x00" + prefix
+	}
+}
+
+// writePromptText writes the text of a prompt element in order, until sb holds length bytes.
+func writePromptText(sb *strings.Builder, v any, length int) {
+	if sb.Len() >= length {
+		return
+	}
+	switch v := v.(type) {
+	case string:
+		sb.WriteString(v)
+		sb.WriteByte('\n')
+	case []any:
+		for _, e := range v {
+			writePromptText(sb, e, length)
+		}
+	case map[string]any:
+		// messages, content parts and gemini contents, the keys are visited in a fixed order
+		for _, key := range []string{"role", "content", "text", "parts"} {
+			if e, ok := v[key]; ok {
+				writePromptText(sb, e, length)
+			}
+		}
+	}
+}
+
+func hash64(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	// fnv barely changes the high bits for similar strings, mix them to spread the ring (splitmix64 finalizer)
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
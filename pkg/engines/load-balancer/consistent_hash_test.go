@@ -0,0 +1,106 @@
+package loadbalancer
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/infinigence/octollm/pkg/octollm"
+)
+
+func newTestRequest(t *testing.T, body string) *octollm.Request {
+	httpReq, err := http.NewRequestWithContext(context.Background(), http.MethodPost, "http://localhost/v1/chat/completions", nil)
+	require.NoError(t, err)
+	req := octollm.NewRequest(httpReq, octollm.APIFormatChatCompletions)
+	req.Body = octollm.NewBodyFromBytes([]byte(body), nil)
+	return req
+}
+
+func TestConsistentHash_Affinity(t *testing.T) {
+	served := map[string]string{} // key -> backend
+	var lastKey string
+	items := make([]BackendItem, 0, 4)
+	for i := range 4 {
+		name := fmt.Sprintf("replica-%d", i)
+		items = append(items, BackendItem{Name: name, Weight: 1, Engine: octollm.EngineFunc(func(req *octollm.Request) (*octollm.Response, error) {
+			if prev, ok := served[lastKey]; ok {
+				assert.Equal(t, prev, name, "key %s moved", lastKey)
+			}
+			served[lastKey] = name
+			return &octollm.Response{StatusCode: http.StatusOK}, nil
+		})})
+	}
+	lb, err := NewConsistentHash(items, DefaultRetryPolicy, HeaderAffinityKey("X-Session-Id"), 0)
+	require.NoError(t, err)
+
+	for round := range 3 {
+		for i := range 50 {
+			lastKey = fmt.Sprintf("session-%d", i)
+			req := newTestRequest(t, `{}`)
+			req.Header.Set("X-Session-Id", lastKey)
+			_, err := lb.Process(req)
+			require.NoError(t, err, "round %d", round)
+		}
+	}
+	backends := map[string]bool{}
+	for _, name := range served {
+		backends[name] = true
+	}
+	assert.Len(t, backends, 4, "keys are spread over all backends")
+}
+
+func TestConsistentHash_BoundedLoad(t *testing.T) {
+	now := time.Now()
+	a := &backend{name: "a", weight: 1}
+	b := &backend{name: "b", weight: 1}
+	h := &ConsistentHash{
+		ring:       []ringPoint{{hash: 0, backend: a}, {hash: 1 << 62, backend: b}},
+		loadFactor: 1.25,
+	}
+	key := "k"
+	first := h.choose([]*backend{a, b}, now, key)
+	second := map[*backend]*backend{a: b, b: a}[first]
+
+	// the key sticks to its backend until it has more than its share of requests in flight
+	first.inflight = 1
+	assert.Equal(t, first, h.choose([]*backend{a, b}, now, key))
+	first.inflight = 2
+	second.inflight = 0
+	assert.Equal(t, second, h.choose([]*backend{a, b}, now, key))
+
+	// an unavailable backend is passed over
+	first.inflight = 0
+	assert.Equal(t, second, h.choose([]*backend{second}, now, key))
+}
+
+func TestPromptPrefixAffinityKey(t *testing.T) {
+	keyOf := PromptPrefixAffinityKey(64)
+	system := `{"role": "system", "content": "You are a helpful assistant which answers in French."}`
+	k1 := keyOf(newTestRequest(t, `{"model": "m", "messages": [`+system+`, {"role": "user", "content": "Hi"}]}`))
+	k2 := keyOf(newTestRequest(t, `{"messages": [`+system+`, {"role": "user", "content": [{"type": "text", "text": "Bye"}]}], "model": "m", "stream": true}`))
+	k3 := keyOf(newTestRequest(t, `{"model": "m", "messages": [{"role": "system", "content": "You are a pirate."}]}`))
+	k4 := keyOf(newTestRequest(t, `{"model": "other", "messages": [`+system+`]}`))
+	assert.NotEmpty(t, k1)
+	assert.Equal(t, k1, k2, "the same system prompt gives the same key")
+	assert.NotEqual(t, k1, k3)
+	assert.NotEqual(t, k1, k4, "keys are per model")
+
+	claude := keyOf(newTestRequest(t, `{"model": "m", "system": [{"type": "text", "text": "Be brief."}], "messages": [{"role": "user", "content": "Hi"}]}`))
+	assert.Contains(t, claude, "Be brief.")
+	assert.Empty(t, keyOf(newTestRequest(t, `{"model": "m"}`)))
+}
+
+func TestUserAffinityKey(t *testing.T) {
+	keyOf := UserAffinityKey()
+	assert.Equal(t, "u1", keyOf(newTestRequest(t, `{"user": "u1"}`)))
+	assert.Equal(t, "u2", keyOf(newTestRequest(t, `{"metadata": {"user_id": "u2"}}`)))
+
+	req := newTestRequest(t, `{"user": "u1"}`)
+	req = req.WithContext(octollm.WithIdentity(req.Context(), octollm.Identity{User: "alice", Org: "acme"}))
+	assert.Equal(t, "acme/alice", keyOf(req))
+}
@@ -0,0 +1,48 @@
+package loadbalancer
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/infinigence/octollm/pkg/octollm"
+)
+
+// Failover sends requests to its tiers in order (primary, secondary, overflow, ...).
+// A request goes to the next tier only when the previous one failed in a way another backend may not,
+// as told by the RetryPolicy, e.g. when all of its backends are down or busy.
+type Failover struct {
+	tiers []octollm.Engine
+	retry RetryPolicy
+}
+
+var _ octollm.Engine = (*Failover)(nil)
+
+func NewFailover(tiers []octollm.Engine, retry RetryPolicy) (*Failover, error) {
+	if len(tiers) == 0 {
+		return nil, fmt.Errorf("tiers must have at least one item")
+	}
+	return &Failover{tiers: tiers, retry: retry}, nil
+}
+
+func (f *Failover) Process(req *octollm.Request) (*octollm.Response, error) {
+	if len(f.tiers) == 1 {
+		return f.tiers[0].Process(req)
+	}
+	// backends convert and rewrite the request in place, each tier must get it as it came
+	snapshot, err := req.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	var resp *octollm.Response
+	for i, tier := range f.tiers {
+		if i > 0 {
+			logrus.WithContext(req.Context()).Warnf("[failover] tier %d failed, fail over to tier %d: %v", i-1, i, err)
+		}
+		resp, err = tier.Process(snapshot.Restore(req))
+		if err == nil || !f.retry.Retryable(req, err) {
+			return resp, err
+		}
+	}
+	return resp, err
+}
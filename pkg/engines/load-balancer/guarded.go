@@ -0,0 +1,116 @@
+package loadbalancer
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/infinigence/octollm/pkg/errutils"
+	"github.com/infinigence/octollm/pkg/octollm"
+)
+
+var ErrBackendUnavailable = errors.New("backend unavailable")
+
+// Availability is implemented by backend engines that can be taken out of rotation.
+// Load balancers skip backends that are not available.
+type Availability interface {
+	Available() bool
+}
+
+// GuardedBackend guards a backend engine with an optional circuit breaker and an optional health checker.
+type GuardedBackend struct {
+	name    string
+	next    octollm.Engine
+	breaker *CircuitBreaker
+	health  *HealthChecker
+}
+
+var _ octollm.Engine = (*GuardedBackend)(nil)
+var _ Availability = (*GuardedBackend)(nil)
+
+// NewGuardedBackend guards next, breaker and health may be nil.
+// When the health checker re-admits the backend, the breaker is reset.
+func NewGuardedBackend(name string, next octollm.Engine, breaker *CircuitBreaker, health *HealthChecker) *GuardedBackend {
+	if breaker != nil && health != nil {
+		health.OnHealthy(breaker.Reset)
+	}
+	return &GuardedBackend{
+		name:    name,
+		next:    next,
+		breaker: breaker,
+		health:  health,
+	}
+}
+
+func (g *GuardedBackend) Available() bool {
+	if g.health != nil && !g.health.Healthy() {
+		return false
+	}
+	if g.breaker != nil && !g.breaker.Available() {
+		return false
+	}
+	return true
+}
+
+func (g *GuardedBackend) Process(req *octollm.Request) (*octollm.Response, error) {
+	if g.health != nil && !g.health.Healthy() {
+		return nil, &errutils.UpstreamHTTPError{Err: fmt.Errorf("%s: %w", g.name, ErrBackendUnavailable)}
+	}
+	if g.breaker == nil {
+		return g.next.Process(req)
+	}
+	if !g.breaker.Allow() {
+		return nil, &errutils.UpstreamHTTPError{Err: fmt.Errorf("%s: circuit breaker open: %w", g.name, ErrBackendUnavailable)}
+	}
+	resp, err := g.next.Process(req)
+	g.breaker.Record(!isBackendFailure(req, err))
+	return resp, err
+}
+
+// Stop stops the health checker, if any.
+func (g *GuardedBackend) Stop() {
+	if g.health != nil {
+		g.health.Stop()
+	}
+}
+
+func (g *GuardedBackend) Status() BackendStatus {
+	s := BackendStatus{
+		Name:      g.name,
+		Available: g.Available(),
+	}
+	if g.breaker != nil {
+		snapshot := g.breaker.Snapshot()
+		s.Breaker = &snapshot
+	}
+	if g.health != nil {
+		snapshot := g.health.Snapshot()
+		s.Health = &snapshot
+	}
+	return s
+}
+
+type BackendStatus struct {
+	Name      string           `json:"name"`
+	Available bool             `json:"available"`
+	Breaker   *BreakerSnapshot `json:"circuit_breaker,omitempty"`
+	Health    *HealthSnapshot  `json:"health_check,omitempty"`
+}
+
+// isBackendFailure tells whether err is the fault of the backend: the upstream could not be reached, or answered
+// with 5xx or 429. The errors of the engines wrapped with the upstream, e.g. a converter refusing the body of the
+// client, client errors (other 4xx) and requests cancelled by the client do not count.
+func isBackendFailure(req *octollm.Request, err error) bool {
+	if err == nil {
+		return false
+	}
+	if req.Context().Err() != nil {
+		return false
+	}
+	respErr := &errutils.UpstreamRespError{}
+	if errors.As(err, &respErr) {
+		return respErr.StatusCode >= 500 || respErr.StatusCode == http.StatusTooManyRequests
+	}
+	httpErr := &errutils.UpstreamHTTPError{}
+	return errors.As(err, &httpErr)
+}
@@ -0,0 +1,195 @@
+package loadbalancer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/infinigence/octollm/pkg/octollm"
+)
+
+// Probe checks a backend once, a nil error means healthy.
+type Probe func(ctx context.Context) error
+
+// HTTPGetProbe probes a backend by a GET to a health URL, any 2xx status is healthy.
+func HTTPGetProbe(client *http.Client, url string) Probe {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return fmt.Errorf("health url returned status %d", resp.StatusCode)
+		}
+		return nil
+	}
+}
+
+// EngineProbe probes a backend by sending it a request, usually a cheap one such as a 1-token completion.
+// newRequest builds a fresh request for each probe.
+func EngineProbe(engine octollm.Engine, newRequest func(ctx context.Context) (*octollm.Request, error)) Probe {
+	return func(ctx context.Context) error {
+		req, err := newRequest(ctx)
+		if err != nil {
+			return err
+		}
+		resp, err := engine.Process(req)
+		if err != nil {
+			return err
+		}
+		if resp.Stream != nil {
+			resp.Stream.Close()
+		} else if resp.Body != nil {
+			resp.Body.Close()
+		}
+		return nil
+	}
+}
+
+type HealthCheckConfig struct {
+	Interval           time.Duration // defaults to 10s
+	Timeout            time.Duration // defaults to 5s
+	UnhealthyThreshold int           // failed probes in a row to eject the backend, defaults to 3
+	HealthyThreshold   int           // successful probes in a row to re-admit the backend, defaults to 2
+}
+
+// HealthChecker probes a backend periodically in the background.
+// A backend is ejected after UnhealthyThreshold failed probes in a row and re-admitted after HealthyThreshold
+// successful ones.
+type HealthChecker struct {
+	name  string
+	probe Probe
+	conf  HealthCheckConfig
+
+	mu        sync.Mutex
+	healthy   bool
+	successes int
+	failures  int
+	lastErr   error
+	lastCheck time.Time
+	onHealthy func()
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+}
+
+func NewHealthChecker(name string, probe Probe, conf HealthCheckConfig) *HealthChecker {
+	if conf.Interval <= 0 {
+		conf.Interval = 10 * time.Second
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = 5 * time.Second
+	}
+	if conf.UnhealthyThreshold <= 0 {
+		conf.UnhealthyThreshold = 3
+	}
+	if conf.HealthyThreshold <= 0 {
+		conf.HealthyThreshold = 2
+	}
+	return &HealthChecker{
+		name:    name,
+		probe:   probe,
+		conf:    conf,
+		healthy: true,
+		stopCh:  make(chan struct{}),
+	}
+}
+
+// Start runs the probes until Stop is called.
+func (h *HealthChecker) Start() {
+	go func() {
+		ticker := time.NewTicker(h.conf.Interval)
+		defer ticker.Stop()
+		for {
+			h.Check()
+			select {
+			case <-ticker.C:
+			case <-h.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+func (h *HealthChecker) Stop() {
+	h.stopOnce.Do(func() { close(h.stopCh) })
+}
+
+// Check runs a probe and updates the health of the backend.
+func (h *HealthChecker) Check() {
+	ctx, cancel := context.WithTimeout(context.Background(), h.conf.Timeout)
+	defer cancel()
+	err := h.probe(ctx)
+
+	h.mu.Lock()
+	h.lastErr = err
+	h.lastCheck = time.Now()
+	var recovered func()
+	if err == nil {
+		h.failures = 0
+		h.successes++
+		if !h.healthy && h.successes >= h.conf.HealthyThreshold {
+			logrus.Infof("[health check] backend %s is healthy again", h.name)
+			h.healthy = true
+			recovered = h.onHealthy
+		}
+	} else {
+		h.successes = 0
+		h.failures++
+		if h.healthy && h.failures >= h.conf.UnhealthyThreshold {
+			logrus.Warnf("[health check] backend %s is ejected after %d failed probes: %v", h.name, h.failures, err)
+			h.healthy = false
+		}
+	}
+	h.mu.Unlock()
+
+	if recovered != nil {
+		recovered()
+	}
+}
+
+// OnHealthy sets a callback run when the backend is re-admitted.
+func (h *HealthChecker) OnHealthy(f func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onHealthy = f
+}
+
+func (h *HealthChecker) Healthy() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.healthy
+}
+
+func (h *HealthChecker) Snapshot() HealthSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := HealthSnapshot{
+		Healthy:   h.healthy,
+		LastCheck: h.lastCheck,
+	}
+	if h.lastErr != nil {
+		s.LastError = h.lastErr.Error()
+	}
+	return s
+}
+
+type HealthSnapshot struct {
+	Healthy   bool      `json:"healthy"`
+	LastCheck time.Time `json:"last_check"`
+	LastError string    `json:"last_error,omitempty"`
+}
@@ -0,0 +1,243 @@
+package loadbalancer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/infinigence/octollm/pkg/octollm"
+)
+
+// hedgeBudgetBurst caps the hedges saved up by a quiet Hedged engine.
+const hedgeBudgetBurst = 10
+
+// HedgePolicy tells when a request is hedged.
+type HedgePolicy struct {
+	Delay         time.Duration // time to wait for an attempt before sending another one, defaults to 500ms
+	MaxHedges     int           // extra attempts per request, defaults to 1
+	BudgetPercent float64       // hedges are at most this percentage of the requests, defaults to 10
+}
+
+// Hedged cuts the tail latency caused by a slow backend. When an attempt has not answered within the delay of
+// its HedgePolicy, a duplicate is sent and the first successful answer is used, the others are cancelled through
+// their request context. A stream has answered when its first chunk arrives.
+//
+// The duplicates go to next, usually a load balancer of this package, which sends each of them to a backend not
+// used by the other attempts as long as there is one.
+type Hedged struct {
+	next   octollm.Engine
+	policy HedgePolicy
+
+	mu     sync.Mutex
+	tokens float64 // hedges allowed, each request earns BudgetPercent/100 of one
+}
+
+var _ octollm.Engine = (*Hedged)(nil)
+
+func NewHedged(next octollm.Engine, policy HedgePolicy) (*Hedged, error) {
+	if next == nil {
+		return nil, fmt.Errorf("next engine must not be nil")
+	}
+	if policy.Delay <= 0 {
+		policy.Delay = 500 * time.Millisecond
+	}
+	if policy.MaxHedges <= 0 {
+		policy.MaxHedges = 1
+	}
+	if policy.BudgetPercent <= 0 {
+		policy.BudgetPercent = 10
+	}
+	return &Hedged{next: next, policy: policy}, nil
+}
+
+// hedgeAttempt is one of the attempts of a hedged request.
+type hedgeAttempt struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	resp *octollm.Response
+	err  error
+	// the first chunk of a stream, read before the attempt counts as answered
+	first    *octollm.StreamChunk
+	gotFirst bool
+}
+
+func (h *Hedged) Process(req *octollm.Request) (*octollm.Response, error) {
+	if !h.earn() {
+		// no hedge may be sent, skip the bookkeeping
+		return h.next.Process(req)
+	}
+
+	body, err := req.Body.Bytes()
+	if err != nil {
+		return nil, fmt.Errorf("read body error: %w", err)
+	}
+	ctx := context.WithValue(req.Context(), hedgeGroupKey{}, &hedgeGroup{used: make(map[*backend]bool)})
+
+	results := make(chan *hedgeAttempt, h.policy.MaxHedges+1)
+	attempts := make([]*hedgeAttempt, 0, h.policy.MaxHedges+1)
+	launch := func() {
+		a := &hedgeAttempt{}
+		a.ctx, a.cancel = context.WithCancel(ctx)
+		// the attempts run concurrently, each needs a request of its own
+		r := req.WithContext(a.ctx)
+		r.Header = req.Header.Clone()
+		r.Body = octollm.NewBodyFromBytes(body, octollm.NewRequestParser(req.Format))
+		attempts = append(attempts, a)
+		go func() {
+			a.run(h.next, r)
+			results <- a
+		}()
+	}
+
+	launch()
+	pending := 1
+	timer := time.NewTimer(h.policy.Delay)
+	defer timer.Stop()
+	for {
+		select {
+		case a := <-results:
+			pending--
+			if a.err == nil {
+				h.dropLosers(attempts, a, results, pending)
+				return a.response(), nil
+			}
+			a.cancel()
+			if pending == 0 {
+				return a.resp, a.err
+			}
+			logrus.WithContext(ctx).Infof("[hedge] attempt failed, waiting for %d other attempts: %v", pending, a.err)
+		case <-timer.C:
+			if len(attempts) > h.policy.MaxHedges || !h.spend() {
+				continue
+			}
+			logrus.WithContext(ctx).Infof("[hedge] no answer after %v, sending hedge %d", h.policy.Delay, len(attempts))
+			launch()
+			pending++
+			timer.Reset(h.policy.Delay)
+		case <-ctx.Done():
+			h.dropLosers(attempts, nil, results, pending)
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// run processes req and waits for the first chunk of a stream.
+func (a *hedgeAttempt) run(next octollm.Engine, req *octollm.Request) {
+	a.resp, a.err = next.Process(req)
+	if a.err != nil || a.resp.Stream == nil {
+		return
+	}
+	a.first, a.gotFirst = <-a.resp.Stream.Chan()
+}
+
+// response returns the response of the winning attempt, with the first chunk put back in front of its stream.
+func (a *hedgeAttempt) response() *octollm.Response {
+	if a.resp.Stream == nil {
+		// the body may still be read from the upstream, the context of the winner is left to the request
+		return a.resp
+	}
+	src := a.resp.Stream
+	outCh := make(chan *octollm.StreamChunk)
+	ctx, cancel := context.WithCancel(a.ctx)
+
+	go func() {
+		defer close(outCh)
+		defer a.cancel()
+		defer src.Close()
+
+		chunk, ok := a.first, a.gotFirst
+		for ok {
+			select {
+			case outCh <- chunk:
+			case <-ctx.Done():
+				return
+			}
+			chunk, ok = <-src.Chan()
+		}
+	}()
+
+	resp := *a.resp
+	resp.Stream = octollm.NewStreamChan(outCh, cancel)
+	return &resp
+}
+
+// dropLosers cancels the attempts other than winner, and releases the responses of those still pending.
+func (h *Hedged) dropLosers(attempts []*hedgeAttempt, winner *hedgeAttempt, results <-chan *hedgeAttempt, pending int) {
+	for _, a := range attempts {
+		if a != winner {
+			a.cancel()
+		}
+	}
+	go func() {
+		for range pending {
+			a := <-results
+			if a.resp == nil {
+				continue
+			}
+			if a.resp.Stream != nil {
+				a.resp.Stream.Close()
+			} else if a.resp.Body != nil {
+				a.resp.Body.Close()
+			}
+		}
+	}()
+}
+
+// earn adds the share of a request to the budget, and tells if a hedge may be sent.
+func (h *Hedged) earn() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.tokens = min(h.tokens+h.policy.BudgetPercent/100, hedgeBudgetBurst)
+	return h.tokens >= 1
+}
+
+// spend takes a hedge from the budget.
+func (h *Hedged) spend() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.tokens < 1 {
+		return false
+	}
+	h.tokens--
+	return true
+}
+
+type hedgeGroupKey struct{}
+
+// hedgeGroup keeps the backends used by the attempts of a hedged request, so that balancers send each attempt to
+// another backend.
+type hedgeGroup struct {
+	mu   sync.Mutex
+	used map[*backend]bool
+}
+
+func hedgeGroupFrom(ctx context.Context) *hedgeGroup {
+	g, _ := ctx.Value(hedgeGroupKey{}).(*hedgeGroup)
+	return g
+}
+
+// pick filters out the backends used by other attempts if any other candidate remains, and records the backend
+// chosen from the rest.
+func (g *hedgeGroup) pick(candidates []*backend, choose func([]*backend) *backend) *backend {
+	if g == nil {
+		return choose(candidates)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	unused := make([]*backend, 0, len(candidates))
+	for _, b := range candidates {
+		if !g.used[b] {
+			unused = append(unused, b)
+		}
+	}
+	if len(unused) > 0 {
+		candidates = unused
+	}
+	b := choose(candidates)
+	g.used[b] = true
+	return b
+}
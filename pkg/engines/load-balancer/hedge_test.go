@@ -0,0 +1,121 @@
+package loadbalancer
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/infinigence/octollm/pkg/octollm"
+)
+
+func TestHedged(t *testing.T) {
+	var slowCancelled atomic.Bool
+	slow := octollm.EngineFunc(func(req *octollm.Request) (*octollm.Response, error) {
+		select {
+		case <-req.Context().Done():
+			slowCancelled.Store(true)
+			return nil, req.Context().Err()
+		case <-time.After(5 * time.Second):
+			return octollm.NewNonStreamResponse(http.StatusOK, nil, octollm.NewBodyFromBytes([]byte("slow"), nil)), nil
+		}
+	})
+	fast := octollm.EngineFunc(func(req *octollm.Request) (*octollm.Response, error) {
+		return octollm.NewNonStreamResponse(http.StatusOK, nil, octollm.NewBodyFromBytes([]byte("fast"), nil)), nil
+	})
+	lb, err := NewLeastInflight([]BackendItem{
+		{Name: "slow", Weight: 1, Engine: slow},
+		{Name: "fast", Weight: 1, Engine: fast},
+	}, DefaultRetryPolicy)
+	require.NoError(t, err)
+	h, err := NewHedged(lb, HedgePolicy{Delay: 10 * time.Millisecond, BudgetPercent: 100})
+	require.NoError(t, err)
+
+	for range 5 {
+		start := time.Now()
+		resp, err := h.Process(newTestRequest(t, `{"model":"m"}`))
+		require.NoError(t, err)
+		b, err := resp.Body.Bytes()
+		require.NoError(t, err)
+		assert.Equal(t, "fast", string(b))
+		assert.Less(t, time.Since(start), time.Second)
+	}
+	assert.Eventually(t, slowCancelled.Load, time.Second, 10*time.Millisecond, "the slow attempt is cancelled")
+}
+
+func TestHedged_Stream(t *testing.T) {
+	var calls atomic.Int32
+	engine := octollm.EngineFunc(func(req *octollm.Request) (*octollm.Response, error) {
+		ch := make(chan *octollm.StreamChunk)
+		delay := time.Duration(0)
+		if calls.Add(1) == 1 {
+			// the first attempt answers with headers at once but is slow to send its first chunk
+			delay = 5 * time.Second
+		}
+		go func() {
+			defer close(ch)
+			for _, s := range []string{"a", "b"} {
+				select {
+				case <-time.After(delay):
+				case <-req.Context().Done():
+					return
+				}
+				ch <- &octollm.StreamChunk{Body: octollm.NewBodyFromBytes([]byte(s), nil)}
+			}
+		}()
+		return octollm.NewStreamResponse(http.StatusOK, nil, octollm.NewStreamChan(ch, nil)), nil
+	})
+	h, err := NewHedged(engine, HedgePolicy{Delay: 10 * time.Millisecond, BudgetPercent: 100})
+	require.NoError(t, err)
+
+	start := time.Now()
+	resp, err := h.Process(newTestRequest(t, `{"model":"m","stream":true}`))
+	require.NoError(t, err)
+	var got []string
+	for chunk := range resp.Stream.Chan() {
+		b, err := chunk.Body.Bytes()
+		require.NoError(t, err)
+		got = append(got, string(b))
+	}
+	resp.Stream.Close()
+	assert.Equal(t, []string{"a", "b"}, got)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.EqualValues(t, 2, calls.Load())
+}
+
+func TestHedged_Budget(t *testing.T) {
+	var calls atomic.Int32
+	engine := octollm.EngineFunc(func(req *octollm.Request) (*octollm.Response, error) {
+		calls.Add(1)
+		time.Sleep(20 * time.Millisecond)
+		return octollm.NewNonStreamResponse(http.StatusOK, nil, octollm.NewBodyFromBytes([]byte("ok"), nil)), nil
+	})
+	h, err := NewHedged(engine, HedgePolicy{Delay: time.Millisecond, BudgetPercent: 25})
+	require.NoError(t, err)
+
+	for range 20 {
+		_, err := h.Process(newTestRequest(t, `{"model":"m"}`))
+		require.NoError(t, err)
+	}
+	// every request is slow enough to be hedged, but only a quarter of them may be
+	assert.EqualValues(t, 25, calls.Load())
+}
+
+func TestHedged_ClientCancel(t *testing.T) {
+	engine := octollm.EngineFunc(func(req *octollm.Request) (*octollm.Response, error) {
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	})
+	h, err := NewHedged(engine, HedgePolicy{Delay: time.Millisecond, BudgetPercent: 100})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req := newTestRequest(t, `{"model":"m"}`)
+	_, err = h.Process(req.WithContext(ctx))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
@@ -0,0 +1,51 @@
+package loadbalancer
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/infinigence/octollm/pkg/octollm"
+)
+
+// LeastInflight sends each request to the backend with the fewest requests in flight relative to its weight.
+// Streams are in flight until they end.
+type LeastInflight struct {
+	*balancer
+}
+
+var _ octollm.Engine = (*LeastInflight)(nil)
+
+func NewLeastInflight(backends []BackendItem, retry RetryPolicy) (*LeastInflight, error) {
+	l, err := newBalancer("least inflight load balancer", backends, retry)
+	if err != nil {
+		return nil, err
+	}
+	l.choose = chooseLeastInflight
+	l.trackLoad = true
+	return &LeastInflight{balancer: l}, nil
+}
+
+func chooseLeastInflight(candidates []*backend, _ time.Time, _ string) *backend {
+	var best *backend
+	bestLoad := 0.0
+	ties := 0
+	for _, b := range candidates {
+		load := b.load()
+		switch {
+		case best == nil || load < bestLoad:
+			best, bestLoad, ties = b, load, 1
+		case load == bestLoad:
+			// pick uniformly among the ties
+			ties++
+			if rand.Intn(ties) == 0 {
+				best = b
+			}
+		}
+	}
+	return best
+}
+
+// load is the number of requests in flight including a new one, relative to the weight.
+func (b *backend) load() float64 {
+	return float64(b.inflight+1) / float64(b.weight)
+}
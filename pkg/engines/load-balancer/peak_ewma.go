@@ -0,0 +1,74 @@
+package loadbalancer
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/infinigence/octollm/pkg/octollm"
+)
+
+const (
+	// defaultLatency is the latency assumed for backends not observed yet
+	defaultLatency = time.Second
+	// failureLatency is the least latency observed for a failed request
+	failureLatency = 5 * time.Second
+	// latencyDecay is the time constant of the EWMA, older observations weigh less
+	latencyDecay = 10 * time.Second
+)
+
+// PeakEWMA sends each request to the backend with the lowest expected latency times requests in flight,
+// relative to its weight. The latency is the time to the first chunk for streams and the time to the response
+// otherwise, averaged by a peak-sensitive EWMA: a slower observation is taken at once, a faster one is averaged in.
+// Failures of a backend count as slow responses.
+type PeakEWMA struct {
+	*balancer
+}
+
+var _ octollm.Engine = (*PeakEWMA)(nil)
+
+func NewPeakEWMA(backends []BackendItem, retry RetryPolicy) (*PeakEWMA, error) {
+	l, err := newBalancer("peak EWMA load balancer", backends, retry)
+	if err != nil {
+		return nil, err
+	}
+	l.choose = choosePeakEWMA
+	l.trackLoad = true
+	return &PeakEWMA{balancer: l}, nil
+}
+
+func choosePeakEWMA(candidates []*backend, now time.Time, _ string) *backend {
+	var best *backend
+	bestCost := 0.0
+	ties := 0
+	for _, b := range candidates {
+		cost := b.cost(now)
+		switch {
+		case best == nil || cost < bestCost:
+			best, bestCost, ties = b, cost, 1
+		case cost == bestCost:
+			ties++
+			if rand.Intn(ties) == 0 {
+				best = b
+			}
+		}
+	}
+	return best
+}
+
+// cost is the expected latency of a new request on b, relative to the weight.
+// The latency decays while the backend is not observed, so that a backend once slow gets tried again.
+func (b *backend) cost(now time.Time) float64 {
+	b.updateLatency(0, now)
+	return b.latency * b.load()
+}
+
+func (b *backend) updateLatency(latency float64, now time.Time) {
+	if latency > b.latency {
+		b.latency = latency
+	} else {
+		decay := math.Exp(-now.Sub(b.latencyAt).Seconds() / latencyDecay.Seconds())
+		b.latency = b.latency*decay + latency*(1-decay)
+	}
+	b.latencyAt = now
+}
@@ -0,0 +1,61 @@
+package loadbalancer
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/infinigence/octollm/pkg/octollm"
+)
+
+// PowerOfTwoChoices picks two backends at random, in proportion to their weights, and sends each request to the
+// one with fewer requests in flight relative to its weight. It balances nearly as well as LeastInflight while
+// avoiding herding on a single backend which just freed up.
+type PowerOfTwoChoices struct {
+	*balancer
+}
+
+var _ octollm.Engine = (*PowerOfTwoChoices)(nil)
+
+func NewPowerOfTwoChoices(backends []BackendItem, retry RetryPolicy) (*PowerOfTwoChoices, error) {
+	l, err := newBalancer("P2C load balancer", backends, retry)
+	if err != nil {
+		return nil, err
+	}
+	l.choose = choosePowerOfTwo
+	l.trackLoad = true
+	return &PowerOfTwoChoices{balancer: l}, nil
+}
+
+func choosePowerOfTwo(candidates []*backend, _ time.Time, _ string) *backend {
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+	i := pickWeighted(candidates, -1)
+	j := pickWeighted(candidates, i)
+	a, b := candidates[i], candidates[j]
+	if b.load() < a.load() {
+		return b
+	}
+	return a
+}
+
+// pickWeighted returns the index of a random candidate other than exclude, in proportion to the weights.
+func pickWeighted(candidates []*backend, exclude int) int {
+	total := 0
+	for i, b := range candidates {
+		if i != exclude {
+			total += b.weight
+		}
+	}
+	n := rand.Intn(total)
+	for i, b := range candidates {
+		if i == exclude {
+			continue
+		}
+		if n < b.weight {
+			return i
+		}
+		n -= b.weight
+	}
+	return len(candidates) - 1
+}
@@ -0,0 +1,92 @@
+package loadbalancer
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/infinigence/octollm/pkg/errutils"
+	"github.com/infinigence/octollm/pkg/octollm"
+)
+
+// RetryPolicy decides whether and when a failed request is retried on another backend.
+type RetryPolicy struct {
+	Timeout        time.Duration // no retry is started after this much time since the first attempt
+	MaxAttempts    int           // attempts including the first one
+	InitialBackoff time.Duration // backoff before the first retry, doubled for each further retry
+	MaxBackoff     time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	Timeout:        5 * time.Second,
+	MaxAttempts:    10,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     2 * time.Second,
+}
+
+// Retryable tells whether a request failed with err may succeed when sent again.
+// Connection errors, 408, 429 and 5xx are retryable, other errors would fail the same way on every backend.
+// Nothing is retryable once the client has gone away.
+func (p *RetryPolicy) Retryable(req *octollm.Request, err error) bool {
+	if err == nil || req.Context().Err() != nil {
+		return false
+	}
+	respErr := &errutils.UpstreamRespError{}
+	if errors.As(err, &respErr) {
+		return retryableStatus(respErr.StatusCode)
+	}
+	httpErr := &errutils.UpstreamHTTPError{}
+	if errors.As(err, &httpErr) {
+		return true
+	}
+	handlerErr := &errutils.HandlerError{}
+	if errors.As(err, &handlerErr) {
+		return retryableStatus(handlerErr.StatusCode)
+	}
+	return false
+}
+
+func retryableStatus(status int) bool {
+	return status == http.StatusRequestTimeout || status == http.StatusTooManyRequests || status >= 500
+}
+
+// Backoff returns the delay before the retry following the given attempt (1 for the first attempt),
+// an exponential backoff with jitter in [d/2, d).
+func (p *RetryPolicy) Backoff(attempt int) time.Duration {
+	d := p.InitialBackoff
+	for i := 1; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// RetryAfter returns the delay asked by the Retry-After header of the upstream error, 0 if none.
+func RetryAfter(err error) time.Duration {
+	var header http.Header
+	respErr := &errutils.UpstreamRespError{}
+	handlerErr := &errutils.HandlerError{}
+	if errors.As(err, &respErr) {
+		header = respErr.Header
+	} else if errors.As(err, &handlerErr) {
+		header = handlerErr.Header
+	}
+	v := header.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(v); err == nil {
+		return max(0, time.Duration(seconds)*time.Second)
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(0, time.Until(t))
+	}
+	return 0
+}
@@ -0,0 +1,163 @@
+package loadbalancer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/infinigence/octollm/pkg/errutils"
+	"github.com/infinigence/octollm/pkg/octollm"
+)
+
+func TestRetryPolicy_Retryable(t *testing.T) {
+	p := DefaultRetryPolicy
+	httpReq, err := http.NewRequestWithContext(context.Background(), http.MethodPost, "http://localhost/v1/chat/completions", nil)
+	require.NoError(t, err)
+	req := octollm.NewRequest(httpReq, octollm.APIFormatChatCompletions)
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"bad request", &errutils.UpstreamRespError{StatusCode: http.StatusBadRequest}, false},
+		{"unauthorized", &errutils.UpstreamRespError{StatusCode: http.StatusUnauthorized}, false},
+		{"unprocessable", &errutils.UpstreamRespError{StatusCode: http.StatusUnprocessableEntity}, false},
+		{"too many requests", &errutils.UpstreamRespError{StatusCode: http.StatusTooManyRequests}, true},
+		{"bad gateway", &errutils.UpstreamRespError{StatusCode: http.StatusBadGateway}, true},
+		{"connection error", &errutils.UpstreamHTTPError{Err: errors.New("connection refused")}, true},
+		{"handler error", errutils.NewHandlerError(errors.New("bad"), http.StatusBadRequest, "Bad Request"), false},
+		{"other error", errors.New("failed to parse request body"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, p.Retryable(req, tt.err))
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cancelledReq := octollm.NewRequest(httpReq.WithContext(ctx), octollm.APIFormatChatCompletions)
+	assert.False(t, p.Retryable(cancelledReq, &errutils.UpstreamHTTPError{Err: ctx.Err()}))
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for attempt, want := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 5: time.Second, 50: time.Second} {
+		d := p.Backoff(attempt)
+		assert.GreaterOrEqual(t, d, want/2)
+		assert.LessOrEqual(t, d, want)
+	}
+}
+
+func TestWeightedRoundRobin_Retry(t *testing.T) {
+	calls := map[string]int{}
+	errs := map[string]error{}
+	newEngine := func(name string) octollm.Engine {
+		return octollm.EngineFunc(func(req *octollm.Request) (*octollm.Response, error) {
+			calls[name]++
+			if err := errs[name]; err != nil {
+				return nil, err
+			}
+			return &octollm.Response{StatusCode: http.StatusOK}, nil
+		})
+	}
+	lb, err := NewWeightedRoundRobin([]BackendItem{
+		{Name: "a", Weight: 1, Engine: newEngine("a")},
+		{Name: "b", Weight: 1, Engine: newEngine("b")},
+	}, RetryPolicy{Timeout: time.Minute, MaxAttempts: 3})
+	require.NoError(t, err)
+
+	httpReq, err := http.NewRequestWithContext(context.Background(), http.MethodPost, "http://localhost/v1/chat/completions", nil)
+	require.NoError(t, err)
+	req := octollm.NewRequest(httpReq, octollm.APIFormatChatCompletions)
+
+	// a request failing the same way everywhere is not retried
+	errs["a"] = &errutils.UpstreamRespError{StatusCode: http.StatusBadRequest}
+	errs["b"] = errs["a"]
+	_, err = lb.Process(req)
+	require.Error(t, err)
+	assert.Equal(t, 1, calls["a"]+calls["b"])
+
+	// the failed backend is skipped on the next attempt
+	clear(calls)
+	errs["a"] = &errutils.UpstreamRespError{StatusCode: http.StatusServiceUnavailable}
+	errs["b"] = nil
+	for range 4 {
+		_, err = lb.Process(req)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 2, calls["a"])
+	assert.Equal(t, 4, calls["b"])
+
+	// a backend asking to retry later is not used until then
+	clear(calls)
+	errs["a"] = &errutils.UpstreamRespError{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": []string{"120"}}}
+	for range 4 {
+		_, err = lb.Process(req)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 1, calls["a"])
+	assert.Equal(t, 4, calls["b"])
+
+	// and when all backends ask to retry later beyond the retry period, the client is told to
+	clear(calls)
+	errs["b"] = errs["a"]
+	_, err = lb.Process(req)
+	require.Error(t, err)
+	assert.Equal(t, 1, calls["b"])
+	_, err = lb.Process(req)
+	herr := errutils.AsHandlerError(err)
+	assert.Equal(t, http.StatusTooManyRequests, herr.StatusCode)
+	assert.NotEmpty(t, herr.Header.Get("Retry-After"))
+	assert.Equal(t, 1, calls["b"])
+}
+
+func TestRetryAfter(t *testing.T) {
+	assert.Equal(t, 3*time.Second, RetryAfter(&errutils.UpstreamRespError{Header: http.Header{"Retry-After": []string{"3"}}}))
+	assert.Equal(t, time.Duration(0), RetryAfter(&errutils.UpstreamRespError{StatusCode: http.StatusTooManyRequests}))
+	d := RetryAfter(&errutils.UpstreamRespError{Header: http.Header{"Retry-After": []string{time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)}}})
+	assert.InDelta(t, time.Minute.Seconds(), d.Seconds(), 2)
+}
+
+func TestWeightedRoundRobin_RetryGetsOriginalRequest(t *testing.T) {
+	type seen struct {
+		format octollm.APIFormat
+		body   string
+		header string
+	}
+	var calls []seen
+	// like a backend with a converter and rewrites, the request is changed in place before the upstream fails
+	engine := octollm.EngineFunc(func(req *octollm.Request) (*octollm.Response, error) {
+		b, err := req.Body.Bytes()
+		require.NoError(t, err)
+		calls = append(calls, seen{req.Format, string(b), req.Header.Get("X-Extra")})
+		if len(calls) > 1 {
+			return &octollm.Response{StatusCode: http.StatusOK}, nil
+		}
+		req.Format = octollm.APIFormatClaudeMessages
+		req.Body.SetBytes([]byte(`{"converted":true}`))
+		req.Header.Set("X-Extra", "a")
+		return nil, &errutils.UpstreamRespError{StatusCode: http.StatusServiceUnavailable}
+	})
+	lb, err := NewWeightedRoundRobin([]BackendItem{
+		{Name: "a", Weight: 1, Engine: engine},
+		{Name: "b", Weight: 1, Engine: engine},
+	}, RetryPolicy{Timeout: time.Minute, MaxAttempts: 3})
+	require.NoError(t, err)
+
+	httpReq, err := http.NewRequestWithContext(context.Background(), http.MethodPost, "http://localhost/v1/chat/completions", strings.NewReader(`{"model":"m"}`))
+	require.NoError(t, err)
+	req := octollm.NewRequest(httpReq, octollm.APIFormatChatCompletions)
+	_, err = lb.Process(req)
+	require.NoError(t, err)
+	require.Len(t, calls, 2)
+	assert.Equal(t, seen{octollm.APIFormatChatCompletions, `{"model":"m"}`, ""}, calls[1])
+	assert.Equal(t, octollm.APIFormatChatCompletions, req.Format)
+}
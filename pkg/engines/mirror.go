@@ -0,0 +1,377 @@
+package engines
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+
+	"github.com/infinigence/octollm/pkg/errutils"
+	"github.com/infinigence/octollm/pkg/metrics"
+	"github.com/infinigence/octollm/pkg/octollm"
+)
+
+// MirrorPolicy tells which requests are mirrored to the shadow backend.
+type MirrorPolicy struct {
+	Percent     float64       // sampled percentage of the requests, 0-100
+	Timeout     time.Duration // timeout of a shadow request, defaults to 60s
+	MaxInflight int           // shadow requests in flight, requests over it are not mirrored, defaults to 100
+}
+
+// MirrorResult is the outcome of a mirrored request.
+type MirrorResult struct {
+	Shadow  string
+	Status  int   // status of the shadow response, 0 if it failed without one
+	Err     error // error of the shadow request
+	Latency time.Duration
+	// the outcome of the primary, only set if it came before the shadow timed out, e.g. not for a long stream
+	PrimaryDone    bool
+	PrimaryStatus  int
+	PrimaryLatency time.Duration
+
+	// output text of both sides, e.g. the message content of chat completions, compared when both completed
+	Compared       bool
+	Same           bool
+	PrimaryTextLen int // runes
+	ShadowTextLen  int // runes
+}
+
+// MirrorEngine copies a sample of the requests to a shadow backend, e.g. an upstream under evaluation. The client
+// is answered by Next alone: the copy is sent asynchronously, its response is discarded once compared with the one
+// of Next, and the result goes to Report.
+type MirrorEngine struct {
+	Next   octollm.Engine
+	Shadow octollm.Engine
+	Name   string // name of the shadow, e.g. its backend
+	Policy MirrorPolicy
+	Report func(*MirrorResult) // defaults to logging and counting the result in metrics.Default
+
+	inflight atomic.Int32
+}
+
+var _ octollm.Engine = (*MirrorEngine)(nil)
+
+func NewMirrorEngine(next, shadow octollm.Engine, name string, policy MirrorPolicy) *MirrorEngine {
+	if policy.Timeout <= 0 {
+		policy.Timeout = 60 * time.Second
+	}
+	if policy.MaxInflight <= 0 {
+		policy.MaxInflight = 100
+	}
+	return &MirrorEngine{Next: next, Shadow: shadow, Name: name, Policy: policy, Report: reportMirrorResult}
+}
+
+func (e *MirrorEngine) Process(req *octollm.Request) (*octollm.Response, error) {
+	if rand.Float64()*100 >= e.Policy.Percent {
+		return e.Next.Process(req)
+	}
+	if int(e.inflight.Add(1)) > e.Policy.MaxInflight {
+		e.inflight.Add(-1)
+		logrus.WithContext(req.Context()).Debugf("[mirror] skip mirroring to %s: too many shadow requests in flight", e.Name)
+		return e.Next.Process(req)
+	}
+
+	// the body is read once and cached, the copy is taken before Next may rewrite it
+	body, err := req.Body.Bytes()
+	if err != nil {
+		e.inflight.Add(-1)
+		return nil, fmt.Errorf("read body error: %w", err)
+	}
+	shadowCtx, cancel := context.WithTimeout(context.WithoutCancel(req.Context()), e.Policy.Timeout)
+	shadowReq := req.WithContext(shadowCtx)
+	shadowReq.Header = req.Header.Clone()
+	shadowReq.Query = cloneValues(req.Query)
+	shadowReq.Body = octollm.NewBodyFromBytes(bytes.Clone(body), octollm.NewRequestParser(req.Format))
+
+	m := &mirroring{format: req.Format, primary: make(chan primaryOutcome, 1)}
+	go func() {
+		defer e.inflight.Add(-1)
+		defer cancel()
+		report := e.Report
+		if report == nil {
+			report = reportMirrorResult
+		}
+		report(m.runShadow(e.Name, e.Shadow, shadowReq))
+	}()
+
+	start := time.Now()
+	resp, err := e.Next.Process(req)
+	outcome := primaryOutcome{latency: time.Since(start)}
+	if err != nil {
+		outcome.status = primaryErrorStatus(err)
+		m.setPrimary(outcome)
+		return resp, err
+	}
+	outcome.status = resp.StatusCode
+	if resp.Stream != nil {
+		return m.teeStream(req.Context(), resp, outcome), nil
+	}
+	if resp.Body != nil {
+		// a non-stream body is read whole anyway, Bytes caches it for the client
+		if b, err := resp.Body.Bytes(); err == nil {
+			outcome.text = outputText(req.Format, b, false)
+			outcome.complete = true
+		}
+	}
+	m.setPrimary(outcome)
+	return resp, nil
+}
+
+// mirroring is a mirrored request, the primary side hands its outcome over to the shadow side.
+type mirroring struct {
+	format      octollm.APIFormat
+	primary     chan primaryOutcome
+	primaryOnce sync.Once
+}
+
+type primaryOutcome struct {
+	status   int
+	latency  time.Duration // until the response, or the error
+	text     string
+	complete bool // text is the whole output
+}
+
+func (m *mirroring) setPrimary(outcome primaryOutcome) {
+	m.primaryOnce.Do(func() { m.primary <- outcome })
+}
+
+// teeStream forwards the stream of the primary to the client, collecting its output text on the way.
+func (m *mirroring) teeStream(ctx context.Context, resp *octollm.Response, outcome primaryOutcome) *octollm.Response {
+	src := resp.Stream
+	outCh := make(chan *octollm.StreamChunk)
+	done := make(chan struct{})
+	var closeOnce sync.Once
+
+	go func() {
+		defer close(outCh)
+		var sb strings.Builder
+		defer func() {
+			outcome.text = sb.String()
+			m.setPrimary(outcome)
+		}()
+		for {
+			chunk, ok := <-src.Chan()
+			if !ok {
+				// the source also ends when the client closes the stream or goes away, the text is cut then
+				select {
+				case <-done:
+				default:
+					outcome.complete = ctx.Err() == nil
+				}
+				return
+			}
+			if chunk.Body != nil {
+				if b, err := chunk.Body.Bytes(); err == nil {
+					sb.WriteString(outputText(m.format, b, true))
+				}
+			}
+			select {
+			case outCh <- chunk:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	newResp := *resp
+	newResp.Stream = octollm.NewStreamChan(outCh, func() {
+		closeOnce.Do(func() { close(done) })
+		src.Close()
+	})
+	return &newResp
+}
+
+// runShadow processes the copy of the request, reads its output whole and compares it with the one of the primary.
+func (m *mirroring) runShadow(name string, shadow octollm.Engine, req *octollm.Request) *MirrorResult {
+	r := &MirrorResult{Shadow: name}
+	start := time.Now()
+	text, err := m.shadowOutput(r, shadow, req)
+	r.Latency = time.Since(start)
+	if err == nil {
+		// the output is complete unless the timeout cut it
+		err = req.Context().Err()
+	}
+	r.Err = err
+
+	select {
+	case primary := <-m.primary:
+		r.PrimaryDone = true
+		r.PrimaryStatus = primary.status
+		r.PrimaryLatency = primary.latency
+		if err == nil && primary.complete {
+			r.Compared = true
+			r.Same = primary.text == text
+			r.PrimaryTextLen = utf8.RuneCountInString(primary.text)
+			r.ShadowTextLen = utf8.RuneCountInString(text)
+		}
+	case <-req.Context().Done():
+		// the primary is still going, e.g. a long stream
+	}
+	return r
+}
+
+// shadowOutput processes req and returns its output text, the status goes to r.
+func (m *mirroring) shadowOutput(r *MirrorResult, shadow octollm.Engine, req *octollm.Request) (string, error) {
+	resp, err := shadow.Process(req)
+	if err != nil {
+		httpErr := &errutils.UpstreamRespError{}
+		if errors.As(err, &httpErr) {
+			r.Status = httpErr.StatusCode
+		}
+		return "", err
+	}
+	r.Status = resp.StatusCode
+
+	switch {
+	case resp.Stream != nil:
+		defer resp.Stream.Close()
+		var sb strings.Builder
+		for chunk := range resp.Stream.Chan() {
+			if chunk.Body == nil {
+				continue
+			}
+			if b, err := chunk.Body.Bytes(); err == nil {
+				sb.WriteString(outputText(m.format, b, true))
+			}
+		}
+		return sb.String(), nil
+	case resp.Body != nil:
+		defer resp.Body.Close()
+		b, err := resp.Body.Bytes()
+		if err != nil {
+			return "", fmt.Errorf("read body error: %w", err)
+		}
+		return outputText(m.format, b, false), nil
+	default:
+		return "", nil
+	}
+}
+
+func primaryErrorStatus(err error) int {
+	httpErr := &errutils.UpstreamRespError{}
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode
+	}
+	return errutils.AsHandlerError(err).StatusCode
+}
+
+func cloneValues(values map[string][]string) map[string][]string {
+	if values == nil {
+		return nil
+	}
+	cloned := make(map[string][]string, len(values))
+	for k, v := range values {
+		cloned[k] = append([]string(nil), v...)
+	}
+	return cloned
+}
+
+// outputText returns the generated text of a response body or stream chunk, the body itself for formats without
+// generated text, e.g. embeddings.
+func outputText(format octollm.APIFormat, b []byte, chunk bool) string {
+	var paths []string
+	switch format {
+	case octollm.APIFormatChatCompletions:
+		paths = []string{"choices.0.message.content"}
+		if chunk {
+			paths = []string{"choices.0.delta.content"}
+		}
+	case octollm.APIFormatLegacyCompletions:
+		paths = []string{"choices.0.text"}
+	case octollm.APIFormatClaudeMessages:
+		paths = []string{"content.#.text"}
+		if chunk {
+			paths = []string{"delta.text"}
+		}
+	case octollm.APIFormatResponses:
+		paths = []string{"output.#.content.#.text"}
+		if chunk {
+			if gjson.GetBytes(b, "type").String() != "response.output_text.delta" {
+				return ""
+			}
+			paths = []string{"delta"}
+		}
+	case octollm.APIFormatVertexGenerateContent:
+		paths = []string{"candidates.0.content.parts.#.text"}
+	default:
+		return string(b)
+	}
+
+	var sb strings.Builder
+	var collect func(v gjson.Result)
+	collect = func(v gjson.Result) {
+		if v.IsArray() {
+			for _, item := range v.Array() {
+				collect(item)
+			}
+			return
+		}
+		if v.Type == gjson.String {
+			sb.WriteString(v.Str)
+		}
+	}
+	for _, path := range paths {
+		collect(gjson.GetBytes(b, path))
+	}
+	return sb.String()
+}
+
+var (
+	mirrorRequestsTotal = metrics.Default.Counter("octollm_mirror_requests_total",
+		"Shadow requests by shadow and status, the status is 0 for requests failed without a response.")
+	mirrorLatencySeconds = metrics.Default.Counter("octollm_mirror_latency_seconds_total",
+		"Total latency of the shadow requests and of their primaries, by shadow and side.")
+	mirrorOutputsTotal = metrics.Default.Counter("octollm_mirror_outputs_total",
+		"Compared outputs by shadow and result (same or different).")
+	mirrorOutputRunes = metrics.Default.Counter("octollm_mirror_output_runes_total",
+		"Total output text length of the compared requests, by shadow and side.")
+)
+
+func reportMirrorResult(r *MirrorResult) {
+	fields := logrus.Fields{
+		"shadow":  r.Shadow,
+		"status":  r.Status,
+		"latency": r.Latency,
+	}
+	if r.PrimaryDone {
+		fields["primary_status"] = r.PrimaryStatus
+		fields["primary_latency"] = r.PrimaryLatency
+	}
+	logger := logrus.WithFields(fields)
+	if r.Err != nil {
+		logger.Infof("[mirror] shadow request failed: %v", r.Err)
+	} else if r.Compared {
+		logger.WithFields(logrus.Fields{
+			"same":             r.Same,
+			"primary_text_len": r.PrimaryTextLen,
+			"shadow_text_len":  r.ShadowTextLen,
+		}).Info("[mirror] shadow request done")
+	} else {
+		logger.Info("[mirror] shadow request done, output not compared")
+	}
+
+	mirrorRequestsTotal.Inc(map[string]string{"shadow": r.Shadow, "status": strconv.Itoa(r.Status)})
+	mirrorLatencySeconds.Add(map[string]string{"shadow": r.Shadow, "side": "shadow"}, r.Latency.Seconds())
+	if r.PrimaryDone {
+		mirrorLatencySeconds.Add(map[string]string{"shadow": r.Shadow, "side": "primary"}, r.PrimaryLatency.Seconds())
+	}
+	if r.Compared {
+		result := "different"
+		if r.Same {
+			result = "same"
+		}
+		mirrorOutputsTotal.Inc(map[string]string{"shadow": r.Shadow, "result": result})
+		mirrorOutputRunes.Add(map[string]string{"shadow": r.Shadow, "side": "primary"}, float64(r.PrimaryTextLen))
+		mirrorOutputRunes.Add(map[string]string{"shadow": r.Shadow, "side": "shadow"}, float64(r.ShadowTextLen))
+	}
+}
@@ -0,0 +1,152 @@
+package engines
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/infinigence/octollm/pkg/octollm"
+)
+
+func TestMirrorEngine(t *testing.T) {
+	// the primary rewrites the body, the shadow still gets the original one
+	primary := octollm.EngineFunc(func(req *octollm.Request) (*octollm.Response, error) {
+		req.Body.SetBytes([]byte(`{"model":"rewritten"}`))
+		return chatAnswer("hello").Process(req)
+	})
+	shadowBodies := make(chan string, 1)
+	shadow := octollm.EngineFunc(func(req *octollm.Request) (*octollm.Response, error) {
+		b, err := req.Body.Bytes()
+		require.NoError(t, err)
+		shadowBodies <- string(b)
+		return chatAnswer("hello!").Process(req)
+	})
+	results := make(chan *MirrorResult, 1)
+	e := NewMirrorEngine(primary, shadow, "new", MirrorPolicy{Percent: 100})
+	e.Report = func(r *MirrorResult) { results <- r }
+
+	resp, err := e.Process(newTestRequest(t, context.Background(), `{"model":"m"}`))
+	require.NoError(t, err)
+	b, err := resp.Body.Bytes()
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `"hello"`)
+
+	assert.Equal(t, `{"model":"m"}`, <-shadowBodies)
+	r := <-results
+	assert.NoError(t, r.Err)
+	assert.Equal(t, "new", r.Shadow)
+	assert.Equal(t, http.StatusOK, r.Status)
+	assert.Equal(t, http.StatusOK, r.PrimaryStatus)
+	assert.True(t, r.Compared)
+	assert.False(t, r.Same)
+	assert.Equal(t, 5, r.PrimaryTextLen)
+	assert.Equal(t, 6, r.ShadowTextLen)
+}
+
+func TestMirrorEngine_Stream(t *testing.T) {
+	results := make(chan *MirrorResult, 1)
+	e := NewMirrorEngine(chatStream("a", "b"), chatStream("a", "b"), "new", MirrorPolicy{Percent: 100})
+	e.Report = func(r *MirrorResult) { results <- r }
+
+	// the client goes away once the stream is read, the shadow request is not cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	resp, err := e.Process(newTestRequest(t, ctx, `{"model":"m","stream":true}`))
+	require.NoError(t, err)
+	var got []string
+	for chunk := range resp.Stream.Chan() {
+		b, err := chunk.Body.Bytes()
+		require.NoError(t, err)
+		got = append(got, string(b))
+	}
+	resp.Stream.Close()
+	cancel()
+	assert.Len(t, got, 2)
+
+	select {
+	case r := <-results:
+		assert.NoError(t, r.Err)
+		assert.True(t, r.Compared)
+		assert.True(t, r.Same)
+		assert.Equal(t, 2, r.ShadowTextLen)
+	case <-time.After(time.Second):
+		t.Fatal("no mirror result")
+	}
+}
+
+func TestMirrorEngine_StreamClosedByClient(t *testing.T) {
+	// like the http client, the source ends its channel when closed
+	primary := octollm.EngineFunc(func(req *octollm.Request) (*octollm.Response, error) {
+		ch := make(chan *octollm.StreamChunk, 1)
+		ch <- &octollm.StreamChunk{Body: octollm.NewBodyFromBytes([]byte(`{"choices":[{"delta":{"content":"a"}}]}`), nil)}
+		var closeOnce sync.Once
+		return octollm.NewStreamResponse(http.StatusOK, nil, octollm.NewStreamChan(ch, func() { closeOnce.Do(func() { close(ch) }) })), nil
+	})
+	results := make(chan *MirrorResult, 1)
+	e := NewMirrorEngine(primary, chatAnswer("ab"), "new", MirrorPolicy{Percent: 100})
+	e.Report = func(r *MirrorResult) { results <- r }
+
+	// the client closes the stream after the first chunk
+	resp, err := e.Process(newTestRequest(t, context.Background(), `{"model":"m","stream":true}`))
+	require.NoError(t, err)
+	<-resp.Stream.Chan()
+	resp.Stream.Close()
+
+	select {
+	case r := <-results:
+		assert.NoError(t, r.Err)
+		assert.False(t, r.Compared, "the cut output of the primary is not compared")
+	case <-time.After(time.Second):
+		t.Fatal("no mirror result")
+	}
+}
+
+func TestMirrorEngine_Sampling(t *testing.T) {
+	// a shadow request would be in flight until released, and only then reported
+	release := make(chan struct{})
+	defer close(release)
+	shadow := octollm.EngineFunc(func(req *octollm.Request) (*octollm.Response, error) {
+		<-release
+		return chatAnswer("x").Process(req)
+	})
+	var reports atomic.Int32
+	e := NewMirrorEngine(chatAnswer("x"), shadow, "new", MirrorPolicy{Percent: 0})
+	e.Report = func(*MirrorResult) { reports.Add(1) }
+	for range 10 {
+		_, err := e.Process(newTestRequest(t, context.Background(), `{"model":"m"}`))
+		require.NoError(t, err)
+	}
+	assert.Zero(t, e.inflight.Load())
+	assert.Zero(t, reports.Load())
+}
+
+func TestMirrorEngine_PrimaryOutlastsShadow(t *testing.T) {
+	results := make(chan *MirrorResult, 1)
+	e := NewMirrorEngine(chatStream("a", "b"), chatAnswer("ab"), "new", MirrorPolicy{Percent: 100, Timeout: 20 * time.Millisecond})
+	e.Report = func(r *MirrorResult) { results <- r }
+
+	// the client does not read the stream before the shadow times out
+	resp, err := e.Process(newTestRequest(t, context.Background(), `{"model":"m","stream":true}`))
+	require.NoError(t, err)
+	defer resp.Stream.Close()
+	var r *MirrorResult
+	select {
+	case r = <-results:
+	case <-time.After(time.Second):
+		t.Fatal("no mirror result")
+	}
+	assert.False(t, r.PrimaryDone)
+	assert.False(t, r.Compared)
+
+	// an unknown primary latency is not counted as 0
+	r.Shadow = "outlasted"
+	r.PrimaryLatency = time.Second
+	reportMirrorResult(r)
+	assert.Zero(t, mirrorLatencySeconds.Value(map[string]string{"shadow": "outlasted", "side": "primary"}))
+	assert.Positive(t, mirrorLatencySeconds.Value(map[string]string{"shadow": "outlasted", "side": "shadow"}))
+}
@@ -2,20 +2,90 @@ package engines
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"maps"
+	"slices"
 
 	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
 	"github.com/infinigence/octollm/pkg/octollm"
 	"github.com/sirupsen/logrus"
 	"github.com/tidwall/sjson"
 )
 
+// RewritePolicy rewrites a JSON body. RemoveKeys, SetKeys and SetKeysByExpr are applied first, in this order and
+// by sorted keys, then Ops in order.
 type RewritePolicy struct {
 	SetKeys       map[string]any    `json:"set_keys" yaml:"set_keys"`
 	SetKeysByExpr map[string]string `json:"set_keys_by_expr" yaml:"set_keys_by_expr"`
 	RemoveKeys    []string          `json:"remove_keys" yaml:"remove_keys"`
+	Ops           []*RewriteOp      `json:"ops" yaml:"ops"`
+
+	programs map[string]*vm.Program // compiled SetKeysByExpr by key, set by Compile
+	ops      []*compiledOp          // compiled Ops by index, set by Compile
 }
 
+// Compile compiles the expressions of SetKeysByExpr and Ops, type-checked against octollm.ExprEnv, and validates
+// Ops, so that errors show up when the config is loaded and requests do not pay for the compilation.
+// It must be called before the policy is used by requests.
+func (p *RewritePolicy) Compile() error {
+	if p == nil {
+		return nil
+	}
+	programs := make(map[string]*vm.Program, len(p.SetKeysByExpr))
+	for _, k := range slices.Sorted(maps.Keys(p.SetKeysByExpr)) {
+		prog, err := compileRewriteExpr(p.SetKeysByExpr[k])
+		if err != nil {
+			return fmt.Errorf("set_keys_by_expr %s: %w", k, err)
+		}
+		programs[k] = prog
+	}
+	ops := make([]*compiledOp, len(p.Ops))
+	for i, op := range p.Ops {
+		c, err := compileOp(op)
+		if err != nil {
+			return fmt.Errorf("ops[%d]: %w", i, err)
+		}
+		ops[i] = c
+	}
+	p.programs = programs
+	p.ops = ops
+	return nil
+}
+
+// program returns the compiled expression of SetKeysByExpr[k], compiling it if the policy was not compiled.
+func (p *RewritePolicy) program(k string) (*vm.Program, error) {
+	if prog, ok := p.programs[k]; ok {
+		return prog, nil
+	}
+	return compileRewriteExpr(p.SetKeysByExpr[k])
+}
+
+// compiledOp returns the compiled Ops[i], compiling it if the policy was not compiled.
+func (p *RewritePolicy) compiledOp(i int) (*compiledOp, error) {
+	if len(p.ops) == len(p.Ops) {
+		return p.ops[i], nil
+	}
+	return compileOp(p.Ops[i])
+}
+
+func (p *RewritePolicy) hasExprs() bool {
+	if p == nil {
+		return false
+	}
+	if len(p.SetKeysByExpr) > 0 {
+		return true
+	}
+	return slices.ContainsFunc(p.Ops, func(op *RewriteOp) bool { return op.When != "" || op.Expr != "" })
+}
+
+func compileRewriteExpr(code string) (*vm.Program, error) {
+	return expr.Compile(code, expr.Env(&octollm.ExprEnv{}))
+}
+
+// Merge returns p overridden by other: other wins on the keys of SetKeys and SetKeysByExpr, RemoveKeys are joined,
+// and the Ops of other run after the ones of p.
 func (p *RewritePolicy) Merge(other *RewritePolicy) *RewritePolicy {
 	if other == nil {
 		return p
@@ -45,18 +115,48 @@ func (p *RewritePolicy) Merge(other *RewritePolicy) *RewritePolicy {
 	}
 
 	merged.RemoveKeys = append(merged.RemoveKeys, other.RemoveKeys...)
+	merged.Ops = slices.Concat(p.Ops, other.Ops)
 
 	return merged
 }
 
+// newRewriteEnv builds the environment of SetKeysByExpr, the same as the one of rules.
+func newRewriteEnv(req *octollm.Request) *octollm.ExprEnv {
+	env, err := octollm.NewExprEnv(req)
+	if err != nil {
+		logrus.WithContext(req.Context()).Debugf("[RewriteEngine] build expr env: %v", err)
+	}
+	return env
+}
+
+// withResponse returns a copy of env with a response body as RawResp, or a stream chunk as RawChunk.
+// The body is only parsed if the policy has expressions to see it.
+func withResponse(ctx context.Context, env *octollm.ExprEnv, policy *RewritePolicy, b []byte, chunk bool) *octollm.ExprEnv {
+	if !policy.hasExprs() {
+		return env
+	}
+	raw := make(map[string]any)
+	if err := json.Unmarshal(b, &raw); err != nil {
+		logrus.WithContext(ctx).Debugf("[RewriteEngine] unmarshal response body: %v", err)
+		raw = nil
+	}
+	e := *env
+	if chunk {
+		e.RawChunk = raw
+	} else {
+		e.RawResp = raw
+	}
+	return &e
+}
+
 type llmJSONRewriter struct {
 	policy  *RewritePolicy
 	ctx     context.Context
-	exprEnv any
+	exprEnv *octollm.ExprEnv
 }
 
 // RewriteJSON 重写JSON字符串
-// 先执行 RemoveKeys，后执行 SetKeys
+// 先执行 RemoveKeys，后执行 SetKeys、SetKeysByExpr，最后按顺序执行 Ops
 func (r *llmJSONRewriter) RewriteJSON(reqBody []byte) []byte {
 	if r.policy == nil {
 		return reqBody
@@ -70,15 +170,16 @@ func (r *llmJSONRewriter) RewriteJSON(reqBody []byte) []byte {
 		}
 	}
 
-	for k, v := range r.policy.SetKeys {
-		reqBody, err = sjson.SetBytes(reqBody, k, v)
+	for _, k := range slices.Sorted(maps.Keys(r.policy.SetKeys)) {
+		reqBody, err = sjson.SetBytes(reqBody, k, r.policy.SetKeys[k])
 		if err != nil {
 			logrus.WithContext(r.ctx).Warnf("[llmJSONRewriter.RewriteJSON] set key (%s) error: %s", k, err)
 		}
 	}
 
-	for k, code := range r.policy.SetKeysByExpr {
-		prog, err := expr.Compile(code, expr.Env(r.exprEnv))
+	for _, k := range slices.Sorted(maps.Keys(r.policy.SetKeysByExpr)) {
+		code := r.policy.SetKeysByExpr[k]
+		prog, err := r.policy.program(k)
 		if err != nil {
 			logrus.WithContext(r.ctx).Warnf("[llmJSONRewriter.RewriteJSON] compile expr (%s) error: %s", code, err)
 			continue
@@ -101,6 +202,21 @@ func (r *llmJSONRewriter) RewriteJSON(reqBody []byte) []byte {
 		}
 	}
 
+	for i, op := range r.policy.Ops {
+		c, err := r.policy.compiledOp(i)
+		if err != nil {
+			logrus.WithContext(r.ctx).Warnf("[llmJSONRewriter.RewriteJSON] compile op %d (%s %s) error: %s", i, op.Op, op.Key, err)
+			continue
+		}
+		b, err := op.apply(reqBody, c, r.exprEnv)
+		if err != nil {
+			// a failed op leaves the body as it was
+			logrus.WithContext(r.ctx).Warnf("[llmJSONRewriter.RewriteJSON] apply op %d (%s %s) error: %s", i, op.Op, op.Key, err)
+			continue
+		}
+		reqBody = b
+	}
+
 	return reqBody
 }
 
@@ -126,9 +242,9 @@ func NewRewriteEngine(next octollm.Engine, requestRewrite, nonstreamResponseRewr
 func (e *RewriteEngine) Process(req *octollm.Request) (*octollm.Response, error) {
 	if e.RequestRewrite != nil {
 		reqRewriter := &llmJSONRewriter{
-			policy: e.RequestRewrite,
-			ctx:    req.Context(),
-			// exprEnv: req.Meta,
+			policy:  e.RequestRewrite,
+			ctx:     req.Context(),
+			exprEnv: newRewriteEnv(req),
 		}
 		b, err := req.Body.Bytes()
 		if err != nil {
@@ -140,6 +256,11 @@ func (e *RewriteEngine) Process(req *octollm.Request) (*octollm.Response, error)
 	if e.Next == nil {
 		return nil, fmt.Errorf("next engine is nil")
 	}
+	// the env of the response rewrites is built before Next may consume the request body
+	var respEnv *octollm.ExprEnv
+	if e.NonstreamResponseRewrite.hasExprs() || e.StreamChunkRewrite.hasExprs() {
+		respEnv = newRewriteEnv(req)
+	}
 	resp, err := e.Next.Process(req)
 	if err != nil {
 		return nil, fmt.Errorf("underlying engine run error: %w", err)
@@ -153,7 +274,6 @@ func (e *RewriteEngine) Process(req *octollm.Request) (*octollm.Response, error)
 		chunkRewriter := &llmJSONRewriter{
 			policy: e.StreamChunkRewrite,
 			ctx:    req.Context(),
-			// exprEnv: req.Meta,
 		}
 		rewritenChunk := make(chan *octollm.StreamChunk)
 		originalStream := resp.Stream
@@ -166,6 +286,7 @@ func (e *RewriteEngine) Process(req *octollm.Request) (*octollm.Response, error)
 					logrus.WithContext(ctx).Warnf("read stream chunk error: %s", err)
 					continue
 				}
+				chunkRewriter.exprEnv = withResponse(ctx, respEnv, e.StreamChunkRewrite, b, true)
 				chunk.Body.SetBytes(chunkRewriter.RewriteJSON(b))
 				select {
 				case rewritenChunk <- chunk:
@@ -184,15 +305,15 @@ func (e *RewriteEngine) Process(req *octollm.Request) (*octollm.Response, error)
 		if e.NonstreamResponseRewrite == nil {
 			return resp, nil
 		}
-		respRewriter := &llmJSONRewriter{
-			policy: e.NonstreamResponseRewrite,
-			ctx:    req.Context(),
-			// exprEnv: req.Meta,
-		}
 		b, err := resp.Body.Bytes()
 		if err != nil {
 			return nil, fmt.Errorf("read response body error: %w", err)
 		}
+		respRewriter := &llmJSONRewriter{
+			policy:  e.NonstreamResponseRewrite,
+			ctx:     req.Context(),
+			exprEnv: withResponse(req.Context(), respEnv, e.NonstreamResponseRewrite, b, false),
+		}
 		resp.Body.SetBytes(respRewriter.RewriteJSON(b))
 		logrus.WithContext(req.Context()).Debugf("[RewriteEngine.Run] non-stream response body rewritten")
 	}
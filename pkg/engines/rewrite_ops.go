@@ -0,0 +1,234 @@
+package engines
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+
+	"github.com/infinigence/octollm/pkg/octollm"
+)
+
+const (
+	RewriteOpSet          = "set"
+	RewriteOpSetIfAbsent  = "set_if_absent"
+	RewriteOpRemove       = "remove"
+	RewriteOpRename       = "rename"        // moves the value of Key to To
+	RewriteOpAppend       = "append"        // appends to the array of Key, e.g. a message to "messages"
+	RewriteOpPrependText  = "prepend_text"  // prepends to a string, or to the first text part of an array of parts
+	RewriteOpClamp        = "clamp"         // bounds a number to [Min, Max]
+	RewriteOpRegexReplace = "regex_replace" // replaces the matches of Pattern in a string
+)
+
+// RewriteOp is an operation of the ordered Ops of a RewritePolicy.
+//
+// Keys are paths in the sjson syntax, e.g. "messages.0.content". For remove, prepend_text, clamp and
+// regex_replace, a "#" segment stands for all elements of an array, e.g. "messages.#.content".
+// Expressions see the body as it was before the policy was applied.
+type RewriteOp struct {
+	Op    string `json:"op" yaml:"op"` // see RewriteOp*
+	Key   string `json:"key" yaml:"key"`
+	When  string `json:"when" yaml:"when"`   // expression, the op is skipped unless it is true
+	To    string `json:"to" yaml:"to"`       // rename
+	Value any    `json:"value" yaml:"value"` // set, set_if_absent, append, and the text of prepend_text
+	// Expr gives the value of set, set_if_absent and append instead of Value, a nil result skips the op.
+	Expr        string   `json:"expr" yaml:"expr"`
+	Min         *float64 `json:"min" yaml:"min"` // clamp
+	Max         *float64 `json:"max" yaml:"max"`
+	Pattern     string   `json:"pattern" yaml:"pattern"`         // regex_replace, in the RE2 syntax
+	Replacement string   `json:"replacement" yaml:"replacement"` // regex_replace, may refer to submatches as $1
+}
+
+// compiledOp holds the expressions and pattern of a RewriteOp.
+type compiledOp struct {
+	when  *vm.Program
+	value *vm.Program
+	re    *regexp.Regexp
+}
+
+func compileOp(op *RewriteOp) (*compiledOp, error) {
+	if op.Key == "" {
+		return nil, fmt.Errorf("op %s needs a key", op.Op)
+	}
+	c := &compiledOp{}
+	switch op.Op {
+	case RewriteOpSet, RewriteOpSetIfAbsent, RewriteOpAppend:
+		if op.Expr != "" {
+			prog, err := compileRewriteExpr(op.Expr)
+			if err != nil {
+				return nil, fmt.Errorf("expr: %w", err)
+			}
+			c.value = prog
+		}
+	case RewriteOpRemove:
+	case RewriteOpRename:
+		if op.To == "" {
+			return nil, fmt.Errorf("op %s needs to", op.Op)
+		}
+	case RewriteOpPrependText:
+		if _, ok := op.Value.(string); !ok {
+			return nil, fmt.Errorf("op %s needs a string value", op.Op)
+		}
+	case RewriteOpClamp:
+		if op.Min == nil && op.Max == nil {
+			return nil, fmt.Errorf("op %s needs min or max", op.Op)
+		}
+		if op.Min != nil && op.Max != nil && *op.Min > *op.Max {
+			return nil, fmt.Errorf("op %s: min %v is greater than max %v", op.Op, *op.Min, *op.Max)
+		}
+	case RewriteOpRegexReplace:
+		re, err := regexp.Compile(op.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("pattern: %w", err)
+		}
+		c.re = re
+	default:
+		return nil, fmt.Errorf("unsupported op %q", op.Op)
+	}
+	if op.When != "" {
+		prog, err := expr.Compile(op.When, expr.Env(&octollm.ExprEnv{}), expr.AsBool())
+		if err != nil {
+			return nil, fmt.Errorf("when: %w", err)
+		}
+		c.when = prog
+	}
+	return c, nil
+}
+
+// apply applies the op to body, env is the environment of its expressions.
+func (op *RewriteOp) apply(body []byte, c *compiledOp, env *octollm.ExprEnv) ([]byte, error) {
+	if c.when != nil {
+		ok, err := expr.Run(c.when, env)
+		if err != nil {
+			return body, fmt.Errorf("run when: %w", err)
+		}
+		if ok != true {
+			return body, nil
+		}
+	}
+	value := op.Value
+	if c.value != nil {
+		v, err := expr.Run(c.value, env)
+		if err != nil {
+			return body, fmt.Errorf("run expr: %w", err)
+		}
+		if v == nil {
+			return body, nil
+		}
+		value = v
+	}
+
+	switch op.Op {
+	case RewriteOpSet:
+		return sjson.SetBytes(body, op.Key, value)
+	case RewriteOpSetIfAbsent:
+		if gjson.GetBytes(body, op.Key).Exists() {
+			return body, nil
+		}
+		return sjson.SetBytes(body, op.Key, value)
+	case RewriteOpAppend:
+		return sjson.SetBytes(body, op.Key+".-1", value)
+	case RewriteOpRename:
+		v := gjson.GetBytes(body, op.Key)
+		if !v.Exists() {
+			return body, nil
+		}
+		body, err := sjson.SetRawBytes(body, op.To, []byte(v.Raw))
+		if err != nil {
+			return body, err
+		}
+		return sjson.DeleteBytes(body, op.Key)
+	}
+
+	// the ops on existing values apply to all the paths of a key with "#"
+	paths := expandPath(body, op.Key)
+	if op.Op == RewriteOpRemove {
+		// removing array elements shifts the later ones
+		slices.Reverse(paths)
+	}
+	var err error
+	for _, path := range paths {
+		switch op.Op {
+		case RewriteOpRemove:
+			body, err = sjson.DeleteBytes(body, path)
+		case RewriteOpPrependText:
+			body, err = prependText(body, path, value.(string))
+		case RewriteOpClamp:
+			body, err = clamp(body, path, op.Min, op.Max)
+		case RewriteOpRegexReplace:
+			v := gjson.GetBytes(body, path)
+			if v.Type == gjson.String {
+				body, err = sjson.SetBytes(body, path, c.re.ReplaceAllString(v.Str, op.Replacement))
+			}
+		}
+		if err != nil {
+			return body, err
+		}
+	}
+	return body, nil
+}
+
+// expandPath returns the paths of key in body, with each "#" segment replaced by the indexes of its array.
+func expandPath(body []byte, key string) []string {
+	segments := strings.Split(key, ".")
+	i := slices.Index(segments, "#")
+	if i < 0 {
+		return []string{key}
+	}
+	prefix := strings.Join(segments[:i], ".")
+	rest := ""
+	if i+1 < len(segments) {
+		rest = "." + strings.Join(segments[i+1:], ".")
+	}
+	n := int(gjson.GetBytes(body, prefix+".#").Int())
+	var paths []string
+	for j := range n {
+		paths = append(paths, expandPath(body, prefix+"."+strconv.Itoa(j)+rest)...)
+	}
+	return paths
+}
+
+// prependText prepends text to the string at path, or to the first text part if it is an array of content parts.
+func prependText(body []byte, path, text string) ([]byte, error) {
+	v := gjson.GetBytes(body, path)
+	switch {
+	case v.Type == gjson.String:
+		return sjson.SetBytes(body, path, text+v.Str)
+	case v.IsArray():
+		for i, part := range v.Array() {
+			if t := part.Get("text"); t.Type == gjson.String {
+				return sjson.SetBytes(body, path+"."+strconv.Itoa(i)+".text", text+t.Str)
+			}
+		}
+	}
+	return body, nil
+}
+
+func clamp(body []byte, path string, minValue, maxValue *float64) ([]byte, error) {
+	v := gjson.GetBytes(body, path)
+	if v.Type != gjson.Number {
+		return body, nil
+	}
+	n := v.Float()
+	clamped := n
+	if minValue != nil {
+		clamped = math.Max(clamped, *minValue)
+	}
+	if maxValue != nil {
+		clamped = math.Min(clamped, *maxValue)
+	}
+	if clamped == n {
+		return body, nil
+	}
+	if clamped == math.Trunc(clamped) && math.Abs(clamped) < 1<<53 {
+		return sjson.SetBytes(body, path, int64(clamped))
+	}
+	return sjson.SetBytes(body, path, clamped)
+}
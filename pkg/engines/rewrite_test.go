@@ -0,0 +1,198 @@
+package engines
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/infinigence/octollm/pkg/octollm"
+)
+
+func TestRewriteEngine_ExprEnv(t *testing.T) {
+	var sent string
+	upstream := octollm.EngineFunc(func(req *octollm.Request) (*octollm.Response, error) {
+		// like the http client, read the body through its reader
+		r, err := req.Body.Reader()
+		require.NoError(t, err)
+		b, err := io.ReadAll(r)
+		require.NoError(t, err)
+		sent = string(b)
+		return chatAnswer("hi").Process(req)
+	})
+	policy := func(exprs map[string]string) *RewritePolicy {
+		p := &RewritePolicy{SetKeysByExpr: exprs}
+		require.NoError(t, p.Compile())
+		return p
+	}
+	e := NewRewriteEngine(upstream,
+		policy(map[string]string{
+			"max_tokens": `RawReq.max_tokens > 1000 ? 1000 : nil`,
+			"user":       `User`,
+		}),
+		policy(map[string]string{
+			"metadata":    `RawReq.metadata`,
+			"content_len": `len(RawResp.choices[0].message.content)`,
+		}),
+		nil)
+
+	req := newTestRequest(t, context.Background(), `{"model":"m","max_tokens":4000,"metadata":{"trace":"t1"}}`)
+	req = req.WithContext(octollm.WithIdentity(req.Context(), octollm.Identity{User: "alice"}))
+	resp, err := e.Process(req)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"model":"m","max_tokens":1000,"metadata":{"trace":"t1"},"user":"alice"}`, sent)
+	b, err := resp.Body.Bytes()
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"choices":[{"message":{"role":"assistant","content":"hi"}}],"metadata":{"trace":"t1"},"content_len":2}`, string(b))
+}
+
+func TestRewriteEngine_StreamChunkEnv(t *testing.T) {
+	p := &RewritePolicy{SetKeysByExpr: map[string]string{
+		"model": `RawReq.model`,
+		"upper": `upper(RawChunk.choices[0].delta.content)`,
+	}}
+	require.NoError(t, p.Compile())
+	e := NewRewriteEngine(chatStream("a", "b"), nil, nil, p)
+
+	resp, err := e.Process(newTestRequest(t, context.Background(), `{"model":"m","stream":true}`))
+	require.NoError(t, err)
+	var got []string
+	for chunk := range resp.Stream.Chan() {
+		b, err := chunk.Body.Bytes()
+		require.NoError(t, err)
+		got = append(got, string(b))
+	}
+	resp.Stream.Close()
+	require.Len(t, got, 2)
+	assert.JSONEq(t, `{"choices":[{"delta":{"content":"a"}}],"model":"m","upper":"A"}`, got[0])
+	assert.JSONEq(t, `{"choices":[{"delta":{"content":"b"}}],"model":"m","upper":"B"}`, got[1])
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestRewritePolicy_Ops(t *testing.T) {
+	ptr := func(f float64) *float64 { return &f }
+	tests := []struct {
+		name string
+		ops  []*RewriteOp
+		body string
+		want string
+	}{
+		{
+			name: "in order",
+			ops: []*RewriteOp{
+				{Op: RewriteOpSet, Key: "max_tokens", Value: 10},
+				{Op: RewriteOpRename, Key: "max_tokens", To: "max_completion_tokens"},
+			},
+			body: `{"max_tokens":100}`,
+			want: `{"max_completion_tokens":10}`,
+		},
+		{
+			name: "set if absent",
+			ops: []*RewriteOp{
+				{Op: RewriteOpSetIfAbsent, Key: "temperature", Value: 0.7},
+				{Op: RewriteOpSetIfAbsent, Key: "top_p", Value: 0.9},
+			},
+			body: `{"temperature":0}`,
+			want: `{"temperature":0,"top_p":0.9}`,
+		},
+		{
+			name: "rename absent key",
+			ops:  []*RewriteOp{{Op: RewriteOpRename, Key: "max_tokens", To: "max_completion_tokens"}},
+			body: `{"model":"m"}`,
+			want: `{"model":"m"}`,
+		},
+		{
+			name: "append",
+			ops: []*RewriteOp{
+				{Op: RewriteOpAppend, Key: "messages", Value: map[string]any{"role": "system", "content": "be brief"}},
+				{Op: RewriteOpAppend, Key: "stop", Value: "END"},
+			},
+			body: `{"messages":[{"role":"user","content":"hi"}]}`,
+			want: `{"messages":[{"role":"user","content":"hi"},{"role":"system","content":"be brief"}],"stop":["END"]}`,
+		},
+		{
+			name: "prepend text",
+			ops: []*RewriteOp{
+				{Op: RewriteOpPrependText, Key: "messages.0.content", Value: "[a] "},
+				{Op: RewriteOpPrependText, Key: "messages.1.content", Value: "[b] "},
+			},
+			body: `{"messages":[{"role":"user","content":"hi"},{"role":"user","content":[{"type":"image_url"},{"type":"text","text":"look"}]}]}`,
+			want: `{"messages":[{"role":"user","content":"[a] hi"},{"role":"user","content":[{"type":"image_url"},{"type":"text","text":"[b] look"}]}]}`,
+		},
+		{
+			name: "clamp",
+			ops: []*RewriteOp{
+				{Op: RewriteOpClamp, Key: "max_tokens", Min: ptr(1), Max: ptr(1000)},
+				{Op: RewriteOpClamp, Key: "temperature", Max: ptr(1.5)},
+				{Op: RewriteOpClamp, Key: "top_p", Min: ptr(0.1)},
+			},
+			body: `{"max_tokens":4000,"temperature":2,"top_p":0.5}`,
+			want: `{"max_tokens":1000,"temperature":1.5,"top_p":0.5}`,
+		},
+		{
+			name: "regex replace all messages",
+			ops: []*RewriteOp{
+				{Op: RewriteOpRegexReplace, Key: "messages.#.content", Pattern: `\b(\d{4})\d{8}(\d{4})\b`, Replacement: "$1****$2"},
+			},
+			body: `{"messages":[{"content":"card 1234567812345678"},{"content":[{"type":"text"}]},{"content":"none"}]}`,
+			want: `{"messages":[{"content":"card 1234****5678"},{"content":[{"type":"text"}]},{"content":"none"}]}`,
+		},
+		{
+			name: "remove from all elements",
+			ops:  []*RewriteOp{{Op: RewriteOpRemove, Key: "messages.#.name"}},
+			body: `{"messages":[{"role":"user","name":"a"},{"role":"user","name":"b"}]}`,
+			want: `{"messages":[{"role":"user"},{"role":"user"}]}`,
+		},
+		{
+			name: "when",
+			ops: []*RewriteOp{
+				{Op: RewriteOpSet, Key: "a", Value: 1, When: `RawReq.model == "m"`},
+				{Op: RewriteOpSet, Key: "b", Value: 1, When: `RawReq.model == "other"`},
+				{Op: RewriteOpSet, Key: "c", Expr: `RawReq.model + "-x"`},
+				{Op: RewriteOpSet, Key: "d", Expr: `nil`},
+			},
+			body: `{"model":"m"}`,
+			want: `{"model":"m","a":1,"c":"m-x"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &RewritePolicy{Ops: tt.ops}
+			require.NoError(t, p.Compile())
+			req := newTestRequest(t, context.Background(), tt.body)
+			r := &llmJSONRewriter{policy: p, ctx: req.Context(), exprEnv: newRewriteEnv(req)}
+			assert.JSONEq(t, tt.want, string(r.RewriteJSON([]byte(tt.body))))
+		})
+	}
+}
+
+func TestRewritePolicy_CompileOps(t *testing.T) {
+	for _, op := range []*RewriteOp{
+		{Op: "upsert", Key: "a"},
+		{Op: RewriteOpSet},
+		{Op: RewriteOpRename, Key: "a"},
+		{Op: RewriteOpPrependText, Key: "a", Value: 1},
+		{Op: RewriteOpClamp, Key: "a"},
+		{Op: RewriteOpRegexReplace, Key: "a", Pattern: "("},
+		{Op: RewriteOpSet, Key: "a", When: `User`},
+		{Op: RewriteOpSet, Key: "a", Expr: `Unknown`},
+	} {
+		p := &RewritePolicy{Ops: []*RewriteOp{op}}
+		assert.Error(t, p.Compile(), "%+v", op)
+	}
+}
+
+func TestRewritePolicy_MergeOps(t *testing.T) {
+	base := &RewritePolicy{Ops: []*RewriteOp{{Op: RewriteOpSet, Key: "a", Value: 1}}}
+	override := &RewritePolicy{Ops: []*RewriteOp{{Op: RewriteOpSet, Key: "a", Value: 2}}}
+	merged := base.Merge(override)
+	require.NoError(t, merged.Compile())
+	require.Len(t, merged.Ops, 2)
+
+	r := &llmJSONRewriter{policy: merged, ctx: context.Background()}
+	assert.JSONEq(t, `{"a":2}`, string(r.RewriteJSON([]byte(`{}`))))
+	assert.Len(t, base.Ops, 1)
+}
@@ -3,7 +3,13 @@ package ruleengine
 import (
 	"errors"
 	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/infinigence/octollm/pkg/errutils"
 	"github.com/infinigence/octollm/pkg/octollm"
 	"github.com/sirupsen/logrus"
 )
@@ -21,54 +27,213 @@ func (f MatchFunc) Match(req *octollm.Request) bool {
 type Rule struct {
 	Name    string // optional name for logging
 	Matcher Matcher
-	Engine  octollm.Engine
+	// Engine handles the matched request. An engine may instead tell the rule engine where to go on by
+	// returning an ErrWithAction, e.g. after only acting on the request.
+	Engine octollm.Engine
+	// Action and Chain are taken by a matched rule without Engine, Chain is the target of jump and call.
+	Action RuleEngineAction
+	Chain  string
+	// Tags are added to the matched request before anything else, so that later rules and engines see them.
+	Tags map[string]string
+	// Audit rules are only evaluated: a match is logged and counted, but neither the tags nor the engine or action
+	// are applied, and the chain goes on with the next rule.
+	Audit bool
+	// Effect describes what the rule does for audit logs, e.g. "deny".
+	Effect string
+	// Stats counts the evaluations and outcomes of the rule, if not nil.
+	Stats *RuleStats
 }
 
 type RuleChain []Rule
 
+// RuleEngine runs the rules of the "default" chain in order, and the first matched rule handles the request.
+// Rules may jump to or call other chains, see RuleEngineAction.
 type RuleEngine struct {
 	Chains map[string]RuleChain
+	// Fallback handles the requests which the default chain ended or returned without handling, if not nil
+	Fallback octollm.Engine
 }
 
 var _ octollm.Engine = (*RuleEngine)(nil)
 
+// maxChainDepth bounds the nesting of jumps and calls, deeper nesting is taken as a loop
+const maxChainDepth = 32
+
 func (e *RuleEngine) Process(req *octollm.Request) (*octollm.Response, error) {
 	// find default chain
-	currChain, ok := e.Chains["default"]
-	if !ok {
-		currChain, ok = e.Chains[""]
-		if !ok {
+	name := "default"
+	if _, ok := e.Chains[name]; !ok {
+		name = ""
+		if _, ok := e.Chains[name]; !ok {
 			return nil, ErrNoRuleChain
 		}
 	}
 
-	logrus.WithContext(req.Context()).Debugf("[rule-engine] executing default rule chain")
-	for _, r := range currChain {
+	resp, handled, err := e.runChain(req, name, 0)
+	if err != nil {
+		return nil, err
+	}
+	if !handled {
+		if e.Fallback != nil {
+			logrus.WithContext(req.Context()).Debugf("[rule-engine] no rule handled the request, executing fallback")
+			return e.Fallback.Process(req)
+		}
+		return nil, ErrNoRuleMatched
+	}
+	return resp, nil
+}
+
+// runChain runs the rules of a chain, handled is false if the chain ended or returned without handling the request.
+func (e *RuleEngine) runChain(req *octollm.Request, name string, depth int) (resp *octollm.Response, handled bool, err error) {
+	if depth > maxChainDepth {
+		return nil, false, ErrRuleChainLoop
+	}
+	chain, ok := e.Chains[name]
+	if !ok {
+		return nil, false, fmt.Errorf("%w: %s", ErrNoRuleChain, name)
+	}
+
+	logrus.WithContext(req.Context()).Debugf("[rule-engine] executing rule chain %q", name)
+	for _, r := range chain {
 		logrus.WithContext(req.Context()).Debugf("[rule-engine] going to match rule %s", r.Name)
-		if !r.Matcher.Match(req) {
+		start := time.Now()
+		matched := r.Matcher.Match(req)
+		r.Stats.evaluated(time.Since(start), matched)
+		if !matched {
+			continue
+		}
+		if r.Audit {
+			logrus.WithContext(req.Context()).Infof("[rule-engine] audit: rule %s of chain %q matched, would %s", r.Name, name, r.effect())
 			continue
 		}
-		logrus.WithContext(req.Context()).Debugf("[rule-engine] rule %s matched, executing", r.Name)
-		resp, err := r.Engine.Process(req)
-		if err == nil {
-			logrus.WithContext(req.Context()).Debugf("[rule-engine] rule %s exec success", r.Name)
-			return resp, nil
+		r.Stats.addExecuted()
+		if len(r.Tags) > 0 {
+			tags := req.Tags()
+			for k, v := range r.Tags {
+				tags.Set(k, v)
+			}
+			logrus.WithContext(req.Context()).Debugf("[rule-engine] rule %s tagged the request: %v", r.Name, r.Tags)
 		}
 
-		logrus.WithContext(req.Context()).Errorf("[rule-engine] rule %s exec error: %s", r.Name, err.Error())
-		eAct := &ErrWithAction{}
-		if !errors.As(err, &eAct) {
-			return nil, fmt.Errorf("%w: %w", ErrRuleActionError, err)
+		action, target := r.Action, r.Chain
+		if r.Engine != nil {
+			logrus.WithContext(req.Context()).Debugf("[rule-engine] rule %s matched, executing", r.Name)
+			resp, err := r.Engine.Process(req)
+			if err == nil {
+				logrus.WithContext(req.Context()).Debugf("[rule-engine] rule %s exec success", r.Name)
+				return resp, true, nil
+			}
+
+			eAct := &ErrWithAction{}
+			if !errors.As(err, &eAct) {
+				logrus.WithContext(req.Context()).Errorf("[rule-engine] rule %s exec error: %s", r.Name, err.Error())
+				if failureStatus(err) >= http.StatusInternalServerError {
+					// denials and client errors are the rule doing its job
+					r.Stats.addError()
+				}
+				return nil, true, fmt.Errorf("%w: %w", ErrRuleActionError, err)
+			}
+			action, target = eAct.Action, eAct.Chain
 		}
 
-		switch eAct.Action {
+		switch action {
 		case RuleEngineActionContinue:
-			logrus.WithContext(req.Context()).Debugf("[rule-engine] continue to next rule")
+			logrus.WithContext(req.Context()).Debugf("[rule-engine] rule %s: continue to next rule", r.Name)
 			continue
+		case RuleEngineActionReturn:
+			logrus.WithContext(req.Context()).Debugf("[rule-engine] rule %s: return from chain %q", r.Name, name)
+			return nil, false, nil
+		case RuleEngineActionJump:
+			logrus.WithContext(req.Context()).Debugf("[rule-engine] rule %s: jump to chain %q", r.Name, target)
+			return e.runChain(req, target, depth+1)
+		case RuleEngineActionCall:
+			logrus.WithContext(req.Context()).Debugf("[rule-engine] rule %s: call chain %q", r.Name, target)
+			resp, handled, err := e.runChain(req, target, depth+1)
+			if handled || err != nil {
+				return resp, handled, err
+			}
 		default:
-			return nil, fmt.Errorf("%w: %w", ErrRuleActionError, err)
+			return nil, true, fmt.Errorf("%w: rule %s: unsupported action %q", ErrRuleActionError, r.Name, action)
+		}
+	}
+
+	return nil, false, nil
+}
+
+// failureStatus returns the status of the response of a failed request.
+func failureStatus(err error) int {
+	httpErr := &errutils.UpstreamRespError{}
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode
+	}
+	return errutils.AsHandlerError(err).StatusCode
+}
+
+// effect describes what the rule would do for audit logs.
+func (r *Rule) effect() string {
+	switch {
+	case r.Effect != "":
+		return r.Effect
+	case r.Action == RuleEngineActionJump || r.Action == RuleEngineActionCall:
+		return fmt.Sprintf("%s chain %q", r.Action, r.Chain)
+	case r.Action != "":
+		return string(r.Action)
+	default:
+		return "handle the request"
+	}
+}
+
+// Validate checks that the targets of jumps and calls exist, and that chains never reach themselves through them.
+// Targets given by ErrWithAction at runtime are not known and not checked.
+func (e *RuleEngine) Validate() error {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(e.Chains))
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("rule chain loop: %s", strings.Join(append(path, name), " -> "))
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		for _, r := range e.Chains[name] {
+			if r.Engine == nil && r.Action == "" {
+				return fmt.Errorf("rule %s of chain %q has neither engine nor action", r.Name, name)
+			}
+			if r.Action != RuleEngineActionJump && r.Action != RuleEngineActionCall {
+				continue
+			}
+			if _, ok := e.Chains[r.Chain]; !ok {
+				return fmt.Errorf("rule %s of chain %q: unknown chain %q", r.Name, name, r.Chain)
+			}
+			if err := visit(r.Chain, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		return nil
+	}
+	for _, name := range slices.Sorted(maps.Keys(e.Chains)) {
+		if err := visit(name, nil); err != nil {
+			return err
 		}
 	}
+	return nil
+}
+
+// SideEffect is an engine which only acts on the request, e.g. tags it, and lets the chain go on with the next rule.
+type SideEffect func(req *octollm.Request)
 
-	return nil, ErrNoRuleMatched
+var _ octollm.Engine = (SideEffect)(nil)
+
+func (f SideEffect) Process(req *octollm.Request) (*octollm.Response, error) {
+	f(req)
+	return nil, ErrorWithAction(errSideEffect, RuleEngineActionContinue)
 }
+
+var errSideEffect = fmt.Errorf("side effect only")
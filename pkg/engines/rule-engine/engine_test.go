@@ -0,0 +1,168 @@
+package ruleengine
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/infinigence/octollm/pkg/octollm"
+)
+
+func TestRuleEngine_Chains(t *testing.T) {
+	var trace []string
+	answer := func(name string) octollm.Engine {
+		return octollm.EngineFunc(func(req *octollm.Request) (*octollm.Response, error) {
+			return &octollm.Response{StatusCode: http.StatusOK, Header: http.Header{"Served-By": []string{name}}}, nil
+		})
+	}
+	note := func(name string) SideEffect {
+		return func(req *octollm.Request) { trace = append(trace, name) }
+	}
+	header := func(key, value string) Matcher {
+		return MatchFunc(func(req *octollm.Request) bool { return req.Header.Get(key) == value })
+	}
+
+	e := &RuleEngine{Chains: map[string]RuleChain{
+		"default": {
+			{Name: "tag", Matcher: AlwaysTrueMatcher, Engine: note("default")},
+			{Name: "call-checks", Matcher: AlwaysTrueMatcher, Action: RuleEngineActionCall, Chain: "checks"},
+			{Name: "jump-premium", Matcher: header("Tier", "premium"), Action: RuleEngineActionJump, Chain: "premium"},
+			{Name: "fallback", Matcher: AlwaysTrueMatcher, Engine: answer("default")},
+		},
+		"checks": {
+			{Name: "tag", Matcher: AlwaysTrueMatcher, Engine: note("checks")},
+			{Name: "trusted", Matcher: header("Trusted", "1"), Action: RuleEngineActionReturn},
+			{Name: "deny", Matcher: header("Bad", "1"), Engine: answer("deny")},
+		},
+		"premium": {
+			{Name: "tag", Matcher: AlwaysTrueMatcher, Engine: note("premium")},
+			{Name: "fast", Matcher: header("Fast", "1"), Engine: answer("premium")},
+		},
+	}}
+	require.NoError(t, e.Validate())
+
+	process := func(header map[string]string) (string, error) {
+		trace = nil
+		httpReq, err := http.NewRequestWithContext(context.Background(), http.MethodPost, "http://localhost/v1/chat/completions", nil)
+		require.NoError(t, err)
+		for k, v := range header {
+			httpReq.Header.Set(k, v)
+		}
+		resp, err := e.Process(octollm.NewRequest(httpReq, octollm.APIFormatChatCompletions))
+		if err != nil {
+			return "", err
+		}
+		return resp.Header.Get("Served-By"), nil
+	}
+
+	// the called chain returns at its end, the caller goes on
+	served, err := process(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "default", served)
+	assert.Equal(t, []string{"default", "checks"}, trace)
+
+	// a return skips the rest of the called chain
+	served, err = process(map[string]string{"Trusted": "1", "Bad": "1"})
+	require.NoError(t, err)
+	assert.Equal(t, "default", served)
+
+	// the called chain handles the request
+	served, err = process(map[string]string{"Bad": "1"})
+	require.NoError(t, err)
+	assert.Equal(t, "deny", served)
+
+	served, err = process(map[string]string{"Tier": "premium", "Fast": "1"})
+	require.NoError(t, err)
+	assert.Equal(t, "premium", served)
+	assert.Equal(t, []string{"default", "checks", "premium"}, trace)
+
+	// a jump does not come back, the end of the target chain ends the default chain too
+	_, err = process(map[string]string{"Tier": "premium"})
+	assert.ErrorIs(t, err, ErrNoRuleMatched)
+}
+
+func TestRuleEngine_Validate(t *testing.T) {
+	loop := &RuleEngine{Chains: map[string]RuleChain{
+		"default": {{Name: "a", Matcher: AlwaysTrueMatcher, Action: RuleEngineActionCall, Chain: "x"}},
+		"x":       {{Name: "b", Matcher: AlwaysTrueMatcher, Action: RuleEngineActionJump, Chain: "y"}},
+		"y":       {{Name: "c", Matcher: AlwaysFalseMatcher, Action: RuleEngineActionCall, Chain: "x"}},
+	}}
+	assert.ErrorContains(t, loop.Validate(), "default -> x -> y -> x")
+
+	unknown := &RuleEngine{Chains: map[string]RuleChain{
+		"default": {{Name: "a", Matcher: AlwaysTrueMatcher, Action: RuleEngineActionJump, Chain: "missing"}},
+	}}
+	assert.ErrorContains(t, unknown.Validate(), `unknown chain "missing"`)
+
+	// loops through ErrWithAction are only found at runtime
+	engine := octollm.EngineFunc(func(req *octollm.Request) (*octollm.Response, error) {
+		return nil, &ErrWithAction{Action: RuleEngineActionCall, Chain: "default", Err: errSideEffect}
+	})
+	e := &RuleEngine{Chains: map[string]RuleChain{"default": {{Name: "self", Matcher: AlwaysTrueMatcher, Engine: engine}}}}
+	require.NoError(t, e.Validate())
+	httpReq, err := http.NewRequestWithContext(context.Background(), http.MethodPost, "http://localhost/v1/chat/completions", nil)
+	require.NoError(t, err)
+	_, err = e.Process(octollm.NewRequest(httpReq, octollm.APIFormatChatCompletions))
+	assert.ErrorIs(t, err, ErrRuleChainLoop)
+}
+
+func TestRuleEngine_AuditAndStats(t *testing.T) {
+	answer := func(name string) octollm.Engine {
+		return octollm.EngineFunc(func(req *octollm.Request) (*octollm.Response, error) {
+			return &octollm.Response{StatusCode: http.StatusOK, Header: http.Header{"Served-By": []string{name}}}, nil
+		})
+	}
+	failing := octollm.EngineFunc(func(req *octollm.Request) (*octollm.Response, error) {
+		return nil, errors.New("upstream down")
+	})
+	header := func(key string) Matcher {
+		return MatchFunc(func(req *octollm.Request) bool { return req.Header.Get(key) != "" })
+	}
+
+	auditStats, denyStats, failStats := &RuleStats{}, &RuleStats{}, &RuleStats{}
+	e := &RuleEngine{Chains: map[string]RuleChain{"default": {
+		{Name: "new-deny", Matcher: AlwaysTrueMatcher, Engine: answer("deny"), Tags: map[string]string{"denied": "1"},
+			Audit: true, Effect: "deny", Stats: auditStats},
+		{Name: "deny", Matcher: header("Bad"), Engine: answer("deny"), Stats: denyStats},
+		{Name: "fail", Matcher: header("Fail"), Engine: failing, Stats: failStats},
+		{Name: "fallback", Matcher: AlwaysTrueMatcher, Engine: answer("default")},
+	}}}
+
+	process := func(header map[string]string) (*octollm.Request, string, error) {
+		httpReq, err := http.NewRequestWithContext(context.Background(), http.MethodPost, "http://localhost/v1/chat/completions", nil)
+		require.NoError(t, err)
+		for k, v := range header {
+			httpReq.Header.Set(k, v)
+		}
+		req := octollm.NewRequest(httpReq, octollm.APIFormatChatCompletions)
+		resp, err := e.Process(req)
+		if err != nil {
+			return req, "", err
+		}
+		return req, resp.Header.Get("Served-By"), nil
+	}
+
+	// the audit rule matches but neither tags nor handles the request
+	req, served, err := process(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "default", served)
+	assert.Equal(t, 0, req.Tags().Len())
+
+	_, served, err = process(map[string]string{"Bad": "1"})
+	require.NoError(t, err)
+	assert.Equal(t, "deny", served)
+	_, _, err = process(map[string]string{"Fail": "1"})
+	assert.ErrorIs(t, err, ErrRuleActionError)
+
+	counts := func(s *RuleStats) [4]int64 {
+		snap := s.Snapshot()
+		return [4]int64{snap.Evaluations, snap.Matched, snap.Executed, snap.Errors}
+	}
+	assert.Equal(t, [4]int64{3, 3, 0, 0}, counts(auditStats))
+	assert.Equal(t, [4]int64{3, 1, 1, 0}, counts(denyStats))
+	assert.Equal(t, [4]int64{2, 1, 1, 1}, counts(failStats))
+}
@@ -1,16 +1,25 @@
 package ruleengine
 
-import "fmt"
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/infinigence/octollm/pkg/errutils"
+)
 
 type RuleEngineAction string
 
+// Actions tell the rule engine where to go on after a rule, like the targets of iptables.
 const (
-	RuleEngineActionContinue RuleEngineAction = "continue"
-	// RuleEngineActionReturn   RuleEngineAction = "return"
+	RuleEngineActionContinue RuleEngineAction = "continue" // go on with the next rule
+	RuleEngineActionReturn   RuleEngineAction = "return"   // return to the rule after the call of the chain
+	RuleEngineActionJump     RuleEngineAction = "jump"     // go on with another chain, which returns to the caller of this one
+	RuleEngineActionCall     RuleEngineAction = "call"     // run another chain, then go on with the next rule if it returns
 )
 
 type ErrWithAction struct {
 	Action RuleEngineAction
+	Chain  string // target of jump and call
 	Err    error
 }
 
@@ -18,6 +27,10 @@ func (e *ErrWithAction) Error() string {
 	return fmt.Sprintf("rule exec error (action %s): %s", e.Action, e.Err.Error())
 }
 
+func (e *ErrWithAction) Unwrap() error {
+	return e.Err
+}
+
 func ErrorWithAction(err error, action RuleEngineAction) *ErrWithAction {
 	return &ErrWithAction{
 		Action: action,
@@ -25,8 +38,14 @@ func ErrorWithAction(err error, action RuleEngineAction) *ErrWithAction {
 	}
 }
 
+// ErrNoRuleChain and ErrNoRuleMatched are HandlerErrors, so clients see a stable status code.
+// ErrRuleActionError is not, so the status of the error returned by the rule's engine is kept.
 var (
-	ErrNoRuleChain     = fmt.Errorf("no rule chain found")
-	ErrNoRuleMatched   = fmt.Errorf("no rule matched")
+	ErrNoRuleChain error = errutils.NewHandlerError(
+		fmt.Errorf("no rule chain found"), http.StatusInternalServerError, "No Rule Chain")
+	ErrNoRuleMatched error = errutils.NewHandlerError(
+		fmt.Errorf("no rule matched"), http.StatusForbidden, "Request Not Allowed By Any Rule")
+	ErrRuleChainLoop error = errutils.NewHandlerError(
+		fmt.Errorf("rule chains nested deeper than %d, probably a loop", maxChainDepth), http.StatusInternalServerError, "Rule Chain Loop")
 	ErrRuleActionError = fmt.Errorf("rule action error")
 )
@@ -1,8 +1,8 @@
 package ruleengine
 
 import (
-	"encoding/json"
 	"fmt"
+	"sync"
 
 	"github.com/expr-lang/expr"
 	"github.com/expr-lang/expr/vm"
@@ -20,36 +20,55 @@ func (f FeatureExtractorFunc) Features(req *octollm.Request) (map[string]any, er
 	return f(req)
 }
 
+// ExprMatcher matches requests by an expr-lang expression over ExprMatcherEnv.
+// Build it with NewExprMatcher to have the expression checked up front, a matcher built as a literal compiles it
+// on first use and never matches if it does not compile.
 type ExprMatcher struct {
 	Code             string
 	FeatureExtractor FeatureExtractor
 
-	prog *vm.Program
+	compileOnce sync.Once
+	prog        *vm.Program
+	compileErr  error
 }
 
-type ExprMatcherEnv struct {
-	RawReq   map[string]any
-	Features map[string]any
-	req      *octollm.Request
-}
+// ExprMatcherEnv is the environment of match expressions, with the features extracted by the FeatureExtractor.
+type ExprMatcherEnv = octollm.ExprEnv
 
 var _ Matcher = (*ExprMatcher)(nil)
 
+// NewExprMatcher compiles code, type-checked against ExprMatcherEnv.
+func NewExprMatcher(code string, featureExtractor FeatureExtractor) (*ExprMatcher, error) {
+	m := &ExprMatcher{Code: code, FeatureExtractor: featureExtractor}
+	if _, err := m.program(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *ExprMatcher) program() (*vm.Program, error) {
+	m.compileOnce.Do(func() {
+		m.prog, m.compileErr = expr.Compile(m.Code, expr.Env(&ExprMatcherEnv{}))
+		if m.compileErr != nil {
+			m.compileErr = fmt.Errorf("compile expr %q failed: %w", m.Code, m.compileErr)
+		}
+	})
+	return m.prog, m.compileErr
+}
+
 func (m *ExprMatcher) Match(req *octollm.Request) bool {
-	env, err := m.buildEnvFor(req)
+	prog, err := m.program()
 	if err != nil {
-		logrus.WithContext(req.Context()).Warnf("build env for request failed: %v", err)
+		logrus.WithContext(req.Context()).Warnf("%v", err)
+		return false
 	}
 
-	if m.prog == nil {
-		m.prog, err = expr.Compile(m.Code)
-		if err != nil {
-			logrus.WithContext(req.Context()).Warnf("compile expr code failed: %v", err)
-			return false
-		}
+	env, err := m.buildEnvFor(req)
+	if err != nil {
+		logrus.WithContext(req.Context()).Warnf("build env for request failed: %v", err)
 	}
 
-	output, err := expr.Run(m.prog, env)
+	output, err := expr.Run(prog, env)
 	if err != nil {
 		logrus.WithContext(req.Context()).Warnf("run expr program failed: %v", err)
 		return false
@@ -68,33 +87,21 @@ func (m *ExprMatcher) Match(req *octollm.Request) bool {
 	}
 }
 
-func (env *ExprMatcherEnv) CtxValue(key any) any {
-	return env.req.Context().Value(key)
-}
-
 func (m *ExprMatcher) buildEnvFor(req *octollm.Request) (*ExprMatcherEnv, error) {
-	mapBody := make(map[string]any)
-	b, err := req.Body.Bytes()
+	env, err := octollm.NewExprEnv(req)
 	if err != nil {
-		return nil, fmt.Errorf("read request body failed: %w", err)
-	}
-	if err := json.Unmarshal(b, &mapBody); err != nil {
-		return nil, fmt.Errorf("unmarshal request body failed: %w", err)
+		return env, err
 	}
 
-	var features map[string]any
 	if m.FeatureExtractor != nil {
-		features, err = m.FeatureExtractor.Features(req)
+		features, err := m.FeatureExtractor.Features(req)
 		if err != nil {
-			return nil, fmt.Errorf("extract features failed: %w", err)
+			// features are not available for every format, the rule may still match on the raw request
+			logrus.WithContext(req.Context()).Debugf("[expr-matcher] extract features failed: %v", err)
+		} else {
+			logrus.WithContext(req.Context()).Debugf("[expr-matcher] extracted features: %v", features)
 		}
-		logrus.WithContext(req.Context()).Debugf("[expr-matcher] extracted features: %v", features)
-	}
-
-	env := &ExprMatcherEnv{
-		RawReq:   mapBody,
-		Features: features,
-		req:      req,
+		env.Features = features
 	}
 	return env, nil
 }
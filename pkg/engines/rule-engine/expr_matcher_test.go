@@ -0,0 +1,39 @@
+package ruleengine
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/infinigence/octollm/pkg/octollm"
+)
+
+func TestExprMatcher_Env(t *testing.T) {
+	ctx := octollm.WithClientIP(context.Background(), "10.0.0.1")
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://localhost/v1/chat/completions?tier=low", strings.NewReader(`{"model":"m","max_tokens":10}`))
+	require.NoError(t, err)
+	httpReq.Header.Set("X-Priority", "low")
+	req := octollm.NewRequest(httpReq, octollm.APIFormatChatCompletions)
+	req.Body.SetParser(octollm.NewRequestParser(octollm.APIFormatChatCompletions))
+	req = req.WithContext(octollm.WithIdentity(req.Context(), octollm.Identity{User: "alice", Org: "trial"}))
+	req.Model = "m"
+	req.Tags().Set("tier", "batch")
+
+	for _, code := range []string{
+		`RawReq.max_tokens == 10`,
+		`User == "alice" && Org == "trial"`,
+		`Header["x-priority"] == "low" && Query.tier == "low"`,
+		`Model == "m" && Format == "chat/completions"`,
+		`Tags.tier == "batch"`,
+		`ClientIP == "10.0.0.1"`,
+		`Hour == Now.Hour() && Hour in 0..23`,
+		`Weekday in ["Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday", "Sunday"]`,
+	} {
+		m := &ExprMatcher{Code: code}
+		assert.True(t, m.Match(req), code)
+	}
+}
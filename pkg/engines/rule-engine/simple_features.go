@@ -4,11 +4,24 @@ import (
 	"fmt"
 	"hash/fnv"
 	"strings"
+	"unicode/utf8"
+
+	anthropicSDK "github.com/anthropics/anthropic-sdk-go"
+	openaiSDK "github.com/openai/openai-go/v3"
 
 	"github.com/infinigence/octollm/pkg/octollm"
-	"github.com/openai/openai-go/v3"
+	"github.com/infinigence/octollm/pkg/types/anthropic"
+	"github.com/infinigence/octollm/pkg/types/openai"
+	"github.com/infinigence/octollm/pkg/types/rerank"
 )
 
+// SimpleFeatureExtractor extracts cheap features of the prompt for routing:
+//
+//   - promptTextLen: runes of the prompt text
+//   - estimatedPromptTokens: a rough token count of the prompt text, see estimateTokens
+//   - prefixN, suffixN: hashes of the model and the first and last N runes of the first message
+//     (the system prompt of Claude Messages requests)
+//   - messageCount, toolCount, hasImages, maxTokens and stream: for chat completions and Claude Messages requests
 type SimpleFeatureExtractor struct {
 	PrefixHashLen []int
 	SuffixHashLen []int
@@ -22,13 +35,36 @@ func (e *SimpleFeatureExtractor) Features(req *octollm.Request) (map[string]any,
 
 	switch v := reqBody.(type) {
 	case *openai.ChatCompletionNewParams:
+		r := e.featuresForChatCompletions(&v.ChatCompletionNewParams)
+		r["stream"] = v.Stream.Value
+		return r, nil
+	case *openaiSDK.ChatCompletionNewParams:
 		return e.featuresForChatCompletions(v), nil
+	case *anthropic.MessageNewParams:
+		r := e.featuresForClaudeMessages(&v.MessageNewParams)
+		r["stream"] = v.Stream.Value
+		return r, nil
+	case *anthropicSDK.MessageNewParams:
+		return e.featuresForClaudeMessages(v), nil
+	case *openaiSDK.EmbeddingNewParams:
+		texts := []string{v.Input.OfString.Value}
+		if !v.Input.OfString.Valid() {
+			texts = v.Input.OfArrayOfStrings
+		}
+		return e.featuresForTexts(string(v.Model), texts), nil
+	case *rerank.Request:
+		// the query comes first, so the prefix and suffix hashes are those of the query
+		texts := []string{v.Query}
+		for _, doc := range v.Documents {
+			texts = append(texts, doc.Text)
+		}
+		return e.featuresForTexts(v.Model, texts), nil
 	default:
 		return nil, fmt.Errorf("unsupported request body type %T", reqBody)
 	}
 }
 
-func (e *SimpleFeatureExtractor) featuresForChatCompletions(req *openai.ChatCompletionNewParams) map[string]any {
+func (e *SimpleFeatureExtractor) featuresForChatCompletions(req *openaiSDK.ChatCompletionNewParams) map[string]any {
 	r := make(map[string]any)
 	r["promptTextLen"] = e.featurePromptTextLen(req)
 	for _, l := range e.PrefixHashLen {
@@ -37,20 +73,87 @@ func (e *SimpleFeatureExtractor) featuresForChatCompletions(req *openai.ChatComp
 	for _, l := range e.SuffixHashLen {
 		r["suffix"+fmt.Sprintf("%d", l)] = e.featureSuffixHash(req, l)
 	}
+
+	tokens := 0
+	hasImages := false
+	for _, msg := range req.Messages {
+		tokens += estimateTokens(e.combinedTextForChatCompletionsMessage(&msg))
+		if parts, ok := msg.GetContent().AsAny().(*[]openaiSDK.ChatCompletionContentPartUnionParam); ok {
+			for _, part := range *parts {
+				hasImages = hasImages || part.OfImageURL != nil
+			}
+		}
+	}
+	maxTokens := req.MaxCompletionTokens.Value
+	if !req.MaxCompletionTokens.Valid() {
+		maxTokens = req.MaxTokens.Value
+	}
+	r["estimatedPromptTokens"] = tokens
+	r["messageCount"] = len(req.Messages)
+	r["toolCount"] = len(req.Tools)
+	r["hasImages"] = hasImages
+	r["maxTokens"] = int(maxTokens)
+	r["stream"] = false
 	return r
 }
 
-func (e *SimpleFeatureExtractor) combinedTextForChatCompletionsMessage(msg *openai.ChatCompletionMessageParamUnion) string {
+// featuresForClaudeMessages extracts the features of a Claude Messages request. The text of the prompt is that of
+// the system blocks, and of the text, thinking and tool result blocks of the messages. The prefix and suffix hashes
+// are computed on the system prompt, or on the first message if there is none, like those of chat completions
+// whose first message is usually the system one.
+func (e *SimpleFeatureExtractor) featuresForClaudeMessages(req *anthropicSDK.MessageNewParams) map[string]any {
+	var texts []string
+	system := ""
+	for _, block := range req.System {
+		system += block.Text
+	}
+	if system != "" {
+		texts = append(texts, system)
+	}
+
+	hasImages := false
+	for _, msg := range req.Messages {
+		text := ""
+		for _, block := range msg.Content {
+			switch {
+			case block.OfText != nil:
+				text += block.OfText.Text
+			case block.OfThinking != nil:
+				text += block.OfThinking.Thinking
+			case block.OfImage != nil:
+				hasImages = true
+			case block.OfToolResult != nil:
+				for _, content := range block.OfToolResult.Content {
+					if content.OfText != nil {
+						text += content.OfText.Text
+					}
+					hasImages = hasImages || content.OfImage != nil
+				}
+			}
+		}
+		texts = append(texts, text)
+	}
+
+	r := e.featuresForTexts(string(req.Model), texts)
+	r["messageCount"] = len(req.Messages)
+	r["toolCount"] = len(req.Tools)
+	r["hasImages"] = hasImages
+	r["maxTokens"] = int(req.MaxTokens)
+	r["stream"] = false
+	return r
+}
+
+func (e *SimpleFeatureExtractor) combinedTextForChatCompletionsMessage(msg *openaiSDK.ChatCompletionMessageParamUnion) string {
 	switch v := msg.GetContent().AsAny().(type) {
 	case *string:
 		return *v
-	case *[]openai.ChatCompletionContentPartTextParam:
+	case *[]openaiSDK.ChatCompletionContentPartTextParam:
 		r := ""
 		for _, part := range *v {
 			r += part.Text
 		}
 		return r
-	case *[]openai.ChatCompletionContentPartUnionParam:
+	case *[]openaiSDK.ChatCompletionContentPartUnionParam:
 		r := ""
 		for _, part := range *v {
 			t := part.GetText()
@@ -59,7 +162,7 @@ func (e *SimpleFeatureExtractor) combinedTextForChatCompletionsMessage(msg *open
 			}
 		}
 		return r
-	case *[]openai.ChatCompletionAssistantMessageParamContentArrayOfContentPartUnion:
+	case *[]openaiSDK.ChatCompletionAssistantMessageParamContentArrayOfContentPartUnion:
 		r := ""
 		for _, part := range *v {
 			t := part.GetText()
@@ -73,7 +176,7 @@ func (e *SimpleFeatureExtractor) combinedTextForChatCompletionsMessage(msg *open
 	}
 }
 
-func (e *SimpleFeatureExtractor) featurePrefixHash(req *openai.ChatCompletionNewParams, l int) string {
+func (e *SimpleFeatureExtractor) featurePrefixHash(req *openaiSDK.ChatCompletionNewParams, l int) string {
 	if len(req.Messages) == 0 {
 		return ""
 	}
@@ -93,7 +196,7 @@ func (e *SimpleFeatureExtractor) featurePrefixHash(req *openai.ChatCompletionNew
 	return biz
 }
 
-func (e *SimpleFeatureExtractor) featureSuffixHash(req *openai.ChatCompletionNewParams, l int) string {
+func (e *SimpleFeatureExtractor) featureSuffixHash(req *openaiSDK.ChatCompletionNewParams, l int) string {
 	if len(req.Messages) == 0 {
 		return ""
 	}
@@ -113,10 +216,67 @@ func (e *SimpleFeatureExtractor) featureSuffixHash(req *openai.ChatCompletionNew
 	return biz
 }
 
-func (e *SimpleFeatureExtractor) featurePromptTextLen(req *openai.ChatCompletionNewParams) int {
+func (e *SimpleFeatureExtractor) featurePromptTextLen(req *openaiSDK.ChatCompletionNewParams) int {
 	allMsgTextLen := 0
 	for _, msg := range req.Messages {
 		allMsgTextLen += len([]rune(e.combinedTextForChatCompletionsMessage(&msg)))
 	}
 	return allMsgTextLen
 }
+
+// featuresForTexts extracts the same features as featuresForChatCompletions from plain text inputs,
+// the prefix and suffix hashes are computed on the first text.
+func (e *SimpleFeatureExtractor) featuresForTexts(model string, texts []string) map[string]any {
+	r := make(map[string]any)
+	textLen := 0
+	for _, text := range texts {
+		textLen += len([]rune(text))
+	}
+	r["promptTextLen"] = textLen
+	tokens := 0
+	for _, text := range texts {
+		tokens += estimateTokens(text)
+	}
+	r["estimatedPromptTokens"] = tokens
+
+	first := ""
+	if len(texts) > 0 {
+		first = strings.TrimSpace(texts[0])
+	}
+	for _, l := range e.PrefixHashLen {
+		prefix := []rune(first)
+		if len(prefix) > l {
+			prefix = prefix[:l]
+		}
+		r["prefix"+fmt.Sprintf("%d", l)] = e.textHash(model, string(prefix))
+	}
+	for _, l := range e.SuffixHashLen {
+		suffix := []rune(first)
+		if len(suffix) > l {
+			suffix = suffix[len(suffix)-l:]
+		}
+		r["suffix"+fmt.Sprintf("%d", l)] = e.textHash(model, string(suffix))
+	}
+	return r
+}
+
+func (e *SimpleFeatureExtractor) textHash(model, text string) string {
+	hasher := fnv.New32a()
+	hasher.Write([]byte(model))
+	hasher.Write([]byte(text))
+	return fmt.Sprintf("%08x", hasher.Sum32())
+}
+
+// estimateTokens estimates the tokens of text without a tokenizer: about 4 ASCII characters per token, and a token
+// per other rune, e.g. CJK characters. It is meant for routing thresholds, not for billing.
+func estimateTokens(text string) int {
+	ascii, other := 0, 0
+	for _, r := range text {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return (ascii+3)/4 + other
+}
@@ -0,0 +1,87 @@
+package ruleengine
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/infinigence/octollm/pkg/octollm"
+)
+
+func newFeaturesTestRequest(t *testing.T, format octollm.APIFormat, body string) *octollm.Request {
+	httpReq, err := http.NewRequest(http.MethodPost, "http://localhost/", strings.NewReader(body))
+	require.NoError(t, err)
+	req := octollm.NewRequest(httpReq, format)
+	req.Body.SetParser(octollm.NewRequestParser(format))
+	return req
+}
+
+func TestSimpleFeatureExtractor_ClaudeMessages(t *testing.T) {
+	e := &SimpleFeatureExtractor{PrefixHashLen: []int{4}, SuffixHashLen: []int{4}}
+	req := newFeaturesTestRequest(t, octollm.APIFormatClaudeMessages, `{
+		"model": "claude",
+		"max_tokens": 1024,
+		"stream": true,
+		"system": [{"type": "text", "text": " Be brief. "}],
+		"tools": [{"name": "get_weather", "input_schema": {"type": "object"}}],
+		"messages": [
+			{"role": "user", "content": [
+				{"type": "text", "text": "hello"},
+				{"type": "image", "source": {"type": "url", "url": "https://example.com/a.png"}}
+			]},
+			{"role": "assistant", "content": [
+				{"type": "thinking", "thinking": "hmm", "signature": "sig"},
+				{"type": "tool_use", "id": "t1", "name": "get_weather", "input": {}}
+			]},
+			{"role": "user", "content": [
+				{"type": "tool_result", "tool_use_id": "t1", "content": [{"type": "text", "text": "sunny"}]}
+			]}
+		]
+	}`)
+
+	features, err := e.Features(req)
+	require.NoError(t, err)
+	// " Be brief. " + "hello" + "hmm" + "sunny"
+	assert.Equal(t, 24, features["promptTextLen"])
+	assert.Equal(t, 8, features["estimatedPromptTokens"])
+	assert.Equal(t, e.textHash("claude", "Be b"), features["prefix4"])
+	assert.Equal(t, e.textHash("claude", "ief."), features["suffix4"])
+	assert.Equal(t, 3, features["messageCount"])
+	assert.Equal(t, 1, features["toolCount"])
+	assert.Equal(t, true, features["hasImages"])
+	assert.Equal(t, 1024, features["maxTokens"])
+	assert.Equal(t, true, features["stream"])
+}
+
+func TestSimpleFeatureExtractor_ChatCompletions(t *testing.T) {
+	e := &SimpleFeatureExtractor{PrefixHashLen: []int{4}}
+	req := newFeaturesTestRequest(t, octollm.APIFormatChatCompletions, `{
+		"model": "gpt",
+		"max_tokens": 100,
+		"max_completion_tokens": 200,
+		"messages": [
+			{"role": "system", "content": "Be brief."},
+			{"role": "user", "content": [
+				{"type": "text", "text": "你好"},
+				{"type": "image_url", "image_url": {"url": "https://example.com/a.png"}}
+			]}
+		]
+	}`)
+
+	features, err := e.Features(req)
+	require.NoError(t, err)
+	assert.Equal(t, 11, features["promptTextLen"])
+	assert.Equal(t, 5, features["estimatedPromptTokens"])
+	assert.Equal(t, e.textHash("gpt", "Be b"), features["prefix4"])
+	assert.Equal(t, 2, features["messageCount"])
+	assert.Equal(t, 0, features["toolCount"])
+	assert.Equal(t, true, features["hasImages"])
+	assert.Equal(t, 200, features["maxTokens"])
+	assert.Equal(t, false, features["stream"])
+
+	m := &ExprMatcher{Code: `Features.hasImages && Features.maxTokens > 100`, FeatureExtractor: e}
+	assert.True(t, m.Match(req))
+}
@@ -0,0 +1,67 @@
+package ruleengine
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// RuleStats counts the evaluations and outcomes of a rule. It is safe for concurrent use, and may be shared by the
+// rules built from the same config for several models or orgs.
+type RuleStats struct {
+	evaluations atomic.Int64
+	matched     atomic.Int64
+	executed    atomic.Int64 // matched and applied, audit rules are never
+	errors      atomic.Int64 // the engine of the rule failed with a 5xx status, denials are not errors
+	evalNanos   atomic.Int64 // total time spent in the matcher
+}
+
+// RuleStatsSnapshot is the value of RuleStats at some time.
+type RuleStatsSnapshot struct {
+	Evaluations        int64   `json:"evaluations"`
+	Matched            int64   `json:"matched"`
+	Executed           int64   `json:"executed"`
+	Errors             int64   `json:"errors"`
+	EvalSeconds        float64 `json:"eval_seconds"`         // total
+	AvgEvalMicrosecond float64 `json:"avg_eval_microsecond"` // per evaluation
+}
+
+func (s *RuleStats) evaluated(d time.Duration, matched bool) {
+	if s == nil {
+		return
+	}
+	s.evaluations.Add(1)
+	s.evalNanos.Add(int64(d))
+	if matched {
+		s.matched.Add(1)
+	}
+}
+
+func (s *RuleStats) addExecuted() {
+	if s != nil {
+		s.executed.Add(1)
+	}
+}
+
+func (s *RuleStats) addError() {
+	if s != nil {
+		s.errors.Add(1)
+	}
+}
+
+func (s *RuleStats) Snapshot() RuleStatsSnapshot {
+	if s == nil {
+		return RuleStatsSnapshot{}
+	}
+	snap := RuleStatsSnapshot{
+		Evaluations: s.evaluations.Load(),
+		Matched:     s.matched.Load(),
+		Executed:    s.executed.Load(),
+		Errors:      s.errors.Load(),
+	}
+	evalNanos := s.evalNanos.Load()
+	snap.EvalSeconds = time.Duration(evalNanos).Seconds()
+	if snap.Evaluations > 0 {
+		snap.AvgEvalMicrosecond = float64(evalNanos) / float64(snap.Evaluations) / 1e3
+	}
+	return snap
+}
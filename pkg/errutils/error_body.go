@@ -0,0 +1,185 @@
+package errutils
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// ErrorStyle is the protocol whose error body shape is used when writing errors to clients.
+type ErrorStyle string
+
+const (
+	ErrorStyleUnknown   ErrorStyle = ""
+	ErrorStyleOpenAI    ErrorStyle = "openai"    // {"error":{"message","type","code"}}
+	ErrorStyleAnthropic ErrorStyle = "anthropic" // {"type":"error","error":{"type","message"}}
+	ErrorStyleGoogle    ErrorStyle = "google"    // {"error":{"code","message","status"}}
+)
+
+// ErrorInfo is the protocol independent content of an error body.
+type ErrorInfo struct {
+	StatusCode int
+	Type       string // error type, derived from StatusCode if empty
+	Code       string // OpenAI error code, optional
+	Message    string
+}
+
+// Body renders the error in the given style.
+func (e *ErrorInfo) Body(style ErrorStyle) []byte {
+	var v any
+	switch style {
+	case ErrorStyleAnthropic:
+		v = map[string]any{
+			"type": "error",
+			"error": map[string]any{
+				"type":    e.typeFor(style),
+				"message": e.Message,
+			},
+		}
+	case ErrorStyleGoogle:
+		v = map[string]any{
+			"error": map[string]any{
+				"code":    e.StatusCode,
+				"message": e.Message,
+				"status":  googleStatus(e.StatusCode),
+			},
+		}
+	default:
+		var code any
+		if e.Code != "" {
+			code = e.Code
+		}
+		v = map[string]any{
+			"error": map[string]any{
+				"message": e.Message,
+				"type":    e.typeFor(style),
+				"param":   nil,
+				"code":    code,
+			},
+		}
+	}
+	b, _ := json.Marshal(v)
+	return b
+}
+
+func (e *ErrorInfo) typeFor(style ErrorStyle) string {
+	if e.Type != "" {
+		return e.Type
+	}
+	return ErrorType(style, e.StatusCode)
+}
+
+// ErrorType returns the error type of the style for a status code.
+// OpenAI and Anthropic share the 4xx types, and differ in the 5xx ones.
+func ErrorType(style ErrorStyle, statusCode int) string {
+	switch statusCode {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return "invalid_request_error"
+	case http.StatusUnauthorized:
+		return "authentication_error"
+	case http.StatusPaymentRequired, http.StatusForbidden:
+		return "permission_error"
+	case http.StatusNotFound:
+		return "not_found_error"
+	case http.StatusRequestEntityTooLarge:
+		return "request_too_large"
+	case http.StatusTooManyRequests:
+		return "rate_limit_error"
+	}
+	if statusCode < 500 {
+		return "invalid_request_error"
+	}
+	if style == ErrorStyleAnthropic {
+		if statusCode == http.StatusServiceUnavailable || statusCode == 529 {
+			return "overloaded_error"
+		}
+		return "api_error"
+	}
+	return "server_error"
+}
+
+func googleStatus(statusCode int) string {
+	switch statusCode {
+	case http.StatusBadRequest:
+		return "INVALID_ARGUMENT"
+	case http.StatusUnauthorized:
+		return "UNAUTHENTICATED"
+	case http.StatusForbidden:
+		return "PERMISSION_DENIED"
+	case http.StatusNotFound:
+		return "NOT_FOUND"
+	case http.StatusConflict:
+		return "ABORTED"
+	case http.StatusTooManyRequests:
+		return "RESOURCE_EXHAUSTED"
+	case 499:
+		return "CANCELLED"
+	case http.StatusNotImplemented:
+		return "UNIMPLEMENTED"
+	case http.StatusServiceUnavailable:
+		return "UNAVAILABLE"
+	case http.StatusGatewayTimeout:
+		return "DEADLINE_EXCEEDED"
+	}
+	if statusCode < 500 {
+		return "FAILED_PRECONDITION"
+	}
+	return "INTERNAL"
+}
+
+// ParseErrorBody recognizes an error body of any known style.
+// The style is ErrorStyleUnknown if the body is not a known error shape, and the message is then the trimmed body.
+func ParseErrorBody(statusCode int, body []byte) (*ErrorInfo, ErrorStyle) {
+	info := &ErrorInfo{StatusCode: statusCode}
+
+	var v struct {
+		Type  string          `json:"type"`
+		Error json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(body, &v); err == nil && len(v.Error) > 0 {
+		var e struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+			Code    any    `json:"code"`
+			Status  string `json:"status"`
+		}
+		if err := json.Unmarshal(v.Error, &e); err == nil {
+			info.Message = e.Message
+			switch {
+			case v.Type == "error":
+				info.Type = e.Type
+				return info, ErrorStyleAnthropic
+			case e.Status != "":
+				return info, ErrorStyleGoogle
+			default:
+				info.Type = e.Type
+				if code, ok := e.Code.(string); ok {
+					info.Code = code
+				}
+				return info, ErrorStyleOpenAI
+			}
+		}
+		// {"error": "message"}
+		if err := json.Unmarshal(v.Error, &info.Message); err == nil {
+			return info, ErrorStyleUnknown
+		}
+	}
+
+	info.Message = strings.TrimSpace(string(body))
+	if info.Message == "" {
+		info.Message = http.StatusText(statusCode)
+	}
+	return info, ErrorStyleUnknown
+}
+
+// TranslateErrorBody renders an upstream error body in the given style.
+// Bodies already in that style are returned as is.
+func TranslateErrorBody(statusCode int, body []byte, style ErrorStyle) []byte {
+	info, srcStyle := ParseErrorBody(statusCode, body)
+	if srcStyle == style {
+		return body
+	}
+	// error types differ between protocols, so derive them from the status code
+	info.Type = ""
+	return info.Body(style)
+}
@@ -0,0 +1,82 @@
+package errutils
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTranslateErrorBody(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		body       string
+		style      ErrorStyle
+		want       string
+	}{
+		{
+			name:       "openai to anthropic",
+			statusCode: http.StatusBadRequest,
+			body:       `{"error":{"message":"bad model","type":"invalid_request_error","param":null,"code":"model_not_found"}}`,
+			style:      ErrorStyleAnthropic,
+			want:       `{"type":"error","error":{"type":"invalid_request_error","message":"bad model"}}`,
+		},
+		{
+			name:       "anthropic to openai",
+			statusCode: 529,
+			body:       `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`,
+			style:      ErrorStyleOpenAI,
+			want:       `{"error":{"message":"Overloaded","type":"server_error","param":null,"code":null}}`,
+		},
+		{
+			name:       "google to openai",
+			statusCode: http.StatusTooManyRequests,
+			body:       `{"error":{"code":429,"message":"Quota exceeded","status":"RESOURCE_EXHAUSTED"}}`,
+			style:      ErrorStyleOpenAI,
+			want:       `{"error":{"message":"Quota exceeded","type":"rate_limit_error","param":null,"code":null}}`,
+		},
+		{
+			name:       "plain text to google",
+			statusCode: http.StatusBadGateway,
+			body:       "upstream connect error\n",
+			style:      ErrorStyleGoogle,
+			want:       `{"error":{"code":502,"message":"upstream connect error","status":"INTERNAL"}}`,
+		},
+		{
+			name:       "same style is kept",
+			statusCode: http.StatusBadRequest,
+			body:       `{"type":"error","error":{"type":"invalid_request_error","message":"x"},"request_id":"req_1"}`,
+			style:      ErrorStyleAnthropic,
+			want:       `{"type":"error","error":{"type":"invalid_request_error","message":"x"},"request_id":"req_1"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := TranslateErrorBody(tt.statusCode, []byte(tt.body), tt.style)
+			assert.JSONEq(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestAsHandlerError(t *testing.T) {
+	notFound := NewHandlerError(fmt.Errorf("model x not found"), http.StatusNotFound, "Model Not Found")
+
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+	}{
+		{name: "handler error", err: fmt.Errorf("failed to get engine: %w", notFound), wantStatus: http.StatusNotFound},
+		{name: "upstream http error", err: &UpstreamHTTPError{Err: fmt.Errorf("connection refused")}, wantStatus: http.StatusBadGateway},
+		{name: "upstream timeout", err: &UpstreamHTTPError{Err: fmt.Errorf("do request error: %w", context.DeadlineExceeded)}, wantStatus: http.StatusGatewayTimeout},
+		{name: "other error", err: fmt.Errorf("boom"), wantStatus: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantStatus, AsHandlerError(tt.err).StatusCode)
+		})
+	}
+}
@@ -2,7 +2,7 @@ package errutils
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/sirupsen/logrus"
@@ -13,15 +13,21 @@ type ContextKey string
 const errorKey ContextKey = "error"
 
 type HandlerError struct {
-	Err        error  // 原始错误
-	StatusCode int    // HTTP 状态码
-	Message    string // 对外显示的消息
+	Err        error       // 原始错误
+	StatusCode int         // HTTP 状态码
+	Message    string      // 对外显示的消息
+	Type       string      // 错误类型, 为空时由状态码决定
+	Header     http.Header // 额外的响应头, 如 Retry-After
 }
 
 func (e *HandlerError) Error() string {
 	return e.Err.Error()
 }
 
+func (e *HandlerError) Unwrap() error {
+	return e.Err
+}
+
 func WithHandlerError(r *http.Request, err *HandlerError) *http.Request {
 	ctx := context.WithValue(r.Context(), errorKey, err)
 	return r.WithContext(ctx)
@@ -40,17 +46,48 @@ func NewHandlerError(err error, status int, msg string) *HandlerError {
 	}
 }
 
+// AsHandlerError maps an error returned by engines to the error returned to clients.
+// HandlerErrors are returned as is, upstream connection errors become 502 (504 on timeout),
+// and any other error becomes 500.
+func AsHandlerError(err error) *HandlerError {
+	handlerErr := &HandlerError{}
+	if errors.As(err, &handlerErr) {
+		return handlerErr
+	}
+	httpErr := &UpstreamHTTPError{}
+	if errors.As(err, &httpErr) {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return NewHandlerError(err, http.StatusGatewayTimeout, "Upstream Timeout")
+		}
+		return NewHandlerError(err, http.StatusBadGateway, "Bad Gateway")
+	}
+	return NewHandlerError(err, http.StatusInternalServerError, "Internal Server Error")
+}
+
+// ErrorHandlingMiddleware writes errors in the OpenAI style.
 func ErrorHandlingMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return ErrorHandlingMiddlewareWithStyle(ErrorStyleOpenAI, next)
+}
+
+// ErrorHandlingMiddlewareWithStyle writes the HandlerError set by next in the given style.
+func ErrorHandlingMiddlewareWithStyle(style ErrorStyle, next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		next.ServeHTTP(w, r)
 
 		if err, ok := r.Context().Value(errorKey).(*HandlerError); ok {
 			logrus.WithContext(r.Context()).Errorf("Handler error: %v (returned as: %v)", err.Err, err.Message)
 
+			info := &ErrorInfo{
+				StatusCode: err.StatusCode,
+				Type:       err.Type,
+				Message:    err.Message,
+			}
+			for k, v := range err.Header {
+				w.Header()[k] = v
+			}
+			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(err.StatusCode)
-			_ = json.NewEncoder(w).Encode(map[string]string{
-				"error": err.Message,
-			})
+			_, _ = w.Write(info.Body(style))
 		}
 	})
 }
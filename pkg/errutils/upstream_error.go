@@ -29,6 +29,10 @@ func (e *UpstreamHTTPError) Error() string {
 	return fmt.Sprintf("upstream http error: status code %d, err %s", e.StatusCode, e.Err.Error())
 }
 
+func (e *UpstreamHTTPError) Unwrap() error {
+	return e.Err
+}
+
 // type UpstreamGeneralError struct {
 // 	Err error
 // }
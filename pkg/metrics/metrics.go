@@ -0,0 +1,168 @@
+// Package metrics keeps in-process counters, served in the Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry holds counters by name.
+type Registry struct {
+	mu       sync.Mutex
+	counters map[string]*Counter
+}
+
+func NewRegistry() *Registry {
+	return &Registry{counters: make(map[string]*Counter)}
+}
+
+// Default is the registry served by the server.
+var Default = NewRegistry()
+
+// Counter returns the counter of a name, creating it on first use.
+func (r *Registry) Counter(name, help string) *Counter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.counters[name]
+	if !ok {
+		c = &Counter{name: name, help: help, series: make(map[string]*series)}
+		r.counters[name] = c
+	}
+	return c
+}
+
+// Sample is the value of a counter for a set of labels.
+type Sample struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  float64           `json:"value"`
+}
+
+// Snapshot returns the samples of all counters, sorted by name and labels.
+func (r *Registry) Snapshot() []Sample {
+	r.mu.Lock()
+	counters := slices.Collect(maps.Values(r.counters))
+	r.mu.Unlock()
+	slices.SortFunc(counters, func(a, b *Counter) int { return strings.Compare(a.name, b.name) })
+
+	var samples []Sample
+	for _, c := range counters {
+		samples = append(samples, c.samples()...)
+	}
+	return samples
+}
+
+// WriteText writes all counters in the Prometheus text exposition format.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	counters := slices.Collect(maps.Values(r.counters))
+	r.mu.Unlock()
+	slices.SortFunc(counters, func(a, b *Counter) int { return strings.Compare(a.name, b.name) })
+
+	for _, c := range counters {
+		if c.help != "" {
+			if _, err := fmt.Fprintf(w, "# HELP %s %s\n", c.name, c.help); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "# TYPE %s counter\n", c.name); err != nil {
+			return err
+		}
+		for _, s := range c.samples() {
+			if _, err := fmt.Fprintf(w, "%s%s %s\n", s.Name, formatLabels(s.Labels), strconv.FormatFloat(s.Value, 'g', -1, 64)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Counter counts events by labels, e.g. requests by model and status.
+type Counter struct {
+	name string
+	help string
+
+	mu     sync.Mutex
+	series map[string]*series // by labelsKey
+}
+
+type series struct {
+	labels map[string]string
+	value  float64
+}
+
+func (c *Counter) Inc(labels map[string]string) {
+	c.Add(labels, 1)
+}
+
+func (c *Counter) Add(labels map[string]string, delta float64) {
+	key := labelsKey(labels)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.series[key]
+	if !ok {
+		s = &series{labels: maps.Clone(labels)}
+		c.series[key] = s
+	}
+	s.value += delta
+}
+
+// Value returns the count of a set of labels.
+func (c *Counter) Value(labels map[string]string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s, ok := c.series[labelsKey(labels)]; ok {
+		return s.value
+	}
+	return 0
+}
+
+func (c *Counter) samples() []Sample {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	samples := make([]Sample, 0, len(c.series))
+	for _, key := range slices.Sorted(maps.Keys(c.series)) {
+		s := c.series[key]
+		samples = append(samples, Sample{Name: c.name, Labels: maps.Clone(s.labels), Value: s.value})
+	}
+	return samples
+}
+
+func labelsKey(labels map[string]string) string {
+	var sb strings.Builder
+	for _, k := range slices.Sorted(maps.Keys(labels)) {
+		sb.WriteString(k)
+		sb.WriteByte('=')
+		sb.WriteString(labels[k])
+		sb.WriteByte(0)
+	}
+	return sb.String()
+}
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels))
+	for _, k := range slices.Sorted(maps.Keys(labels)) {
+		pairs = append(pairs, LabelName(k)+"="+strconv.Quote(labels[k]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// LabelName turns s into a valid label name, characters other than letters, digits and '_' become '_'.
+func LabelName(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		valid := c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || i > 0 && '0' <= c && c <= '9'
+		if !valid {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
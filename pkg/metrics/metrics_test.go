@@ -0,0 +1,33 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry_WriteText(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("requests_total", "Requests.")
+	c.Inc(map[string]string{"model": "m", "tag_tier": "batch"})
+	c.Add(map[string]string{"tag_tier": "batch", "model": "m"}, 2)
+	c.Inc(map[string]string{"model": `a"b`})
+	assert.Same(t, c, r.Counter("requests_total", ""))
+	assert.Equal(t, 3.0, c.Value(map[string]string{"model": "m", "tag_tier": "batch"}))
+
+	var sb strings.Builder
+	require.NoError(t, r.WriteText(&sb))
+	assert.Equal(t, `# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total{model="a\"b"} 1
+requests_total{model="m",tag_tier="batch"} 3
+`, sb.String())
+}
+
+func TestLabelName(t *testing.T) {
+	assert.Equal(t, "tag_tier", LabelName("tag_tier"))
+	assert.Equal(t, "tag_a_b_c", LabelName("tag_a-b.c"))
+	assert.Equal(t, "_x", LabelName("1x"))
+}
@@ -0,0 +1,110 @@
+package octollm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ExprEnv is the environment of the expressions of rules and rewrites, e.g.
+// `Org == "trial" && Hour in 9..18` or `Header["x-priority"] == "low"`.
+type ExprEnv struct {
+	RawReq   map[string]any    // request body
+	RawResp  map[string]any    // response body, only set for response rewrites
+	RawChunk map[string]any    // stream chunk, only set for stream chunk rewrites
+	Features map[string]any    // features extracted from the body, only set for rules
+	User     string            // authenticated caller, empty for anonymous requests
+	Org      string            // org of the caller
+	Header   map[string]string // first value of each request header, keyed by the lowercase name
+	Query    map[string]string // first value of each query parameter
+	Model    string            // resolved model name
+	Format   string            // API format of the request, e.g. "chat/completions", see APIFormat
+	Tags     map[string]string // tags added by rules
+	Now      time.Time
+	Hour     int    // 0-23, in the local time of the server
+	Weekday  string // e.g. "Monday", in the local time of the server
+	ClientIP string
+
+	req *Request
+}
+
+// NewExprEnv builds the environment of a request. The env is complete but RawReq when the body is not a JSON
+// object, the error tells why.
+func NewExprEnv(req *Request) (*ExprEnv, error) {
+	now := time.Now()
+	id := IdentityFrom(req.Context())
+	env := &ExprEnv{
+		User:     id.User,
+		Org:      id.Org,
+		Header:   firstValues(req.Header, true),
+		Query:    firstValues(req.Query, false),
+		Model:    req.Model,
+		Format:   string(req.Format),
+		Tags:     req.Tags().Map(),
+		Now:      now,
+		Hour:     now.Hour(),
+		Weekday:  now.Weekday().String(),
+		ClientIP: req.ClientIP,
+		req:      req,
+	}
+
+	if req.Body == nil {
+		return env, nil
+	}
+	b, err := req.Body.Bytes()
+	if err != nil {
+		return env, fmt.Errorf("read request body failed: %w", err)
+	}
+	rawReq := make(map[string]any)
+	if err := json.Unmarshal(b, &rawReq); err != nil {
+		return env, fmt.Errorf("unmarshal request body failed: %w", err)
+	}
+	env.RawReq = rawReq
+	return env, nil
+}
+
+// CtxValue returns a value of the request context.
+func (env *ExprEnv) CtxValue(key any) any {
+	if env.req == nil {
+		return nil
+	}
+	return env.req.Context().Value(key)
+}
+
+func firstValues(values map[string][]string, lowerKeys bool) map[string]string {
+	m := make(map[string]string, len(values))
+	for k, v := range values {
+		if len(v) == 0 {
+			continue
+		}
+		if lowerKeys {
+			k = strings.ToLower(k)
+		}
+		m[k] = v[0]
+	}
+	return m
+}
+
+type clientIPKey struct{}
+
+// WithClientIP returns a copy of ctx carrying the IP of the client, e.g. as resolved by a server behind proxies.
+// NewRequest takes it over the remote address of the connection.
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+// clientIPOf returns the IP of the client of r, the one carried by the context if any.
+func clientIPOf(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok && ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -9,6 +9,8 @@ import (
 	"github.com/infinigence/octollm/pkg/errutils"
 	"github.com/infinigence/octollm/pkg/types/anthropic"
 	"github.com/infinigence/octollm/pkg/types/openai"
+	"github.com/infinigence/octollm/pkg/types/rerank"
+	"github.com/infinigence/octollm/pkg/types/vertex"
 	"github.com/sirupsen/logrus"
 )
 
@@ -28,7 +30,8 @@ func (s *Server) SetEngine(ep Engine) {
 }
 
 func httpHandler(engine Engine, format APIFormat, parser Parser) http.HandlerFunc {
-	return errutils.ErrorHandlingMiddleware(func(w http.ResponseWriter, r *http.Request) {
+	style := errorStyleOf(format)
+	return errutils.ErrorHandlingMiddlewareWithStyle(style, func(w http.ResponseWriter, r *http.Request) {
 		u := NewRequest(r, format)
 		u.Body.SetParser(parser)
 		resp, err := engine.Process(u)
@@ -36,23 +39,20 @@ func httpHandler(engine Engine, format APIFormat, parser Parser) http.HandlerFun
 			logrus.WithContext(r.Context()).Errorf("Do error: %v", err)
 			httpErr := &errutils.UpstreamRespError{}
 			if errors.As(err, &httpErr) {
-				w.WriteHeader(httpErr.StatusCode)
 				for k, v := range httpErr.Header {
 					if k == "Content-Length" {
 						continue
 					}
 					w.Header().Set(k, v[0])
 				}
+				// the upstream may speak another protocol than the client, e.g. behind a converter
+				body := errutils.TranslateErrorBody(httpErr.StatusCode, httpErr.Body, style)
+				w.Header().Set("Content-Type", "application/json")
 				w.WriteHeader(httpErr.StatusCode)
-				w.Write(httpErr.Body)
-				return
-			}
-			handlerErr := &errutils.HandlerError{}
-			if errors.As(err, &handlerErr) {
-				*r = *errutils.WithHandlerError(r, handlerErr)
+				w.Write(body)
 				return
 			}
-			*r = *errutils.WithError(r, err, http.StatusInternalServerError, "Internal Server Error")
+			*r = *errutils.WithHandlerError(r, errutils.AsHandlerError(err))
 			return
 		}
 
@@ -63,8 +63,16 @@ func httpHandler(engine Engine, format APIFormat, parser Parser) http.HandlerFun
 			}
 			w.Header().Set(k, v[0])
 		}
+		// gemini clients get a stream as a JSON array unless they ask for SSE
+		jsonArray := resp.Stream != nil && format == APIFormatVertexGenerateContent && r.URL.Query().Get("alt") != "sse"
+		if jsonArray {
+			w.Header().Set("Content-Type", "application/json")
+		}
 		w.WriteHeader(http.StatusOK)
-		if resp.Stream != nil {
+		if jsonArray {
+			defer resp.Stream.Close()
+			writeJSONArray(w, r, resp.Stream)
+		} else if resp.Stream != nil {
 			defer resp.Stream.Close()
 			for chunk := range resp.Stream.Chan() {
 				b, err := chunk.Body.Bytes()
@@ -101,14 +109,96 @@ func httpHandler(engine Engine, format APIFormat, parser Parser) http.HandlerFun
 	})
 }
 
+// writeJSONArray writes the chunks of a stream as the elements of a JSON array, flushing each of them.
+func writeJSONArray(w http.ResponseWriter, r *http.Request, stream *StreamChan) {
+	sep := "["
+	for chunk := range stream.Chan() {
+		b, err := chunk.Body.Bytes()
+		if err != nil {
+			// the status is sent already, the array is left unterminated so that the client sees the failure
+			logrus.WithContext(r.Context()).Errorf("[httpHandler] Read chunk error: %v", err)
+			return
+		}
+		w.Write([]byte(sep))
+		w.Write(b)
+		sep = ",\r\n"
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+	if sep == "[" {
+		w.Write([]byte(sep))
+	}
+	w.Write([]byte("]"))
+}
+
+// errorStyleOf returns the error body style that clients of the format can parse.
+func errorStyleOf(format APIFormat) errutils.ErrorStyle {
+	switch format {
+	case APIFormatClaudeMessages:
+		return errutils.ErrorStyleAnthropic
+	case APIFormatVertexGenerateContent:
+		return errutils.ErrorStyleGoogle
+	default:
+		return errutils.ErrorStyleOpenAI
+	}
+}
+
 // ChatCompletionsHandler handles OpenAI /v1/chat/completions requests
 func ChatCompletionsHandler(engine Engine) http.HandlerFunc {
-	return httpHandler(engine, APIFormatChatCompletions, &JSONParser[openai.ChatCompletionNewParams]{})
+	return httpHandler(engine, APIFormatChatCompletions, NewRequestParser(APIFormatChatCompletions))
 }
 
 // LegacyCompletionsHandler handles OpenAI /v1/completions requests
+func LegacyCompletionsHandler(engine Engine) http.HandlerFunc {
+	return httpHandler(engine, APIFormatLegacyCompletions, NewRequestParser(APIFormatLegacyCompletions))
+}
+
+// ResponsesHandler handles OpenAI /v1/responses requests
+func ResponsesHandler(engine Engine) http.HandlerFunc {
+	return httpHandler(engine, APIFormatResponses, NewRequestParser(APIFormatResponses))
+}
 
 // MessagesHandler handles Anthropic /v1/messages requests
 func MessagesHandler(engine Engine) http.HandlerFunc {
-	return httpHandler(engine, APIFormatClaudeMessages, &JSONParser[anthropic.MessageNewParams]{})
+	return httpHandler(engine, APIFormatClaudeMessages, NewRequestParser(APIFormatClaudeMessages))
+}
+
+// GenerateContentHandler handles Gemini / Vertex {model}:generateContent and {model}:streamGenerateContent requests.
+// The model and the method are read from the request path. Upstreams always stream as SSE, the client gets a JSON
+// array of the chunks unless it asks for SSE with alt=sse, as from the Gemini API.
+func GenerateContentHandler(engine Engine) http.HandlerFunc {
+	return httpHandler(engine, APIFormatVertexGenerateContent, NewRequestParser(APIFormatVertexGenerateContent))
+}
+
+// EmbeddingsHandler handles OpenAI /v1/embeddings requests
+func EmbeddingsHandler(engine Engine) http.HandlerFunc {
+	return httpHandler(engine, APIFormatEmbeddings, NewRequestParser(APIFormatEmbeddings))
+}
+
+// RerankHandler handles /v1/rerank requests
+func RerankHandler(engine Engine) http.HandlerFunc {
+	return httpHandler(engine, APIFormatRerank, NewRequestParser(APIFormatRerank))
+}
+
+// NewRequestParser returns the parser of request bodies of the format, nil for an unknown format.
+func NewRequestParser(format APIFormat) Parser {
+	switch format {
+	case APIFormatChatCompletions:
+		return &JSONParser[openai.ChatCompletionNewParams]{}
+	case APIFormatLegacyCompletions:
+		return &JSONParser[openai.CompletionNewParams]{}
+	case APIFormatResponses:
+		return &JSONParser[openai.ResponseNewParams]{}
+	case APIFormatClaudeMessages:
+		return &JSONParser[anthropic.MessageNewParams]{}
+	case APIFormatVertexGenerateContent:
+		return &JSONParser[vertex.GenerateContentRequest]{}
+	case APIFormatEmbeddings:
+		return &JSONParser[openai.EmbeddingNewParams]{}
+	case APIFormatRerank:
+		return &JSONParser[rerank.Request]{}
+	default:
+		return nil
+	}
 }
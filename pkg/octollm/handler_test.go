@@ -0,0 +1,34 @@
+package octollm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateContentHandler_Stream(t *testing.T) {
+	engine := EngineFunc(func(req *Request) (*Response, error) {
+		ch := make(chan *StreamChunk, 2)
+		ch <- &StreamChunk{Body: NewBodyFromBytes([]byte(`{"n":1}`), nil)}
+		ch <- &StreamChunk{Body: NewBodyFromBytes([]byte(`{"n":2}`), nil)}
+		close(ch)
+		header := http.Header{"Content-Type": {"text/event-stream"}}
+		return NewStreamResponse(http.StatusOK, header, NewStreamChan(ch, nil)), nil
+	})
+	serve := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		GenerateContentHandler(engine)(w, httptest.NewRequest(http.MethodPost, url, strings.NewReader(`{}`)))
+		return w
+	}
+
+	w := serve("/v1beta/models/gemini:streamGenerateContent")
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `[{"n":1},{"n":2}]`, w.Body.String())
+
+	w = serve("/v1beta/models/gemini:streamGenerateContent?alt=sse")
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	assert.Equal(t, "data: {\"n\":1}\n\ndata: {\"n\":2}\n\n", w.Body.String())
+}
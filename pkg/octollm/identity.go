@@ -0,0 +1,22 @@
+package octollm
+
+import "context"
+
+type identityKey struct{}
+
+// Identity is the authenticated caller of a request, empty for anonymous requests.
+type Identity struct {
+	User string
+	Org  string
+}
+
+// WithIdentity returns a copy of ctx carrying the identity of the caller.
+func WithIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFrom returns the identity of the caller carried by ctx.
+func IdentityFrom(ctx context.Context) Identity {
+	id, _ := ctx.Value(identityKey{}).(Identity)
+	return id
+}
@@ -16,7 +16,10 @@ const (
 	APIFormatChatCompletions       APIFormat = "chat/completions"
 	APIFormatLegacyCompletions     APIFormat = "completions"
 	APIFormatClaudeMessages        APIFormat = "messages"
+	APIFormatResponses             APIFormat = "responses"
 	APIFormatVertexGenerateContent APIFormat = "vertex"
+	APIFormatEmbeddings            APIFormat = "embeddings"
+	APIFormatRerank                APIFormat = "rerank"
 )
 
 // Parser parses and serializes body of requests or responses.
@@ -154,6 +157,11 @@ func (b *UnifiedBody) SetParsed(v any) {
 	}
 }
 
+// empty tells if the body has no content at all, e.g. of a request without body.
+func (b *UnifiedBody) empty() bool {
+	return b.reader == nil && b.bytes == nil && b.parsed == nil
+}
+
 func (b *UnifiedBody) Close() error {
 	if b.reader == nil {
 		return nil
@@ -169,7 +177,11 @@ type Request struct {
 	Header http.Header
 	Body   *UnifiedBody
 
-	ctx context.Context
+	Model    string // resolved model name, set by the composer
+	ClientIP string
+
+	ctx  context.Context
+	tags *Tags
 }
 
 type Response struct {
@@ -207,13 +219,16 @@ func (sc *StreamChan) Close() {
 }
 
 func NewRequest(r *http.Request, format APIFormat) *Request {
+	tags := NewTags()
 	u := &Request{
-		Method: r.Method,
-		Format: format,
-		URL:    r.URL,
-		Query:  r.URL.Query(),
-		Header: r.Header,
-		ctx:    r.Context(),
+		Method:   r.Method,
+		Format:   format,
+		URL:      r.URL,
+		Query:    r.URL.Query(),
+		Header:   r.Header,
+		ClientIP: clientIPOf(r),
+		ctx:      WithTags(r.Context(), tags),
+		tags:     tags,
 		Body: &UnifiedBody{
 			reader: r.Body,
 		},
@@ -225,6 +240,67 @@ func (u *Request) Context() context.Context {
 	return u.ctx
 }
 
+// Tags returns the tags of the request, which are shared with its copies made by WithContext.
+func (u *Request) Tags() *Tags {
+	if u.tags == nil {
+		// requests not made by NewRequest get their tags on first use
+		u.tags = NewTags()
+		if u.ctx != nil {
+			u.ctx = WithTags(u.ctx, u.tags)
+		}
+	}
+	return u.tags
+}
+
+// WithContext returns a shallow copy of the request with its context changed to ctx.
+func (u *Request) WithContext(ctx context.Context) *Request {
+	r := *u
+	r.ctx = ctx
+	return &r
+}
+
+// RequestSnapshot keeps what engines may change in place on a request, e.g. the format and body replaced by
+// converters and rewrites, so that it can be sent again as it was, e.g. to another backend.
+type RequestSnapshot struct {
+	format APIFormat
+	url    *url.URL
+	header http.Header
+	body   []byte // nil if the request has no body, which is kept
+}
+
+// Snapshot reads the body of the request and takes a snapshot of it.
+func (u *Request) Snapshot() (*RequestSnapshot, error) {
+	s := &RequestSnapshot{format: u.Format, header: u.Header.Clone()}
+	if u.URL != nil {
+		cloned := *u.URL
+		s.url = &cloned
+	}
+	if u.Body != nil && !u.Body.empty() {
+		b, err := u.Body.Bytes()
+		if err != nil {
+			return nil, fmt.Errorf("read body error: %w", err)
+		}
+		s.body = b
+	}
+	return s, nil
+}
+
+// Restore returns a copy of req as it was at the snapshot, with a header and body of its own. The copy keeps the
+// context and the tags of req.
+func (s *RequestSnapshot) Restore(req *Request) *Request {
+	r := req.WithContext(req.Context())
+	r.Format = s.format
+	if s.url != nil {
+		cloned := *s.url
+		r.URL = &cloned
+	}
+	r.Header = s.header.Clone()
+	if s.body != nil {
+		r.Body = NewBodyFromBytes(s.body, NewRequestParser(s.format))
+	}
+	return r
+}
+
 func NewNonStreamResponse(statusCode int, header http.Header, body *UnifiedBody) *Response {
 	return &Response{
 		StatusCode: statusCode,
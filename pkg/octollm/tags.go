@@ -0,0 +1,100 @@
+package octollm
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Tags are request-scoped key-value metadata, e.g. tier=batch set by a rule classifying the traffic.
+// They are shared by the copies of a request, and carried by its context so that logs of the request show them.
+// Tags are safe for concurrent use.
+type Tags struct {
+	mu sync.RWMutex
+	m  map[string]string
+}
+
+func NewTags() *Tags {
+	return &Tags{m: make(map[string]string)}
+}
+
+func (t *Tags) Set(key, value string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.m[key] = value
+}
+
+// Get returns the value of a tag, "" if the tag is not set.
+func (t *Tags) Get(key string) string {
+	if t == nil {
+		return ""
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.m[key]
+}
+
+// Map returns a copy of the tags.
+func (t *Tags) Map() map[string]string {
+	if t == nil {
+		return map[string]string{}
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return maps.Clone(t.m)
+}
+
+func (t *Tags) Len() int {
+	if t == nil {
+		return 0
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.m)
+}
+
+// String formats the tags as key=value pairs sorted by key, e.g. "region=eu,tier=batch".
+func (t *Tags) String() string {
+	m := t.Map()
+	pairs := make([]string, 0, len(m))
+	for _, k := range slices.Sorted(maps.Keys(m)) {
+		pairs = append(pairs, k+"="+m[k])
+	}
+	return strings.Join(pairs, ",")
+}
+
+type tagsKey struct{}
+
+// WithTags returns a copy of ctx carrying the tags of a request.
+func WithTags(ctx context.Context, tags *Tags) context.Context {
+	return context.WithValue(ctx, tagsKey{}, tags)
+}
+
+// TagsFrom returns the tags carried by ctx, nil if there are none.
+func TagsFrom(ctx context.Context) *Tags {
+	tags, _ := ctx.Value(tagsKey{}).(*Tags)
+	return tags
+}
+
+// TagsLogHook adds the tags of the request to the log entries made with its context, as the field "tags".
+type TagsLogHook struct{}
+
+var _ logrus.Hook = TagsLogHook{}
+
+func (TagsLogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (TagsLogHook) Fire(entry *logrus.Entry) error {
+	if entry.Context == nil {
+		return nil
+	}
+	if tags := TagsFrom(entry.Context); tags.Len() > 0 {
+		entry.Data["tags"] = tags.String()
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package openai
+
+// ChatCompletionSimple is a minimal chat completion response used when building responses from other protocols.
+type ChatCompletionSimple struct {
+	ID      string                       `json:"id"`
+	Object  string                       `json:"object"`
+	Created int64                        `json:"created"`
+	Model   string                       `json:"model"`
+	Choices []ChatCompletionChoiceSimple `json:"choices"`
+	Usage   *CompletionUsage             `json:"usage,omitempty"`
+}
+
+type ChatCompletionChoiceSimple struct {
+	Index        int                         `json:"index"`
+	Message      ChatCompletionMessageSimple `json:"message"`
+	FinishReason *string                     `json:"finish_reason"`
+}
+
+type ChatCompletionMessageSimple struct {
+	Role             string           `json:"role"`
+	Content          *string          `json:"content"`
+	ReasoningContent string           `json:"reasoning_content,omitempty"`
+	ToolCalls        []ToolCallSimple `json:"tool_calls,omitempty"`
+}
+
+type ToolCallSimple struct {
+	Index    *int             `json:"index,omitempty"` // only for stream chunks
+	ID       string           `json:"id,omitempty"`
+	Type     string           `json:"type,omitempty"`
+	Function ToolCallFunction `json:"function"`
+}
+
+type ToolCallFunction struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+type CompletionUsage struct {
+	PromptTokens        int                  `json:"prompt_tokens"`
+	CompletionTokens    int                  `json:"completion_tokens"`
+	TotalTokens         int                  `json:"total_tokens"`
+	PromptTokensDetails *PromptTokensDetails `json:"prompt_tokens_details,omitempty"`
+}
+
+type PromptTokensDetails struct {
+	CachedTokens int `json:"cached_tokens"`
+}
+
+// ChatCompletionChunkSimple is a minimal chat completion stream chunk.
+type ChatCompletionChunkSimple struct {
+	ID      string                            `json:"id"`
+	Object  string                            `json:"object"`
+	Created int64                             `json:"created"`
+	Model   string                            `json:"model"`
+	Choices []ChatCompletionChunkChoiceSimple `json:"choices"`
+	Usage   *CompletionUsage                  `json:"usage,omitempty"`
+}
+
+type ChatCompletionChunkChoiceSimple struct {
+	Index        int                       `json:"index"`
+	Delta        ChatCompletionDeltaSimple `json:"delta"`
+	FinishReason *string                   `json:"finish_reason"`
+}
+
+type ChatCompletionDeltaSimple struct {
+	Role             string           `json:"role,omitempty"`
+	Content          string           `json:"content,omitempty"`
+	ReasoningContent string           `json:"reasoning_content,omitempty"`
+	ToolCalls        []ToolCallSimple `json:"tool_calls,omitempty"`
+}
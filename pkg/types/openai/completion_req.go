@@ -0,0 +1,46 @@
+package openai
+
+import (
+	"encoding/json"
+
+	"github.com/openai/openai-go/v3"
+	"github.com/openai/openai-go/v3/packages/param"
+)
+
+// CompletionNewParams is the request body of the legacy /v1/completions API.
+// Like ChatCompletionNewParams, it carries the stream flag which the SDK sets through the method instead.
+type CompletionNewParams struct {
+	openai.CompletionNewParams
+
+	Stream param.Opt[bool] `json:"stream,omitzero"`
+}
+
+func (r CompletionNewParams) MarshalJSON() (data []byte, err error) {
+	type shadow openai.CompletionNewParams
+	type shadow1 struct {
+		shadow
+		Stream param.Opt[bool] `json:"stream,omitzero"`
+	}
+	return param.MarshalObject(r, shadow1{
+		shadow: shadow(r.CompletionNewParams),
+		Stream: r.Stream,
+	})
+}
+
+func (r *CompletionNewParams) UnmarshalJSON(data []byte) error {
+	err := json.Unmarshal(data, &r.CompletionNewParams)
+	if err != nil {
+		return err
+	}
+	type stream struct {
+		Stream param.Opt[bool] `json:"stream,omitzero"`
+	}
+	var s stream
+	err = json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+	r.Stream = s.Stream
+
+	return nil
+}
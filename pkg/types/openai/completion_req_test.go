@@ -0,0 +1,31 @@
+package openai
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompletionNewParams_UnmarshalJSON(t *testing.T) {
+	p := CompletionNewParams{}
+	jStrOriginal := `{
+		"model":"deepseek-coder",
+		"prompt":"def fib(n):",
+		"suffix":"\n    return a",
+		"max_tokens":64,
+		"echo":true,
+		"logprobs":2,
+		"stop":["\n\n"],
+		"stream":true
+	}`
+
+	err := json.Unmarshal([]byte(jStrOriginal), &p)
+	assert.NoError(t, err)
+	assert.Equal(t, "deepseek-coder", string(p.Model))
+	assert.True(t, p.Stream.Value)
+
+	jsonStr, err := json.Marshal(p)
+	assert.NoError(t, err)
+	assert.JSONEq(t, jStrOriginal, string(jsonStr))
+}
@@ -0,0 +1,9 @@
+package openai
+
+import (
+	"github.com/openai/openai-go/v3"
+)
+
+// EmbeddingNewParams is the request body of /v1/embeddings.
+// Embeddings are never streamed, so the SDK params can be used as is.
+type EmbeddingNewParams = openai.EmbeddingNewParams
@@ -0,0 +1,182 @@
+package openai
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// ResponseNewParams is the request body of the Responses API.
+// It does not wrap responses.ResponseNewParams of the SDK, because the SDK param unions silently drop
+// input items without "type" and the output_text contents of assistant messages when unmarshalling.
+type ResponseNewParams struct {
+	Model              string              `json:"model"`
+	Input              ResponseInput       `json:"input,omitzero"`
+	Instructions       *string             `json:"instructions,omitempty"`
+	Stream             *bool               `json:"stream,omitempty"`
+	MaxOutputTokens    *int64              `json:"max_output_tokens,omitempty"`
+	Temperature        *float64            `json:"temperature,omitempty"`
+	TopP               *float64            `json:"top_p,omitempty"`
+	Tools              []ResponseTool      `json:"tools,omitempty"`
+	ToolChoice         *ResponseToolChoice `json:"tool_choice,omitempty"`
+	ParallelToolCalls  *bool               `json:"parallel_tool_calls,omitempty"`
+	Reasoning          *ResponseReasoning  `json:"reasoning,omitempty"`
+	Text               *ResponseTextConfig `json:"text,omitempty"`
+	User               *string             `json:"user,omitempty"`
+	Store              *bool               `json:"store,omitempty"`
+	PreviousResponseID *string             `json:"previous_response_id,omitempty"`
+	Metadata           map[string]string   `json:"metadata,omitempty"`
+	Include            []string            `json:"include,omitempty"`
+}
+
+// ResponseInput is either a string or a list of items.
+type ResponseInput struct {
+	OfString *string
+	OfItems  []ResponseItem
+}
+
+func (r ResponseInput) IsZero() bool {
+	return r.OfString == nil && r.OfItems == nil
+}
+
+func (r ResponseInput) MarshalJSON() ([]byte, error) {
+	if r.OfString != nil {
+		return json.Marshal(*r.OfString)
+	}
+	return json.Marshal(r.OfItems)
+}
+
+func (r *ResponseInput) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(`"`)) {
+		return json.Unmarshal(data, &r.OfString)
+	}
+	return json.Unmarshal(data, &r.OfItems)
+}
+
+// ResponseItem is an input or output item. Only the fields of its Type are set.
+type ResponseItem struct {
+	Type   string `json:"type,omitempty"` // "message", "function_call", "function_call_output" or "reasoning", empty means "message"
+	ID     string `json:"id,omitempty"`
+	Status string `json:"status,omitempty"`
+
+	// message
+	Role    string           `json:"role,omitempty"`
+	Content *ResponseContent `json:"content,omitempty"` // also the reasoning text of reasoning items
+
+	// function_call and function_call_output
+	CallID    string           `json:"call_id,omitempty"`
+	Name      string           `json:"name,omitempty"`
+	Arguments *string          `json:"arguments,omitempty"`
+	Output    *ResponseContent `json:"output,omitempty"`
+
+	// reasoning
+	Summary          []ResponseContentPart `json:"summary,omitzero"`
+	EncryptedContent string                `json:"encrypted_content,omitempty"`
+}
+
+// ResponseContent is either a string or a list of content parts.
+type ResponseContent struct {
+	OfString *string
+	OfParts  []ResponseContentPart
+}
+
+func (c ResponseContent) MarshalJSON() ([]byte, error) {
+	if c.OfString != nil {
+		return json.Marshal(*c.OfString)
+	}
+	if c.OfParts == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(c.OfParts)
+}
+
+func (c *ResponseContent) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(`"`)) {
+		return json.Unmarshal(data, &c.OfString)
+	}
+	return json.Unmarshal(data, &c.OfParts)
+}
+
+// Text concatenates the string content or the text of all text parts.
+func (c *ResponseContent) Text() string {
+	if c == nil {
+		return ""
+	}
+	if c.OfString != nil {
+		return *c.OfString
+	}
+	var text string
+	for _, part := range c.OfParts {
+		text += part.Text
+	}
+	return text
+}
+
+type ResponseContentPart struct {
+	Type        string `json:"type"` // "input_text", "input_image", "input_file", "output_text", "refusal", "summary_text" or "reasoning_text"
+	Text        string `json:"text,omitempty"`
+	ImageURL    string `json:"image_url,omitempty"`
+	FileID      string `json:"file_id,omitempty"`
+	FileData    string `json:"file_data,omitempty"`
+	Filename    string `json:"filename,omitempty"`
+	Detail      string `json:"detail,omitempty"`
+	Refusal     string `json:"refusal,omitempty"`
+	Annotations []any  `json:"annotations,omitzero"`
+}
+
+type ResponseTool struct {
+	Type        string         `json:"type"` // only "function" can be converted
+	Name        string         `json:"name,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Parameters  map[string]any `json:"parameters,omitempty"`
+	Strict      *bool          `json:"strict,omitempty"`
+}
+
+// ResponseToolChoice is either a mode ("none", "auto", "required") or a specific tool.
+type ResponseToolChoice struct {
+	OfMode string
+	Type   string
+	Name   string
+}
+
+func (t ResponseToolChoice) MarshalJSON() ([]byte, error) {
+	if t.OfMode != "" {
+		return json.Marshal(t.OfMode)
+	}
+	return json.Marshal(struct {
+		Type string `json:"type"`
+		Name string `json:"name,omitempty"`
+	}{t.Type, t.Name})
+}
+
+func (t *ResponseToolChoice) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(`"`)) {
+		return json.Unmarshal(data, &t.OfMode)
+	}
+	var v struct {
+		Type string `json:"type"`
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	t.Type, t.Name = v.Type, v.Name
+	return nil
+}
+
+type ResponseReasoning struct {
+	Effort  string `json:"effort,omitempty"`
+	Summary string `json:"summary,omitempty"`
+}
+
+type ResponseTextConfig struct {
+	Format    *ResponseTextFormat `json:"format,omitempty"`
+	Verbosity string              `json:"verbosity,omitempty"`
+}
+
+type ResponseTextFormat struct {
+	Type        string         `json:"type"` // "text", "json_object" or "json_schema"
+	Name        string         `json:"name,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Schema      map[string]any `json:"schema,omitempty"`
+	Strict      *bool          `json:"strict,omitempty"`
+}
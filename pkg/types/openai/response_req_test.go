@@ -0,0 +1,46 @@
+package openai
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseNewParams_RoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		reqJSON string
+	}{
+		{
+			name:    "string input",
+			reqJSON: `{"model": "gpt-4o", "input": "Hi", "tool_choice": "auto"}`,
+		},
+		{
+			name: "item input",
+			reqJSON: `{
+				"model": "gpt-4o",
+				"input": [
+					{"role": "user", "content": [{"type": "input_text", "text": "Hi"}]},
+					{"type": "message", "role": "assistant", "content": [{"type": "output_text", "text": "Hello", "annotations": []}]},
+					{"type": "function_call", "call_id": "call_1", "name": "f", "arguments": "{}"},
+					{"type": "function_call_output", "call_id": "call_1", "output": "ok"}
+				],
+				"tools": [{"type": "function", "name": "f", "parameters": {"type": "object"}}],
+				"tool_choice": {"type": "function", "name": "f"},
+				"text": {"format": {"type": "json_schema", "name": "s", "schema": {"type": "object"}, "strict": true}}
+			}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req ResponseNewParams
+			require.NoError(t, json.Unmarshal([]byte(tt.reqJSON), &req))
+
+			b, err := json.Marshal(&req)
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.reqJSON, string(b))
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package openai
+
+// ResponseSimple is a minimal Responses API response used when building responses from other protocols.
+type ResponseSimple struct {
+	ID                string                     `json:"id"`
+	Object            string                     `json:"object"`
+	CreatedAt         int64                      `json:"created_at"`
+	Status            string                     `json:"status"` // "in_progress", "completed" or "incomplete"
+	Model             string                     `json:"model"`
+	Output            []ResponseItem             `json:"output"`
+	IncompleteDetails *ResponseIncompleteDetails `json:"incomplete_details"`
+	Error             *ResponseErrorSimple       `json:"error"`
+	Usage             *ResponseUsage             `json:"usage,omitempty"`
+}
+
+type ResponseIncompleteDetails struct {
+	Reason string `json:"reason"` // "max_output_tokens" or "content_filter"
+}
+
+type ResponseErrorSimple struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type ResponseUsage struct {
+	InputTokens         int                         `json:"input_tokens"`
+	InputTokensDetails  ResponseInputTokensDetails  `json:"input_tokens_details"`
+	OutputTokens        int                         `json:"output_tokens"`
+	OutputTokensDetails ResponseOutputTokensDetails `json:"output_tokens_details"`
+	TotalTokens         int                         `json:"total_tokens"`
+}
+
+type ResponseInputTokensDetails struct {
+	CachedTokens int `json:"cached_tokens"`
+}
+
+type ResponseOutputTokensDetails struct {
+	ReasoningTokens int `json:"reasoning_tokens"`
+}
+
+// ResponseStreamEventSimple is a semantic event of a streaming Responses API response.
+// Only the fields of its Type are set.
+type ResponseStreamEventSimple struct {
+	Type           string               `json:"type"`
+	SequenceNumber int                  `json:"sequence_number"`
+	Response       *ResponseSimple      `json:"response,omitempty"`
+	OutputIndex    *int                 `json:"output_index,omitempty"`
+	ContentIndex   *int                 `json:"content_index,omitempty"`
+	ItemID         string               `json:"item_id,omitempty"`
+	Item           *ResponseItem        `json:"item,omitempty"`
+	Part           *ResponseContentPart `json:"part,omitempty"`
+	Delta          string               `json:"delta,omitempty"`
+	Text           *string              `json:"text,omitempty"`
+	Arguments      *string              `json:"arguments,omitempty"`
+}
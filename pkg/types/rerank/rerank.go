@@ -0,0 +1,62 @@
+// Package rerank defines the /v1/rerank API types shared by Jina, Cohere and vLLM style rerankers.
+package rerank
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// Request is the body of a rerank request.
+type Request struct {
+	Model           string     `json:"model"`
+	Query           string     `json:"query"`
+	Documents       []Document `json:"documents"`
+	TopN            *int       `json:"top_n,omitempty"`
+	ReturnDocuments *bool      `json:"return_documents,omitempty"`
+	MaxChunksPerDoc *int       `json:"max_chunks_per_doc,omitempty"`
+	User            string     `json:"user,omitempty"`
+}
+
+// Document is either a plain string or an object with a "text" field.
+// Objects are kept as is, so unknown fields survive a round trip.
+type Document struct {
+	Text   string
+	Object map[string]any
+}
+
+func (d Document) MarshalJSON() ([]byte, error) {
+	if d.Object != nil {
+		return json.Marshal(d.Object)
+	}
+	return json.Marshal(d.Text)
+}
+
+func (d *Document) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(`"`)) {
+		return json.Unmarshal(data, &d.Text)
+	}
+	if err := json.Unmarshal(data, &d.Object); err != nil {
+		return err
+	}
+	d.Text, _ = d.Object["text"].(string)
+	return nil
+}
+
+// Response is the body of a rerank response.
+type Response struct {
+	ID      string   `json:"id,omitempty"`
+	Model   string   `json:"model,omitempty"`
+	Results []Result `json:"results"`
+	Usage   *Usage   `json:"usage,omitempty"`
+}
+
+type Result struct {
+	Index          int       `json:"index"`
+	RelevanceScore float64   `json:"relevance_score"`
+	Document       *Document `json:"document,omitempty"`
+}
+
+type Usage struct {
+	PromptTokens int `json:"prompt_tokens,omitempty"`
+	TotalTokens  int `json:"total_tokens"`
+}
@@ -0,0 +1,42 @@
+package rerank
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequest_RoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		reqJSON  string
+		wantText []string
+	}{
+		{
+			name:     "string documents",
+			reqJSON:  `{"model": "bge-reranker-v2-m3", "query": "What is octollm?", "documents": ["a gateway", "a fish"], "top_n": 1}`,
+			wantText: []string{"a gateway", "a fish"},
+		},
+		{
+			name:     "object documents",
+			reqJSON:  `{"model": "jina-reranker-m0", "query": "q", "documents": [{"text": "t1"}, {"image": "https://example.com/a.png"}], "return_documents": false}`,
+			wantText: []string{"t1", ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req Request
+			require.NoError(t, json.Unmarshal([]byte(tt.reqJSON), &req))
+			require.Len(t, req.Documents, len(tt.wantText))
+			for i, text := range tt.wantText {
+				assert.Equal(t, text, req.Documents[i].Text)
+			}
+
+			b, err := json.Marshal(&req)
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.reqJSON, string(b))
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package vertex
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	MethodGenerateContent       = "generateContent"
+	MethodStreamGenerateContent = "streamGenerateContent"
+)
+
+// GenerateContentRequest is the request body of the Gemini / Vertex generateContent and streamGenerateContent methods.
+// The model and the method are part of the URL path instead of the body.
+type GenerateContentRequest struct {
+	Contents          []Content         `json:"contents"`
+	SystemInstruction *Content          `json:"systemInstruction,omitempty"`
+	Tools             []Tool            `json:"tools,omitempty"`
+	ToolConfig        *ToolConfig       `json:"toolConfig,omitempty"`
+	GenerationConfig  *GenerationConfig `json:"generationConfig,omitempty"`
+	SafetySettings    json.RawMessage   `json:"safetySettings,omitempty"`
+	CachedContent     string            `json:"cachedContent,omitempty"`
+	Labels            map[string]string `json:"labels,omitempty"`
+}
+
+type Content struct {
+	Role  string `json:"role,omitempty"` // "user" or "model"
+	Parts []Part `json:"parts"`
+}
+
+type Part struct {
+	Text             string            `json:"text,omitempty"`
+	Thought          bool              `json:"thought,omitempty"`
+	ThoughtSignature string            `json:"thoughtSignature,omitempty"`
+	InlineData       *Blob             `json:"inlineData,omitempty"`
+	FileData         *FileData         `json:"fileData,omitempty"`
+	FunctionCall     *FunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *FunctionResponse `json:"functionResponse,omitempty"`
+}
+
+type Blob struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"` // base64 encoded
+}
+
+type FileData struct {
+	MimeType string `json:"mimeType,omitempty"`
+	FileURI  string `json:"fileUri"`
+}
+
+type FunctionCall struct {
+	ID   string         `json:"id,omitempty"`
+	Name string         `json:"name"`
+	Args map[string]any `json:"args,omitempty"`
+}
+
+type FunctionResponse struct {
+	ID       string         `json:"id,omitempty"`
+	Name     string         `json:"name"`
+	Response map[string]any `json:"response"`
+}
+
+type Tool struct {
+	FunctionDeclarations []FunctionDeclaration `json:"functionDeclarations,omitempty"`
+	GoogleSearch         json.RawMessage       `json:"googleSearch,omitempty"`
+	CodeExecution        json.RawMessage       `json:"codeExecution,omitempty"`
+}
+
+type FunctionDeclaration struct {
+	Name                 string `json:"name"`
+	Description          string `json:"description,omitempty"`
+	Parameters           any    `json:"parameters,omitempty"`           // OpenAPI schema subset
+	ParametersJSONSchema any    `json:"parametersJsonSchema,omitempty"` // JSON schema
+}
+
+type ToolConfig struct {
+	FunctionCallingConfig *FunctionCallingConfig `json:"functionCallingConfig,omitempty"`
+}
+
+const (
+	FunctionCallingModeAuto = "AUTO"
+	FunctionCallingModeAny  = "ANY"
+	FunctionCallingModeNone = "NONE"
+)
+
+type FunctionCallingConfig struct {
+	Mode                 string   `json:"mode,omitempty"`
+	AllowedFunctionNames []string `json:"allowedFunctionNames,omitempty"`
+}
+
+type GenerationConfig struct {
+	Temperature      *float64        `json:"temperature,omitempty"`
+	TopP             *float64        `json:"topP,omitempty"`
+	TopK             *int64          `json:"topK,omitempty"`
+	CandidateCount   *int64          `json:"candidateCount,omitempty"`
+	MaxOutputTokens  *int64          `json:"maxOutputTokens,omitempty"`
+	StopSequences    []string        `json:"stopSequences,omitempty"`
+	PresencePenalty  *float64        `json:"presencePenalty,omitempty"`
+	FrequencyPenalty *float64        `json:"frequencyPenalty,omitempty"`
+	Seed             *int64          `json:"seed,omitempty"`
+	ResponseMimeType string          `json:"responseMimeType,omitempty"`
+	ResponseSchema   json.RawMessage `json:"responseSchema,omitempty"`
+	ThinkingConfig   *ThinkingConfig `json:"thinkingConfig,omitempty"`
+}
+
+type ThinkingConfig struct {
+	IncludeThoughts bool   `json:"includeThoughts,omitempty"`
+	ThinkingBudget  *int64 `json:"thinkingBudget,omitempty"`
+}
+
+// GenerateContentResponse is the response body of generateContent, and each SSE event of streamGenerateContent.
+type GenerateContentResponse struct {
+	Candidates     []Candidate     `json:"candidates,omitempty"`
+	PromptFeedback json.RawMessage `json:"promptFeedback,omitempty"`
+	UsageMetadata  *UsageMetadata  `json:"usageMetadata,omitempty"`
+	ModelVersion   string          `json:"modelVersion,omitempty"`
+	ResponseID     string          `json:"responseId,omitempty"`
+}
+
+type Candidate struct {
+	Content      *Content `json:"content,omitempty"`
+	FinishReason string   `json:"finishReason,omitempty"`
+	Index        int      `json:"index"`
+}
+
+const (
+	FinishReasonStop      = "STOP"
+	FinishReasonMaxTokens = "MAX_TOKENS"
+	FinishReasonSafety    = "SAFETY"
+)
+
+type UsageMetadata struct {
+	PromptTokenCount        int `json:"promptTokenCount"`
+	CandidatesTokenCount    int `json:"candidatesTokenCount"`
+	TotalTokenCount         int `json:"totalTokenCount"`
+	CachedContentTokenCount int `json:"cachedContentTokenCount,omitempty"`
+	ThoughtsTokenCount      int `json:"thoughtsTokenCount,omitempty"`
+}
+
+// ParseModelMethod extracts the model and the method from a URL path like
+// "/v1beta/models/{model}:{method}" or "/v1/projects/{p}/locations/{l}/publishers/google/models/{model}:{method}".
+func ParseModelMethod(path string) (model string, method string, err error) {
+	idx := strings.LastIndex(path, "/models/")
+	if idx == -1 {
+		return "", "", fmt.Errorf("no model in path %s", path)
+	}
+	modelMethod := path[idx+len("/models/"):]
+	colonIdx := strings.LastIndex(modelMethod, ":")
+	if colonIdx <= 0 {
+		return "", "", fmt.Errorf("no method in path %s", path)
+	}
+	model, method = modelMethod[:colonIdx], modelMethod[colonIdx+1:]
+	if method != MethodGenerateContent && method != MethodStreamGenerateContent {
+		return "", "", fmt.Errorf("unsupported method %s", method)
+	}
+	return model, method, nil
+}
+
+// ModelMethodPath builds the Gemini style URL path for the model and method.
+func ModelMethodPath(model string, stream bool) string {
+	method := MethodGenerateContent
+	if stream {
+		method = MethodStreamGenerateContent
+	}
+	return "/v1beta/models/" + model + ":" + method
+}
@@ -0,0 +1,60 @@
+package vertex
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseModelMethod(t *testing.T) {
+	tests := []struct {
+		path       string
+		wantModel  string
+		wantMethod string
+		wantErr    bool
+	}{
+		{path: "/v1beta/models/gemini-2.5-flash:generateContent", wantModel: "gemini-2.5-flash", wantMethod: MethodGenerateContent},
+		{path: "/v1beta/models/gemini-2.5-flash:streamGenerateContent", wantModel: "gemini-2.5-flash", wantMethod: MethodStreamGenerateContent},
+		{path: "/v1/projects/p/locations/us-central1/publishers/google/models/gemini-2.0-flash-001:generateContent", wantModel: "gemini-2.0-flash-001", wantMethod: MethodGenerateContent},
+		{path: "/v1beta/models/gemini-2.5-flash:countTokens", wantErr: true},
+		{path: "/v1beta/models/gemini-2.5-flash", wantErr: true},
+		{path: "/v1/chat/completions", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			model, method, err := ParseModelMethod(tt.path)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantModel, model)
+			assert.Equal(t, tt.wantMethod, method)
+		})
+	}
+}
+
+func TestGenerateContentRequest_RoundTrip(t *testing.T) {
+	reqJSON := `{
+		"contents": [
+			{"role": "user", "parts": [{"text": "Hi"}, {"inlineData": {"mimeType": "image/png", "data": "AAAA"}}]},
+			{"role": "model", "parts": [{"functionCall": {"name": "f", "args": {"a": 1}}}]},
+			{"role": "user", "parts": [{"functionResponse": {"name": "f", "response": {"content": "ok"}}}]}
+		],
+		"systemInstruction": {"parts": [{"text": "Be brief."}]},
+		"tools": [{"functionDeclarations": [{"name": "f", "parameters": {"type": "OBJECT"}}]}],
+		"generationConfig": {"temperature": 0, "maxOutputTokens": 10, "thinkingConfig": {"thinkingBudget": 0}},
+		"safetySettings": [{"category": "HARM_CATEGORY_HATE_SPEECH", "threshold": "BLOCK_NONE"}]
+	}`
+
+	var req GenerateContentRequest
+	require.NoError(t, json.Unmarshal([]byte(reqJSON), &req))
+	assert.Equal(t, 0.0, *req.GenerationConfig.Temperature)
+	assert.Equal(t, int64(0), *req.GenerationConfig.ThinkingConfig.ThinkingBudget)
+
+	b, err := json.Marshal(&req)
+	require.NoError(t, err)
+	assert.JSONEq(t, reqJSON, string(b))
+}